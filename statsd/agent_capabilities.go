@@ -0,0 +1,78 @@
+package statsd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// agentInfoTimeout caps how long the creation of a client waits for the info endpoint of the Agent, see
+// WithAgentCapabilityDetection.
+const agentInfoTimeout = time.Second
+
+// The capabilities listed by the info endpoint of the Agent that enable an extension of the client.
+const (
+	capabilityOriginDetection       = "origin_detection"
+	capabilitySketchInput           = "sketch_input"
+	capabilityPrecomputedHistograms = "precomputed_histograms"
+)
+
+// agentCapabilities are the extensions of the DogStatsD protocol supported by the Agent.
+type agentCapabilities struct {
+	originDetection       bool
+	sketchInput           bool
+	precomputedHistograms bool
+}
+
+// agentInfo is the payload of the info endpoint of the Agent. Only the capabilities are used.
+type agentInfo struct {
+	Capabilities []string `json:"capabilities"`
+}
+
+// fetchAgentCapabilities queries the info endpoint of the Agent at infoURL. Unknown capabilities are ignored.
+func fetchAgentCapabilities(client *http.Client, infoURL string) (agentCapabilities, error) {
+	var capabilities agentCapabilities
+
+	resp, err := client.Get(infoURL)
+	if err != nil {
+		return capabilities, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return capabilities, fmt.Errorf("agent info endpoint returned status %d", resp.StatusCode)
+	}
+
+	var info agentInfo
+	if err = json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return capabilities, fmt.Errorf("could not parse agent info: %v", err)
+	}
+	for _, capability := range info.Capabilities {
+		switch capability {
+		case capabilityOriginDetection:
+			capabilities.originDetection = true
+		case capabilitySketchInput:
+			capabilities.sketchInput = true
+		case capabilityPrecomputedHistograms:
+			capabilities.precomputedHistograms = true
+		}
+	}
+	return capabilities, nil
+}
+
+// apply enables the options matching the capabilities. The options already enabled are kept.
+func (c agentCapabilities) apply(o *Options) {
+	o.originDetection = o.originDetection || c.originDetection
+	o.sketchInput = o.sketchInput || c.sketchInput
+	o.precomputedHistograms = o.precomputedHistograms || c.precomputedHistograms
+}
+
+// detectAgentCapabilities enables the extensions supported by the Agent, see WithAgentCapabilityDetection. The
+// options are left untouched when the Agent can't be queried.
+func detectAgentCapabilities(o *Options) {
+	capabilities, err := fetchAgentCapabilities(&http.Client{Timeout: agentInfoTimeout}, o.agentInfoURL)
+	if err != nil {
+		return
+	}
+	capabilities.apply(o)
+}
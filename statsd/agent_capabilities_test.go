@@ -0,0 +1,70 @@
+package statsd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newAgentInfoServer(status int, body string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+}
+
+func TestFetchAgentCapabilities(t *testing.T) {
+	server := newAgentInfoServer(http.StatusOK, `{"version": "7.50.0", "capabilities": ["sketch_input", "origin_detection", "unknown"]}`)
+	defer server.Close()
+
+	capabilities, err := fetchAgentCapabilities(server.Client(), server.URL)
+	require.NoError(t, err)
+	assert.Equal(t, agentCapabilities{originDetection: true, sketchInput: true}, capabilities)
+}
+
+func TestFetchAgentCapabilitiesErrors(t *testing.T) {
+	for _, server := range []*httptest.Server{
+		newAgentInfoServer(http.StatusNotFound, `{"capabilities": ["sketch_input"]}`),
+		newAgentInfoServer(http.StatusOK, `not json`),
+	} {
+		capabilities, err := fetchAgentCapabilities(server.Client(), server.URL)
+		assert.Error(t, err)
+		assert.Equal(t, agentCapabilities{}, capabilities)
+		server.Close()
+	}
+}
+
+func TestAgentCapabilitiesApply(t *testing.T) {
+	o := &Options{precomputedHistograms: true}
+	agentCapabilities{sketchInput: true}.apply(o)
+	assert.True(t, o.sketchInput)
+	assert.False(t, o.originDetection)
+	// the options already enabled are kept
+	assert.True(t, o.precomputedHistograms)
+}
+
+func TestClientAgentCapabilityDetection(t *testing.T) {
+	server := newAgentInfoServer(http.StatusOK, `{"capabilities": ["sketch_input", "precomputed_histograms"]}`)
+	defer server.Close()
+
+	client, err := NewWithWriter(&statsdWriterWrapper{}, WithoutTelemetry(), WithAgentCapabilityDetection(server.URL))
+	require.NoError(t, err)
+	defer client.Close()
+	assert.True(t, client.sketchInput)
+	assert.True(t, client.precomputed)
+	assert.NoError(t, client.DistributionSketch("latency", 1, 1, 1, 1, nil))
+}
+
+func TestClientAgentCapabilityDetectionFailure(t *testing.T) {
+	server := newAgentInfoServer(http.StatusInternalServerError, "")
+	server.Close()
+
+	client, err := NewWithWriter(&statsdWriterWrapper{}, WithoutTelemetry(), WithAgentCapabilityDetection(server.URL))
+	require.NoError(t, err)
+	defer client.Close()
+	assert.False(t, client.sketchInput)
+	assert.False(t, client.precomputed)
+}
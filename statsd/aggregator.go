@@ -1,6 +1,8 @@
 package statsd
 
 import (
+	"math/rand"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -29,6 +31,26 @@ type aggregator struct {
 	histograms    bufferedMetricContexts
 	distributions bufferedMetricContexts
 	timings       bufferedMetricContexts
+	// percentiles holds the values of HistogramPercentile, flushed as gauges of their percentile.
+	percentiles bufferedMetricContexts
+
+	// setSampleLimit is the maximum number of distinct values of each set
+	// kept per flush, see WithSetSampleLimit.
+	setSampleLimit int64
+	setRandom      *rand.Rand
+	setRandomLock  sync.Mutex
+
+	// gaugeTotals holds the running value of the gauges sent with GaugeDelta, per context. Unlike gauges it is not
+	// reset on flush. It is guarded by gaugesM.
+	gaugeTotals map[string]float64
+
+	// countTotals holds the counts flushed since PrometheusHandler was first called, per context without timestamp,
+	// for its counters not to restart from 0 on each flush. It is nil until then and is guarded by countsM.
+	countTotals countsMap
+
+	// ratesInterval is the interval aggregated counts are divided by to be sent as per second rates, see
+	// WithCountsAsRates. Counts are sent as is when it is 0.
+	ratesInterval time.Duration
 
 	closed chan struct{}
 
@@ -41,31 +63,74 @@ type aggregator struct {
 	inputMetrics    chan metric
 	stopChannelMode chan struct{}
 	wg              sync.WaitGroup
+	nbReceivers     int
 }
 
-func newAggregator(c *Client) *aggregator {
-	return &aggregator{
+func newAggregator(c *Client, maxSamplesPerContext int64, strategy MaxSamplesStrategy) *aggregator {
+	a := &aggregator{
 		client:          c,
 		counts:          countsMap{},
 		gauges:          gaugesMap{},
+		gaugeTotals:     map[string]float64{},
 		sets:            setsMap{},
 		histograms:      newBufferedContexts(newHistogramMetric),
 		distributions:   newBufferedContexts(newDistributionMetric),
 		timings:         newBufferedContexts(newTimingMetric),
+		percentiles:     newBufferedContexts(newHistogramMetric),
+		setRandom:       rand.New(rand.NewSource(time.Now().UnixNano())),
 		closed:          make(chan struct{}),
 		stopChannelMode: make(chan struct{}),
 	}
+
+	for _, bc := range []*bufferedMetricContexts{&a.histograms, &a.distributions, &a.timings, &a.percentiles} {
+		bc.maxSamples = maxSamplesPerContext
+		bc.strategy = strategy
+		bc.flushEarly = func(m metric) { a.client.sendBlocking(m) }
+	}
+	return a
 }
 
-func (a *aggregator) start(flushInterval time.Duration) {
-	ticker := time.NewTicker(flushInterval)
+// seedRandom replaces the random sources of the aggregator with ones seeded with seed, see WithSampleRNGSeed. It must
+// be called before the aggregator is started.
+func (a *aggregator) seedRandom(seed int64) {
+	a.setRandom = rand.New(rand.NewSource(seed))
+	for _, bc := range []*bufferedMetricContexts{&a.histograms, &a.distributions, &a.timings, &a.percentiles} {
+		bc.random = rand.New(rand.NewSource(seed))
+	}
+}
 
+// start flushes the aggregated metrics every flushInterval. When bufferedFlushInterval is set and differs from
+// flushInterval, histograms, distributions and timings are flushed on their own ticker every bufferedFlushInterval
+// instead.
+func (a *aggregator) start(flushInterval time.Duration, bufferedFlushInterval time.Duration) {
+	ticker := a.client.clock.NewTicker(flushInterval)
+
+	if bufferedFlushInterval == 0 || bufferedFlushInterval == flushInterval {
+		go func() {
+			for {
+				select {
+				case <-ticker.C():
+					a.flush()
+				case <-a.closed:
+					ticker.Stop()
+					return
+				}
+			}
+		}()
+		return
+	}
+
+	bufferedTicker := a.client.clock.NewTicker(bufferedFlushInterval)
 	go func() {
 		for {
 			select {
-			case <-ticker.C:
-				a.flush()
+			case <-ticker.C():
+				a.sendMetrics(a.flushSimpleMetrics(nil))
+			case <-bufferedTicker.C():
+				a.sendMetrics(a.flushBufferedMetrics(nil))
 			case <-a.closed:
+				ticker.Stop()
+				bufferedTicker.Stop()
 				return
 			}
 		}
@@ -74,6 +139,7 @@ func (a *aggregator) start(flushInterval time.Duration) {
 
 func (a *aggregator) startReceivingMetric(bufferSize int, nbWorkers int) {
 	a.inputMetrics = make(chan metric, bufferSize)
+	a.nbReceivers = nbWorkers
 	for i := 0; i < nbWorkers; i++ {
 		a.wg.Add(1)
 		go a.pullMetric()
@@ -89,17 +155,38 @@ func (a *aggregator) stop() {
 	a.closed <- struct{}{}
 }
 
+// waitForInputMetrics blocks until the metrics queued in inputMetrics before
+// the call have been sampled. A marker is queued for each goroutine pulling
+// metrics, the channel being FIFO they can only get it once done with the
+// metrics queued before. Concurrent calls must be serialized, see
+// Client.waitForInputMetrics.
+func (a *aggregator) waitForInputMetrics() {
+	var barrier sync.WaitGroup
+	barrier.Add(a.nbReceivers)
+	for i := 0; i < a.nbReceivers; i++ {
+		a.inputMetrics <- metric{barrier: &barrier}
+	}
+	barrier.Wait()
+}
+
 func (a *aggregator) pullMetric() {
 	for {
 		select {
 		case m := <-a.inputMetrics:
+			if m.barrier != nil {
+				// Wait for every goroutine to get its marker so none of them
+				// takes two while another one is still processing a metric.
+				m.barrier.Done()
+				m.barrier.Wait()
+				continue
+			}
 			switch m.metricType {
 			case histogram:
-				a.histogram(m.name, m.fvalue, m.tags, m.rate)
+				a.histogram(m.name, m.fvalue, m.tags, m.rate, m.cardinality)
 			case distribution:
-				a.distribution(m.name, m.fvalue, m.tags, m.rate)
+				a.distribution(m.name, m.fvalue, m.tags, m.rate, m.cardinality)
 			case timing:
-				a.timing(m.name, m.fvalue, m.tags, m.rate)
+				a.timing(m.name, m.fvalue, m.tags, m.rate, m.cardinality)
 			}
 		case <-a.stopChannelMode:
 			a.wg.Done()
@@ -109,7 +196,11 @@ func (a *aggregator) pullMetric() {
 }
 
 func (a *aggregator) flush() {
-	for _, m := range a.flushMetrics() {
+	a.sendMetrics(a.flushMetrics())
+}
+
+func (a *aggregator) sendMetrics(metrics []metric) {
+	for _, m := range metrics {
 		a.client.sendBlocking(m)
 	}
 }
@@ -123,14 +214,22 @@ func (a *aggregator) flushTelemetryMetrics(t *Telemetry) {
 	t.AggregationNbContextGauge = atomic.LoadUint64(&a.nbContextGauge)
 	t.AggregationNbContextCount = atomic.LoadUint64(&a.nbContextCount)
 	t.AggregationNbContextSet = atomic.LoadUint64(&a.nbContextSet)
-	t.AggregationNbContextHistogram = a.histograms.getNbContext()
+	t.AggregationNbContextHistogram = a.histograms.getNbContext() + a.percentiles.getNbContext()
 	t.AggregationNbContextDistribution = a.distributions.getNbContext()
 	t.AggregationNbContextTiming = a.timings.getNbContext()
+	t.AggregationNbDroppedSamples = a.histograms.getNbDroppedSamples() +
+		a.distributions.getNbDroppedSamples() +
+		a.timings.getNbDroppedSamples() +
+		a.percentiles.getNbDroppedSamples()
 }
 
 func (a *aggregator) flushMetrics() []metric {
-	metrics := []metric{}
+	metrics := a.flushSimpleMetrics([]metric{})
+	return a.flushBufferedMetrics(metrics)
+}
 
+// flushSimpleMetrics appends the aggregated sets, gauges and counts to metrics.
+func (a *aggregator) flushSimpleMetrics(metrics []metric) []metric {
 	// We reset the values to avoid sending 'zero' values for metrics not
 	// sampled during this flush interval
 
@@ -155,22 +254,41 @@ func (a *aggregator) flushMetrics() []metric {
 	a.countsM.Lock()
 	counts := a.counts
 	a.counts = countsMap{}
+	// Summing the totals before releasing the lock keeps the counters monotonic for a concurrent scrape.
+	if a.countTotals != nil {
+		for _, c := range counts {
+			context := withCardinality(getContext(c.name, c.tags), c.cardinality)
+			if total, found := a.countTotals[context]; found {
+				total.value += c.value
+			} else {
+				a.countTotals[context] = newCountMetric(c.name, c.value, c.tags, c.cardinality)
+			}
+		}
+	}
 	a.countsM.Unlock()
 
 	for _, c := range counts {
-		metrics = append(metrics, c.flushUnsafe())
+		if a.ratesInterval != 0 {
+			metrics = append(metrics, c.flushAsRateUnsafe(a.ratesInterval))
+		} else {
+			metrics = append(metrics, c.flushUnsafe())
+		}
 	}
 
-	metrics = a.histograms.flush(metrics)
-	metrics = a.distributions.flush(metrics)
-	metrics = a.timings.flush(metrics)
-
 	atomic.AddUint64(&a.nbContextCount, uint64(len(counts)))
 	atomic.AddUint64(&a.nbContextGauge, uint64(len(gauges)))
 	atomic.AddUint64(&a.nbContextSet, uint64(len(sets)))
 	return metrics
 }
 
+// flushBufferedMetrics appends the aggregated histograms, distributions, timings and percentiles to metrics.
+func (a *aggregator) flushBufferedMetrics(metrics []metric) []metric {
+	metrics = a.histograms.flush(metrics)
+	metrics = a.percentiles.flush(metrics)
+	metrics = a.distributions.flush(metrics)
+	return a.timings.flush(metrics)
+}
+
 func getContext(name string, tags []string) string {
 	return name + ":" + strings.Join(tags, tagSeparatorSymbol)
 }
@@ -180,8 +298,37 @@ func getContextAndTags(name string, tags []string) (string, string) {
 	return name + ":" + stringTags, stringTags
 }
 
-func (a *aggregator) count(name string, value int64, tags []string) error {
-	context := getContext(name, tags)
+// withRate keeps the values sampled with different rates in different contexts: the Agent upscales the values of a
+// message with its single rate.
+func withRate(context string, rate float64) string {
+	if rate >= 1 {
+		return context
+	}
+	return context + "|@" + strconv.FormatFloat(rate, 'f', -1, 64)
+}
+
+// withCardinality keeps the values sent with different cardinalities in different contexts.
+func withCardinality(context string, cardinality Cardinality) string {
+	if cardinality == CardinalityNotSet {
+		return context
+	}
+	return context + "|" + cardinality.String()
+}
+
+// count sums every value given, the rate of the call is ignored: the counts are flushed with a rate of 1 (see
+// countMetric.flushUnsafe) so sampling the calls would undercount them.
+func (a *aggregator) count(name string, value int64, tags []string, cardinality Cardinality) error {
+	return a.sampleCount(withCardinality(getContext(name, tags), cardinality), name, value, tags, noTimestamp, cardinality)
+}
+
+// countWithTimestamp aggregates the points of a timestamped count by second: the points of each second are summed and
+// flushed with that second as their timestamp.
+func (a *aggregator) countWithTimestamp(name string, value int64, tags []string, timestamp int64, cardinality Cardinality) error {
+	context := withCardinality(getContext(name, tags), cardinality) + "|T" + strconv.FormatInt(timestamp, 10)
+	return a.sampleCount(context, name, value, tags, timestamp, cardinality)
+}
+
+func (a *aggregator) sampleCount(context string, name string, value int64, tags []string, timestamp int64, cardinality Cardinality) error {
 	a.countsM.RLock()
 	if count, found := a.counts[context]; found {
 		count.sample(value)
@@ -198,13 +345,15 @@ func (a *aggregator) count(name string, value int64, tags []string) error {
 		return nil
 	}
 
-	a.counts[context] = newCountMetric(name, value, tags)
+	count := newCountMetric(name, value, tags, cardinality)
+	count.timestamp = timestamp
+	a.counts[context] = count
 	a.countsM.Unlock()
 	return nil
 }
 
-func (a *aggregator) gauge(name string, value float64, tags []string) error {
-	context := getContext(name, tags)
+func (a *aggregator) gauge(name string, value float64, tags []string, cardinality Cardinality) error {
+	context := withCardinality(getContext(name, tags), cardinality)
 	a.gaugesM.RLock()
 	if gauge, found := a.gauges[context]; found {
 		gauge.sample(value)
@@ -213,7 +362,7 @@ func (a *aggregator) gauge(name string, value float64, tags []string) error {
 	}
 	a.gaugesM.RUnlock()
 
-	gauge := newGaugeMetric(name, value, tags)
+	gauge := newGaugeMetric(name, value, tags, cardinality)
 
 	a.gaugesM.Lock()
 	// Check if another goroutines hasn't created the value betwen the 'RUnlock' and 'Lock'
@@ -227,8 +376,25 @@ func (a *aggregator) gauge(name string, value float64, tags []string) error {
 	return nil
 }
 
-func (a *aggregator) set(name string, value string, tags []string) error {
-	context := getContext(name, tags)
+// gaugeDelta adds delta to the running value of the gauge and samples the new value, see Client.GaugeDelta.
+func (a *aggregator) gaugeDelta(name string, delta float64, tags []string, cardinality Cardinality) error {
+	context := withCardinality(getContext(name, tags), cardinality)
+	// The running value and the sample are updated under the same lock so the last value flushed is always the
+	// latest running value.
+	a.gaugesM.Lock()
+	value := a.gaugeTotals[context] + delta
+	a.gaugeTotals[context] = value
+	if gauge, found := a.gauges[context]; found {
+		gauge.sample(value)
+	} else {
+		a.gauges[context] = newGaugeMetric(name, value, tags, cardinality)
+	}
+	a.gaugesM.Unlock()
+	return nil
+}
+
+func (a *aggregator) set(name string, value string, tags []string, cardinality Cardinality) error {
+	context := withCardinality(getContext(name, tags), cardinality)
 	a.setsM.RLock()
 	if set, found := a.sets[context]; found {
 		set.sample(value)
@@ -244,26 +410,67 @@ func (a *aggregator) set(name string, value string, tags []string) error {
 		a.setsM.Unlock()
 		return nil
 	}
-	a.sets[context] = newSetMetric(name, value, tags)
+	a.sets[context] = a.newSet(name, value, tags, cardinality)
 	a.setsM.Unlock()
 	return nil
 }
 
+// setMulti adds several values to a set, looking up its context once. Lock is held while sampling every value so none
+// of them can be added to a set that is being flushed.
+func (a *aggregator) setMulti(name string, values []string, tags []string, cardinality Cardinality) error {
+	context := withCardinality(getContext(name, tags), cardinality)
+	a.setsM.RLock()
+	if set, found := a.sets[context]; found {
+		for _, v := range values {
+			set.sample(v)
+		}
+		a.setsM.RUnlock()
+		return nil
+	}
+	a.setsM.RUnlock()
+
+	a.setsM.Lock()
+	set, found := a.sets[context]
+	if !found {
+		set = a.newSet(name, values[0], tags, cardinality)
+		a.sets[context] = set
+	}
+	for _, v := range values {
+		set.sample(v)
+	}
+	a.setsM.Unlock()
+	return nil
+}
+
+// newSet creates the context of a set with its first value, see WithSetSampleLimit.
+func (a *aggregator) newSet(name string, value string, tags []string, cardinality Cardinality) *setMetric {
+	set := newSetMetric(name, value, tags, cardinality)
+	set.maxValues = a.setSampleLimit
+	set.random = a.setRandom
+	set.randomLock = &a.setRandomLock
+	return set
+}
+
 // Only histograms, distributions and timings are sampled with a rate since we
 // only pack them in on message instead of aggregating them. Discarding the
 // sample rate will have impacts on the CPU and memory usage of the Agent.
 
 // type alias for Client.sendToAggregator
-type bufferedMetricSampleFunc func(name string, value float64, tags []string, rate float64) error
+type bufferedMetricSampleFunc func(name string, value float64, tags []string, rate float64, cardinality Cardinality) error
+
+func (a *aggregator) histogram(name string, value float64, tags []string, rate float64, cardinality Cardinality) error {
+	return a.histograms.sample(name, value, tags, rate, cardinality)
+}
 
-func (a *aggregator) histogram(name string, value float64, tags []string, rate float64) error {
-	return a.histograms.sample(name, value, tags, rate)
+func (a *aggregator) distribution(name string, value float64, tags []string, rate float64, cardinality Cardinality) error {
+	return a.distributions.sample(name, value, tags, rate, cardinality)
 }
 
-func (a *aggregator) distribution(name string, value float64, tags []string, rate float64) error {
-	return a.distributions.sample(name, value, tags, rate)
+func (a *aggregator) timing(name string, value float64, tags []string, rate float64, cardinality Cardinality) error {
+	return a.timings.sample(name, value, tags, rate, cardinality)
 }
 
-func (a *aggregator) timing(name string, value float64, tags []string, rate float64) error {
-	return a.timings.sample(name, value, tags, rate)
+// histogramPercentile samples a value of HistogramPercentile, name already holding the suffix of the percentile.
+func (a *aggregator) histogramPercentile(name string, value float64, tags []string, rate float64, percentile float64, cardinality Cardinality) error {
+	return a.percentiles.samplePercentile(name, value, tags, rate, cardinality, percentile)
 }
@@ -1,79 +1,83 @@
 package statsd
 
 import (
+	"fmt"
 	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestAggregatorSample(t *testing.T) {
-	a := newAggregator(nil)
+	a := newAggregator(nil, 0, MaxSamplesFlush)
 
 	tags := []string{"tag1", "tag2"}
 
 	for i := 0; i < 2; i++ {
-		a.gauge("gaugeTest", 21, tags)
+		a.gauge("gaugeTest", 21, tags, CardinalityNotSet)
 		assert.Len(t, a.gauges, 1)
 		assert.Contains(t, a.gauges, "gaugeTest:tag1,tag2")
 
-		a.count("countTest", 21, tags)
+		a.count("countTest", 21, tags, CardinalityNotSet)
 		assert.Len(t, a.counts, 1)
 		assert.Contains(t, a.counts, "countTest:tag1,tag2")
 
-		a.set("setTest", "value1", tags)
+		a.set("setTest", "value1", tags, CardinalityNotSet)
 		assert.Len(t, a.sets, 1)
 		assert.Contains(t, a.sets, "setTest:tag1,tag2")
 
-		a.set("setTest", "value1", tags)
+		a.set("setTest", "value1", tags, CardinalityNotSet)
 		assert.Len(t, a.sets, 1)
 		assert.Contains(t, a.sets, "setTest:tag1,tag2")
 
-		a.histogram("histogramTest", 21, tags, 1)
+		a.histogram("histogramTest", 21, tags, 1, CardinalityNotSet)
 		assert.Len(t, a.histograms.values, 1)
 		assert.Contains(t, a.histograms.values, "histogramTest:tag1,tag2")
 
-		a.distribution("distributionTest", 21, tags, 1)
+		a.distribution("distributionTest", 21, tags, 1, CardinalityNotSet)
 		assert.Len(t, a.distributions.values, 1)
 		assert.Contains(t, a.distributions.values, "distributionTest:tag1,tag2")
 
-		a.timing("timingTest", 21, tags, 1)
+		a.timing("timingTest", 21, tags, 1, CardinalityNotSet)
 		assert.Len(t, a.timings.values, 1)
 		assert.Contains(t, a.timings.values, "timingTest:tag1,tag2")
 	}
 }
 
 func TestAggregatorFlush(t *testing.T) {
-	a := newAggregator(nil)
+	a := newAggregator(nil, 0, MaxSamplesFlush)
 
 	tags := []string{"tag1", "tag2"}
 
-	a.gauge("gaugeTest1", 21, tags)
-	a.gauge("gaugeTest1", 10, tags)
-	a.gauge("gaugeTest2", 15, tags)
+	a.gauge("gaugeTest1", 21, tags, CardinalityNotSet)
+	a.gauge("gaugeTest1", 10, tags, CardinalityNotSet)
+	a.gauge("gaugeTest2", 15, tags, CardinalityNotSet)
 
-	a.count("countTest1", 21, tags)
-	a.count("countTest1", 10, tags)
-	a.count("countTest2", 1, tags)
+	a.count("countTest1", 21, tags, CardinalityNotSet)
+	a.count("countTest1", 10, tags, CardinalityNotSet)
+	a.count("countTest2", 1, tags, CardinalityNotSet)
 
-	a.set("setTest1", "value1", tags)
-	a.set("setTest1", "value1", tags)
-	a.set("setTest1", "value2", tags)
-	a.set("setTest2", "value1", tags)
+	a.set("setTest1", "value1", tags, CardinalityNotSet)
+	a.set("setTest1", "value1", tags, CardinalityNotSet)
+	a.set("setTest1", "value2", tags, CardinalityNotSet)
+	a.set("setTest2", "value1", tags, CardinalityNotSet)
 
-	a.histogram("histogramTest1", 21, tags, 1)
-	a.histogram("histogramTest1", 22, tags, 1)
-	a.histogram("histogramTest2", 23, tags, 1)
+	a.histogram("histogramTest1", 21, tags, 1, CardinalityNotSet)
+	a.histogram("histogramTest1", 22, tags, 1, CardinalityNotSet)
+	a.histogram("histogramTest2", 23, tags, 1, CardinalityNotSet)
 
-	a.distribution("distributionTest1", 21, tags, 1)
-	a.distribution("distributionTest1", 22, tags, 1)
-	a.distribution("distributionTest2", 23, tags, 1)
+	a.distribution("distributionTest1", 21, tags, 1, CardinalityNotSet)
+	a.distribution("distributionTest1", 22, tags, 1, CardinalityNotSet)
+	a.distribution("distributionTest2", 23, tags, 1, CardinalityNotSet)
 
-	a.timing("timingTest1", 21, tags, 1)
-	a.timing("timingTest1", 22, tags, 1)
-	a.timing("timingTest2", 23, tags, 1)
+	a.timing("timingTest1", 21, tags, 1, CardinalityNotSet)
+	a.timing("timingTest1", 22, tags, 1, CardinalityNotSet)
+	a.timing("timingTest2", 23, tags, 1, CardinalityNotSet)
 
 	metrics := a.flushMetrics()
 
@@ -196,7 +200,7 @@ func TestAggregatorFlush(t *testing.T) {
 }
 
 func TestAggregatorFlushConcurrency(t *testing.T) {
-	a := newAggregator(nil)
+	a := newAggregator(nil, 0, MaxSamplesFlush)
 
 	var wg sync.WaitGroup
 	wg.Add(10)
@@ -207,12 +211,12 @@ func TestAggregatorFlushConcurrency(t *testing.T) {
 		go func() {
 			defer wg.Done()
 
-			a.gauge("gaugeTest1", 21, tags)
-			a.count("countTest1", 21, tags)
-			a.set("setTest1", "value1", tags)
-			a.histogram("histogramTest1", 21, tags, 1)
-			a.distribution("distributionTest1", 21, tags, 1)
-			a.timing("timingTest1", 21, tags, 1)
+			a.gauge("gaugeTest1", 21, tags, CardinalityNotSet)
+			a.count("countTest1", 21, tags, CardinalityNotSet)
+			a.set("setTest1", "value1", tags, CardinalityNotSet)
+			a.histogram("histogramTest1", 21, tags, 1, CardinalityNotSet)
+			a.distribution("distributionTest1", 21, tags, 1, CardinalityNotSet)
+			a.timing("timingTest1", 21, tags, 1, CardinalityNotSet)
 		}()
 	}
 
@@ -226,3 +230,318 @@ func TestAggregatorFlushConcurrency(t *testing.T) {
 
 	wg.Wait()
 }
+
+func TestAggregatorFlushInterval(t *testing.T) {
+	clock := newFakeClock()
+	client, err := NewWithWriter(&statsdWriterWrapper{},
+		WithoutTelemetry(),
+		WithAggregationInterval(10*time.Second),
+		WithBufferFlushInterval(time.Hour),
+		withClock(clock),
+	)
+	require.Nil(t, err)
+	defer client.Close()
+
+	client.Gauge("gauge", 21, nil, 1)
+
+	clock.Advance(5 * time.Second)
+	assert.Equal(t, uint64(0), atomic.LoadUint64(&client.agg.nbContextGauge))
+
+	clock.Advance(5 * time.Second)
+	// The tick is sent synchronously, so this second tick can only be received once the first flush is done. Nothing
+	// was sampled in between so it must not have sent anything.
+	clock.Advance(10 * time.Second)
+	assert.Equal(t, uint64(1), atomic.LoadUint64(&client.agg.nbContextGauge))
+}
+
+func TestAggregatorIdleContextsAreNotFlushedAgain(t *testing.T) {
+	w := statsdWriterWrapper{}
+	clock := newFakeClock()
+	client, err := NewWithWriter(&w,
+		WithoutTelemetry(),
+		WithExtendedClientSideAggregation(),
+		WithAggregationInterval(10*time.Second),
+		WithBufferFlushInterval(time.Hour),
+		withClock(clock),
+	)
+	require.Nil(t, err)
+
+	client.Gauge("stale.gauge", 1, nil, 1)
+	client.Count("stale.count", 1, nil, 1)
+	client.Histogram("stale.histogram", 1, nil, 1)
+	client.Gauge("fresh.gauge", 1, nil, 1)
+	clock.Advance(10 * time.Second)
+
+	// The contexts are dropped by each flush: the ones not sampled again are not sent with their last value.
+	client.Gauge("fresh.gauge", 2, nil, 1)
+	clock.Advance(10 * time.Second)
+	clock.Advance(10 * time.Second)
+	require.Nil(t, client.Close())
+
+	ts := &testServer{}
+	ts.assertMetric(t, w.data, []string{
+		"stale.gauge:1|g",
+		"stale.count:1|c",
+		"stale.histogram:1|h",
+		"fresh.gauge:1|g",
+		"fresh.gauge:2|g",
+	})
+}
+
+func TestAggregatorCountsAsRates(t *testing.T) {
+	w := statsdWriterWrapper{}
+	clock := newFakeClock()
+	client, err := NewWithWriter(&w,
+		WithoutTelemetry(),
+		WithAggregationInterval(2*time.Second),
+		WithBufferFlushInterval(time.Hour),
+		WithCountsAsRates(),
+		withClock(clock),
+	)
+	require.Nil(t, err)
+
+	for i := 0; i < 10; i++ {
+		client.Incr("requests", []string{"tag:a"}, 1)
+	}
+	client.Count("bytes", 3, nil, 1)
+	client.Gauge("gauge", 10, nil, 1)
+	clock.Advance(2 * time.Second)
+	// the tick is sent synchronously: the second one is only received once the first flush is done
+	clock.Advance(2 * time.Second)
+	require.Nil(t, client.Close())
+
+	ts := &testServer{}
+	ts.assertMetric(t, w.data, []string{
+		"requests:5|g|#tag:a",
+		"bytes:1.5|g",
+		"gauge:10|g",
+	})
+}
+
+func TestAggregatorGaugeDelta(t *testing.T) {
+	w := statsdWriterWrapper{}
+	clock := newFakeClock()
+	client, err := NewWithWriter(&w,
+		WithoutTelemetry(),
+		WithAggregationInterval(2*time.Second),
+		WithBufferFlushInterval(time.Hour),
+		withClock(clock),
+	)
+	require.Nil(t, err)
+
+	for _, delta := range []float64{5, 3, -2} {
+		client.GaugeDelta("in_flight", delta, []string{"tag:a"}, 1)
+	}
+	client.GaugeDelta("in_flight", -1, []string{"tag:b"}, 1)
+	clock.Advance(2 * time.Second)
+
+	// the running value is kept across flushes, contexts without delta are not sent again
+	client.GaugeDelta("in_flight", -4, []string{"tag:a"}, 1)
+	client.GaugeDelta("in_flight", 0.5, []string{"tag:a"}, 1)
+	clock.Advance(2 * time.Second)
+	require.Nil(t, client.Close())
+
+	ts := &testServer{}
+	ts.assertMetric(t, w.data, []string{
+		"in_flight:6|g|#tag:a",
+		"in_flight:-1|g|#tag:b",
+		"in_flight:2.5|g|#tag:a",
+	})
+}
+
+func TestAggregatorGaugeDeltaWithoutAggregation(t *testing.T) {
+	client, err := NewWithWriter(&statsdWriterWrapper{}, WithoutTelemetry(), WithoutClientSideAggregation())
+	require.Nil(t, err)
+	defer client.Close()
+
+	assert.Equal(t, ErrGaugeDeltaWithoutAggregation, client.GaugeDelta("in_flight", 1, nil, 1))
+}
+
+func TestAggregatorHistogramFlushInterval(t *testing.T) {
+	clock := newFakeClock()
+	client, err := NewWithWriter(&statsdWriterWrapper{},
+		WithoutTelemetry(),
+		WithExtendedClientSideAggregation(),
+		WithAggregationInterval(2*time.Second),
+		WithHistogramFlushInterval(10*time.Second),
+		WithBufferFlushInterval(time.Hour),
+		withClock(clock),
+	)
+	require.Nil(t, err)
+	defer client.Close()
+
+	client.Gauge("gauge", 21, nil, 1)
+	client.Histogram("histogram", 21, nil, 1)
+
+	// Both tickers are read by the same goroutine and ticks are sent synchronously: a tick can only be received once
+	// the flush triggered by the previous one is done.
+	clock.Advance(2 * time.Second)
+	clock.Advance(2 * time.Second)
+	assert.Equal(t, uint64(1), atomic.LoadUint64(&client.agg.nbContextGauge))
+	assert.Equal(t, uint64(0), client.agg.histograms.getNbContext())
+
+	clock.Advance(6 * time.Second)
+	clock.Advance(2 * time.Second)
+	assert.Equal(t, uint64(1), atomic.LoadUint64(&client.agg.nbContextGauge))
+	assert.Equal(t, uint64(1), client.agg.histograms.getNbContext())
+}
+
+func TestAggregatorCountWithTimestamp(t *testing.T) {
+	a := newAggregator(nil, 0, MaxSamplesFlush)
+	tags := []string{"tag1"}
+
+	a.countWithTimestamp("count", 1, tags, 1658934956, CardinalityNotSet)
+	a.countWithTimestamp("count", 2, tags, 1658934956, CardinalityNotSet)
+	a.countWithTimestamp("count", 3, tags, 1658934957, CardinalityNotSet)
+	a.count("count", 4, tags, CardinalityNotSet)
+	assert.Len(t, a.counts, 3)
+	assert.Contains(t, a.counts, "count:tag1|T1658934956")
+	assert.Contains(t, a.counts, "count:tag1|T1658934957")
+	assert.Contains(t, a.counts, "count:tag1")
+
+	metrics := a.flushMetrics()
+	sort.Slice(metrics, func(i, j int) bool {
+		return metrics[i].timestamp < metrics[j].timestamp
+	})
+	assert.Equal(t, []metric{
+		{metricType: count, name: "count", tags: tags, rate: 1, ivalue: 4},
+		{metricType: count, name: "count", tags: tags, rate: 1, ivalue: 3, timestamp: 1658934956},
+		{metricType: count, name: "count", tags: tags, rate: 1, ivalue: 3, timestamp: 1658934957},
+	}, metrics)
+}
+
+func TestAggregatorSampleRate(t *testing.T) {
+	a := newAggregator(nil, 0, MaxSamplesFlush)
+	// keep all the values to only test the grouping
+	sampler := &recordingSampler{}
+	a.histograms.sampler = sampler
+	a.distributions.sampler = sampler
+	a.timings.sampler = sampler
+
+	tags := []string{"tag1"}
+	for _, rate := range []float64{0.1, 0.5, 0.1, 1} {
+		a.histogram("histogram", 1, tags, rate, CardinalityNotSet)
+		a.distribution("distribution", 2, tags, rate, CardinalityNotSet)
+		a.timing("timing", 3, tags, rate, CardinalityNotSet)
+	}
+
+	metrics := a.flushMetrics()
+	sort.Slice(metrics, func(i, j int) bool {
+		if metrics[i].metricType != metrics[j].metricType {
+			return metrics[i].metricType < metrics[j].metricType
+		}
+		return metrics[i].rate < metrics[j].rate
+	})
+
+	// the values sampled with different rates are not merged
+	expected := []metric{}
+	for _, m := range []struct {
+		mType metricType
+		name  string
+		value float64
+	}{{histogramAggregated, "histogram", 1}, {distributionAggregated, "distribution", 2}, {timingAggregated, "timing", 3}} {
+		expected = append(expected,
+			metric{metricType: m.mType, name: m.name, stags: "tag1", rate: 0.1, fvalues: []float64{m.value, m.value}},
+			metric{metricType: m.mType, name: m.name, stags: "tag1", rate: 0.5, fvalues: []float64{m.value}},
+			metric{metricType: m.mType, name: m.name, stags: "tag1", rate: 1, fvalues: []float64{m.value}},
+		)
+	}
+	assert.Equal(t, expected, metrics)
+}
+
+func TestAggregatorMaxSamplesPerContextFlush(t *testing.T) {
+	w := statsdWriterWrapper{}
+	client, err := NewWithWriter(&w,
+		WithoutTelemetry(),
+		WithExtendedClientSideAggregation(),
+		WithMaxSamplesPerContext(2, MaxSamplesFlush),
+	)
+	require.Nil(t, err)
+
+	for i := 1; i <= 5; i++ {
+		client.Histogram("histogram", float64(i), []string{"tag1"}, 1)
+	}
+	assert.Equal(t, uint64(0), client.agg.histograms.getNbDroppedSamples())
+	client.Close()
+
+	ts := &testServer{}
+	ts.assertMetric(t, w.data, []string{
+		"histogram:1:2|h|#tag1",
+		"histogram:3:4|h|#tag1",
+		"histogram:5|h|#tag1",
+	})
+}
+
+func TestAggregatorMaxSamplesPerContextReservoir(t *testing.T) {
+	w := statsdWriterWrapper{}
+	client, err := NewWithWriter(&w,
+		WithoutTelemetry(),
+		WithExtendedClientSideAggregation(),
+		WithMaxSamplesPerContext(10, MaxSamplesReservoir),
+	)
+	require.Nil(t, err)
+
+	for i := 1; i <= 100; i++ {
+		client.Distribution("distribution", float64(i), []string{"tag1"}, 1)
+	}
+
+	tlm := Telemetry{}
+	client.agg.flushTelemetryMetrics(&tlm)
+	assert.Equal(t, uint64(90), tlm.AggregationNbDroppedSamples)
+	client.Close()
+
+	require.Len(t, w.data, 1)
+	assert.Regexp(t, "^distribution(:[0-9]+){10}\\|d\\|@0.1\\|#tag1$", w.data[0])
+}
+
+func TestAggregatorSetUniqueValues(t *testing.T) {
+	w := statsdWriterWrapper{}
+	client, err := NewWithWriter(&w, WithoutTelemetry(), WithAggregationInterval(time.Hour))
+	require.Nil(t, err)
+
+	for i := 0; i < 100; i++ {
+		client.Set("set", "same", []string{"tag1"}, 1)
+		client.Set("set", fmt.Sprintf("value%d", i%3), []string{"tag1"}, 1)
+		client.SetInt("set", int64(i%2), []string{"tag1"}, 1)
+		client.Set("set", "same", []string{"tag2"}, 1)
+	}
+	client.Close()
+
+	// each distinct value of a context is sent once per flush
+	ts := &testServer{}
+	ts.assertMetric(t, w.data, []string{
+		"set:same|s|#tag1",
+		"set:value0|s|#tag1",
+		"set:value1|s|#tag1",
+		"set:value2|s|#tag1",
+		"set:0|s|#tag1",
+		"set:1|s|#tag1",
+		"set:same|s|#tag2",
+	})
+}
+
+func TestAggregatorSetSampleLimit(t *testing.T) {
+	w := statsdWriterWrapper{}
+	client, err := NewWithWriter(&w, WithoutTelemetry(), WithSetSampleLimit(10))
+	require.Nil(t, err)
+
+	for i := 0; i < 100; i++ {
+		client.Set("set", fmt.Sprintf("value%d", i), []string{"tag1"}, 1)
+		client.SetInt("other_set", int64(i%5), []string{"tag1"}, 1)
+	}
+	client.Close()
+
+	distinct := map[string]struct{}{}
+	nbOther := 0
+	for _, m := range w.data {
+		if strings.HasPrefix(m, "set:") {
+			assert.Regexp(t, "^set:value[0-9]+\\|s\\|#tag1$", m)
+			distinct[m] = struct{}{}
+		} else {
+			nbOther++
+		}
+	}
+	assert.Len(t, distinct, 10)
+	// sets under the limit are left untouched
+	assert.Equal(t, 5, nbOther)
+}
@@ -0,0 +1,88 @@
+package statsd
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Batch accumulates metrics into a buffer private to the batch, see Client.Batch. Its methods behave like the ones of
+// Client except that the metrics are never aggregated on the client side. A Batch is not safe for concurrent use and
+// must not be used after the function given to Client.Batch returns.
+type Batch struct {
+	client *Client
+	worker *worker
+}
+
+// Batch calls fn with a Batch and sends the metrics it accumulated once fn returns. The metrics of a batch are written
+// together: they land in the same payload, or in as few payloads as possible when they exceed WithMaxBytesPerPayload
+// or WithMaxMessagesPerPayload, and no metric from another goroutine is interleaved within those payloads.
+//
+// The batch buffer is not shared with other goroutines so the metrics are written without contending for the lock of
+// the client workers. They bypass the client side aggregation since aggregating them would split them again across
+// flushes; sampling still applies.
+func (c *Client) Batch(fn func(b *Batch)) {
+	if c == nil {
+		return
+	}
+	w := c.getBatchWorker()
+	b := &Batch{client: c, worker: w}
+	fn(b)
+	b.worker = nil
+	w.flush()
+	c.putBatchWorker(w)
+}
+
+func (b *Batch) send(name string, tags []string, parameters []Parameter, m metric) error {
+	c := b.client
+	m.name = name
+	m.tags = c.processTags(tags)
+	if err := c.validateMetric(name, m.tags); err != nil {
+		return err
+	}
+	m.globalTags = c.getGlobalTags().metricTags
+	m.namespace = c.resolveNamespace(parameters)
+	m.cardinality = c.resolveCardinality(parameters)
+	m.containerID = c.resolveContainerID(parameters)
+	return b.worker.processMetric(m)
+}
+
+// Gauge adds a gauge to the batch, see Client.Gauge.
+func (b *Batch) Gauge(name string, value float64, tags []string, rate float64, parameters ...Parameter) error {
+	atomic.AddUint64(&b.client.telemetry.totalMetricsGauge, 1)
+	return b.send(name, tags, parameters, metric{metricType: gauge, fvalue: value, rate: b.client.resolveRate(GaugeType, rate)})
+}
+
+// Count adds a count to the batch, see Client.Count.
+func (b *Batch) Count(name string, value int64, tags []string, rate float64, parameters ...Parameter) error {
+	atomic.AddUint64(&b.client.telemetry.totalMetricsCount, 1)
+	return b.send(name, tags, parameters, metric{metricType: count, ivalue: value, rate: b.client.resolveRate(CountType, rate)})
+}
+
+// Histogram adds a histogram value to the batch, see Client.Histogram.
+func (b *Batch) Histogram(name string, value float64, tags []string, rate float64, parameters ...Parameter) error {
+	atomic.AddUint64(&b.client.telemetry.totalMetricsHistogram, 1)
+	return b.send(name, tags, parameters, metric{metricType: histogram, fvalue: value, rate: b.client.resolveRate(HistogramType, rate)})
+}
+
+// Distribution adds a distribution value to the batch, see Client.Distribution.
+func (b *Batch) Distribution(name string, value float64, tags []string, rate float64, parameters ...Parameter) error {
+	atomic.AddUint64(&b.client.telemetry.totalMetricsDistribution, 1)
+	return b.send(name, tags, parameters, metric{metricType: distribution, fvalue: value, rate: b.client.resolveRate(DistributionType, rate)})
+}
+
+// Set adds a set value to the batch, see Client.Set.
+func (b *Batch) Set(name string, value string, tags []string, rate float64, parameters ...Parameter) error {
+	atomic.AddUint64(&b.client.telemetry.totalMetricsSet, 1)
+	return b.send(name, tags, parameters, metric{metricType: set, svalue: value, rate: b.client.resolveRate(SetType, rate)})
+}
+
+// Timing adds a timing to the batch, see Client.Timing.
+func (b *Batch) Timing(name string, value time.Duration, tags []string, rate float64, parameters ...Parameter) error {
+	return b.TimeInMilliseconds(name, value.Seconds()*1000, tags, rate, parameters...)
+}
+
+// TimeInMilliseconds adds a timing in milliseconds to the batch, see Client.TimeInMilliseconds.
+func (b *Batch) TimeInMilliseconds(name string, value float64, tags []string, rate float64, parameters ...Parameter) error {
+	atomic.AddUint64(&b.client.telemetry.totalMetricsTiming, 1)
+	return b.send(name, tags, parameters, metric{metricType: timing, fvalue: value, rate: b.client.resolveRate(TimingType, rate)})
+}
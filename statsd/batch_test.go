@@ -0,0 +1,97 @@
+package statsd
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBatchContiguous(t *testing.T) {
+	w := &payloadRecorder{}
+	client, err := NewWithWriter(w, WithoutTelemetry(), WithBufferFlushInterval(time.Millisecond))
+	require.Nil(t, err)
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					client.Count("other", 1, nil, 1)
+				}
+			}
+		}()
+	}
+
+	expected := []string{}
+	client.Batch(func(b *Batch) {
+		for i := 0; i < 20; i++ {
+			b.Gauge(fmt.Sprintf("batch.gauge%d", i), float64(i), []string{"tag"}, 1)
+			expected = append(expected, fmt.Sprintf("batch.gauge%d:%d|g|#tag", i, i))
+		}
+	})
+	close(stop)
+	wg.Wait()
+	require.Nil(t, client.Close())
+
+	found := 0
+	for _, payload := range w.payloads {
+		if strings.Contains(payload, "batch.") {
+			found++
+			assert.Equal(t, strings.Join(expected, "\n")+"\n", payload)
+		}
+	}
+	assert.Equal(t, 1, found)
+}
+
+func TestBatchSplit(t *testing.T) {
+	const maxBytes = 100
+	w := &payloadRecorder{}
+	client, err := NewWithWriter(w, WithoutTelemetry(), WithMaxBytesPerPayload(maxBytes), WithNamespace("ns."), WithTags([]string{"env:prod"}))
+	require.Nil(t, err)
+
+	client.Count("other", 1, nil, 1)
+	expected := []string{}
+	client.Batch(func(b *Batch) {
+		for i := 0; i < 10; i++ {
+			b.Count("batch.count", int64(i), nil, 1)
+			b.Histogram("batch.histogram", float64(i), nil, 1)
+			b.Timing("batch.timing", time.Duration(i)*time.Millisecond, nil, 1)
+			expected = append(expected,
+				fmt.Sprintf("ns.batch.count:%d|c|#env:prod", i),
+				fmt.Sprintf("ns.batch.histogram:%d|h|#env:prod", i),
+				fmt.Sprintf("ns.batch.timing:%d.000000|ms|#env:prod", i),
+			)
+		}
+	})
+	require.Nil(t, client.Close())
+
+	received := []string{}
+	for _, payload := range w.payloads {
+		assert.LessOrEqual(t, len(payload), maxBytes)
+		if !strings.Contains(payload, "batch.") {
+			continue
+		}
+		assert.NotContains(t, payload, "other")
+		received = append(received, strings.Split(strings.TrimSuffix(payload, "\n"), "\n")...)
+	}
+	assert.Greater(t, len(w.payloads), 2)
+	assert.Equal(t, expected, received)
+}
+
+func TestBatchNilClient(t *testing.T) {
+	var client *Client
+	called := false
+	client.Batch(func(b *Batch) { called = true })
+	assert.False(t, called)
+}
@@ -1,6 +1,7 @@
 package statsd
 
 import (
+	"bytes"
 	"strconv"
 )
 
@@ -18,61 +19,133 @@ const errPartialWrite = partialWriteError("value partially written")
 
 const metricOverhead = 512
 
+// bufferConfig describes how the buffers of a client serialize messages and where their memory comes from. It is
+// built once from the options by resolveOptions and shared by all the buffers of the client.
+type bufferConfig struct {
+	// separator terminates each message, see WithRecordSeparator.
+	separator byte
+	// floatPrecision is the number of decimals float values are rounded to, see WithFloatPrecision. -1 keeps them as
+	// is.
+	floatPrecision int
+	// statsd is set by WithStatsdFormat to drop the DogStatsD extensions from the metrics.
+	statsd *statsdFormat
+	// tagOrder is the order of the global tags and the tags of the call in metrics, see WithTagOrder.
+	tagOrder TagOrder
+	// alwaysRate is set by WithAlwaysEmitSampleRate to write the rate of the metrics sent with a rate of 1.
+	alwaysRate bool
+	// allocate and release back the buffers with the memory of the user, see WithBufferAllocator.
+	allocate func(size int) []byte
+	release  func([]byte)
+	// containerID is the container ID found by WithOriginDetection, sent with every message.
+	containerID string
+	// externalEnv is the external data sent with every message, see WithExternalData.
+	externalEnv string
+}
+
+// messageContainerID returns the container ID to send with a message: the one given to the call through the
+// ContainerID parameter if any, the one of the client otherwise.
+func (c *bufferConfig) messageContainerID(override string) string {
+	if override != "" {
+		return override
+	}
+	return c.containerID
+}
+
+// defaultBufferConfig is the configuration of the buffers of a client created without options.
+var defaultBufferConfig = bufferConfig{
+	separator:      defaultRecordSeparator,
+	floatPrecision: defaultFloatPrecision,
+	tagOrder:       defaultTagOrder,
+}
+
 // statsdBuffer is a buffer containing statsd messages
 // this struct methods are NOT safe for concurent use
 type statsdBuffer struct {
+	bufferConfig
 	buffer       []byte
 	maxSize      int
 	maxElements  int
 	elementCount int
+	// allocated is the memory given by the allocator of WithBufferAllocator, handed back to its releaser when the
+	// buffer is discarded. buffer starts on it but is re-allocated by Go if a message does not fit.
+	allocated []byte
 }
 
 func newStatsdBuffer(maxSize, maxElements int) *statsdBuffer {
+	return newStatsdBufferWith(make([]byte, 0, maxSize+metricOverhead), maxSize, maxElements) // pre-allocate the needed size + metricOverhead to avoid having Go re-allocate on it's own if an element does not fit
+}
+
+// newStatsdBufferWith creates a buffer writing into the given memory, see WithBufferAllocator.
+func newStatsdBufferWith(buffer []byte, maxSize, maxElements int) *statsdBuffer {
 	return &statsdBuffer{
-		buffer:      make([]byte, 0, maxSize+metricOverhead), // pre-allocate the needed size + metricOverhead to avoid having Go re-allocate on it's own if an element does not fit
-		maxSize:     maxSize,
-		maxElements: maxElements,
+		bufferConfig: defaultBufferConfig,
+		buffer:       buffer,
+		maxSize:      maxSize,
+		maxElements:  maxElements,
 	}
 }
 
-func (b *statsdBuffer) writeGauge(namespace string, globalTags []string, name string, value float64, tags []string, rate float64) error {
+func (b *statsdBuffer) writeGauge(namespace string, globalTags []string, name string, value float64, tags []string, rate float64, timestamp int64, cardinality Cardinality, containerID string) error {
 	if b.elementCount >= b.maxElements {
 		return errBufferFull
 	}
 	originalBuffer := b.buffer
-	b.buffer = appendGauge(b.buffer, namespace, globalTags, name, value, tags, rate)
+	if b.statsd != nil {
+		name, rate = b.statsd.metric(name, globalTags, tags, "", rate, false)
+		globalTags, tags = nil, nil
+	}
+	first, second := b.orderTags(globalTags, tags)
+	b.buffer = appendGauge(b.buffer, namespace, first, name, b.roundFloat(value), second, rate, b.alwaysRate)
+	b.appendExtensions(timestamp, containerID, cardinality)
 	b.writeSeparator()
 	return b.validateNewElement(originalBuffer)
 }
 
-func (b *statsdBuffer) writeCount(namespace string, globalTags []string, name string, value int64, tags []string, rate float64) error {
+func (b *statsdBuffer) writeCount(namespace string, globalTags []string, name string, value int64, tags []string, rate float64, timestamp int64, cardinality Cardinality, containerID string) error {
 	if b.elementCount >= b.maxElements {
 		return errBufferFull
 	}
 	originalBuffer := b.buffer
-	b.buffer = appendCount(b.buffer, namespace, globalTags, name, value, tags, rate)
+	if b.statsd != nil {
+		name, rate = b.statsd.metric(name, globalTags, tags, "", rate, true)
+		globalTags, tags = nil, nil
+	}
+	first, second := b.orderTags(globalTags, tags)
+	b.buffer = appendCount(b.buffer, namespace, first, name, value, second, rate, b.alwaysRate)
+	b.appendExtensions(timestamp, containerID, cardinality)
 	b.writeSeparator()
 	return b.validateNewElement(originalBuffer)
 }
 
-func (b *statsdBuffer) writeHistogram(namespace string, globalTags []string, name string, value float64, tags []string, rate float64) error {
+func (b *statsdBuffer) writeHistogram(namespace string, globalTags []string, name string, value float64, tags []string, rate float64, cardinality Cardinality, containerID string) error {
 	if b.elementCount >= b.maxElements {
 		return errBufferFull
 	}
 	originalBuffer := b.buffer
-	b.buffer = appendHistogram(b.buffer, namespace, globalTags, name, value, tags, rate)
+	if b.statsd != nil {
+		name, rate = b.statsd.metric(name, globalTags, tags, "", rate, false)
+		globalTags, tags = nil, nil
+	}
+	first, second := b.orderTags(globalTags, tags)
+	b.buffer = appendHistogram(b.buffer, namespace, first, name, b.roundFloat(value), second, rate, b.alwaysRate)
+	b.appendExtensions(0, containerID, cardinality)
 	b.writeSeparator()
 	return b.validateNewElement(originalBuffer)
 }
 
 // writeAggregated serialized as many values as possible in the current buffer and return the position in values where it stopped.
-func (b *statsdBuffer) writeAggregated(metricSymbol []byte, namespace string, globalTags []string, name string, values []float64, tags string, tagSize int, precision int) (int, error) {
+func (b *statsdBuffer) writeAggregated(metricSymbol []byte, namespace string, globalTags []string, name string, values []float64, tags string, tagSize int, precision int, rate float64, cardinality Cardinality, containerID string) (int, error) {
 	if b.elementCount >= b.maxElements {
 		return 0, errBufferFull
 	}
 
 	originalBuffer := b.buffer
+	if b.statsd != nil {
+		name, rate = b.statsd.metric(name, globalTags, nil, tags, rate, bytes.Equal(metricSymbol, timingSymbol))
+		globalTags, tags = nil, ""
+	}
 	b.buffer = appendHeader(b.buffer, namespace, name)
+	precision = b.precision(precision)
 
 	// buffer already full
 	if len(b.buffer)+tagSize > b.maxSize {
@@ -88,7 +161,7 @@ func (b *statsdBuffer) writeAggregated(metricSymbol []byte, namespace string, gl
 			b.buffer = append(b.buffer, ':')
 		}
 
-		b.buffer = strconv.AppendFloat(b.buffer, v, 'f', precision, 64)
+		b.buffer = strconv.AppendFloat(b.buffer, b.roundFloat(v), 'f', precision, 64)
 
 		// Should we stop serializing and switch to another buffer
 		if len(b.buffer)+tagSize > b.maxSize {
@@ -106,7 +179,14 @@ func (b *statsdBuffer) writeAggregated(metricSymbol []byte, namespace string, gl
 
 	b.buffer = append(b.buffer, '|')
 	b.buffer = append(b.buffer, metricSymbol...)
-	b.buffer = appendTagsAggregated(b.buffer, globalTags, tags)
+	b.buffer = appendRate(b.buffer, rate, b.alwaysRate)
+	if b.tagOrder == TagOrderCallFirst && tags != "" {
+		callTags := [1]string{tags}
+		b.buffer = appendTags(b.buffer, callTags[:], globalTags)
+	} else {
+		b.buffer = appendTagsAggregated(b.buffer, globalTags, tags)
+	}
+	b.appendExtensions(0, containerID, cardinality)
 	b.writeSeparator()
 	b.elementCount++
 
@@ -117,52 +197,119 @@ func (b *statsdBuffer) writeAggregated(metricSymbol []byte, namespace string, gl
 
 }
 
-func (b *statsdBuffer) writeDistribution(namespace string, globalTags []string, name string, value float64, tags []string, rate float64) error {
+func (b *statsdBuffer) writeDistribution(namespace string, globalTags []string, name string, value float64, tags []string, rate float64, cardinality Cardinality, containerID string) error {
 	if b.elementCount >= b.maxElements {
 		return errBufferFull
 	}
 	originalBuffer := b.buffer
-	b.buffer = appendDistribution(b.buffer, namespace, globalTags, name, value, tags, rate)
+	if b.statsd != nil {
+		name, rate = b.statsd.metric(name, globalTags, tags, "", rate, false)
+		globalTags, tags = nil, nil
+	}
+	first, second := b.orderTags(globalTags, tags)
+	b.buffer = appendDistribution(b.buffer, namespace, first, name, b.roundFloat(value), second, rate, b.alwaysRate)
+	b.appendExtensions(0, containerID, cardinality)
 	b.writeSeparator()
 	return b.validateNewElement(originalBuffer)
 }
 
-func (b *statsdBuffer) writeSet(namespace string, globalTags []string, name string, value string, tags []string, rate float64) error {
+func (b *statsdBuffer) writeSet(namespace string, globalTags []string, name string, value string, tags []string, rate float64, cardinality Cardinality, containerID string) error {
 	if b.elementCount >= b.maxElements {
 		return errBufferFull
 	}
 	originalBuffer := b.buffer
-	b.buffer = appendSet(b.buffer, namespace, globalTags, name, value, tags, rate)
+	if b.statsd != nil {
+		name, rate = b.statsd.metric(name, globalTags, tags, "", rate, false)
+		globalTags, tags = nil, nil
+	}
+	first, second := b.orderTags(globalTags, tags)
+	b.buffer = appendSet(b.buffer, namespace, first, name, value, second, rate, b.alwaysRate)
+	b.appendExtensions(0, containerID, cardinality)
+	b.writeSeparator()
+	return b.validateNewElement(originalBuffer)
+}
+
+func (b *statsdBuffer) writeSetInt(namespace string, globalTags []string, name string, value int64, tags []string, rate float64, cardinality Cardinality, containerID string) error {
+	if b.elementCount >= b.maxElements {
+		return errBufferFull
+	}
+	originalBuffer := b.buffer
+	if b.statsd != nil {
+		name, rate = b.statsd.metric(name, globalTags, tags, "", rate, false)
+		globalTags, tags = nil, nil
+	}
+	first, second := b.orderTags(globalTags, tags)
+	b.buffer = appendSetInt(b.buffer, namespace, first, name, value, second, rate, b.alwaysRate)
+	b.appendExtensions(0, containerID, cardinality)
 	b.writeSeparator()
 	return b.validateNewElement(originalBuffer)
 }
 
-func (b *statsdBuffer) writeTiming(namespace string, globalTags []string, name string, value float64, tags []string, rate float64) error {
+func (b *statsdBuffer) writeTiming(namespace string, globalTags []string, name string, value float64, tags []string, rate float64, cardinality Cardinality, containerID string) error {
 	if b.elementCount >= b.maxElements {
 		return errBufferFull
 	}
 	originalBuffer := b.buffer
-	b.buffer = appendTiming(b.buffer, namespace, globalTags, name, value, tags, rate)
+	if b.statsd != nil {
+		name, rate = b.statsd.metric(name, globalTags, tags, "", rate, true)
+		globalTags, tags = nil, nil
+	}
+	first, second := b.orderTags(globalTags, tags)
+	b.buffer = appendTiming(b.buffer, namespace, first, name, b.roundFloat(value), second, rate, b.precision(timingPrecision), b.alwaysRate)
+	b.appendExtensions(0, containerID, cardinality)
 	b.writeSeparator()
 	return b.validateNewElement(originalBuffer)
 }
 
-func (b *statsdBuffer) writeEvent(event *Event, globalTags []string) error {
+func (b *statsdBuffer) writeDistributionSketch(namespace string, globalTags []string, name string, count int64, sum float64, min float64, max float64, tags []string, cardinality Cardinality, containerID string) error {
+	if b.statsd != nil {
+		return ErrStatsdFormat
+	}
+	if b.elementCount >= b.maxElements {
+		return errBufferFull
+	}
+	originalBuffer := b.buffer
+	first, second := b.orderTags(globalTags, tags)
+	b.buffer = appendDistributionSketch(b.buffer, namespace, first, name, count, b.roundFloat(sum), b.roundFloat(min), b.roundFloat(max), second)
+	b.appendExtensions(0, containerID, cardinality)
+	b.writeSeparator()
+	return b.validateNewElement(originalBuffer)
+}
+
+func (b *statsdBuffer) writeEvent(event *Event, globalTags []string, cardinality Cardinality) error {
+	if b.statsd != nil {
+		return ErrStatsdFormat
+	}
 	if b.elementCount >= b.maxElements {
 		return errBufferFull
 	}
 	originalBuffer := b.buffer
 	b.buffer = appendEvent(b.buffer, event, globalTags)
+	b.appendExtensions(0, "", cardinality)
 	b.writeSeparator()
 	return b.validateNewElement(originalBuffer)
 }
 
-func (b *statsdBuffer) writeServiceCheck(serviceCheck *ServiceCheck, globalTags []string) error {
+func (b *statsdBuffer) writeServiceCheck(serviceCheck *ServiceCheck, globalTags []string, cardinality Cardinality) error {
+	if b.statsd != nil {
+		return ErrStatsdFormat
+	}
 	if b.elementCount >= b.maxElements {
 		return errBufferFull
 	}
 	originalBuffer := b.buffer
 	b.buffer = appendServiceCheck(b.buffer, serviceCheck, globalTags)
+	b.appendExtensions(0, "", cardinality)
+	b.writeSeparator()
+	return b.validateNewElement(originalBuffer)
+}
+
+func (b *statsdBuffer) writeRaw(message string) error {
+	if b.elementCount >= b.maxElements {
+		return errBufferFull
+	}
+	originalBuffer := b.buffer
+	b.buffer = append(b.buffer, message...)
 	b.writeSeparator()
 	return b.validateNewElement(originalBuffer)
 }
@@ -176,8 +323,44 @@ func (b *statsdBuffer) validateNewElement(originalBuffer []byte) error {
 	return nil
 }
 
+// roundFloat rounds value to the number of decimals set by WithFloatPrecision, if any.
+func (b *statsdBuffer) roundFloat(value float64) float64 {
+	if b.floatPrecision < 0 {
+		return value
+	}
+	return roundFloat(value, b.floatPrecision)
+}
+
+// precision returns the precision to format float values with: defaultPrecision unless WithFloatPrecision is used, in
+// which case the values are already rounded and formatted with the fewest digits needed.
+func (b *statsdBuffer) precision(defaultPrecision int) int {
+	if b.floatPrecision < 0 {
+		return defaultPrecision
+	}
+	return -1
+}
+
+// orderTags returns the global tags and the tags of a metric in the order they are serialized in, see WithTagOrder.
+func (b *statsdBuffer) orderTags(globalTags []string, tags []string) ([]string, []string) {
+	if b.tagOrder == TagOrderCallFirst {
+		return tags, globalTags
+	}
+	return globalTags, tags
+}
+
+// appendExtensions appends the DogStatsD fields following the tags of a message, unless WithStatsdFormat is used.
+func (b *statsdBuffer) appendExtensions(timestamp int64, containerID string, cardinality Cardinality) {
+	if b.statsd != nil {
+		return
+	}
+	b.buffer = appendTimestamp(b.buffer, timestamp)
+	b.buffer = appendContainerID(b.buffer, b.messageContainerID(containerID))
+	b.buffer = appendExternalEnv(b.buffer, b.externalEnv)
+	b.buffer = appendCardinality(b.buffer, cardinality)
+}
+
 func (b *statsdBuffer) writeSeparator() {
-	b.buffer = append(b.buffer, '\n')
+	b.buffer = append(b.buffer, b.separator)
 }
 
 func (b *statsdBuffer) reset() {
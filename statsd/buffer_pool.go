@@ -1,16 +1,49 @@
 package statsd
 
+import "sync/atomic"
+
+// BufferPoolStats describes the use of the pool of buffers of a client, see Client.BufferPoolStats. A high number of
+// misses compared to gets means the pool is too small for the load, see WithBufferPoolSize.
+type BufferPoolStats struct {
+	// Capacity is the number of buffers the pool can hold.
+	Capacity int
+	// Available is the number of buffers currently in the pool.
+	Available int
+	// Gets is the number of buffers borrowed from the pool since the client started.
+	Gets uint64
+	// Puts is the number of buffers returned to the pool since the client started. Buffers returned to a full pool
+	// are counted but discarded.
+	Puts uint64
+	// Misses is the number of buffers allocated because the pool was empty when borrowing one.
+	Misses uint64
+}
+
 type bufferPool struct {
+	// The counters are first to be 64 bits aligned for atomic operations on 32 bits architectures.
+	gets   uint64
+	puts   uint64
+	misses uint64
+
 	pool              chan *statsdBuffer
 	bufferMaxSize     int
 	bufferMaxElements int
+	config            bufferConfig
+	// closed is set once the client is closed: the buffers returned after that are released instead of pooled.
+	closed int32
 }
 
 func newBufferPool(poolSize, bufferMaxSize, bufferMaxElements int) *bufferPool {
+	return newBufferPoolWithConfig(poolSize, bufferMaxSize, bufferMaxElements, defaultBufferConfig)
+}
+
+// newBufferPoolWithConfig creates a pool whose buffers serialize messages and get their memory as described by
+// config, see bufferConfig.
+func newBufferPoolWithConfig(poolSize, bufferMaxSize, bufferMaxElements int, config bufferConfig) *bufferPool {
 	p := &bufferPool{
 		pool:              make(chan *statsdBuffer, poolSize),
 		bufferMaxSize:     bufferMaxSize,
 		bufferMaxElements: bufferMaxElements,
+		config:            config,
 	}
 	for i := 0; i < poolSize; i++ {
 		p.addNewBuffer()
@@ -19,22 +52,75 @@ func newBufferPool(poolSize, bufferMaxSize, bufferMaxElements int) *bufferPool {
 }
 
 func (p *bufferPool) addNewBuffer() {
-	p.pool <- newStatsdBuffer(p.bufferMaxSize, p.bufferMaxElements)
+	p.pool <- p.newBuffer()
+}
+
+func (p *bufferPool) newBuffer() *statsdBuffer {
+	var b *statsdBuffer
+	if p.config.allocate != nil {
+		allocated := p.config.allocate(p.bufferMaxSize + metricOverhead)
+		b = newStatsdBufferWith(allocated[:0], p.bufferMaxSize, p.bufferMaxElements)
+		b.allocated = allocated
+	} else {
+		b = newStatsdBuffer(p.bufferMaxSize, p.bufferMaxElements)
+	}
+	b.bufferConfig = p.config
+	return b
+}
+
+// discard hands the memory of a buffer the pool doesn't keep back to the releaser of WithBufferAllocator.
+func (p *bufferPool) discard(buffer *statsdBuffer) {
+	if p.config.release != nil && buffer.allocated != nil {
+		p.config.release(buffer.allocated)
+		buffer.allocated = nil
+		buffer.buffer = nil
+	}
 }
 
 func (p *bufferPool) borrowBuffer() *statsdBuffer {
+	atomic.AddUint64(&p.gets, 1)
 	select {
 	case b := <-p.pool:
 		return b
 	default:
-		return newStatsdBuffer(p.bufferMaxSize, p.bufferMaxElements)
+		atomic.AddUint64(&p.misses, 1)
+		return p.newBuffer()
 	}
 }
 
 func (p *bufferPool) returnBuffer(buffer *statsdBuffer) {
+	atomic.AddUint64(&p.puts, 1)
 	buffer.reset()
+	if atomic.LoadInt32(&p.closed) == 1 {
+		p.discard(buffer)
+		return
+	}
 	select {
 	case p.pool <- buffer:
 	default:
+		p.discard(buffer)
+	}
+}
+
+// close releases the buffers of the pool, and the ones returned after that, when the client is closed.
+func (p *bufferPool) close() {
+	atomic.StoreInt32(&p.closed, 1)
+	for {
+		select {
+		case b := <-p.pool:
+			p.discard(b)
+		default:
+			return
+		}
+	}
+}
+
+func (p *bufferPool) stats() BufferPoolStats {
+	return BufferPoolStats{
+		Capacity:  cap(p.pool),
+		Available: len(p.pool),
+		Gets:      atomic.LoadUint64(&p.gets),
+		Puts:      atomic.LoadUint64(&p.puts),
+		Misses:    atomic.LoadUint64(&p.misses),
 	}
 }
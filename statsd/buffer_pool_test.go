@@ -1,9 +1,11 @@
 package statsd
 
 import (
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestBufferPoolSize(t *testing.T) {
@@ -33,7 +35,7 @@ func TestBufferPoolEmpty(t *testing.T) {
 func TestBufferReturn(t *testing.T) {
 	bufferPool := newBufferPool(1, 1024, 20)
 	buffer := bufferPool.borrowBuffer()
-	buffer.writeCount("", nil, "", 1, nil, 1)
+	buffer.writeCount("", nil, "", 1, nil, 1, 0, CardinalityNotSet, "")
 
 	assert.Equal(t, 0, len(bufferPool.pool))
 	bufferPool.returnBuffer(buffer)
@@ -41,3 +43,149 @@ func TestBufferReturn(t *testing.T) {
 	buffer = bufferPool.borrowBuffer()
 	assert.Equal(t, 0, len(buffer.bytes()))
 }
+
+func TestBufferPoolStats(t *testing.T) {
+	bufferPool := newBufferPool(2, 1024, 20)
+	assert.Equal(t, BufferPoolStats{Capacity: 2, Available: 2}, bufferPool.stats())
+
+	b1 := bufferPool.borrowBuffer()
+	b2 := bufferPool.borrowBuffer()
+	assert.Equal(t, BufferPoolStats{Capacity: 2, Available: 0, Gets: 2}, bufferPool.stats())
+
+	// the pool is drained: a new buffer has to be allocated
+	b3 := bufferPool.borrowBuffer()
+	assert.Equal(t, BufferPoolStats{Capacity: 2, Available: 0, Gets: 3, Misses: 1}, bufferPool.stats())
+
+	bufferPool.returnBuffer(b1)
+	bufferPool.returnBuffer(b2)
+	// returned to a full pool, the buffer is discarded
+	bufferPool.returnBuffer(b3)
+	assert.Equal(t, BufferPoolStats{Capacity: 2, Available: 2, Gets: 3, Puts: 3, Misses: 1}, bufferPool.stats())
+}
+
+// countingAllocator allocates buffers from the Go heap, counting the allocations and keeping the released slices.
+type countingAllocator struct {
+	sync.Mutex
+	sizes    []int
+	released [][]byte
+}
+
+func (a *countingAllocator) allocate(size int) []byte {
+	a.Lock()
+	defer a.Unlock()
+	a.sizes = append(a.sizes, size)
+	return make([]byte, size)
+}
+
+func (a *countingAllocator) release(b []byte) {
+	a.Lock()
+	defer a.Unlock()
+	a.released = append(a.released, b)
+}
+
+func TestBufferPoolAllocator(t *testing.T) {
+	a := &countingAllocator{}
+	config := defaultBufferConfig
+	config.allocate, config.release = a.allocate, a.release
+	bufferPool := newBufferPoolWithConfig(1, 1024, 20, config)
+	// the buffers filling the pool are allocated right away
+	assert.Equal(t, []int{1024 + metricOverhead}, a.sizes)
+
+	b1 := bufferPool.borrowBuffer()
+	// the pool is empty: the allocator is called on the miss
+	b2 := bufferPool.borrowBuffer()
+	assert.Equal(t, []int{1024 + metricOverhead, 1024 + metricOverhead}, a.sizes)
+	assert.Equal(t, 0, len(b2.bytes()))
+	assert.Equal(t, 1024+metricOverhead, cap(b2.bytes()))
+
+	b2.writeCount("", nil, "metric", 1, nil, 1, 0, CardinalityNotSet, "")
+	assert.Equal(t, "metric:1|c\n", string(b2.bytes()))
+
+	bufferPool.returnBuffer(b1)
+	assert.Empty(t, a.released)
+	// returned to a full pool, the buffer is released
+	allocated := b2.allocated
+	bufferPool.returnBuffer(b2)
+	require.Len(t, a.released, 1)
+	assert.Equal(t, &allocated[0], &a.released[0][0])
+
+	// the buffers in the pool and the ones returned after are released once closed
+	b3 := bufferPool.borrowBuffer()
+	bufferPool.close()
+	bufferPool.returnBuffer(b3)
+	assert.Len(t, a.released, 2)
+	assert.Equal(t, 0, len(bufferPool.pool))
+}
+
+func TestClientBufferAllocator(t *testing.T) {
+	a := &countingAllocator{}
+	w := statsdWriterWrapper{}
+	client, err := NewWithWriter(&w, WithoutTelemetry(), WithBufferPoolSize(1), WithWorkersCount(1), WithBufferAllocator(a.allocate, a.release))
+	require.Nil(t, err)
+
+	for i := 0; i < 10; i++ {
+		client.Count("count", 1, nil, 1)
+		client.Flush()
+	}
+	client.Close()
+
+	assert.NotEmpty(t, w.data)
+	a.Lock()
+	defer a.Unlock()
+	// every buffer allocated is released, including the one of the worker
+	assert.Equal(t, len(a.sizes), len(a.released))
+}
+
+func TestClientBufferAllocatorReleasesEveryBuffer(t *testing.T) {
+	a := &countingAllocator{}
+	w := statsdWriterWrapper{}
+	client, err := NewWithWriter(&w, WithoutTelemetry(), WithBufferPoolSize(2), WithWorkersCount(2), WithoutClientSideAggregation(), WithBufferAllocator(a.allocate, a.release))
+	require.Nil(t, err)
+
+	// the batches and GaugeNegative borrow buffers from the pool for their workers
+	for i := 0; i < 10; i++ {
+		client.Batch(func(b *Batch) {
+			b.Count("count", 1, nil, 1)
+		})
+		client.GaugeNegative("gauge", -1, nil, 1)
+		client.Count("count", 1, nil, 1)
+	}
+	client.Close()
+
+	assert.NotEmpty(t, w.data)
+	a.Lock()
+	defer a.Unlock()
+	assert.Equal(t, len(a.sizes), len(a.released))
+}
+
+func BenchmarkBufferPoolMiss(b *testing.B) {
+	// the memory of the discarded buffers is reused through a sync.Pool
+	var memory sync.Pool
+	allocate := func(size int) []byte {
+		if m, ok := memory.Get().(*[]byte); ok {
+			return *m
+		}
+		return make([]byte, size)
+	}
+	release := func(m []byte) {
+		memory.Put(&m)
+	}
+	config := defaultBufferConfig
+	config.allocate, config.release = allocate, release
+	pools := map[string]*bufferPool{
+		"default":   newBufferPool(1, 8192, 1024),
+		"allocator": newBufferPoolWithConfig(1, 8192, 1024, config),
+	}
+	for name, pool := range pools {
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				// the second buffer is a miss, it is then returned to a full pool
+				b1 := pool.borrowBuffer()
+				b2 := pool.borrowBuffer()
+				pool.returnBuffer(b1)
+				pool.returnBuffer(b2)
+			}
+		})
+	}
+}
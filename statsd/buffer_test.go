@@ -8,86 +8,118 @@ import (
 
 func TestBufferGauge(t *testing.T) {
 	buffer := newStatsdBuffer(1024, 1)
-	err := buffer.writeGauge("namespace.", []string{"tag:tag"}, "metric", 1, []string{}, 1)
+	err := buffer.writeGauge("namespace.", []string{"tag:tag"}, "metric", 1, []string{}, 1, 0, CardinalityNotSet, "")
 	assert.Nil(t, err)
 	assert.Equal(t, "namespace.metric:1|g|#tag:tag\n", string(buffer.bytes()))
 }
 
 func TestBufferCount(t *testing.T) {
 	buffer := newStatsdBuffer(1024, 1)
-	err := buffer.writeCount("namespace.", []string{"tag:tag"}, "metric", 1, []string{}, 1)
+	err := buffer.writeCount("namespace.", []string{"tag:tag"}, "metric", 1, []string{}, 1, 0, CardinalityNotSet, "")
 	assert.Nil(t, err)
 	assert.Equal(t, "namespace.metric:1|c|#tag:tag\n", string(buffer.bytes()))
 }
 
+func TestBufferGaugeWithTimestamp(t *testing.T) {
+	buffer := newStatsdBuffer(1024, 1)
+	err := buffer.writeGauge("namespace.", []string{"tag:tag"}, "metric", 1, []string{}, 1, 1658934956, CardinalityNotSet, "")
+	assert.Nil(t, err)
+	assert.Equal(t, "namespace.metric:1|g|#tag:tag|T1658934956\n", string(buffer.bytes()))
+}
+
+func TestBufferCountWithTimestamp(t *testing.T) {
+	buffer := newStatsdBuffer(1024, 1)
+	err := buffer.writeCount("namespace.", []string{"tag:tag"}, "metric", 1, []string{}, 1, 1658934956, CardinalityNotSet, "")
+	assert.Nil(t, err)
+	assert.Equal(t, "namespace.metric:1|c|#tag:tag|T1658934956\n", string(buffer.bytes()))
+}
+
+func TestBufferRaw(t *testing.T) {
+	buffer := newStatsdBuffer(20, 2)
+	err := buffer.writeRaw("metric:1|c|#a:b")
+	assert.Nil(t, err)
+	assert.Equal(t, "metric:1|c|#a:b\n", string(buffer.bytes()))
+
+	err = buffer.writeRaw("metric:1|c")
+	assert.Equal(t, errBufferFull, err)
+	assert.Equal(t, "metric:1|c|#a:b\n", string(buffer.bytes()))
+}
+
 func TestBufferHistogram(t *testing.T) {
 	buffer := newStatsdBuffer(1024, 1)
-	err := buffer.writeHistogram("namespace.", []string{"tag:tag"}, "metric", 1, []string{}, 1)
+	err := buffer.writeHistogram("namespace.", []string{"tag:tag"}, "metric", 1, []string{}, 1, CardinalityNotSet, "")
 	assert.Nil(t, err)
 	assert.Equal(t, "namespace.metric:1|h|#tag:tag\n", string(buffer.bytes()))
 }
 
 func TestBufferDistribution(t *testing.T) {
 	buffer := newStatsdBuffer(1024, 1)
-	err := buffer.writeDistribution("namespace.", []string{"tag:tag"}, "metric", 1, []string{}, 1)
+	err := buffer.writeDistribution("namespace.", []string{"tag:tag"}, "metric", 1, []string{}, 1, CardinalityNotSet, "")
 	assert.Nil(t, err)
 	assert.Equal(t, "namespace.metric:1|d|#tag:tag\n", string(buffer.bytes()))
 }
 func TestBufferSet(t *testing.T) {
 	buffer := newStatsdBuffer(1024, 1)
-	err := buffer.writeSet("namespace.", []string{"tag:tag"}, "metric", "value", []string{}, 1)
+	err := buffer.writeSet("namespace.", []string{"tag:tag"}, "metric", "value", []string{}, 1, CardinalityNotSet, "")
 	assert.Nil(t, err)
 	assert.Equal(t, "namespace.metric:value|s|#tag:tag\n", string(buffer.bytes()))
 }
 
+func TestBufferSetInt(t *testing.T) {
+	buffer := newStatsdBuffer(1024, 1)
+	err := buffer.writeSetInt("namespace.", []string{"tag:tag"}, "metric", 1234, []string{}, 1, CardinalityNotSet, "")
+	assert.Nil(t, err)
+	assert.Equal(t, "namespace.metric:1234|s|#tag:tag\n", string(buffer.bytes()))
+}
+
 func TestBufferTiming(t *testing.T) {
 	buffer := newStatsdBuffer(1024, 1)
-	err := buffer.writeTiming("namespace.", []string{"tag:tag"}, "metric", 1, []string{}, 1)
+	err := buffer.writeTiming("namespace.", []string{"tag:tag"}, "metric", 1, []string{}, 1, CardinalityNotSet, "")
 	assert.Nil(t, err)
 	assert.Equal(t, "namespace.metric:1.000000|ms|#tag:tag\n", string(buffer.bytes()))
 }
 
 func TestBufferEvent(t *testing.T) {
 	buffer := newStatsdBuffer(1024, 1)
-	err := buffer.writeEvent(&Event{Title: "title", Text: "text"}, []string{"tag:tag"})
+	err := buffer.writeEvent(&Event{Title: "title", Text: "text"}, []string{"tag:tag"}, CardinalityNotSet)
 	assert.Nil(t, err)
 	assert.Equal(t, "_e{5,4}:title|text|#tag:tag\n", string(buffer.bytes()))
 }
 
 func TestBufferServiceCheck(t *testing.T) {
 	buffer := newStatsdBuffer(1024, 1)
-	err := buffer.writeServiceCheck(&ServiceCheck{Name: "name", Status: Ok}, []string{"tag:tag"})
+	err := buffer.writeServiceCheck(&ServiceCheck{Name: "name", Status: Ok}, []string{"tag:tag"}, CardinalityNotSet)
 	assert.Nil(t, err)
 	assert.Equal(t, "_sc|name|0|#tag:tag\n", string(buffer.bytes()))
 }
 
 func TestBufferFullSize(t *testing.T) {
 	buffer := newStatsdBuffer(30, 10)
-	err := buffer.writeGauge("namespace.", []string{"tag:tag"}, "metric", 1, []string{}, 1)
+	err := buffer.writeGauge("namespace.", []string{"tag:tag"}, "metric", 1, []string{}, 1, 0, CardinalityNotSet, "")
 	assert.Nil(t, err)
 	assert.Len(t, buffer.bytes(), 30)
-	err = buffer.writeGauge("namespace.", []string{"tag:tag"}, "metric", 1, []string{}, 1)
+	err = buffer.writeGauge("namespace.", []string{"tag:tag"}, "metric", 1, []string{}, 1, 0, CardinalityNotSet, "")
 	assert.Equal(t, errBufferFull, err)
 }
 
 func TestBufferSeparator(t *testing.T) {
 	buffer := newStatsdBuffer(1024, 10)
-	err := buffer.writeGauge("namespace.", []string{"tag:tag"}, "metric", 1, []string{}, 1)
+	err := buffer.writeGauge("namespace.", []string{"tag:tag"}, "metric", 1, []string{}, 1, 0, CardinalityNotSet, "")
 	assert.Nil(t, err)
-	err = buffer.writeGauge("namespace.", []string{"tag:tag"}, "metric", 1, []string{}, 1)
+	err = buffer.writeGauge("namespace.", []string{"tag:tag"}, "metric", 1, []string{}, 1, 0, CardinalityNotSet, "")
 	assert.Nil(t, err)
 	assert.Equal(t, "namespace.metric:1|g|#tag:tag\nnamespace.metric:1|g|#tag:tag\n", string(buffer.bytes()))
 }
 
 func TestBufferAggregated(t *testing.T) {
 	buffer := newStatsdBuffer(1024, 1)
-	pos, err := buffer.writeAggregated([]byte("h"), "namespace.", []string{"tag:tag"}, "metric", []float64{1}, "", 12, -1)
+	pos, err := buffer.writeAggregated([]byte("h"), "namespace.", []string{"tag:tag"}, "metric", []float64{1}, "", 12, -1, 1, CardinalityNotSet, "")
 	assert.Nil(t, err)
 	assert.Equal(t, 1, pos)
 	assert.Equal(t, "namespace.metric:1|h|#tag:tag\n", string(buffer.bytes()))
 
 	buffer = newStatsdBuffer(1024, 1)
-	pos, err = buffer.writeAggregated([]byte("h"), "namespace.", []string{"tag:tag"}, "metric", []float64{1, 2, 3, 4}, "", 12, -1)
+	pos, err = buffer.writeAggregated([]byte("h"), "namespace.", []string{"tag:tag"}, "metric", []float64{1, 2, 3, 4}, "", 12, -1, 1, CardinalityNotSet, "")
 	assert.Nil(t, err)
 	assert.Equal(t, 4, pos)
 	assert.Equal(t, "namespace.metric:1:2:3:4|h|#tag:tag\n", string(buffer.bytes()))
@@ -95,29 +127,29 @@ func TestBufferAggregated(t *testing.T) {
 	// max element already used
 	buffer = newStatsdBuffer(1024, 1)
 	buffer.elementCount = 1
-	pos, err = buffer.writeAggregated([]byte("h"), "namespace.", []string{"tag:tag"}, "metric", []float64{1, 2, 3, 4}, "", 12, -1)
+	pos, err = buffer.writeAggregated([]byte("h"), "namespace.", []string{"tag:tag"}, "metric", []float64{1, 2, 3, 4}, "", 12, -1, 1, CardinalityNotSet, "")
 	assert.Equal(t, errBufferFull, err)
 
 	// not enought size to start serializing (tags and header too big)
 	buffer = newStatsdBuffer(4, 1)
-	pos, err = buffer.writeAggregated([]byte("h"), "namespace.", []string{"tag:tag"}, "metric", []float64{1, 2, 3, 4}, "", 12, -1)
+	pos, err = buffer.writeAggregated([]byte("h"), "namespace.", []string{"tag:tag"}, "metric", []float64{1, 2, 3, 4}, "", 12, -1, 1, CardinalityNotSet, "")
 	assert.Equal(t, errBufferFull, err)
 
 	// not enought size to serializing one message
 	buffer = newStatsdBuffer(29, 1)
-	pos, err = buffer.writeAggregated([]byte("h"), "namespace.", []string{"tag:tag"}, "metric", []float64{1, 2, 3, 4}, "", 12, -1)
+	pos, err = buffer.writeAggregated([]byte("h"), "namespace.", []string{"tag:tag"}, "metric", []float64{1, 2, 3, 4}, "", 12, -1, 1, CardinalityNotSet, "")
 	assert.Equal(t, errBufferFull, err)
 
 	// space for only 1 number
 	buffer = newStatsdBuffer(30, 1)
-	pos, err = buffer.writeAggregated([]byte("h"), "namespace.", []string{"tag:tag"}, "metric", []float64{1, 2, 3, 4}, "", 12, -1)
+	pos, err = buffer.writeAggregated([]byte("h"), "namespace.", []string{"tag:tag"}, "metric", []float64{1, 2, 3, 4}, "", 12, -1, 1, CardinalityNotSet, "")
 	assert.Equal(t, errPartialWrite, err)
 	assert.Equal(t, 1, pos)
 	assert.Equal(t, "namespace.metric:1|h|#tag:tag\n", string(buffer.bytes()))
 
 	// first value too big
 	buffer = newStatsdBuffer(30, 1)
-	pos, err = buffer.writeAggregated([]byte("h"), "namespace.", []string{"tag:tag"}, "metric", []float64{12, 2, 3, 4}, "", 12, -1)
+	pos, err = buffer.writeAggregated([]byte("h"), "namespace.", []string{"tag:tag"}, "metric", []float64{12, 2, 3, 4}, "", 12, -1, 1, CardinalityNotSet, "")
 	assert.Equal(t, errBufferFull, err)
 	assert.Equal(t, 0, pos)
 	assert.Equal(t, "", string(buffer.bytes())) // checking that the buffer was reset
@@ -125,46 +157,108 @@ func TestBufferAggregated(t *testing.T) {
 	// not enough space left
 	buffer = newStatsdBuffer(40, 1)
 	buffer.buffer = append(buffer.buffer, []byte("abcdefghij")...)
-	pos, err = buffer.writeAggregated([]byte("h"), "namespace.", []string{"tag:tag"}, "metric", []float64{12, 2, 3, 4}, "", 12, -1)
+	pos, err = buffer.writeAggregated([]byte("h"), "namespace.", []string{"tag:tag"}, "metric", []float64{12, 2, 3, 4}, "", 12, -1, 1, CardinalityNotSet, "")
 	assert.Equal(t, errBufferFull, err)
 	assert.Equal(t, 0, pos)
 	assert.Equal(t, "abcdefghij", string(buffer.bytes())) // checking that the buffer was reset
 
 	// space for only 2 number
 	buffer = newStatsdBuffer(32, 1)
-	pos, err = buffer.writeAggregated([]byte("h"), "namespace.", []string{"tag:tag"}, "metric", []float64{1, 2, 3, 4}, "", 12, -1)
+	pos, err = buffer.writeAggregated([]byte("h"), "namespace.", []string{"tag:tag"}, "metric", []float64{1, 2, 3, 4}, "", 12, -1, 1, CardinalityNotSet, "")
 	assert.Equal(t, errPartialWrite, err)
 	assert.Equal(t, 2, pos)
 	assert.Equal(t, "namespace.metric:1:2|h|#tag:tag\n", string(buffer.bytes()))
 }
 
+func TestBufferAggregatedWithRate(t *testing.T) {
+	buffer := newStatsdBuffer(1024, 1)
+	pos, err := buffer.writeAggregated([]byte("d"), "namespace.", []string{"tag:tag"}, "metric", []float64{1, 2, 3, 4}, "", 18, -1, 0.5, CardinalityNotSet, "")
+	assert.Nil(t, err)
+	assert.Equal(t, 4, pos)
+	assert.Equal(t, "namespace.metric:1:2:3:4|d|@0.5|#tag:tag\n", string(buffer.bytes()))
+}
+
 func TestBufferMaxElement(t *testing.T) {
 	buffer := newStatsdBuffer(1024, 1)
 
-	err := buffer.writeGauge("namespace.", []string{"tag:tag"}, "metric", 1, []string{}, 1)
+	err := buffer.writeGauge("namespace.", []string{"tag:tag"}, "metric", 1, []string{}, 1, 0, CardinalityNotSet, "")
 	assert.Nil(t, err)
 
-	err = buffer.writeGauge("namespace.", []string{"tag:tag"}, "metric", 1, []string{}, 1)
+	err = buffer.writeGauge("namespace.", []string{"tag:tag"}, "metric", 1, []string{}, 1, 0, CardinalityNotSet, "")
 	assert.Equal(t, errBufferFull, err)
 
-	err = buffer.writeCount("namespace.", []string{"tag:tag"}, "metric", 1, []string{}, 1)
+	err = buffer.writeCount("namespace.", []string{"tag:tag"}, "metric", 1, []string{}, 1, 0, CardinalityNotSet, "")
 	assert.Equal(t, errBufferFull, err)
 
-	err = buffer.writeHistogram("namespace.", []string{"tag:tag"}, "metric", 1, []string{}, 1)
+	err = buffer.writeHistogram("namespace.", []string{"tag:tag"}, "metric", 1, []string{}, 1, CardinalityNotSet, "")
 	assert.Equal(t, errBufferFull, err)
 
-	err = buffer.writeDistribution("namespace.", []string{"tag:tag"}, "metric", 1, []string{}, 1)
+	err = buffer.writeDistribution("namespace.", []string{"tag:tag"}, "metric", 1, []string{}, 1, CardinalityNotSet, "")
 	assert.Equal(t, errBufferFull, err)
 
-	err = buffer.writeSet("namespace.", []string{"tag:tag"}, "metric", "value", []string{}, 1)
+	err = buffer.writeSet("namespace.", []string{"tag:tag"}, "metric", "value", []string{}, 1, CardinalityNotSet, "")
 	assert.Equal(t, errBufferFull, err)
 
-	err = buffer.writeTiming("namespace.", []string{"tag:tag"}, "metric", 1, []string{}, 1)
+	err = buffer.writeTiming("namespace.", []string{"tag:tag"}, "metric", 1, []string{}, 1, CardinalityNotSet, "")
 	assert.Equal(t, errBufferFull, err)
 
-	err = buffer.writeEvent(&Event{Title: "title", Text: "text"}, []string{"tag:tag"})
+	err = buffer.writeEvent(&Event{Title: "title", Text: "text"}, []string{"tag:tag"}, CardinalityNotSet)
 	assert.Equal(t, errBufferFull, err)
 
-	err = buffer.writeServiceCheck(&ServiceCheck{Name: "name", Status: Ok}, []string{"tag:tag"})
+	err = buffer.writeServiceCheck(&ServiceCheck{Name: "name", Status: Ok}, []string{"tag:tag"}, CardinalityNotSet)
 	assert.Equal(t, errBufferFull, err)
 }
+
+func TestBufferContainerID(t *testing.T) {
+	buffer := newStatsdBuffer(1024, 10)
+	buffer.containerID = "container-id"
+	err := buffer.writeGauge("namespace.", []string{"tag:tag"}, "metric", 1, []string{}, 1, 1658934956, CardinalityNotSet, "")
+	assert.Nil(t, err)
+	err = buffer.writeHistogram("namespace.", []string{"tag:tag"}, "metric", 1, []string{}, 1, CardinalityNotSet, "")
+	assert.Nil(t, err)
+	_, err = buffer.writeAggregated([]byte("d"), "namespace.", []string{"tag:tag"}, "metric", []float64{1, 2}, "", 12, -1, 1, CardinalityNotSet, "")
+	assert.Nil(t, err)
+	err = buffer.writeEvent(&Event{Title: "title", Text: "text"}, []string{"tag:tag"}, CardinalityNotSet)
+	assert.Nil(t, err)
+	err = buffer.writeServiceCheck(&ServiceCheck{Name: "name", Status: Ok}, []string{"tag:tag"}, CardinalityNotSet)
+	assert.Nil(t, err)
+	assert.Equal(t, "namespace.metric:1|g|#tag:tag|T1658934956|c:container-id\n"+
+		"namespace.metric:1|h|#tag:tag|c:container-id\n"+
+		"namespace.metric:1:2|d|#tag:tag|c:container-id\n"+
+		"_e{5,4}:title|text|#tag:tag|c:container-id\n"+
+		"_sc|name|0|#tag:tag|c:container-id\n", string(buffer.bytes()))
+}
+
+func TestBufferExternalEnv(t *testing.T) {
+	buffer := newStatsdBuffer(1024, 10)
+	buffer.externalEnv = "it-false,cn-nginx"
+	err := buffer.writeCount("namespace.", []string{"tag:tag"}, "metric", 1, []string{"tag2"}, 1, 0, CardinalityNotSet, "")
+	assert.Nil(t, err)
+
+	// the external data comes after the container ID
+	buffer.containerID = "container-id"
+	err = buffer.writeCount("namespace.", []string{"tag:tag"}, "metric", 1, []string{"tag2"}, 1, 0, CardinalityNotSet, "")
+	assert.Nil(t, err)
+	_, err = buffer.writeAggregated([]byte("d"), "namespace.", []string{"tag:tag"}, "metric", []float64{1, 2}, "tag2", 12, -1, 1, CardinalityNotSet, "")
+	assert.Nil(t, err)
+	err = buffer.writeServiceCheck(&ServiceCheck{Name: "name", Status: Ok}, []string{"tag:tag"}, CardinalityNotSet)
+	assert.Nil(t, err)
+	assert.Equal(t, "namespace.metric:1|c|#tag:tag,tag2|e:it-false,cn-nginx\n"+
+		"namespace.metric:1|c|#tag:tag,tag2|c:container-id|e:it-false,cn-nginx\n"+
+		"namespace.metric:1:2|d|#tag:tag,tag2|c:container-id|e:it-false,cn-nginx\n"+
+		"_sc|name|0|#tag:tag|c:container-id|e:it-false,cn-nginx\n", string(buffer.bytes()))
+}
+
+func TestBufferCardinality(t *testing.T) {
+	buffer := newStatsdBuffer(1024, 10)
+	buffer.containerID = "container-id"
+	err := buffer.writeGauge("namespace.", []string{"tag:tag"}, "metric", 1, []string{}, 1, 1658934956, CardinalityLow, "")
+	assert.Nil(t, err)
+	_, err = buffer.writeAggregated([]byte("d"), "namespace.", []string{"tag:tag"}, "metric", []float64{1, 2}, "", 12, -1, 1, CardinalityOrchestrator, "")
+	assert.Nil(t, err)
+	err = buffer.writeEvent(&Event{Title: "title", Text: "text"}, []string{"tag:tag"}, CardinalityHigh)
+	assert.Nil(t, err)
+	assert.Equal(t, "namespace.metric:1|g|#tag:tag|T1658934956|c:container-id|card:low\n"+
+		"namespace.metric:1:2|d|#tag:tag|c:container-id|card:orchestrator\n"+
+		"_e{5,4}:title|text|#tag:tag|c:container-id|card:high\n", string(buffer.bytes()))
+}
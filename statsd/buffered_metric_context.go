@@ -11,10 +11,18 @@ import (
 // and Timing. Since those 3 metric types behave the same way and are sampled
 // with the same type they're represented by the same class.
 type bufferedMetricContexts struct {
-	nbContext uint64
-	mutex     sync.RWMutex
-	values    bufferedMetricMap
-	newMetric func(string, float64, string) *bufferedMetric
+	nbContext        uint64
+	nbDroppedSamples uint64
+	mutex            sync.RWMutex
+	values           bufferedMetricMap
+	newMetric        func(string, float64, string, float64) *bufferedMetric
+
+	// maxSamples and strategy are given to each new context, see
+	// WithMaxSamplesPerContext. flushEarly sends the values of a context that
+	// reached maxSamples with the MaxSamplesFlush strategy.
+	maxSamples int64
+	strategy   MaxSamplesStrategy
+	flushEarly func(metric)
 
 	// Each bufferedMetricContexts uses its own random source and random
 	// lock to prevent goroutines from contending for the lock on the
@@ -23,9 +31,13 @@ type bufferedMetricContexts struct {
 	// pseudorandom number).
 	random     *rand.Rand
 	randomLock sync.Mutex
+	// sampler is set through WithSampler, the random source is used when nil.
+	sampler Sampler
+	// ddSketch accumulates the values of the contexts into DDSketches, see WithDDSketchEncoding.
+	ddSketch bool
 }
 
-func newBufferedContexts(newMetric func(string, float64, string) *bufferedMetric) bufferedMetricContexts {
+func newBufferedContexts(newMetric func(string, float64, string, float64) *bufferedMetric) bufferedMetricContexts {
 	return bufferedMetricContexts{
 		values:    bufferedMetricMap{},
 		newMetric: newMetric,
@@ -44,22 +56,42 @@ func (bc *bufferedMetricContexts) flush(metrics []metric) []metric {
 	bc.mutex.Unlock()
 
 	for _, d := range values {
-		metrics = append(metrics, d.flushUnsafe())
+		if d.sketch != nil {
+			metrics = append(metrics, d.flushSketchUnsafe()...)
+		} else {
+			metrics = append(metrics, d.flushUnsafe())
+		}
 	}
 	atomic.AddUint64(&bc.nbContext, uint64(len(values)))
 	return metrics
 }
 
-func (bc *bufferedMetricContexts) sample(name string, value float64, tags []string, rate float64) error {
-	if !shouldSample(rate, bc.random, &bc.randomLock) {
+func (bc *bufferedMetricContexts) sampleContext(v *bufferedMetric, value float64) {
+	flushed, dropped := v.sample(value)
+	if flushed != nil {
+		bc.flushEarly(*flushed)
+	} else if dropped {
+		atomic.AddUint64(&bc.nbDroppedSamples, 1)
+	}
+}
+
+func (bc *bufferedMetricContexts) sample(name string, value float64, tags []string, rate float64, cardinality Cardinality) error {
+	return bc.samplePercentile(name, value, tags, rate, cardinality, 0)
+}
+
+// samplePercentile samples a value of a context flushed as the gauge of its percentile, see
+// Client.HistogramPercentile. A percentile of 0 flushes the values themselves.
+func (bc *bufferedMetricContexts) samplePercentile(name string, value float64, tags []string, rate float64, cardinality Cardinality, percentile float64) error {
+	if !sampleWith(bc.sampler, name, tags, rate, bc.random, &bc.randomLock) {
 		return nil
 	}
 
 	context, stringTags := getContextAndTags(name, tags)
+	context = withRate(withCardinality(context, cardinality), rate)
 
 	bc.mutex.RLock()
 	if v, found := bc.values[context]; found {
-		v.sample(value)
+		bc.sampleContext(v, value)
 		bc.mutex.RUnlock()
 		return nil
 	}
@@ -68,11 +100,21 @@ func (bc *bufferedMetricContexts) sample(name string, value float64, tags []stri
 	bc.mutex.Lock()
 	// Check if another goroutines hasn't created the value betwen the 'RUnlock' and 'Lock'
 	if v, found := bc.values[context]; found {
-		v.sample(value)
+		bc.sampleContext(v, value)
 		bc.mutex.Unlock()
 		return nil
 	}
-	bc.values[context] = bc.newMetric(name, value, stringTags)
+	v := bc.newMetric(name, value, stringTags, rate)
+	v.cardinality = cardinality
+	v.percentile = percentile
+	v.maxSamples = bc.maxSamples
+	v.strategy = bc.strategy
+	v.random = bc.random
+	v.randomLock = &bc.randomLock
+	if bc.ddSketch && percentile == 0 {
+		v.useSketch()
+	}
+	bc.values[context] = v
 	bc.mutex.Unlock()
 	return nil
 }
@@ -80,3 +122,7 @@ func (bc *bufferedMetricContexts) sample(name string, value float64, tags []stri
 func (bc *bufferedMetricContexts) getNbContext() uint64 {
 	return atomic.LoadUint64(&bc.nbContext)
 }
+
+func (bc *bufferedMetricContexts) getNbDroppedSamples() uint64 {
+	return atomic.LoadUint64(&bc.nbDroppedSamples)
+}
@@ -0,0 +1,48 @@
+package statsd
+
+// Cardinality controls how many origin tags the Agent adds to a metric found through origin detection, see
+// WithCardinality. Higher cardinalities add more precise tags (ex: the pod name) at the cost of more contexts.
+type Cardinality int
+
+const (
+	// CardinalityNotSet lets the Agent use its own configured cardinality. It is the default.
+	CardinalityNotSet Cardinality = iota
+	// CardinalityLow only adds tags with a low number of values, like the image name.
+	CardinalityLow
+	// CardinalityOrchestrator also adds the tags set by the orchestrator, like the pod name.
+	CardinalityOrchestrator
+	// CardinalityHigh adds every origin tag, including the container ID.
+	CardinalityHigh
+)
+
+func (c Cardinality) String() string {
+	switch c {
+	case CardinalityLow:
+		return "low"
+	case CardinalityOrchestrator:
+		return "orchestrator"
+	case CardinalityHigh:
+		return "high"
+	default:
+		return ""
+	}
+}
+
+func (c Cardinality) isValid() bool {
+	return c >= CardinalityNotSet && c <= CardinalityHigh
+}
+
+// Parameter overrides a setting of the client for a single call. It is given as the last arguments of the methods
+// submitting metrics, ex:
+//
+//	client.Gauge("queue.size", 12, nil, 1, statsd.CardinalityHigh)
+type Parameter interface {
+	apply(m *metric)
+}
+
+// apply overrides the cardinality of the client. Invalid values and CardinalityNotSet are ignored.
+func (c Cardinality) apply(m *metric) {
+	if c != CardinalityNotSet && c.isValid() {
+		m.cardinality = c
+	}
+}
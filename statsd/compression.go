@@ -0,0 +1,61 @@
+package statsd
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+)
+
+// Compression is the algorithm used to compress payloads before they are written, see WithPayloadCompression.
+type Compression int
+
+const (
+	// CompressionNone sends payloads as is. This is the default.
+	CompressionNone Compression = iota
+	// CompressionGzip compresses each payload with gzip (RFC 1952).
+	CompressionGzip
+	// CompressionDeflate compresses each payload with deflate using the zlib format (RFC 1950).
+	CompressionDeflate
+)
+
+// compressWriter is implemented by both *gzip.Writer and *zlib.Writer.
+type compressWriter interface {
+	io.WriteCloser
+	Reset(w io.Writer)
+}
+
+// payloadCompressor compresses payloads into its own scratch buffer so the
+// buffers from the pool keep the uncompressed serialization. It is not
+// thread-safe: it is only used from the sender loop.
+type payloadCompressor struct {
+	scratch bytes.Buffer
+	writer  compressWriter
+}
+
+func newPayloadCompressor(compression Compression) *payloadCompressor {
+	p := &payloadCompressor{}
+	switch compression {
+	case CompressionGzip:
+		p.writer = gzip.NewWriter(&p.scratch)
+	case CompressionDeflate:
+		p.writer = zlib.NewWriter(&p.scratch)
+	default:
+		return nil
+	}
+	return p
+}
+
+// compress returns the compressed payload. The returned slice is only valid
+// until the next call.
+func (p *payloadCompressor) compress(payload []byte) ([]byte, error) {
+	p.scratch.Reset()
+	p.writer.Reset(&p.scratch)
+	if _, err := p.writer.Write(payload); err != nil {
+		return nil, err
+	}
+	if err := p.writer.Close(); err != nil {
+		return nil, err
+	}
+	return p.scratch.Bytes(), nil
+}
@@ -0,0 +1,88 @@
+package statsd
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func decompress(t *testing.T, compression Compression, payload []byte) string {
+	var r io.Reader
+	var err error
+	switch compression {
+	case CompressionGzip:
+		r, err = gzip.NewReader(bytes.NewReader(payload))
+	case CompressionDeflate:
+		r, err = zlib.NewReader(bytes.NewReader(payload))
+	}
+	require.Nil(t, err)
+
+	data, err := ioutil.ReadAll(r)
+	require.Nil(t, err)
+	return string(data)
+}
+
+func TestPayloadCompressor(t *testing.T) {
+	assert.Nil(t, newPayloadCompressor(CompressionNone))
+
+	for _, compression := range []Compression{CompressionGzip, CompressionDeflate} {
+		p := newPayloadCompressor(compression)
+		// the compressor is reused between payloads
+		for _, payload := range []string{"metric:1|g\n", "metric:1:2:3:4|d|#tag1,tag2\n"} {
+			compressed, err := p.compress([]byte(payload))
+			require.Nil(t, err)
+			assert.Equal(t, payload, decompress(t, compression, compressed))
+		}
+	}
+}
+
+// rawWriter keeps each payload as written.
+type rawWriter struct {
+	payloads [][]byte
+}
+
+func (w *rawWriter) Write(p []byte) (int, error) {
+	w.payloads = append(w.payloads, append([]byte{}, p...))
+	return len(p), nil
+}
+
+func (w *rawWriter) Close() error {
+	return nil
+}
+
+func TestPayloadCompressionRoundTrip(t *testing.T) {
+	send := func(c *Client) {
+		c.Gauge("gauge", 1, []string{"tag1"}, 1)
+		c.Count("count", 2, []string{"tag1"}, 1)
+		c.DistributionSamples("distribution", []float64{1, 2, 3, 4}, []string{"tag1"}, 1)
+		c.Close()
+	}
+
+	expected := &rawWriter{}
+	client, err := NewWithWriter(expected, WithoutTelemetry(), WithWorkersCount(1))
+	require.Nil(t, err)
+	send(client)
+	require.Len(t, expected.payloads, 1)
+
+	for _, compression := range []Compression{CompressionGzip, CompressionDeflate} {
+		w := &rawWriter{}
+		client, err := NewWithWriter(w, WithoutTelemetry(), WithWorkersCount(1), WithPayloadCompression(compression))
+		require.Nil(t, err)
+		send(client)
+
+		require.Len(t, w.payloads, 1)
+		assert.NotEqual(t, expected.payloads[0], w.payloads[0])
+		assert.Equal(t, string(expected.payloads[0]), decompress(t, compression, w.payloads[0]))
+	}
+}
+
+func TestPayloadCompressionUDP(t *testing.T) {
+	_, err := New("localhost:8765", WithPayloadCompression(CompressionGzip))
+	assert.Error(t, err)
+}
@@ -0,0 +1,135 @@
+package statsd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+const (
+	// cgroupPath is the path to the cgroup file where we can find the container ID if any.
+	cgroupPath = "/proc/self/cgroup"
+	// mountInfoPath is the path to the mountinfo file, used when the container ID is not part of the cgroup file (ex:
+	// cgroup v2 with a private cgroup namespace).
+	mountInfoPath = "/proc/self/mountinfo"
+
+	uuidSource      = "[0-9a-f]{8}[-_][0-9a-f]{4}[-_][0-9a-f]{4}[-_][0-9a-f]{4}[-_][0-9a-f]{12}"
+	containerSource = "[0-9a-f]{64}"
+	taskSource      = "[0-9a-f]{32}-\\d+"
+
+	// externalEnvVarName is set by the Datadog admission controller with data about the origin of the process that
+	// the Agent can't find on its own.
+	externalEnvVarName = "DD_EXTERNAL_ENV"
+)
+
+var (
+	// expLine matches a line in the cgroup file and captures its path. It
+	// works for both cgroup v1 ("<id>:<controllers>:<path>") and cgroup v2
+	// ("0::<path>").
+	expLine = regexp.MustCompile(`^\d+:[^:]*:(.+)$`)
+
+	// expContainerID matches the container ID at the end of a cgroup path.
+	expContainerID = regexp.MustCompile(fmt.Sprintf(`(%s|%s|%s)(?:\.scope)?$`, uuidSource, containerSource, taskSource))
+
+	// expMountInfoContainerID matches the container ID in the mount points
+	// of the container runtimes (ex: /var/lib/docker/containers/<id>/hostname).
+	expMountInfoContainerID = regexp.MustCompile(fmt.Sprintf(`/(?:containers|sandboxes)/(%s)/`, containerSource))
+
+	// containerID caches the container ID detected for the clients using
+	// WithOriginDetection, it is empty when the detection failed.
+	containerID          string
+	containerIDDetection sync.Once
+)
+
+// parseContainerID returns the container ID found in the content of a cgroup file, or an empty string.
+func parseContainerID(r io.Reader) string {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		path := expLine.FindStringSubmatch(scanner.Text())
+		if len(path) != 2 {
+			continue
+		}
+		if parts := expContainerID.FindStringSubmatch(path[1]); len(parts) == 2 {
+			return parts[1]
+		}
+	}
+	return ""
+}
+
+// parseMountInfo returns the container ID found in the content of a mountinfo file, or an empty string.
+func parseMountInfo(r io.Reader) string {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if parts := expMountInfoContainerID.FindStringSubmatch(scanner.Text()); len(parts) == 2 {
+			return parts[1]
+		}
+	}
+	return ""
+}
+
+func readFile(path string, parse func(io.Reader) string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	return parse(f)
+}
+
+// detectContainerID reads the container ID of the current process. Any error is ignored and results in an empty
+// container ID.
+func detectContainerID(cgroupPath string, mountInfoPath string) string {
+	if id := readFile(cgroupPath, parseContainerID); id != "" {
+		return id
+	}
+	return readFile(mountInfoPath, parseMountInfo)
+}
+
+// initContainerID returns the container ID of the process. The detection only happens once per process since the
+// container can't change.
+func initContainerID() string {
+	containerIDDetection.Do(func() {
+		containerID = detectContainerID(cgroupPath, mountInfoPath)
+	})
+	return containerID
+}
+
+// ContainerID is a Parameter overriding the container ID sent with a metric, for example when a client is shared by
+// the containers of a pod and each metric should be attributed to the container it is about:
+//
+//	client.Gauge("queue.size", 12, nil, 1, statsd.ContainerID(id))
+//
+// It takes precedence over the container ID found by WithOriginDetection. An empty ContainerID is ignored. Since the
+// aggregated contexts don't track the container, metrics given a ContainerID bypass the client side aggregation, like
+// the ones with a timestamp. It is ignored by GaugeDelta.
+type ContainerID string
+
+// apply overrides the container ID of the client.
+func (id ContainerID) apply(m *metric) {
+	if id != "" {
+		m.containerID = sanitizeExternalEnv(string(id))
+	}
+}
+
+// sanitizeExternalEnv removes the characters that would corrupt a DogStatsD message.
+func sanitizeExternalEnv(value string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '|' || r == '\n' || r == '\r' {
+			return -1
+		}
+		return r
+	}, strings.TrimSpace(value))
+}
+
+// resolveExternalEnv returns the external data sent with every message. The value given by the user has priority
+// over the DD_EXTERNAL_ENV environment variable.
+func resolveExternalEnv(userProvided string) string {
+	if userProvided != "" {
+		return userProvided
+	}
+	return sanitizeExternalEnv(os.Getenv(externalEnvVarName))
+}
@@ -0,0 +1,91 @@
+package statsd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setTestContainerID overrides the detected container ID and returns a function restoring it.
+func setTestContainerID(id string) func() {
+	// the real detection must not overwrite the value afterwards
+	containerIDDetection.Do(func() {})
+	previous := containerID
+	containerID = id
+	return func() { containerID = previous }
+}
+
+func TestParseContainerID(t *testing.T) {
+	for input, expected := range map[string]string{
+		// cgroup v1, docker
+		`12:pids:/docker/8c046cb0b72cd4c99f51b5591cd5b095967f58ee003710a45280c28ee1a9c7fa
+11:hugetlb:/docker/8c046cb0b72cd4c99f51b5591cd5b095967f58ee003710a45280c28ee1a9c7fa
+1:name=systemd:/docker/8c046cb0b72cd4c99f51b5591cd5b095967f58ee003710a45280c28ee1a9c7fa`: "8c046cb0b72cd4c99f51b5591cd5b095967f58ee003710a45280c28ee1a9c7fa",
+		// cgroup v1, kubernetes
+		`11:devices:/kubepods/besteffort/pod3d274242-8ee0-11e9-a8a6-1e68d864ef1a/3e74d3fd9db4c9dd921ae05c2502fb984d0cde1b36e581b13f79c639da4518a1`: "3e74d3fd9db4c9dd921ae05c2502fb984d0cde1b36e581b13f79c639da4518a1",
+		// cgroup v2, systemd driver
+		`0::/kubepods.slice/kubepods-burstable.slice/kubepods-burstable-pod2d3da189_6407_48e3_9ab6_78188d75e609.slice/cri-containerd-9ba7eed4d50a52b4fb04e22f22bfa3ef81c5f99c51c76bdc1fda88f9a8d51c33.scope`: "9ba7eed4d50a52b4fb04e22f22bfa3ef81c5f99c51c76bdc1fda88f9a8d51c33",
+		// ECS fargate
+		`1:name=systemd:/ecs/34dc0b5e626f2c5c4c5170e34b10e765-1234567890`: "34dc0b5e626f2c5c4c5170e34b10e765-1234567890",
+		// PCF
+		`1:name=systemd:/system.slice/garden.service/garden/6f265890-5165-7fab-6b52-18d1`: "",
+		// not in a container
+		`0::/user.slice/user-1000.slice/session-1.scope`: "",
+		// cgroup v2 with a private namespace
+		`0::/`: "",
+		"":     "",
+	} {
+		assert.Equal(t, expected, parseContainerID(strings.NewReader(input)), input)
+	}
+}
+
+func TestParseMountInfo(t *testing.T) {
+	input := `608 554 0:42 / / rw,relatime master:289 - overlay overlay rw
+620 608 254:1 /var/lib/docker/containers/0cfa82bf3ab29da271548d6a044e95c948c6fd2f7578fb41833a44ca23da425f/hostname /etc/hostname rw,relatime - ext4 /dev/vda1 rw`
+	assert.Equal(t, "0cfa82bf3ab29da271548d6a044e95c948c6fd2f7578fb41833a44ca23da425f", parseMountInfo(strings.NewReader(input)))
+	assert.Equal(t, "", parseMountInfo(strings.NewReader("608 554 0:42 / / rw,relatime master:289 - overlay overlay rw")))
+}
+
+func TestDetectContainerID(t *testing.T) {
+	dir, err := ioutil.TempDir("", "statsd-container")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	cgroup := filepath.Join(dir, "cgroup")
+	mountInfo := filepath.Join(dir, "mountinfo")
+	id := "8c046cb0b72cd4c99f51b5591cd5b095967f58ee003710a45280c28ee1a9c7fa"
+
+	// missing files: detection fails silently
+	assert.Equal(t, "", detectContainerID(cgroup, mountInfo))
+
+	// cgroup v2 with a private namespace: we fallback on mountinfo
+	require.Nil(t, ioutil.WriteFile(cgroup, []byte("0::/\n"), 0644))
+	require.Nil(t, ioutil.WriteFile(mountInfo, []byte("1 2 3:4 /var/lib/docker/containers/"+id+"/hostname /etc/hostname rw - ext4 /dev/vda1 rw\n"), 0644))
+	assert.Equal(t, id, detectContainerID(cgroup, mountInfo))
+
+	require.Nil(t, ioutil.WriteFile(cgroup, []byte("1:name=systemd:/docker/"+id+"\n"), 0644))
+	require.Nil(t, os.Remove(mountInfo))
+	assert.Equal(t, id, detectContainerID(cgroup, mountInfo))
+}
+
+func TestSanitizeExternalEnv(t *testing.T) {
+	assert.Equal(t, "it-false,cn-nginx,pu-123", sanitizeExternalEnv(" it-false,cn-nginx,pu-123\n"))
+	assert.Equal(t, "it-false,cn-nginx", sanitizeExternalEnv("it-false|,cn-ng\r\ninx"))
+}
+
+func TestResolveExternalEnv(t *testing.T) {
+	defer os.Unsetenv(externalEnvVarName)
+
+	assert.Equal(t, "", resolveExternalEnv(""))
+
+	os.Setenv(externalEnvVarName, "it-false,cn-nginx|")
+	assert.Equal(t, "it-false,cn-nginx", resolveExternalEnv(""))
+
+	// the value given by the user has priority
+	assert.Equal(t, "it-true", resolveExternalEnv("it-true"))
+}
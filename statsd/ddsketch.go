@@ -0,0 +1,86 @@
+package statsd
+
+import (
+	"math"
+	"sort"
+	"strconv"
+)
+
+const (
+	// ddSketchRelativeAccuracy is the maximum relative error of the values flushed by the sketches of
+	// WithDDSketchEncoding: every value is flushed as the center of its bin, within 1% of it.
+	ddSketchRelativeAccuracy = 0.01
+	// ddSketchMinValue is the smallest absolute value with its own bins, smaller ones are counted as 0.
+	ddSketchMinValue = 1e-9
+	// ddSketchSignificantDigits is the number of significant digits the centers of the bins are rounded to, keeping
+	// the messages short for an error negligible compared to ddSketchRelativeAccuracy.
+	ddSketchSignificantDigits = 6
+)
+
+var (
+	ddSketchGamma    = (1 + ddSketchRelativeAccuracy) / (1 - ddSketchRelativeAccuracy)
+	ddSketchLogGamma = math.Log(ddSketchGamma)
+)
+
+// ddSketch accumulates the values of a distribution context into the logarithmic bins of a DDSketch, see
+// WithDDSketchEncoding. The bin of index i holds the values in (gamma^(i-1), gamma^i] so any of them is within
+// ddSketchRelativeAccuracy of the center of the bin. The memory used depends on the range of the values, not on their
+// number.
+type ddSketch struct {
+	positive map[int]uint64
+	negative map[int]uint64
+	zeros    uint64
+}
+
+func newDDSketch() *ddSketch {
+	return &ddSketch{
+		positive: map[int]uint64{},
+		negative: map[int]uint64{},
+	}
+}
+
+func (s *ddSketch) add(v float64) {
+	switch {
+	case v >= ddSketchMinValue:
+		s.positive[ddSketchIndex(v)]++
+	case v <= -ddSketchMinValue:
+		s.negative[ddSketchIndex(-v)]++
+	default:
+		s.zeros++
+	}
+}
+
+// ddSketchIndex returns the index of the bin of a positive value.
+func ddSketchIndex(v float64) int {
+	return int(math.Ceil(math.Log(v) / ddSketchLogGamma))
+}
+
+// ddSketchValue returns the center of the bin of index i, the value it is flushed as.
+func ddSketchValue(i int) float64 {
+	v := 2 * math.Pow(ddSketchGamma, float64(i)) / (ddSketchGamma + 1)
+	v, _ = strconv.ParseFloat(strconv.FormatFloat(v, 'g', ddSketchSignificantDigits, 64), 64)
+	return v
+}
+
+// valuesByCount returns the centers of the non empty bins grouped by their number of values, the counts and the
+// values being in increasing order.
+func (s *ddSketch) valuesByCount() ([]uint64, map[uint64][]float64) {
+	byCount := map[uint64][]float64{}
+	for i, n := range s.positive {
+		byCount[n] = append(byCount[n], ddSketchValue(i))
+	}
+	for i, n := range s.negative {
+		byCount[n] = append(byCount[n], -ddSketchValue(i))
+	}
+	if s.zeros != 0 {
+		byCount[s.zeros] = append(byCount[s.zeros], 0)
+	}
+
+	counts := make([]uint64, 0, len(byCount))
+	for n, values := range byCount {
+		sort.Float64s(values)
+		counts = append(counts, n)
+	}
+	sort.Slice(counts, func(i, j int) bool { return counts[i] < counts[j] })
+	return counts, byCount
+}
@@ -0,0 +1,110 @@
+package statsd
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ddSketchTolerance is the relative error allowed for a value flushed by a sketch, including the rounding of the
+// centers of the bins.
+const ddSketchTolerance = ddSketchRelativeAccuracy + 1e-5
+
+func TestDDSketchRelativeError(t *testing.T) {
+	for _, v := range []float64{1e-6, 0.001, 0.5, 1, 1.5, 10, 42, 1234.5678, 1e9} {
+		center := ddSketchValue(ddSketchIndex(v))
+		assert.InEpsilon(t, v, center, ddSketchTolerance, "%v flushed as %v", v, center)
+	}
+}
+
+func TestDDSketchValuesByCount(t *testing.T) {
+	s := newDDSketch()
+	for _, v := range []float64{10, 10, 10.01, 100, -5, -5, 0, 1e-12} {
+		s.add(v)
+	}
+
+	counts, byCount := s.valuesByCount()
+	require.Equal(t, []uint64{1, 2, 3}, counts)
+	assert.Equal(t, []float64{ddSketchValue(ddSketchIndex(100))}, byCount[1])
+	// the zeros and the values too small to have their own bin share the bin of 0
+	assert.Equal(t, []float64{-ddSketchValue(ddSketchIndex(5)), 0}, byCount[2])
+	// 10 and 10.01 share a bin
+	assert.Equal(t, []float64{ddSketchValue(ddSketchIndex(10))}, byCount[3])
+}
+
+// sketchSamples returns the samples described by the distribution messages of a DDSketch, each value being repeated
+// as many times as the Agent would count it given the rate of its message.
+func sketchSamples(t *testing.T, messages []string) []float64 {
+	var samples []float64
+	for _, message := range messages {
+		parts := strings.Split(message, "|")
+		require.Equal(t, "d", parts[1], message)
+		weight := 1
+		if len(parts) > 2 && strings.HasPrefix(parts[2], "@") {
+			rate, err := strconv.ParseFloat(parts[2][1:], 64)
+			require.NoError(t, err)
+			weight = int(math.Round(1 / rate))
+		}
+		for _, value := range strings.Split(parts[0], ":")[1:] {
+			v, err := strconv.ParseFloat(value, 64)
+			require.NoError(t, err)
+			for i := 0; i < weight; i++ {
+				samples = append(samples, v)
+			}
+		}
+	}
+	sort.Float64s(samples)
+	return samples
+}
+
+func TestClientDDSketchEncoding(t *testing.T) {
+	w := statsdWriterWrapper{}
+	client, err := NewWithWriter(&w, WithoutTelemetry(), WithExtendedClientSideAggregation(), WithDDSketchEncoding())
+	require.Nil(t, err)
+
+	random := rand.New(rand.NewSource(42))
+	raw := make([]float64, 10000)
+	for i := range raw {
+		raw[i] = math.Exp(random.NormFloat64()) * 100
+		client.Distribution("latency", raw[i], nil, 1)
+	}
+	client.Close()
+
+	// the samples are flushed as much fewer values
+	assert.Less(t, len(w.data), 100)
+	samples := sketchSamples(t, w.data)
+	require.Len(t, samples, len(raw))
+	sort.Float64s(raw)
+	for _, p := range []float64{0, 50, 75, 90, 95, 99, 99.9, 100} {
+		expected := nearestRankPercentile(raw, p)
+		actual := nearestRankPercentile(samples, p)
+		assert.InEpsilon(t, expected, actual, ddSketchTolerance, "p%v", p)
+	}
+}
+
+func TestClientDDSketchEncodingRate(t *testing.T) {
+	w := statsdWriterWrapper{}
+	client, err := NewWithWriter(&w, WithoutTelemetry(), WithExtendedClientSideAggregation(), WithDDSketchEncoding(), WithSampler(&recordingSampler{}))
+	require.Nil(t, err)
+
+	for i := 0; i < 4; i++ {
+		client.Distribution("latency", 2, []string{"tag1"}, 0.5)
+	}
+	client.Distribution("latency", 3, []string{"tag1"}, 0.5)
+	// histograms are not affected
+	client.Histogram("histogram", 2, nil, 1)
+	client.Close()
+
+	ts := &testServer{}
+	ts.assertMetric(t, w.data, []string{
+		"latency:" + strconv.FormatFloat(ddSketchValue(ddSketchIndex(3)), 'f', -1, 64) + "|d|@0.5|#tag1",
+		"latency:" + strconv.FormatFloat(ddSketchValue(ddSketchIndex(2)), 'f', -1, 64) + "|d|@0.125|#tag1",
+		"histogram:2|h",
+	})
+}
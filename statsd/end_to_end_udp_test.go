@@ -43,8 +43,8 @@ func TestKownEnvTags(t *testing.T) {
 		expectedTags,
 	)
 
-	sort.Strings(client.tags)
-	assert.Equal(t, expectedTags, client.tags)
+	sort.Strings(client.getGlobalTags().tags)
+	assert.Equal(t, expectedTags, client.getGlobalTags().tags)
 	ts.sendAllAndAssert(t, client)
 }
 
@@ -76,8 +76,8 @@ func TestKnownEnvTagsWithCustomTags(t *testing.T) {
 	ts.sendAllAndAssert(t, client)
 
 	sort.Strings(expectedTags)
-	sort.Strings(client.tags)
-	assert.Equal(t, expectedTags, client.tags)
+	sort.Strings(client.getGlobalTags().tags)
+	assert.Equal(t, expectedTags, client.getGlobalTags().tags)
 }
 
 func TestKnownEnvTagsEmptyString(t *testing.T) {
@@ -102,7 +102,7 @@ func TestKnownEnvTagsEmptyString(t *testing.T) {
 		nil,
 	)
 
-	assert.Len(t, client.tags, 0)
+	assert.Len(t, client.getGlobalTags().tags, 0)
 	ts.sendAllAndAssert(t, client)
 }
 
@@ -186,9 +186,9 @@ func getTestMap() map[string]testCase {
 				ts.sendAllAndAssert(t, client)
 				// We send 4 non aggregated metrics, 1 service_check and 1 event. So 2 reads (5 items per
 				// payload). Then we flush the aggregator that will send 5 metrics, so 1 read. Finally,
-				// the telemetry is 18 metrics flushed at a different time so 4 more payload for a
-				// total of 8 reads on the network
-				ts.assertNbRead(t, 8)
+				// the telemetry is 31 metrics flushed at a different time so 7 more payload for a
+				// total of 10 reads on the network
+				ts.assertNbRead(t, 10)
 			},
 		},
 		"With max messages per payload + WithoutClientSideAggregation": testCase{
@@ -202,9 +202,9 @@ func getTestMap() map[string]testCase {
 			func(t *testing.T, ts *testServer, client *Client) {
 				ts.sendAllAndAssert(t, client)
 				// We send 9 non aggregated metrics, 1 service_check and 1 event. So 3 reads (5 items
-				// per payload). Then the telemetry is 18 metrics flushed at a different time so 4 more
+				// per payload). Then the telemetry is 21 metrics flushed at a different time so 5 more
 				// payload for a total of 8 reads on the network
-				ts.assertNbRead(t, 7)
+				ts.assertNbRead(t, 8)
 			},
 		},
 		"ChannelMode without client side aggregation": testCase{
@@ -232,6 +232,23 @@ func getTestMap() map[string]testCase {
 				ts.assert(t, client, expectedMetrics)
 			},
 		},
+		"Timing client side aggregation": testCase{
+			[]Option{
+				WithTimingAggregation(),
+			},
+			func(t *testing.T, ts *testServer, client *Client) {
+				ts.sendAllAndAssert(t, client)
+			},
+		},
+		"Timing client side aggregation + ChannelMode": testCase{
+			[]Option{
+				WithTimingAggregation(),
+				WithChannelMode(),
+			},
+			func(t *testing.T, ts *testServer, client *Client) {
+				ts.sendAllAndAssert(t, client)
+			},
+		},
 		"Basic client side aggregation + ChannelMode": testCase{
 			[]Option{
 				WithChannelMode(),
@@ -267,3 +284,34 @@ func TestFullPipelineUDP(t *testing.T) {
 		})
 	}
 }
+
+func TestTimingAggregationFlushInterval(t *testing.T) {
+	ts, client := newClientAndTestServer(t,
+		"udp",
+		"localhost:8765",
+		nil,
+		WithTimingAggregation(),
+		WithAggregationInterval(100*time.Millisecond),
+		WithoutTelemetry(),
+	)
+
+	client.TimeInMilliseconds("timing", 1, []string{"tag1", "tag2"}, 1)
+	client.TimeInMilliseconds("timing", 2, []string{"tag1", "tag2"}, 1)
+	client.TimeInMilliseconds("timing", 3, []string{"tag1", "tag2"}, 1)
+	client.TimeInMilliseconds("timing", 4, []string{"tag1", "tag3"}, 1)
+	client.Histogram("histogram", 5, []string{"tag1", "tag2"}, 1)
+	client.Histogram("histogram", 6, []string{"tag1", "tag2"}, 1)
+
+	// we don't call Close or Flush: the aggregator must send the timings on
+	// its own after the aggregation interval.
+	ts.wait(t, 4, 5, false)
+	client.Close()
+	ts.stop()
+
+	ts.assertMetric(t, ts.getData(), []string{
+		"timing:1.000000:2.000000:3.000000|ms|#tag1,tag2",
+		"timing:4.000000|ms|#tag1,tag3",
+		"histogram:5|h|#tag1,tag2",
+		"histogram:6|h|#tag1,tag2",
+	})
+}
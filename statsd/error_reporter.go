@@ -0,0 +1,127 @@
+package statsd
+
+import (
+	"fmt"
+	"sync"
+)
+
+// ErrorHandler is called with a *DroppedMetricError every time the client drops data, see WithErrorHandler.
+type ErrorHandler func(err error)
+
+type dropReasonErr string
+
+const (
+	// ErrQueueFull is the reason given when a metric or a payload is dropped because a client queue is full: the
+	// channel of a worker or the aggregator in channel mode or the queue of payloads waiting to be written.
+	ErrQueueFull = dropReasonErr("statsd queue is full")
+	// ErrWriteFailed is the reason given when a payload could not be written to the transport.
+	ErrWriteFailed = dropReasonErr("statsd write failed")
+	// ErrBufferTooSmall is the reason given when a metric does not fit in an empty buffer, see
+	// WithMaxBytesPerPayload, WithMaxMessagesPerPayload and WithOversizedMetricPolicy.
+	ErrBufferTooSmall = dropReasonErr("statsd metric is too big for the buffer")
+	// ErrBlockTimeout is the reason given when a metric is dropped because the caller waited longer than the duration
+	// set by WithMaxBlockDuration.
+	ErrBlockTimeout = dropReasonErr("statsd client was blocked for too long")
+	// ErrRateLimited is the reason given when a payload is dropped because the client sent more payloads than allowed
+	// by WithRateLimit.
+	ErrRateLimited = dropReasonErr("statsd rate limit exceeded")
+	// ErrSerialization is the reason given when a metric can't be serialized: it was rejected by the validation of
+	// WithStrictNameValidation or its message could not be written. The error is in the Cause.
+	ErrSerialization = dropReasonErr("statsd metric could not be serialized")
+)
+
+func (e dropReasonErr) Error() string {
+	return string(e)
+}
+
+// DroppedMetricError describes data dropped by the client. It unwraps to its Reason so it can be checked with
+// errors.Is(err, ErrQueueFull).
+type DroppedMetricError struct {
+	// Name of the dropped metric. It is empty when a whole payload is dropped since it contains many metrics, and for
+	// events and service checks.
+	Name string
+	// Reason is one of ErrQueueFull, ErrWriteFailed, ErrBufferTooSmall, ErrBlockTimeout, ErrRateLimited or
+	// ErrSerialization.
+	Reason error
+	// Cause is the error returned by the transport for ErrWriteFailed and the serialization error for
+	// ErrSerialization, nil otherwise.
+	Cause error
+}
+
+func (e *DroppedMetricError) Error() string {
+	msg := e.Reason.Error()
+	if e.Name != "" {
+		msg = fmt.Sprintf("%s: dropped '%s'", msg, e.Name)
+	}
+	if e.Cause != nil {
+		msg = fmt.Sprintf("%s: %s", msg, e.Cause)
+	}
+	return msg
+}
+
+func (e *DroppedMetricError) Unwrap() error {
+	return e.Reason
+}
+
+// errorReporterQueueSize is the number of errors that can wait for the ErrorHandler before new ones are discarded.
+const errorReporterQueueSize = 128
+
+// errorReporter forwards errors to the user ErrorHandler from its own goroutine so a slow handler never blocks the
+// workers or the sender. A nil errorReporter discards every error.
+type errorReporter struct {
+	handler ErrorHandler
+	errors  chan error
+	stop    chan struct{}
+	wg      sync.WaitGroup
+}
+
+func newErrorReporter(handler ErrorHandler) *errorReporter {
+	r := &errorReporter{
+		handler: handler,
+		errors:  make(chan error, errorReporterQueueSize),
+		stop:    make(chan struct{}),
+	}
+
+	r.wg.Add(1)
+	go r.run()
+	return r
+}
+
+func (r *errorReporter) run() {
+	defer r.wg.Done()
+	for {
+		select {
+		case err := <-r.errors:
+			r.handler(err)
+		case <-r.stop:
+			// report what was queued before the close
+			for {
+				select {
+				case err := <-r.errors:
+					r.handler(err)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// report never blocks: the error is discarded if too many errors are waiting for the handler.
+func (r *errorReporter) report(name string, reason error, cause error) {
+	if r == nil {
+		return
+	}
+	select {
+	case r.errors <- &DroppedMetricError{Name: name, Reason: reason, Cause: cause}:
+	default:
+	}
+}
+
+func (r *errorReporter) close() {
+	if r == nil {
+		return
+	}
+	close(r.stop)
+	r.wg.Wait()
+}
@@ -0,0 +1,50 @@
+package statsd
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDroppedMetricError(t *testing.T) {
+	err := error(&DroppedMetricError{Name: "metric", Reason: ErrQueueFull})
+	assert.Equal(t, "statsd queue is full: dropped 'metric'", err.Error())
+	assert.True(t, errors.Is(err, ErrQueueFull))
+	assert.False(t, errors.Is(err, ErrWriteFailed))
+
+	err = &DroppedMetricError{Reason: ErrWriteFailed, Cause: fmt.Errorf("connection refused")}
+	assert.Equal(t, "statsd write failed: connection refused", err.Error())
+	assert.True(t, errors.Is(err, ErrWriteFailed))
+}
+
+func TestErrorReporter(t *testing.T) {
+	received := []error{}
+	r := newErrorReporter(func(err error) { received = append(received, err) })
+
+	r.report("metric", ErrBufferTooSmall, nil)
+	r.close()
+
+	assert.Equal(t, []error{&DroppedMetricError{Name: "metric", Reason: ErrBufferTooSmall}}, received)
+}
+
+func TestErrorReporterNeverBlocks(t *testing.T) {
+	unblock := make(chan struct{})
+	r := newErrorReporter(func(err error) { <-unblock })
+
+	// the handler is stuck: errors past the queue size are discarded instead of blocking
+	for i := 0; i < errorReporterQueueSize*2; i++ {
+		r.report("metric", ErrQueueFull, nil)
+	}
+	close(unblock)
+	r.close()
+}
+
+func TestErrorReporterNil(t *testing.T) {
+	var r *errorReporter
+	assert.NotPanics(t, func() {
+		r.report("metric", ErrQueueFull, nil)
+		r.close()
+	})
+}
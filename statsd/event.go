@@ -42,7 +42,9 @@ type Event struct {
 	// Timestamp is a timestamp for the event.  If not provided, the dogstatsd
 	// server will set this to the current time.
 	Timestamp time.Time
-	// Hostname for the event.
+	// Hostname for the event, when it describes another host than the one
+	// sending it. If not provided, the host given to WithConstantHostname is
+	// used. '|' and line breaks are replaced with '_'.
 	Hostname string
 	// AggregationKey groups this event with others of the same key.
 	AggregationKey string
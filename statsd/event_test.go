@@ -2,6 +2,7 @@ package statsd
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -40,6 +41,33 @@ func TestEventEncode(t *testing.T) {
 		}, {
 			&Event{Title: "hi", Text: "line1\nline2", Tags: []string{"hello\nworld"}},
 			`_e{2,12}:hi|line1\nline2|#helloworld`,
+		}, {
+			&Event{Title: "hi", Text: "okay", Timestamp: time.Unix(1658934956, 0)},
+			`_e{2,4}:hi|okay|d:1658934956`,
+		}, {
+			&Event{Title: "hi", Text: "okay", Hostname: "host1", SourceTypeName: "jenkins", Priority: Low, AlertType: Warning},
+			`_e{2,4}:hi|okay|h:host1|p:low|s:jenkins|t:warning`,
+		}, {
+			&Event{
+				Title:          "hi",
+				Text:           "line1\nline2",
+				Timestamp:      time.Unix(1658934956, 0),
+				Hostname:       "host1",
+				AggregationKey: "foo",
+				Priority:       Normal,
+				SourceTypeName: "jenkins",
+				AlertType:      Success,
+				Tags:           []string{"host:foo"},
+			},
+			`_e{2,12}:hi|line1\nline2|d:1658934956|h:host1|k:foo|p:normal|s:jenkins|t:success|#host:foo`,
+		}, {
+			// the hostname can't end the field
+			&Event{Title: "hi", Text: "okay", Hostname: "host|1\nhost\r2"},
+			`_e{2,4}:hi|okay|h:host_1_host_2`,
+		}, {
+			// lengths are in bytes, not in characters
+			&Event{Title: "héllo", Text: "日本\n語"},
+			`_e{6,11}:héllo|日本\n語`,
 		},
 	}
 
@@ -1,6 +1,7 @@
 package statsd
 
 import (
+	"math"
 	"strconv"
 	"strings"
 )
@@ -12,6 +13,7 @@ var (
 	distributionSymbol = []byte("d")
 	setSymbol          = []byte("s")
 	timingSymbol       = []byte("ms")
+	sketchSymbol       = []byte("ds")
 	tagSeparatorSymbol = ","
 )
 
@@ -24,8 +26,10 @@ func appendHeader(buffer []byte, namespace string, name string) []byte {
 	return buffer
 }
 
-func appendRate(buffer []byte, rate float64) []byte {
-	if rate < 1 {
+// appendRate appends the sample rate of a metric, omitted when it is 1 unless alwaysRate is set, see
+// WithAlwaysEmitSampleRate.
+func appendRate(buffer []byte, rate float64, alwaysRate bool) []byte {
+	if rate < 1 || alwaysRate {
 		buffer = append(buffer, "|@"...)
 		buffer = strconv.AppendFloat(buffer, rate, 'f', -1, 64)
 	}
@@ -94,58 +98,90 @@ func appendTagsAggregated(buffer []byte, globalTags []string, tags string) []byt
 	return buffer
 }
 
-func appendFloatMetric(buffer []byte, typeSymbol []byte, namespace string, globalTags []string, name string, value float64, tags []string, rate float64, precision int) []byte {
+func appendFloatMetric(buffer []byte, typeSymbol []byte, namespace string, globalTags []string, name string, value float64, tags []string, rate float64, precision int, alwaysRate bool) []byte {
 	buffer = appendHeader(buffer, namespace, name)
 	buffer = strconv.AppendFloat(buffer, value, 'f', precision, 64)
 	buffer = append(buffer, '|')
 	buffer = append(buffer, typeSymbol...)
-	buffer = appendRate(buffer, rate)
+	buffer = appendRate(buffer, rate, alwaysRate)
 	buffer = appendTags(buffer, globalTags, tags)
 	return buffer
 }
 
-func appendIntegerMetric(buffer []byte, typeSymbol []byte, namespace string, globalTags []string, name string, value int64, tags []string, rate float64) []byte {
+func appendIntegerMetric(buffer []byte, typeSymbol []byte, namespace string, globalTags []string, name string, value int64, tags []string, rate float64, alwaysRate bool) []byte {
 	buffer = appendHeader(buffer, namespace, name)
 	buffer = strconv.AppendInt(buffer, value, 10)
 	buffer = append(buffer, '|')
 	buffer = append(buffer, typeSymbol...)
-	buffer = appendRate(buffer, rate)
+	buffer = appendRate(buffer, rate, alwaysRate)
 	buffer = appendTags(buffer, globalTags, tags)
 	return buffer
 }
 
-func appendStringMetric(buffer []byte, typeSymbol []byte, namespace string, globalTags []string, name string, value string, tags []string, rate float64) []byte {
+func appendStringMetric(buffer []byte, typeSymbol []byte, namespace string, globalTags []string, name string, value string, tags []string, rate float64, alwaysRate bool) []byte {
 	buffer = appendHeader(buffer, namespace, name)
 	buffer = append(buffer, value...)
 	buffer = append(buffer, '|')
 	buffer = append(buffer, typeSymbol...)
-	buffer = appendRate(buffer, rate)
+	buffer = appendRate(buffer, rate, alwaysRate)
 	buffer = appendTags(buffer, globalTags, tags)
 	return buffer
 }
 
-func appendGauge(buffer []byte, namespace string, globalTags []string, name string, value float64, tags []string, rate float64) []byte {
-	return appendFloatMetric(buffer, gaugeSymbol, namespace, globalTags, name, value, tags, rate, -1)
+func appendGauge(buffer []byte, namespace string, globalTags []string, name string, value float64, tags []string, rate float64, alwaysRate bool) []byte {
+	return appendFloatMetric(buffer, gaugeSymbol, namespace, globalTags, name, value, tags, rate, -1, alwaysRate)
 }
 
-func appendCount(buffer []byte, namespace string, globalTags []string, name string, value int64, tags []string, rate float64) []byte {
-	return appendIntegerMetric(buffer, countSymbol, namespace, globalTags, name, value, tags, rate)
+func appendCount(buffer []byte, namespace string, globalTags []string, name string, value int64, tags []string, rate float64, alwaysRate bool) []byte {
+	return appendIntegerMetric(buffer, countSymbol, namespace, globalTags, name, value, tags, rate, alwaysRate)
 }
 
-func appendHistogram(buffer []byte, namespace string, globalTags []string, name string, value float64, tags []string, rate float64) []byte {
-	return appendFloatMetric(buffer, histogramSymbol, namespace, globalTags, name, value, tags, rate, -1)
+func appendHistogram(buffer []byte, namespace string, globalTags []string, name string, value float64, tags []string, rate float64, alwaysRate bool) []byte {
+	return appendFloatMetric(buffer, histogramSymbol, namespace, globalTags, name, value, tags, rate, -1, alwaysRate)
 }
 
-func appendDistribution(buffer []byte, namespace string, globalTags []string, name string, value float64, tags []string, rate float64) []byte {
-	return appendFloatMetric(buffer, distributionSymbol, namespace, globalTags, name, value, tags, rate, -1)
+func appendDistribution(buffer []byte, namespace string, globalTags []string, name string, value float64, tags []string, rate float64, alwaysRate bool) []byte {
+	return appendFloatMetric(buffer, distributionSymbol, namespace, globalTags, name, value, tags, rate, -1, alwaysRate)
 }
 
-func appendSet(buffer []byte, namespace string, globalTags []string, name string, value string, tags []string, rate float64) []byte {
-	return appendStringMetric(buffer, setSymbol, namespace, globalTags, name, value, tags, rate)
+func appendSet(buffer []byte, namespace string, globalTags []string, name string, value string, tags []string, rate float64, alwaysRate bool) []byte {
+	return appendStringMetric(buffer, setSymbol, namespace, globalTags, name, value, tags, rate, alwaysRate)
 }
 
-func appendTiming(buffer []byte, namespace string, globalTags []string, name string, value float64, tags []string, rate float64) []byte {
-	return appendFloatMetric(buffer, timingSymbol, namespace, globalTags, name, value, tags, rate, 6)
+func appendSetInt(buffer []byte, namespace string, globalTags []string, name string, value int64, tags []string, rate float64, alwaysRate bool) []byte {
+	return appendIntegerMetric(buffer, setSymbol, namespace, globalTags, name, value, tags, rate, alwaysRate)
+}
+
+// timingPrecision is the number of decimals timings are formatted with by default.
+const timingPrecision = 6
+
+func appendTiming(buffer []byte, namespace string, globalTags []string, name string, value float64, tags []string, rate float64, precision int, alwaysRate bool) []byte {
+	return appendFloatMetric(buffer, timingSymbol, namespace, globalTags, name, value, tags, rate, precision, alwaysRate)
+}
+
+// appendDistributionSketch appends the summary of a distribution, see Client.DistributionSketch.
+func appendDistributionSketch(buffer []byte, namespace string, globalTags []string, name string, count int64, sum float64, min float64, max float64, tags []string) []byte {
+	buffer = appendHeader(buffer, namespace, name)
+	buffer = strconv.AppendInt(buffer, count, 10)
+	for _, v := range [3]float64{sum, min, max} {
+		buffer = append(buffer, ':')
+		buffer = strconv.AppendFloat(buffer, v, 'f', -1, 64)
+	}
+	buffer = append(buffer, '|')
+	buffer = append(buffer, sketchSymbol...)
+	buffer = appendTags(buffer, globalTags, tags)
+	return buffer
+}
+
+// roundFloat rounds value to precision decimals. Values too big to be scaled without losing precision, and values
+// that are not finite, are returned as is.
+func roundFloat(value float64, precision int) float64 {
+	scale := math.Pow10(precision)
+	scaled := value * scale
+	if math.IsNaN(scaled) || math.Abs(scaled) >= 1<<53 {
+		return value
+	}
+	return math.Round(scaled) / scale
 }
 
 func escapedEventTextLen(text string) int {
@@ -163,6 +199,19 @@ func appendEscapedEventText(buffer []byte, text string) []byte {
 	return buffer
 }
 
+// appendEscapedHostname replaces the characters that would end the hostname field, '|' and line breaks, with '_'.
+func appendEscapedHostname(buffer []byte, hostname string) []byte {
+	for i := 0; i < len(hostname); i++ {
+		switch hostname[i] {
+		case '|', '\n', '\r':
+			buffer = append(buffer, '_')
+		default:
+			buffer = append(buffer, hostname[i])
+		}
+	}
+	return buffer
+}
+
 func appendEvent(buffer []byte, event *Event, globalTags []string) []byte {
 	escapedTextLen := escapedEventTextLen(event.Text)
 
@@ -186,7 +235,7 @@ func appendEvent(buffer []byte, event *Event, globalTags []string) []byte {
 
 	if len(event.Hostname) != 0 {
 		buffer = append(buffer, "|h:"...)
-		buffer = append(buffer, event.Hostname...)
+		buffer = appendEscapedHostname(buffer, event.Hostname)
 	}
 
 	if len(event.AggregationKey) != 0 {
@@ -240,7 +289,7 @@ func appendServiceCheck(buffer []byte, serviceCheck *ServiceCheck, globalTags []
 
 	if len(serviceCheck.Hostname) != 0 {
 		buffer = append(buffer, "|h:"...)
-		buffer = append(buffer, serviceCheck.Hostname...)
+		buffer = appendEscapedHostname(buffer, serviceCheck.Hostname)
 	}
 
 	buffer = appendTags(buffer, globalTags, serviceCheck.Tags)
@@ -252,6 +301,39 @@ func appendServiceCheck(buffer []byte, serviceCheck *ServiceCheck, globalTags []
 	return buffer
 }
 
+func appendTimestamp(buffer []byte, timestamp int64) []byte {
+	if timestamp > noTimestamp {
+		buffer = append(buffer, "|T"...)
+		buffer = strconv.AppendInt(buffer, timestamp, 10)
+	}
+	return buffer
+}
+
+func appendContainerID(buffer []byte, containerID string) []byte {
+	if containerID != "" {
+		buffer = append(buffer, "|c:"...)
+		buffer = append(buffer, containerID...)
+	}
+	return buffer
+}
+
+func appendExternalEnv(buffer []byte, externalEnv string) []byte {
+	if externalEnv != "" {
+		buffer = append(buffer, "|e:"...)
+		buffer = append(buffer, externalEnv...)
+	}
+	return buffer
+}
+
+func appendCardinality(buffer []byte, cardinality Cardinality) []byte {
+	if cardinality == CardinalityNotSet {
+		return buffer
+	}
+	buffer = append(buffer, "|card:"...)
+	buffer = append(buffer, cardinality.String()...)
+	return buffer
+}
+
 func appendSeparator(buffer []byte) []byte {
 	return append(buffer, '\n')
 }
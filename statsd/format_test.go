@@ -1,6 +1,7 @@
 package statsd
 
 import (
+	"math"
 	"testing"
 	"time"
 
@@ -45,79 +46,128 @@ func TestFormatAppendTagsAggregated(t *testing.T) {
 
 func TestFormatAppendGauge(t *testing.T) {
 	var buffer []byte
-	buffer = appendGauge(buffer, "namespace.", []string{"global:tag"}, "gauge", 1., []string{"tag:tag"}, 1)
+	buffer = appendGauge(buffer, "namespace.", []string{"global:tag"}, "gauge", 1., []string{"tag:tag"}, 1, false)
 	assert.Equal(t, `namespace.gauge:1|g|#global:tag,tag:tag`, string(buffer))
 }
 
 func TestFormatAppendCount(t *testing.T) {
 	var buffer []byte
-	buffer = appendCount(buffer, "namespace.", []string{"global:tag"}, "count", 2, []string{"tag:tag"}, 1)
+	buffer = appendCount(buffer, "namespace.", []string{"global:tag"}, "count", 2, []string{"tag:tag"}, 1, false)
 	assert.Equal(t, `namespace.count:2|c|#global:tag,tag:tag`, string(buffer))
 }
 
+func TestFormatAppendRate(t *testing.T) {
+	var buffer []byte
+	buffer = appendCount(buffer, "", nil, "count", 2, nil, 1, true)
+	assert.Equal(t, `count:2|c|@1`, string(buffer))
+
+	buffer = appendCount(buffer[:0], "", nil, "count", 2, nil, 0.5, true)
+	assert.Equal(t, `count:2|c|@0.5`, string(buffer))
+}
+
+func TestFormatAppendTimestamp(t *testing.T) {
+	var buffer []byte
+	buffer = appendGauge(buffer, "namespace.", []string{"global:tag"}, "gauge", 1., []string{"tag:tag"}, 1, false)
+	buffer = appendTimestamp(buffer, 1658934956)
+	assert.Equal(t, `namespace.gauge:1|g|#global:tag,tag:tag|T1658934956`, string(buffer))
+
+	var buffer2 []byte
+	buffer2 = appendCount(buffer2, "namespace.", []string{"global:tag"}, "count", 2, []string{"tag:tag"}, 0.5, false)
+	buffer2 = appendTimestamp(buffer2, 1658934956)
+	assert.Equal(t, `namespace.count:2|c|@0.5|#global:tag,tag:tag|T1658934956`, string(buffer2))
+
+	var buffer3 []byte
+	buffer3 = appendGauge(buffer3, "namespace.", []string{"global:tag"}, "gauge", 1., []string{"tag:tag"}, 1, false)
+	buffer3 = appendTimestamp(buffer3, noTimestamp)
+	assert.Equal(t, `namespace.gauge:1|g|#global:tag,tag:tag`, string(buffer3))
+}
+
 func TestFormatAppendHistogram(t *testing.T) {
 	var buffer []byte
-	buffer = appendHistogram(buffer, "namespace.", []string{"global:tag"}, "histogram", 3., []string{"tag:tag"}, 1)
+	buffer = appendHistogram(buffer, "namespace.", []string{"global:tag"}, "histogram", 3., []string{"tag:tag"}, 1, false)
 	assert.Equal(t, `namespace.histogram:3|h|#global:tag,tag:tag`, string(buffer))
 }
 
 func TestFormatAppendDistribution(t *testing.T) {
 	var buffer []byte
-	buffer = appendDistribution(buffer, "namespace.", []string{"global:tag"}, "distribution", 4., []string{"tag:tag"}, 1)
+	buffer = appendDistribution(buffer, "namespace.", []string{"global:tag"}, "distribution", 4., []string{"tag:tag"}, 1, false)
 	assert.Equal(t, `namespace.distribution:4|d|#global:tag,tag:tag`, string(buffer))
 }
 
+func TestFormatAppendDistributionSketch(t *testing.T) {
+	var buffer []byte
+	buffer = appendDistributionSketch(buffer, "namespace.", []string{"global:tag"}, "sketch", 20, 85.5, 0.25, 9, []string{"tag:tag"})
+	assert.Equal(t, `namespace.sketch:20:85.5:0.25:9|ds|#global:tag,tag:tag`, string(buffer))
+}
+
 func TestFormatAppendSet(t *testing.T) {
 	var buffer []byte
-	buffer = appendSet(buffer, "namespace.", []string{"global:tag"}, "set", "five", []string{"tag:tag"}, 1)
+	buffer = appendSet(buffer, "namespace.", []string{"global:tag"}, "set", "five", []string{"tag:tag"}, 1, false)
 	assert.Equal(t, `namespace.set:five|s|#global:tag,tag:tag`, string(buffer))
 }
 
+func TestFormatAppendSetInt(t *testing.T) {
+	var buffer []byte
+	buffer = appendSetInt(buffer, "namespace.", []string{"global:tag"}, "set", -5, []string{"tag:tag"}, 1, false)
+	assert.Equal(t, `namespace.set:-5|s|#global:tag,tag:tag`, string(buffer))
+}
+
 func TestFormatAppendTiming(t *testing.T) {
 	var buffer []byte
-	buffer = appendTiming(buffer, "namespace.", []string{"global:tag"}, "timing", 6., []string{"tag:tag"}, 1)
+	buffer = appendTiming(buffer, "namespace.", []string{"global:tag"}, "timing", 6., []string{"tag:tag"}, 1, timingPrecision, false)
 	assert.Equal(t, `namespace.timing:6.000000|ms|#global:tag,tag:tag`, string(buffer))
 }
 
+func TestFormatRoundFloat(t *testing.T) {
+	assert.Equal(t, 1.235, roundFloat(1.23456, 3))
+	assert.Equal(t, 2.5, roundFloat(2.5, 3))
+	assert.Equal(t, 3., roundFloat(2.5, 0))
+	assert.Equal(t, -0.12, roundFloat(-0.1234, 2))
+	// values too big to be scaled are kept
+	assert.Equal(t, 1e300, roundFloat(1e300, 3))
+	assert.True(t, math.IsNaN(roundFloat(math.NaN(), 3)))
+	assert.True(t, math.IsInf(roundFloat(math.Inf(1), 3), 1))
+}
+
 func TestFormatNoTag(t *testing.T) {
 	var buffer []byte
-	buffer = appendGauge(buffer, "", []string{}, "gauge", 1., []string{}, 1)
+	buffer = appendGauge(buffer, "", []string{}, "gauge", 1., []string{}, 1, false)
 	assert.Equal(t, `gauge:1|g`, string(buffer))
 }
 
 func TestFormatOneTag(t *testing.T) {
 	var buffer []byte
-	buffer = appendGauge(buffer, "", []string{}, "gauge", 1., []string{"tag1:tag1"}, 1)
+	buffer = appendGauge(buffer, "", []string{}, "gauge", 1., []string{"tag1:tag1"}, 1, false)
 	assert.Equal(t, `gauge:1|g|#tag1:tag1`, string(buffer))
 }
 
 func TestFormatTwoTag(t *testing.T) {
 	var buffer []byte
-	buffer = appendGauge(buffer, "", []string{}, "metric", 1., []string{"tag1:tag1", "tag2:tag2"}, 1)
+	buffer = appendGauge(buffer, "", []string{}, "metric", 1., []string{"tag1:tag1", "tag2:tag2"}, 1, false)
 	assert.Equal(t, `metric:1|g|#tag1:tag1,tag2:tag2`, string(buffer))
 }
 
 func TestFormatRate(t *testing.T) {
 	var buffer []byte
-	buffer = appendGauge(buffer, "", []string{}, "metric", 1., []string{}, 0.1)
+	buffer = appendGauge(buffer, "", []string{}, "metric", 1., []string{}, 0.1, false)
 	assert.Equal(t, `metric:1|g|@0.1`, string(buffer))
 }
 
 func TestFormatRateAndTag(t *testing.T) {
 	var buffer []byte
-	buffer = appendGauge(buffer, "", []string{}, "metric", 1., []string{"tag1:tag1"}, 0.1)
+	buffer = appendGauge(buffer, "", []string{}, "metric", 1., []string{"tag1:tag1"}, 0.1, false)
 	assert.Equal(t, `metric:1|g|@0.1|#tag1:tag1`, string(buffer))
 }
 
 func TestFormatNil(t *testing.T) {
 	var buffer []byte
-	buffer = appendGauge(buffer, "", nil, "metric", 1., nil, 1)
+	buffer = appendGauge(buffer, "", nil, "metric", 1., nil, 1, false)
 	assert.Equal(t, `metric:1|g`, string(buffer))
 }
 
 func TestFormatTagRemoveNewLines(t *testing.T) {
 	var buffer []byte
-	buffer = appendGauge(buffer, "", []string{"tag\n:d\nog\n"}, "metric", 1., []string{"\ntag\n:d\nog2\n"}, 0.1)
+	buffer = appendGauge(buffer, "", []string{"tag\n:d\nog\n"}, "metric", 1., []string{"\ntag\n:d\nog2\n"}, 0.1, false)
 	assert.Equal(t, `metric:1|g|@0.1|#tag:dog,tag:dog2`, string(buffer))
 }
 
@@ -333,3 +383,20 @@ func TestFormatSeparator(t *testing.T) {
 	buffer = appendSeparator(buffer)
 	assert.Equal(t, "\n", string(buffer))
 }
+
+func TestFormatAppendContainerID(t *testing.T) {
+	assert.Equal(t, "", string(appendContainerID(nil, "")))
+	assert.Equal(t, "|c:other-id", string(appendContainerID(nil, "other-id")))
+
+	config := bufferConfig{containerID: "container-id"}
+	assert.Equal(t, "container-id", config.messageContainerID(""))
+	// the container ID given to the call takes precedence
+	assert.Equal(t, "other-id", config.messageContainerID("other-id"))
+}
+
+func TestFormatAppendCardinality(t *testing.T) {
+	assert.Equal(t, "", string(appendCardinality(nil, CardinalityNotSet)))
+	assert.Equal(t, "|card:low", string(appendCardinality(nil, CardinalityLow)))
+	assert.Equal(t, "|card:orchestrator", string(appendCardinality(nil, CardinalityOrchestrator)))
+	assert.Equal(t, "|card:high", string(appendCardinality(nil, CardinalityHigh)))
+}
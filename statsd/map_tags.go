@@ -0,0 +1,91 @@
+package statsd
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// mapTagsKeysPool holds the scratch slices used to sort the keys of map tags.
+var mapTagsKeysPool = sync.Pool{
+	New: func() interface{} {
+		keys := make([]string, 0, 16)
+		return &keys
+	},
+}
+
+// mapTags converts tags to "key:value" tags sorted by key, so a given map always produces the same tags and
+// therefore the same aggregation context. A key with an empty value gives a tag without value ("key").
+//
+// The tags are sliced from a single string, which costs two allocations whatever the number of tags.
+func mapTags(tags map[string]string) []string {
+	if len(tags) == 0 {
+		return nil
+	}
+
+	keysPtr := mapTagsKeysPool.Get().(*[]string)
+	keys := (*keysPtr)[:0]
+	size := 0
+	for k, v := range tags {
+		keys = append(keys, k)
+		size += len(k) + len(v) + 1
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.Grow(size)
+	for _, k := range keys {
+		b.WriteString(k)
+		if v := tags[k]; v != "" {
+			b.WriteByte(':')
+			b.WriteString(v)
+		}
+	}
+	joined := b.String()
+
+	result := make([]string, len(keys))
+	start := 0
+	for i, k := range keys {
+		end := start + len(k)
+		if v := tags[k]; v != "" {
+			end += len(v) + 1
+		}
+		result[i] = joined[start:end]
+		start = end
+	}
+
+	*keysPtr = keys[:0]
+	mapTagsKeysPool.Put(keysPtr)
+	return result
+}
+
+// GaugeM is Gauge with the tags given as a map, see mapTags.
+func (c *Client) GaugeM(name string, value float64, tags map[string]string, rate float64, parameters ...Parameter) error {
+	return c.Gauge(name, value, mapTags(tags), rate, parameters...)
+}
+
+// CountM is Count with the tags given as a map, see mapTags.
+func (c *Client) CountM(name string, value int64, tags map[string]string, rate float64, parameters ...Parameter) error {
+	return c.Count(name, value, mapTags(tags), rate, parameters...)
+}
+
+// HistogramM is Histogram with the tags given as a map, see mapTags.
+func (c *Client) HistogramM(name string, value float64, tags map[string]string, rate float64, parameters ...Parameter) error {
+	return c.Histogram(name, value, mapTags(tags), rate, parameters...)
+}
+
+// DistributionM is Distribution with the tags given as a map, see mapTags.
+func (c *Client) DistributionM(name string, value float64, tags map[string]string, rate float64, parameters ...Parameter) error {
+	return c.Distribution(name, value, mapTags(tags), rate, parameters...)
+}
+
+// SetM is Set with the tags given as a map, see mapTags.
+func (c *Client) SetM(name string, value string, tags map[string]string, rate float64, parameters ...Parameter) error {
+	return c.Set(name, value, mapTags(tags), rate, parameters...)
+}
+
+// TimingM is Timing with the tags given as a map, see mapTags.
+func (c *Client) TimingM(name string, value time.Duration, tags map[string]string, rate float64, parameters ...Parameter) error {
+	return c.Timing(name, value, mapTags(tags), rate, parameters...)
+}
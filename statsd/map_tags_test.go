@@ -0,0 +1,55 @@
+package statsd
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMapTags(t *testing.T) {
+	assert.Nil(t, mapTags(nil))
+	assert.Nil(t, mapTags(map[string]string{}))
+	assert.Equal(t, []string{"a:1", "b", "env:prod", "host:h-1"}, mapTags(map[string]string{"host": "h-1", "env": "prod", "b": "", "a": "1"}))
+}
+
+func TestMapTagsAllocations(t *testing.T) {
+	tags := map[string]string{"env": "prod", "host": "h-1", "service": "api", "version": "1.2"}
+	allocs := testing.AllocsPerRun(100, func() { mapTags(tags) })
+	// the joined string and the slice of tags
+	assert.Equal(t, float64(2), allocs)
+}
+
+func TestMapTagsMetrics(t *testing.T) {
+	tags := map[string]string{"host": "h-1", "env": "prod", "standalone": ""}
+	sortedTags := []string{"env:prod", "host:h-1", "standalone"}
+
+	send := func(withMap bool) string {
+		var buf bytes.Buffer
+		client, err := NewWithWriter(&buf, WithoutTelemetry(), WithoutClientSideAggregation(), WithWorkersCount(1))
+		require.Nil(t, err)
+		if withMap {
+			client.GaugeM("gauge", 1, tags, 1)
+			client.CountM("count", 2, tags, 1)
+			client.HistogramM("histogram", 3, tags, 1)
+			client.DistributionM("distribution", 4, tags, 1)
+			client.SetM("set", "value", tags, 1)
+			client.TimingM("timing", time.Second, tags, 1)
+		} else {
+			client.Gauge("gauge", 1, sortedTags, 1)
+			client.Count("count", 2, sortedTags, 1)
+			client.Histogram("histogram", 3, sortedTags, 1)
+			client.Distribution("distribution", 4, sortedTags, 1)
+			client.Set("set", "value", sortedTags, 1)
+			client.Timing("timing", time.Second, sortedTags, 1)
+		}
+		require.Nil(t, client.Close())
+		return buf.String()
+	}
+
+	expected := send(false)
+	assert.Contains(t, expected, "gauge:1|g|#env:prod,host:h-1,standalone\n")
+	assert.Equal(t, expected, send(true))
+}
@@ -2,8 +2,11 @@ package statsd
 
 import (
 	"math"
+	"math/rand"
+	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
 /*
@@ -14,16 +17,21 @@ Those are metrics type that can be aggregated on the client side:
 */
 
 type countMetric struct {
-	value int64
-	name  string
-	tags  []string
+	value       int64
+	name        string
+	tags        []string
+	cardinality Cardinality
+	// timestamp is the second of the points aggregated, noTimestamp for the
+	// counts without timestamp.
+	timestamp int64
 }
 
-func newCountMetric(name string, value int64, tags []string) *countMetric {
+func newCountMetric(name string, value int64, tags []string, cardinality Cardinality) *countMetric {
 	return &countMetric{
-		value: value,
-		name:  name,
-		tags:  tags,
+		value:       value,
+		name:        name,
+		tags:        tags,
+		cardinality: cardinality,
 	}
 }
 
@@ -33,27 +41,40 @@ func (c *countMetric) sample(v int64) {
 
 func (c *countMetric) flushUnsafe() metric {
 	return metric{
-		metricType: count,
-		name:       c.name,
-		tags:       c.tags,
-		rate:       1,
-		ivalue:     c.value,
+		metricType:  count,
+		name:        c.name,
+		tags:        c.tags,
+		rate:        1,
+		ivalue:      c.value,
+		timestamp:   c.timestamp,
+		cardinality: c.cardinality,
 	}
 }
 
+// flushAsRateUnsafe returns the count as a gauge of its per second rate over interval, see WithCountsAsRates.
+func (c *countMetric) flushAsRateUnsafe(interval time.Duration) metric {
+	m := c.flushUnsafe()
+	m.metricType = gauge
+	m.fvalue = float64(c.value) / interval.Seconds()
+	m.ivalue = 0
+	return m
+}
+
 // Gauge
 
 type gaugeMetric struct {
-	value uint64
-	name  string
-	tags  []string
+	value       uint64
+	name        string
+	tags        []string
+	cardinality Cardinality
 }
 
-func newGaugeMetric(name string, value float64, tags []string) *gaugeMetric {
+func newGaugeMetric(name string, value float64, tags []string, cardinality Cardinality) *gaugeMetric {
 	return &gaugeMetric{
-		value: math.Float64bits(value),
-		name:  name,
-		tags:  tags,
+		value:       math.Float64bits(value),
+		name:        name,
+		tags:        tags,
+		cardinality: cardinality,
 	}
 }
 
@@ -63,28 +84,43 @@ func (g *gaugeMetric) sample(v float64) {
 
 func (g *gaugeMetric) flushUnsafe() metric {
 	return metric{
-		metricType: gauge,
-		name:       g.name,
-		tags:       g.tags,
-		rate:       1,
-		fvalue:     math.Float64frombits(g.value),
+		metricType:  gauge,
+		name:        g.name,
+		tags:        g.tags,
+		rate:        1,
+		fvalue:      math.Float64frombits(g.value),
+		cardinality: g.cardinality,
 	}
 }
 
 // Set
 
 type setMetric struct {
-	data map[string]struct{}
-	name string
-	tags []string
+	data        map[string]struct{}
+	name        string
+	tags        []string
+	cardinality Cardinality
+
+	// maxValues is the maximum number of distinct values kept until the next
+	// flush, 0 means no limit. See WithSetSampleLimit.
+	maxValues int64
+	// values indexes the keys of data for the reservoir sampling. It is only
+	// built once maxValues is reached.
+	values []string
+	// totalValues is the number of distinct values sampled since maxValues
+	// was reached, including the ones dropped by the reservoir.
+	totalValues int64
+	random      *rand.Rand
+	randomLock  *sync.Mutex
 	sync.Mutex
 }
 
-func newSetMetric(name string, value string, tags []string) *setMetric {
+func newSetMetric(name string, value string, tags []string, cardinality Cardinality) *setMetric {
 	set := &setMetric{
-		data: map[string]struct{}{},
-		name: name,
-		tags: tags,
+		data:        map[string]struct{}{},
+		name:        name,
+		tags:        tags,
+		cardinality: cardinality,
 	}
 	set.data[value] = struct{}{}
 	return set
@@ -93,7 +129,35 @@ func newSetMetric(name string, value string, tags []string) *setMetric {
 func (s *setMetric) sample(v string) {
 	s.Lock()
 	defer s.Unlock()
-	s.data[v] = struct{}{}
+
+	if _, found := s.data[v]; found {
+		return
+	}
+	if s.maxValues == 0 || int64(len(s.data)) < s.maxValues {
+		s.data[v] = struct{}{}
+		return
+	}
+
+	if s.values == nil {
+		s.values = make([]string, 0, len(s.data))
+		for value := range s.data {
+			s.values = append(s.values, value)
+		}
+		s.totalValues = int64(len(s.values))
+	}
+
+	// Reservoir sampling: v replaces a random value with a probability of
+	// maxValues/totalValues. A value evicted earlier is counted again if it
+	// comes back, which is part of the approximation.
+	s.totalValues++
+	s.randomLock.Lock()
+	i := s.random.Int63n(s.totalValues)
+	s.randomLock.Unlock()
+	if i < s.maxValues {
+		delete(s.data, s.values[i])
+		s.values[i] = v
+		s.data[v] = struct{}{}
+	}
 }
 
 // Sets are aggregated on the agent side too. We flush the keys so a set from
@@ -107,11 +171,12 @@ func (s *setMetric) flushUnsafe() []metric {
 	i := 0
 	for value := range s.data {
 		metrics[i] = metric{
-			metricType: set,
-			name:       s.name,
-			tags:       s.tags,
-			rate:       1,
-			svalue:     value,
+			metricType:  set,
+			name:        s.name,
+			tags:        s.tags,
+			rate:        1,
+			svalue:      value,
+			cardinality: s.cardinality,
 		}
 		i++
 	}
@@ -127,55 +192,166 @@ type bufferedMetric struct {
 	name string
 	// Histograms and Distributions store tags as one string since we need
 	// to compute its size multiple time when serializing.
-	tags  string
-	mtype metricType
+	tags        string
+	mtype       metricType
+	cardinality Cardinality
+	// The user-specified sample rate, shared by all the values since the
+	// contexts are split by rate (see withRate). The values are sampled by
+	// the client before being buffered so we need to send it along with them
+	// for the agent to upscale them.
+	specifiedRate float64
+
+	// maxSamples is the maximum number of values kept in data, 0 means no
+	// limit. See WithMaxSamplesPerContext.
+	maxSamples int64
+	strategy   MaxSamplesStrategy
+	// totalSamples is the number of values sampled since the last flush,
+	// including the ones dropped by the reservoir.
+	totalSamples int64
+	random       *rand.Rand
+	randomLock   *sync.Mutex
+
+	// percentile is set for the contexts of HistogramPercentile: the values are flushed as a gauge of their
+	// percentile instead.
+	percentile float64
+
+	// sketch replaces data when using WithDDSketchEncoding, maxSamples is then ignored.
+	sketch *ddSketch
+}
+
+// useSketch moves the values of s into a DDSketch, see WithDDSketchEncoding.
+func (s *bufferedMetric) useSketch() {
+	s.sketch = newDDSketch()
+	for _, v := range s.data {
+		s.sketch.add(v)
+	}
+	s.data = nil
 }
 
-func (s *bufferedMetric) sample(v float64) {
+func (s *bufferedMetric) sample(v float64) (flushed *metric, dropped bool) {
 	s.Lock()
 	defer s.Unlock()
-	s.data = append(s.data, v)
+
+	if s.sketch != nil {
+		s.sketch.add(v)
+		return nil, false
+	}
+	if s.maxSamples == 0 || int64(len(s.data)) < s.maxSamples {
+		s.data = append(s.data, v)
+		s.totalSamples++
+		return nil, false
+	}
+
+	if s.strategy == MaxSamplesFlush {
+		m := s.flushUnsafe()
+		s.data = []float64{v}
+		s.totalSamples = 1
+		return &m, false
+	}
+
+	// Reservoir sampling: v replaces a random value with a probability of
+	// maxSamples/totalSamples so data stays a uniform sample of all the values.
+	s.totalSamples++
+	s.randomLock.Lock()
+	i := s.random.Int63n(s.totalSamples)
+	s.randomLock.Unlock()
+	if i < s.maxSamples {
+		s.data[i] = v
+	}
+	return nil, true
 }
 
 func (s *bufferedMetric) flushUnsafe() metric {
+	if s.percentile != 0 {
+		return s.flushPercentileUnsafe()
+	}
+	rate := s.specifiedRate
+	if s.totalSamples > int64(len(s.data)) {
+		// Some values were dropped by the reservoir: the agent needs to
+		// upscale the ones we send.
+		rate *= float64(len(s.data)) / float64(s.totalSamples)
+	}
+	return metric{
+		metricType:  s.mtype,
+		name:        s.name,
+		stags:       s.tags,
+		rate:        rate,
+		fvalues:     s.data,
+		cardinality: s.cardinality,
+	}
+}
+
+// flushSketchUnsafe returns the bins of the sketch of s as packed messages, one per number of values in a bin: the
+// centers of the bins holding n values are sent together with the rate divided by n for the Agent to count each of
+// them n times.
+func (s *bufferedMetric) flushSketchUnsafe() []metric {
+	counts, byCount := s.sketch.valuesByCount()
+	metrics := make([]metric, 0, len(counts))
+	for _, n := range counts {
+		metrics = append(metrics, metric{
+			metricType:  s.mtype,
+			name:        s.name,
+			stags:       s.tags,
+			rate:        s.specifiedRate / float64(n),
+			fvalues:     byCount[n],
+			cardinality: s.cardinality,
+		})
+	}
+	return metrics
+}
+
+// flushPercentileUnsafe returns the gauge of the percentile of the values. The values were sampled with the rate
+// before being buffered, the percentile of the ones kept is an estimation of the real one so no rate is sent.
+func (s *bufferedMetric) flushPercentileUnsafe() metric {
+	var tags []string
+	if s.tags != "" {
+		tags = strings.Split(s.tags, tagSeparatorSymbol)
+	}
 	return metric{
-		metricType: s.mtype,
-		name:       s.name,
-		stags:      s.tags,
-		rate:       1,
-		fvalues:    s.data,
+		metricType:  gauge,
+		name:        s.name,
+		tags:        tags,
+		rate:        1,
+		fvalue:      nearestRankPercentile(s.data, s.percentile),
+		cardinality: s.cardinality,
 	}
 }
 
 type histogramMetric = bufferedMetric
 
-func newHistogramMetric(name string, value float64, stringTags string) *histogramMetric {
+func newHistogramMetric(name string, value float64, stringTags string, rate float64) *histogramMetric {
 	return &histogramMetric{
-		data:  []float64{value},
-		name:  name,
-		tags:  stringTags,
-		mtype: histogramAggregated,
+		data:          []float64{value},
+		name:          name,
+		tags:          stringTags,
+		mtype:         histogramAggregated,
+		specifiedRate: rate,
+		totalSamples:  1,
 	}
 }
 
 type distributionMetric = bufferedMetric
 
-func newDistributionMetric(name string, value float64, stringTags string) *distributionMetric {
+func newDistributionMetric(name string, value float64, stringTags string, rate float64) *distributionMetric {
 	return &distributionMetric{
-		data:  []float64{value},
-		name:  name,
-		tags:  stringTags,
-		mtype: distributionAggregated,
+		data:          []float64{value},
+		name:          name,
+		tags:          stringTags,
+		mtype:         distributionAggregated,
+		specifiedRate: rate,
+		totalSamples:  1,
 	}
 }
 
 type timingMetric = bufferedMetric
 
-func newTimingMetric(name string, value float64, stringTags string) *timingMetric {
+func newTimingMetric(name string, value float64, stringTags string, rate float64) *timingMetric {
 	return &timingMetric{
-		data:  []float64{value},
-		name:  name,
-		tags:  stringTags,
-		mtype: timingAggregated,
+		data:          []float64{value},
+		name:          name,
+		tags:          stringTags,
+		mtype:         timingAggregated,
+		specifiedRate: rate,
+		totalSamples:  1,
 	}
 }
@@ -1,9 +1,12 @@
 package statsd
 
 import (
+	"fmt"
 	"math"
+	"math/rand"
 	"sort"
 	"strings"
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -11,14 +14,14 @@ import (
 )
 
 func TestNewCountMetric(t *testing.T) {
-	c := newCountMetric("test", 21, []string{"tag1", "tag2"})
+	c := newCountMetric("test", 21, []string{"tag1", "tag2"}, CardinalityNotSet)
 	assert.Equal(t, c.value, int64(21))
 	assert.Equal(t, c.name, "test")
 	assert.Equal(t, c.tags, []string{"tag1", "tag2"})
 }
 
 func TestCountMetricSample(t *testing.T) {
-	c := newCountMetric("test", 21, []string{"tag1", "tag2"})
+	c := newCountMetric("test", 21, []string{"tag1", "tag2"}, CardinalityNotSet)
 	c.sample(12)
 	assert.Equal(t, c.value, int64(33))
 	assert.Equal(t, c.name, "test")
@@ -26,7 +29,7 @@ func TestCountMetricSample(t *testing.T) {
 }
 
 func TestFlushUnsafeCountMetricSample(t *testing.T) {
-	c := newCountMetric("test", 21, []string{"tag1", "tag2"})
+	c := newCountMetric("test", 21, []string{"tag1", "tag2"}, CardinalityNotSet)
 	m := c.flushUnsafe()
 	assert.Equal(t, m.metricType, count)
 	assert.Equal(t, m.ivalue, int64(21))
@@ -42,14 +45,14 @@ func TestFlushUnsafeCountMetricSample(t *testing.T) {
 }
 
 func TestNewGaugeMetric(t *testing.T) {
-	g := newGaugeMetric("test", 21, []string{"tag1", "tag2"})
+	g := newGaugeMetric("test", 21, []string{"tag1", "tag2"}, CardinalityNotSet)
 	assert.Equal(t, math.Float64frombits(g.value), float64(21))
 	assert.Equal(t, g.name, "test")
 	assert.Equal(t, g.tags, []string{"tag1", "tag2"})
 }
 
 func TestGaugeMetricSample(t *testing.T) {
-	g := newGaugeMetric("test", 21, []string{"tag1", "tag2"})
+	g := newGaugeMetric("test", 21, []string{"tag1", "tag2"}, CardinalityNotSet)
 	g.sample(12)
 	assert.Equal(t, math.Float64frombits(g.value), float64(12))
 	assert.Equal(t, g.name, "test")
@@ -57,7 +60,7 @@ func TestGaugeMetricSample(t *testing.T) {
 }
 
 func TestFlushUnsafeGaugeMetricSample(t *testing.T) {
-	g := newGaugeMetric("test", 21, []string{"tag1", "tag2"})
+	g := newGaugeMetric("test", 21, []string{"tag1", "tag2"}, CardinalityNotSet)
 	m := g.flushUnsafe()
 	assert.Equal(t, m.metricType, gauge)
 	assert.Equal(t, m.fvalue, float64(21))
@@ -73,14 +76,14 @@ func TestFlushUnsafeGaugeMetricSample(t *testing.T) {
 }
 
 func TestNewSetMetric(t *testing.T) {
-	s := newSetMetric("test", "value1", []string{"tag1", "tag2"})
+	s := newSetMetric("test", "value1", []string{"tag1", "tag2"}, CardinalityNotSet)
 	assert.Equal(t, s.data, map[string]struct{}{"value1": struct{}{}})
 	assert.Equal(t, s.name, "test")
 	assert.Equal(t, s.tags, []string{"tag1", "tag2"})
 }
 
 func TestSetMetricSample(t *testing.T) {
-	s := newSetMetric("test", "value1", []string{"tag1", "tag2"})
+	s := newSetMetric("test", "value1", []string{"tag1", "tag2"}, CardinalityNotSet)
 	s.sample("value2")
 	assert.Equal(t, s.data, map[string]struct{}{"value1": struct{}{}, "value2": struct{}{}})
 	assert.Equal(t, s.name, "test")
@@ -88,7 +91,7 @@ func TestSetMetricSample(t *testing.T) {
 }
 
 func TestFlushUnsafeSetMetricSample(t *testing.T) {
-	s := newSetMetric("test", "value1", []string{"tag1", "tag2"})
+	s := newSetMetric("test", "value1", []string{"tag1", "tag2"}, CardinalityNotSet)
 	m := s.flushUnsafe()
 
 	require.Len(t, m, 1)
@@ -118,7 +121,7 @@ func TestFlushUnsafeSetMetricSample(t *testing.T) {
 }
 
 func TestNewHistogramMetric(t *testing.T) {
-	s := newHistogramMetric("test", 1.0, "tag1,tag2")
+	s := newHistogramMetric("test", 1.0, "tag1,tag2", 1)
 	assert.Equal(t, s.data, []float64{1.0})
 	assert.Equal(t, s.name, "test")
 	assert.Equal(t, s.tags, "tag1,tag2")
@@ -126,7 +129,7 @@ func TestNewHistogramMetric(t *testing.T) {
 }
 
 func TestHistogramMetricSample(t *testing.T) {
-	s := newHistogramMetric("test", 1.0, "tag1,tag2")
+	s := newHistogramMetric("test", 1.0, "tag1,tag2", 1)
 	s.sample(123.45)
 	assert.Equal(t, s.data, []float64{1.0, 123.45})
 	assert.Equal(t, s.name, "test")
@@ -135,7 +138,7 @@ func TestHistogramMetricSample(t *testing.T) {
 }
 
 func TestFlushUnsafeHistogramMetricSample(t *testing.T) {
-	s := newHistogramMetric("test", 1.0, "tag1,tag2")
+	s := newHistogramMetric("test", 1.0, "tag1,tag2", 1)
 	m := s.flushUnsafe()
 
 	assert.Equal(t, m.metricType, histogramAggregated)
@@ -156,7 +159,7 @@ func TestFlushUnsafeHistogramMetricSample(t *testing.T) {
 }
 
 func TestNewDistributionMetric(t *testing.T) {
-	s := newDistributionMetric("test", 1.0, "tag1,tag2")
+	s := newDistributionMetric("test", 1.0, "tag1,tag2", 1)
 	assert.Equal(t, s.data, []float64{1.0})
 	assert.Equal(t, s.name, "test")
 	assert.Equal(t, s.tags, "tag1,tag2")
@@ -164,7 +167,7 @@ func TestNewDistributionMetric(t *testing.T) {
 }
 
 func TestDistributionMetricSample(t *testing.T) {
-	s := newDistributionMetric("test", 1.0, "tag1,tag2")
+	s := newDistributionMetric("test", 1.0, "tag1,tag2", 1)
 	s.sample(123.45)
 	assert.Equal(t, s.data, []float64{1.0, 123.45})
 	assert.Equal(t, s.name, "test")
@@ -173,7 +176,7 @@ func TestDistributionMetricSample(t *testing.T) {
 }
 
 func TestFlushUnsafeDistributionMetricSample(t *testing.T) {
-	s := newDistributionMetric("test", 1.0, "tag1,tag2")
+	s := newDistributionMetric("test", 1.0, "tag1,tag2", 1)
 	m := s.flushUnsafe()
 
 	assert.Equal(t, m.metricType, distributionAggregated)
@@ -194,7 +197,7 @@ func TestFlushUnsafeDistributionMetricSample(t *testing.T) {
 }
 
 func TestNewTimingMetric(t *testing.T) {
-	s := newTimingMetric("test", 1.0, "tag1,tag2")
+	s := newTimingMetric("test", 1.0, "tag1,tag2", 1)
 	assert.Equal(t, s.data, []float64{1.0})
 	assert.Equal(t, s.name, "test")
 	assert.Equal(t, s.tags, "tag1,tag2")
@@ -202,7 +205,7 @@ func TestNewTimingMetric(t *testing.T) {
 }
 
 func TestTimingMetricSample(t *testing.T) {
-	s := newTimingMetric("test", 1.0, "tag1,tag2")
+	s := newTimingMetric("test", 1.0, "tag1,tag2", 1)
 	s.sample(123.45)
 	assert.Equal(t, s.data, []float64{1.0, 123.45})
 	assert.Equal(t, s.name, "test")
@@ -211,7 +214,7 @@ func TestTimingMetricSample(t *testing.T) {
 }
 
 func TestFlushUnsafeTimingMetricSample(t *testing.T) {
-	s := newTimingMetric("test", 1.0, "tag1,tag2")
+	s := newTimingMetric("test", 1.0, "tag1,tag2", 1)
 	m := s.flushUnsafe()
 
 	assert.Equal(t, m.metricType, timingAggregated)
@@ -230,3 +233,81 @@ func TestFlushUnsafeTimingMetricSample(t *testing.T) {
 	assert.Equal(t, m.stags, "tag1,tag2")
 	assert.Nil(t, m.tags)
 }
+
+func TestFlushUnsafeTimingMetricWithRate(t *testing.T) {
+	s := newTimingMetric("test", 1.0, "tag1,tag2", 0.5)
+	s.sample(21)
+	m := s.flushUnsafe()
+
+	assert.Equal(t, m.metricType, timingAggregated)
+	assert.Equal(t, m.fvalues, []float64{1.0, 21.0})
+	assert.Equal(t, m.rate, 0.5)
+}
+
+func TestBufferedMetricMaxSamplesFlush(t *testing.T) {
+	s := newHistogramMetric("test", 1.0, "tag1,tag2", 1)
+	s.maxSamples = 2
+	s.strategy = MaxSamplesFlush
+
+	flushed, dropped := s.sample(2)
+	assert.Nil(t, flushed)
+	assert.False(t, dropped)
+
+	// the context is full: its values are flushed and the new value starts a new batch
+	flushed, dropped = s.sample(3)
+	assert.False(t, dropped)
+	require.NotNil(t, flushed)
+	assert.Equal(t, []float64{1, 2}, flushed.fvalues)
+	assert.Equal(t, 1.0, flushed.rate)
+	assert.Equal(t, []float64{3}, s.data)
+}
+
+func TestBufferedMetricMaxSamplesReservoir(t *testing.T) {
+	s := newHistogramMetric("test", 1.0, "tag1,tag2", 0.5)
+	s.maxSamples = 10
+	s.strategy = MaxSamplesReservoir
+	s.random = rand.New(rand.NewSource(1))
+	s.randomLock = &sync.Mutex{}
+
+	nbDropped := 0
+	for i := 1; i < 100; i++ {
+		flushed, dropped := s.sample(float64(i))
+		assert.Nil(t, flushed)
+		if dropped {
+			nbDropped++
+		}
+	}
+	assert.Equal(t, 90, nbDropped)
+	assert.Len(t, s.data, 10)
+
+	// the rate is adjusted to the number of values kept
+	m := s.flushUnsafe()
+	assert.Len(t, m.fvalues, 10)
+	assert.Equal(t, 0.5*10/100, m.rate)
+}
+
+func TestSetMetricSampleLimit(t *testing.T) {
+	s := newSetMetric("test", "value0", []string{"tag1", "tag2"}, CardinalityNotSet)
+	s.maxValues = 10
+	s.random = rand.New(rand.NewSource(1))
+	s.randomLock = &sync.Mutex{}
+
+	for i := 1; i < 100; i++ {
+		s.sample(fmt.Sprintf("value%d", i))
+		assert.LessOrEqual(t, len(s.data), 10)
+	}
+	assert.Len(t, s.data, 10)
+	assert.Len(t, s.values, 10)
+	assert.Equal(t, int64(100), s.totalValues)
+	// data and values must hold the same values
+	for _, v := range s.values {
+		assert.Contains(t, s.data, v)
+	}
+
+	// values already kept are not sampled again
+	s.sample(s.values[0])
+	assert.Equal(t, int64(100), s.totalValues)
+
+	m := s.flushUnsafe()
+	assert.Len(t, m, 10)
+}
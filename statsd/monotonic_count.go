@@ -0,0 +1,32 @@
+package statsd
+
+import "sync"
+
+// monotonicCounts keeps the last absolute value seen by MonotonicCount for each context.
+type monotonicCounts struct {
+	sync.Mutex
+	values map[string]float64
+}
+
+// delta stores value as the last value for the context and returns the count to submit. Nothing must be submitted
+// when ok is false: either this is the first value for the context or the counter was reset.
+func (m *monotonicCounts) delta(context string, value float64) (delta int64, ok bool) {
+	m.Lock()
+	defer m.Unlock()
+
+	if m.values == nil {
+		m.values = map[string]float64{}
+	}
+
+	last, found := m.values[context]
+	if !found || value < last {
+		m.values[context] = value
+		return 0, false
+	}
+
+	delta = int64(value - last)
+	// We only move forward by what was submitted so fractional parts are not
+	// lost and end up in a later delta.
+	m.values[context] = last + float64(delta)
+	return delta, true
+}
@@ -0,0 +1,101 @@
+package statsd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMonotonicCountsDelta(t *testing.T) {
+	m := monotonicCounts{}
+
+	// first value: nothing to submit
+	_, ok := m.delta("metric:tag1", 10)
+	assert.False(t, ok)
+
+	delta, ok := m.delta("metric:tag1", 15)
+	assert.True(t, ok)
+	assert.Equal(t, int64(5), delta)
+
+	// each context has its own value
+	_, ok = m.delta("metric:tag2", 100)
+	assert.False(t, ok)
+
+	// reset: nothing to submit and the new value is used as the reference
+	_, ok = m.delta("metric:tag1", 0)
+	assert.False(t, ok)
+	delta, ok = m.delta("metric:tag1", 3)
+	assert.True(t, ok)
+	assert.Equal(t, int64(3), delta)
+}
+
+func TestMonotonicCountsFractionalDelta(t *testing.T) {
+	m := monotonicCounts{}
+
+	m.delta("metric", 0)
+	delta, _ := m.delta("metric", 1.5)
+	assert.Equal(t, int64(1), delta)
+	// the remaining 0.5 is carried over
+	delta, _ = m.delta("metric", 2)
+	assert.Equal(t, int64(1), delta)
+}
+
+func TestMonotonicCount(t *testing.T) {
+	w := statsdWriterWrapper{}
+	client, err := NewWithWriter(&w, WithoutTelemetry(), WithoutClientSideAggregation())
+	require.Nil(t, err)
+
+	client.MonotonicCount("bytes", 100, []string{"tag1"}, 1)
+	client.MonotonicCount("bytes", 150, []string{"tag1"}, 1)
+	client.MonotonicCount("bytes", 0, []string{"tag1"}, 1)
+	client.MonotonicCount("bytes", 20, []string{"tag1"}, 1)
+	client.Close()
+
+	ts := &testServer{}
+	ts.assertMetric(t, w.data, []string{
+		"bytes:50|c|#tag1",
+		"bytes:20|c|#tag1",
+	})
+}
+
+// toggleSampler keeps the values while keep is true.
+type toggleSampler struct {
+	keep bool
+}
+
+func (s *toggleSampler) ShouldSample(name string, tags []string, rate float64) bool {
+	return s.keep
+}
+
+func TestMonotonicCountSampling(t *testing.T) {
+	w := statsdWriterWrapper{}
+	sampler := &toggleSampler{keep: true}
+	client, err := NewWithWriter(&w, WithoutTelemetry(), WithoutClientSideAggregation(), WithSampler(sampler))
+	require.Nil(t, err)
+
+	client.MonotonicCount("bytes", 100, []string{"tag1"}, 0.5)
+	// the dropped value doesn't consume its difference
+	sampler.keep = false
+	client.MonotonicCount("bytes", 150, []string{"tag1"}, 0.5)
+	sampler.keep = true
+	client.MonotonicCount("bytes", 180, []string{"tag1"}, 0.5)
+	client.Close()
+
+	ts := &testServer{}
+	ts.assertMetric(t, w.data, []string{"bytes:80|c|#tag1"})
+}
+
+func TestMonotonicCountProcessedTags(t *testing.T) {
+	w := statsdWriterWrapper{}
+	client, err := NewWithWriter(&w, WithoutTelemetry(), WithoutClientSideAggregation(), WithSortedTags())
+	require.Nil(t, err)
+
+	// the tags are the same once sorted
+	client.MonotonicCount("bytes", 100, []string{"tag2", "tag1"}, 1)
+	client.MonotonicCount("bytes", 150, []string{"tag1", "tag2"}, 1)
+	client.Close()
+
+	ts := &testServer{}
+	ts.assertMetric(t, w.data, []string{"bytes:50|c|#tag1,tag2"})
+}
@@ -0,0 +1,26 @@
+package statsd
+
+import "strings"
+
+// Namespace is a Parameter overriding the namespace of the client for a single metric, for example when a library
+// shares the client of an application but reports its metrics under its own prefix:
+//
+//	client.Gauge("queue.size", 12, nil, 1, statsd.Namespace("mylib"))
+//
+// It replaces the namespace set with WithNamespace instead of being added to it. Like with WithNamespace, a trailing
+// dot is added when missing. An empty Namespace is ignored. Since the aggregated contexts don't track the namespace,
+// metrics given a Namespace different from the one of the client bypass the client side aggregation, like the ones
+// with a ContainerID. It is ignored by GaugeDelta.
+type Namespace string
+
+// apply overrides the namespace of the client.
+func (n Namespace) apply(m *metric) {
+	if n == "" {
+		return
+	}
+	if strings.HasSuffix(string(n), ".") {
+		m.namespace = string(n)
+	} else {
+		m.namespace = string(n) + "."
+	}
+}
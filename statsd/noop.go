@@ -1,53 +1,102 @@
 package statsd
 
-import "time"
+import (
+	"context"
+	"time"
+)
 
 // NoOpClient is a statsd client that does nothing. Can be useful in testing
 // situations for library users.
 type NoOpClient struct{}
 
+// NewNoOp returns a client discarding everything it is given. No goroutine is started and no connection is opened,
+// making it a cheap drop-in replacement for a real client when metrics are disabled.
+func NewNoOp() ClientInterface {
+	return &NoOpClient{}
+}
+
 // Gauge does nothing and returns nil
-func (n *NoOpClient) Gauge(name string, value float64, tags []string, rate float64) error {
+func (n *NoOpClient) Gauge(name string, value float64, tags []string, rate float64, parameters ...Parameter) error {
+	return nil
+}
+
+// GaugeWithTimestamp does nothing and returns nil
+func (n *NoOpClient) GaugeWithTimestamp(name string, value float64, tags []string, rate float64, timestamp time.Time, parameters ...Parameter) error {
+	return nil
+}
+
+// GaugeNegative does nothing and returns nil
+func (n *NoOpClient) GaugeNegative(name string, value float64, tags []string, rate float64, parameters ...Parameter) error {
 	return nil
 }
 
 // Count does nothing and returns nil
-func (n *NoOpClient) Count(name string, value int64, tags []string, rate float64) error {
+func (n *NoOpClient) Count(name string, value int64, tags []string, rate float64, parameters ...Parameter) error {
+	return nil
+}
+
+// CountWithTimestamp does nothing and returns nil
+func (n *NoOpClient) CountWithTimestamp(name string, value int64, tags []string, rate float64, timestamp time.Time, parameters ...Parameter) error {
+	return nil
+}
+
+// MonotonicCount does nothing and returns nil
+func (n *NoOpClient) MonotonicCount(name string, value float64, tags []string, rate float64, parameters ...Parameter) error {
 	return nil
 }
 
 // Histogram does nothing and returns nil
-func (n *NoOpClient) Histogram(name string, value float64, tags []string, rate float64) error {
+func (n *NoOpClient) Histogram(name string, value float64, tags []string, rate float64, parameters ...Parameter) error {
 	return nil
 }
 
 // Distribution does nothing and returns nil
-func (n *NoOpClient) Distribution(name string, value float64, tags []string, rate float64) error {
+func (n *NoOpClient) Distribution(name string, value float64, tags []string, rate float64, parameters ...Parameter) error {
+	return nil
+}
+
+// DistributionSamples does nothing and returns nil
+func (n *NoOpClient) DistributionSamples(name string, values []float64, tags []string, rate float64, parameters ...Parameter) error {
+	return nil
+}
+
+// Add does nothing and returns nil
+func (n *NoOpClient) Add(name string, delta int64, tags []string, rate float64, parameters ...Parameter) error {
 	return nil
 }
 
 // Decr does nothing and returns nil
-func (n *NoOpClient) Decr(name string, tags []string, rate float64) error {
+func (n *NoOpClient) Decr(name string, tags []string, rate float64, parameters ...Parameter) error {
 	return nil
 }
 
 // Incr does nothing and returns nil
-func (n *NoOpClient) Incr(name string, tags []string, rate float64) error {
+func (n *NoOpClient) Incr(name string, tags []string, rate float64, parameters ...Parameter) error {
 	return nil
 }
 
 // Set does nothing and returns nil
-func (n *NoOpClient) Set(name string, value string, tags []string, rate float64) error {
+func (n *NoOpClient) Set(name string, value string, tags []string, rate float64, parameters ...Parameter) error {
+	return nil
+}
+
+// SetInt does nothing and returns nil
+func (n *NoOpClient) SetInt(name string, value int64, tags []string, rate float64, parameters ...Parameter) error {
+	return nil
+}
+
+// SetMulti does nothing and returns nil
+func (n *NoOpClient) SetMulti(name string, values []string, tags []string, rate float64, parameters ...Parameter) error {
 	return nil
 }
 
 // Timing does nothing and returns nil
-func (n *NoOpClient) Timing(name string, value time.Duration, tags []string, rate float64) error {
+func (n *NoOpClient) Timing(name string, value time.Duration, tags []string, rate float64, parameters ...Parameter) error {
 	return nil
 }
 
 // TimeInMilliseconds does nothing and returns nil
-func (n *NoOpClient) TimeInMilliseconds(name string, value float64, tags []string, rate float64) error {
+func (n *NoOpClient) TimeInMilliseconds(name string, value float64, tags []string, rate float64, parameters ...Parameter) error {
 	return nil
 }
 
@@ -81,6 +130,11 @@ func (n *NoOpClient) Flush() error {
 	return nil
 }
 
+// Drain does nothing and returns nil
+func (n *NoOpClient) Drain(ctx context.Context) error {
+	return nil
+}
+
 // Verify that NoOpClient implements the ClientInterface.
 // https://golang.org/doc/faq#guarantee_satisfies_interface
 var _ ClientInterface = &NoOpClient{}
@@ -1,6 +1,7 @@
 package statsd
 
 import (
+	"context"
 	"testing"
 	"time"
 
@@ -13,12 +14,20 @@ func TestNoOpClient(t *testing.T) {
 	tags := []string{"a:b"}
 
 	a.Nil(c.Gauge("asd", 123.4, tags, 56.0))
+	a.Nil(c.GaugeWithTimestamp("asd", 123.4, tags, 56.0, time.Now()))
+	a.Nil(c.GaugeNegative("asd", -123.4, tags, 56.0))
 	a.Nil(c.Count("asd", 1234, tags, 56.0))
+	a.Nil(c.CountWithTimestamp("asd", 1234, tags, 56.0, time.Now()))
+	a.Nil(c.MonotonicCount("asd", 1234, tags, 56.0))
 	a.Nil(c.Histogram("asd", 12.34, tags, 56.0))
 	a.Nil(c.Distribution("asd", 1.234, tags, 56.0))
+	a.Nil(c.DistributionSamples("asd", []float64{1.234}, tags, 56.0))
+	a.Nil(c.Add("asd", -3, tags, 56.0))
 	a.Nil(c.Decr("asd", tags, 56.0))
 	a.Nil(c.Incr("asd", tags, 56.0))
 	a.Nil(c.Set("asd", "asd", tags, 56.0))
+	a.Nil(c.SetInt("asd", 123, tags, 56.0))
+	a.Nil(c.SetMulti("asd", []string{"asd"}, tags, 56.0))
 	a.Nil(c.Timing("asd", time.Second, tags, 56.0))
 	a.Nil(c.TimeInMilliseconds("asd", 1234.5, tags, 56.0))
 	a.Nil(c.Event(nil))
@@ -27,4 +36,43 @@ func TestNoOpClient(t *testing.T) {
 	a.Nil(c.SimpleServiceCheck("asd", Ok))
 	a.Nil(c.Close())
 	a.Nil(c.Flush())
+	a.Nil(c.Drain(context.Background()))
+}
+
+func TestNoOpClientNoAllocation(t *testing.T) {
+	c := NewNoOp()
+	tags := []string{"a:b"}
+	now := time.Now()
+	ctx := context.Background()
+	samples := []float64{1.234}
+	event := &Event{Title: "asd", Text: "zxc"}
+	sc := &ServiceCheck{Name: "asd", Status: Ok}
+
+	allocs := testing.AllocsPerRun(100, func() {
+		c.Gauge("asd", 123.4, tags, 56.0)
+		c.GaugeWithTimestamp("asd", 123.4, tags, 56.0, now)
+		c.GaugeNegative("asd", -123.4, tags, 56.0)
+		c.Count("asd", 1234, tags, 56.0)
+		c.CountWithTimestamp("asd", 1234, tags, 56.0, now)
+		c.MonotonicCount("asd", 1234, tags, 56.0)
+		c.Histogram("asd", 12.34, tags, 56.0)
+		c.Distribution("asd", 1.234, tags, 56.0, CardinalityHigh)
+		c.DistributionSamples("asd", samples, tags, 56.0)
+		c.Add("asd", -3, tags, 56.0)
+		c.Decr("asd", tags, 56.0)
+		c.Incr("asd", tags, 56.0)
+		c.Set("asd", "asd", tags, 56.0)
+		c.SetInt("asd", 123, tags, 56.0)
+		c.SetMulti("asd", []string{"asd"}, tags, 56.0)
+		c.Timing("asd", time.Second, tags, 56.0)
+		c.TimeInMilliseconds("asd", 1234.5, tags, 56.0)
+		c.Event(event)
+		c.SimpleEvent("asd", "zxc")
+		c.ServiceCheck(sc)
+		c.SimpleServiceCheck("asd", Ok)
+		c.Flush()
+		c.Drain(ctx)
+		c.Close()
+	})
+	assert.Zero(t, allocs)
 }
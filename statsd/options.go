@@ -3,6 +3,9 @@ package statsd
 import (
 	"fmt"
 	"math"
+	"net/url"
+	"os"
+	"regexp"
 	"strings"
 	"time"
 )
@@ -20,9 +23,57 @@ var (
 	defaultTelemetry                = true
 	defaultReceivingMode            = mutexMode
 	defaultChannelModeBufferSize    = 4096
+	defaultChannelModeTimeout       = time.Duration(0)
 	defaultAggregationFlushInterval = 2 * time.Second
 	defaultAggregation              = true
 	defaultExtendedAggregation      = false
+	defaultTimingAggregation        = false
+	defaultCompression              = CompressionNone
+	defaultMaxSamplesPerContext     = 0
+	defaultMaxSamplesStrategy       = MaxSamplesFlush
+	defaultOriginDetection          = false
+	defaultCardinality              = CardinalityNotSet
+	defaultSetSampleLimit           = 0
+	defaultTagDeduplication         = false
+	defaultTagNormalization         = false
+	defaultMaxBlockDuration         = time.Duration(0)
+	defaultPrecomputedHistograms    = false
+	defaultAdaptivePayloadPacking   = false
+	defaultHistogramFlushInterval   = time.Duration(0)
+	defaultStrictNameValidation     = false
+	defaultRateLimit                = 0
+	defaultConstantHostname         = ""
+	defaultFlushJitter              = 0.0
+	defaultFlushHighWaterMark       = 1.0
+	defaultTelemetryInterval        = telemetryInterval
+	defaultTelemetryNamespace       = ""
+	defaultMaxQueueBytes            = 0
+	defaultSynchronousMode          = false
+	defaultRecordSeparator          = byte('\n')
+	defaultSortedTags               = false
+	defaultMaxTagsPerMetric         = 0
+	defaultMaxTagsStrategy          = MaxTagsTruncate
+	defaultFloatPrecision           = -1
+	defaultCountsAsRates            = false
+	defaultUnifiedServiceTagging    = false
+	defaultOversizedMetricPolicy    = OversizedMetricDropAndCount
+	defaultTagInterning             = false
+	defaultStatsdFormat             = false
+	defaultStatsdTagJoiner          = ""
+	defaultTagOrder                 = TagOrderGlobalFirst
+	defaultSketchInput              = false
+	defaultImmediateEvents          = false
+	defaultUDPSendBufferSize        = 0
+	defaultAgentInfoURL             = ""
+	defaultMaxTagValueLength        = 0
+	defaultTagTruncationMarker      = ""
+	defaultChannelModeErrOnFull     = false
+	defaultOutageBufferSize         = 0
+	defaultConnectTimeout           = time.Duration(0)
+	defaultLazyConnection           = false
+	defaultDDSketchEncoding         = false
+	defaultAlwaysEmitSampleRate     = false
+	defaultFlushEveryNMetrics       = 0
 )
 
 // Options contains the configuration options for a client.
@@ -39,10 +90,74 @@ type Options struct {
 	telemetry                bool
 	receiveMode              receivingMode
 	channelModeBufferSize    int
+	channelModeTimeout       time.Duration
 	aggregationFlushInterval time.Duration
 	aggregation              bool
 	extendedAggregation      bool
+	timingAggregation        bool
+	defaultSampleRates       map[MetricType]float64
 	telemetryAddr            string
+	clock                    clock
+	errorHandler             ErrorHandler
+	compression              Compression
+	maxSamplesPerContext     int
+	maxSamplesStrategy       MaxSamplesStrategy
+	originDetection          bool
+	externalData             string
+	cardinality              Cardinality
+	setSampleLimit           int
+	additionalAddresses      []string
+	tagFilter                func(tag string) bool
+	tagDenylist              []*regexp.Regexp
+	tagDeduplication         bool
+	tagNormalization         bool
+	sampler                  Sampler
+	maxBlockDuration         time.Duration
+	precomputedHistograms    bool
+	adaptivePayloadPacking   bool
+	histogramFlushInterval   time.Duration
+	strictNameValidation     bool
+	rateLimit                int
+	constantHostname         string
+	flushJitter              float64
+	flushHighWaterMark       float64
+	telemetryTags            []string
+	telemetryInterval        time.Duration
+	telemetryNamespace       string
+	shutdownSignals          []os.Signal
+	transport                Transport
+	maxQueueBytes            int
+	synchronousMode          bool
+	recordSeparator          byte
+	sortedTags               bool
+	maxTagsPerMetric         int
+	maxTagsStrategy          MaxTagsStrategy
+	floatPrecision           int
+	countsAsRates            bool
+	unifiedServiceTagging    bool
+	oversizedMetricPolicy    OversizedMetricPolicy
+	tagInterning             bool
+	statsdFormat             bool
+	statsdTagJoiner          string
+	tagOrder                 TagOrder
+	sketchInput              bool
+	immediateEvents          bool
+	udpSendBufferSize        int
+	agentInfoURL             string
+	maxTagValueLength        int
+	tagTruncationMarker      string
+	channelModeErrOnFull     bool
+	outageBufferSize         int
+	sampleRNGSeed            *int64
+	connectTimeout           time.Duration
+	lazyConnection           bool
+	ddSketchEncoding         bool
+	bufferAllocate           func(size int) []byte
+	bufferRelease            func([]byte)
+	alwaysEmitSampleRate     bool
+	flushEveryNMetrics       int
+	// bufferConfig is built from the other options once they are all applied.
+	bufferConfig bufferConfig
 }
 
 func resolveOptions(options []Option) (*Options, error) {
@@ -59,9 +174,58 @@ func resolveOptions(options []Option) (*Options, error) {
 		telemetry:                defaultTelemetry,
 		receiveMode:              defaultReceivingMode,
 		channelModeBufferSize:    defaultChannelModeBufferSize,
+		channelModeTimeout:       defaultChannelModeTimeout,
 		aggregationFlushInterval: defaultAggregationFlushInterval,
 		aggregation:              defaultAggregation,
 		extendedAggregation:      defaultExtendedAggregation,
+		timingAggregation:        defaultTimingAggregation,
+		clock:                    realClock{},
+		compression:              defaultCompression,
+		maxSamplesPerContext:     defaultMaxSamplesPerContext,
+		maxSamplesStrategy:       defaultMaxSamplesStrategy,
+		originDetection:          defaultOriginDetection,
+		cardinality:              defaultCardinality,
+		setSampleLimit:           defaultSetSampleLimit,
+		tagDeduplication:         defaultTagDeduplication,
+		tagNormalization:         defaultTagNormalization,
+		maxBlockDuration:         defaultMaxBlockDuration,
+		precomputedHistograms:    defaultPrecomputedHistograms,
+		adaptivePayloadPacking:   defaultAdaptivePayloadPacking,
+		histogramFlushInterval:   defaultHistogramFlushInterval,
+		strictNameValidation:     defaultStrictNameValidation,
+		rateLimit:                defaultRateLimit,
+		constantHostname:         defaultConstantHostname,
+		flushJitter:              defaultFlushJitter,
+		flushHighWaterMark:       defaultFlushHighWaterMark,
+		telemetryInterval:        defaultTelemetryInterval,
+		telemetryNamespace:       defaultTelemetryNamespace,
+		maxQueueBytes:            defaultMaxQueueBytes,
+		synchronousMode:          defaultSynchronousMode,
+		recordSeparator:          defaultRecordSeparator,
+		sortedTags:               defaultSortedTags,
+		maxTagsPerMetric:         defaultMaxTagsPerMetric,
+		maxTagsStrategy:          defaultMaxTagsStrategy,
+		floatPrecision:           defaultFloatPrecision,
+		countsAsRates:            defaultCountsAsRates,
+		unifiedServiceTagging:    defaultUnifiedServiceTagging,
+		oversizedMetricPolicy:    defaultOversizedMetricPolicy,
+		tagInterning:             defaultTagInterning,
+		statsdFormat:             defaultStatsdFormat,
+		statsdTagJoiner:          defaultStatsdTagJoiner,
+		tagOrder:                 defaultTagOrder,
+		sketchInput:              defaultSketchInput,
+		immediateEvents:          defaultImmediateEvents,
+		udpSendBufferSize:        defaultUDPSendBufferSize,
+		agentInfoURL:             defaultAgentInfoURL,
+		maxTagValueLength:        defaultMaxTagValueLength,
+		tagTruncationMarker:      defaultTagTruncationMarker,
+		channelModeErrOnFull:     defaultChannelModeErrOnFull,
+		outageBufferSize:         defaultOutageBufferSize,
+		connectTimeout:           defaultConnectTimeout,
+		lazyConnection:           defaultLazyConnection,
+		ddSketchEncoding:         defaultDDSketchEncoding,
+		alwaysEmitSampleRate:     defaultAlwaysEmitSampleRate,
+		flushEveryNMetrics:       defaultFlushEveryNMetrics,
 	}
 
 	for _, option := range options {
@@ -71,6 +235,19 @@ func resolveOptions(options []Option) (*Options, error) {
 		}
 	}
 
+	o.bufferConfig = bufferConfig{
+		separator:      o.recordSeparator,
+		floatPrecision: o.floatPrecision,
+		tagOrder:       o.tagOrder,
+		// statsd only supports the rate of sampled counters and timers
+		alwaysRate:  o.alwaysEmitSampleRate && !o.statsdFormat,
+		allocate:    o.bufferAllocate,
+		release:     o.bufferRelease,
+		externalEnv: resolveExternalEnv(o.externalData),
+	}
+	if o.statsdFormat {
+		o.bufferConfig.statsd = &statsdFormat{tagJoiner: o.statsdTagJoiner}
+	}
 	return o, nil
 }
 
@@ -100,6 +277,198 @@ func WithTags(tags []string) Option {
 	}
 }
 
+// WithUnifiedServiceTagging makes the client read its global tags from the environment like the Agent does, on top
+// of the ones given to WithTags:
+//   - "env", "service" and "version" from DD_ENV, DD_SERVICE and DD_VERSION, as done by default.
+//   - The tags listed in DD_TAGS, separated by commas and/or spaces (ex: "team:a,tier:1" or "team:a tier:1").
+//
+// Unlike the default injection, a tag read from the environment is ignored when the tags given to WithTags already
+// have its key (ex: WithTags([]string{"env:prod"}) takes precedence over DD_ENV=staging). DD_ENV, DD_SERVICE and
+// DD_VERSION also take precedence over the same keys in DD_TAGS. The environment is read when the client is created
+// and by SetGlobalTags.
+func WithUnifiedServiceTagging() Option {
+	return func(o *Options) error {
+		o.unifiedServiceTagging = true
+		return nil
+	}
+}
+
+// WithConstantHostname adds a "host:<host>" global tag to every metric, event and service check, for deployments
+// where the Agent can't infer the host sending them. The tag replaces any "host" tag given to WithTags or set from the
+// environment and comes after the other global tags.
+//
+// Events and service checks without a Hostname are sent with host as their hostname as well.
+//
+// When host is empty the hostname of the machine is resolved once, when the client is created, with os.Hostname.
+func WithConstantHostname(host string) Option {
+	return func(o *Options) error {
+		if host == "" {
+			hostname, err := os.Hostname()
+			if err != nil {
+				return fmt.Errorf("could not resolve the hostname: %s", err)
+			}
+			if hostname == "" {
+				return fmt.Errorf("could not resolve the hostname: empty hostname")
+			}
+			host = hostname
+		}
+		o.constantHostname = host
+		return nil
+	}
+}
+
+// WithTagFilter sets a function deciding which tags are kept: tags for which filter returns false are removed from
+// every metric. The filter applies to both the global tags (including the ones from the DD_* environment variables)
+// and the tags given to each call, the order of the remaining tags is preserved. It can be used with WithTagDenylist,
+// in which case a tag has to pass both to be kept.
+//
+// The tags given with events and service checks are not filtered, only the global tags are. The filter is called on
+// the hot path and must be cheap and safe for concurrent use.
+func WithTagFilter(filter func(tag string) bool) Option {
+	return func(o *Options) error {
+		if filter == nil {
+			return fmt.Errorf("tag filter can't be nil")
+		}
+		o.tagFilter = filter
+		return nil
+	}
+}
+
+// WithTagDenylist removes from every metric the tags matching any of the given regular expressions, ex:
+// "^user_id:". The patterns are compiled once when creating the client and follow the same rules as WithTagFilter.
+// Calling it several times adds to the list.
+func WithTagDenylist(patterns ...string) Option {
+	return func(o *Options) error {
+		for _, pattern := range patterns {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return fmt.Errorf("invalid tag denylist pattern %q: %s", pattern, err)
+			}
+			o.tagDenylist = append(o.tagDenylist, re)
+		}
+		return nil
+	}
+}
+
+// WithTagDeduplication makes the client keep a single tag per key on every metric, the key being the text before the
+// first ':'. When the same key appears several times the last tag wins, so the tags given to a call override the global
+// ones (ex: "env:staging" given to Gauge replaces the global "env:prod").
+//
+// This merges the global tags into the tags of each metric, which costs an allocation per call. Events and service
+// checks are not deduplicated.
+func WithTagDeduplication() Option {
+	return func(o *Options) error {
+		o.tagDeduplication = true
+		return nil
+	}
+}
+
+// WithSortedTags makes the client sort the tags of every metric, the global ones included, so that the same tags
+// given in a different order (ex: {"a", "b"} and {"b", "a"}) are aggregated into a single context and serialized
+// identically.
+//
+// Tags are sorted once per metric, after the other tag options (see WithTagDeduplication and WithTagFilter) are
+// applied. Like WithTagDeduplication this merges the global tags into the tags of each metric, which costs an
+// allocation per call with tags. Events and service checks only get the global tags sorted.
+func WithSortedTags() Option {
+	return func(o *Options) error {
+		o.sortedTags = true
+		return nil
+	}
+}
+
+// MaxTagsStrategy is what the client does with a metric having more tags than allowed by WithMaxTagsPerMetric.
+type MaxTagsStrategy int
+
+const (
+	// MaxTagsTruncate sends the metric with its tags past the limit removed.
+	MaxTagsTruncate MaxTagsStrategy = iota
+	// MaxTagsDrop drops the metric: ErrTooManyTags is returned and nothing is sent.
+	MaxTagsDrop
+)
+
+// WithMaxTagsPerMetric limits the number of tags of each metric, global tags included, to protect the Agent from a
+// caller attaching too many tags by mistake. The limit is checked once the other tag options (ex:
+// WithTagDeduplication) are applied. The strategy decides what happens to a metric over the limit: its last tags are
+// removed with MaxTagsTruncate or it is dropped with MaxTagsDrop. Either way it is counted in the
+// "metrics_too_many_tags" telemetry. By default there is no limit.
+//
+// MaxTagsTruncate never removes the global tags, unless they are merged into the tags of each metric by
+// WithTagDeduplication or WithSortedTags.
+//
+// Events and service checks are not limited.
+func WithMaxTagsPerMetric(maxTags int, strategy MaxTagsStrategy) Option {
+	return func(o *Options) error {
+		if maxTags < 0 {
+			return fmt.Errorf("maxTags must be a positive integer")
+		}
+		if strategy != MaxTagsTruncate && strategy != MaxTagsDrop {
+			return fmt.Errorf("unknown max tags strategy: %d", strategy)
+		}
+		o.maxTagsPerMetric = maxTags
+		o.maxTagsStrategy = strategy
+		return nil
+	}
+}
+
+// WithStrictNameValidation makes the client return an error, without sending anything, when given an invalid name or
+// tag instead of sending a message the Agent might not parse:
+//   - metric and service check names may only contain ASCII letters, digits, '_' and '.'
+//   - tags may only contain letters, digits, '_', '-', ':', '.' and '/'
+//   - event titles and texts are required and titles may not contain newlines
+//
+// The tags of a call are validated once processed by WithTagNormalization and the other tag options. The global tags
+// are not validated unless merged into the tags of the call by WithTagDeduplication. By default the client sends names
+// and tags as given.
+func WithStrictNameValidation() Option {
+	return func(o *Options) error {
+		o.strictNameValidation = true
+		return nil
+	}
+}
+
+// WithTagNormalization makes the client lowercase the key of every tag and replace the characters not allowed in
+// DogStatsD tags by '_'. Allowed characters are letters, digits, '_', '-', ':', '.' and '/'. Normalization happens
+// before deduplication and filtering, so WithTagFilter and WithTagDenylist see the normalized tags.
+//
+// The global tags are normalized once when creating the client, the tags of each call are only copied when they need
+// to be modified. Events and service checks are not normalized.
+func WithTagNormalization() Option {
+	return func(o *Options) error {
+		o.tagNormalization = true
+		return nil
+	}
+}
+
+// WithMaxTagValueLength truncates the value of each tag, the text after the first ':', to n bytes so tags like URLs
+// are not rejected by the Agent for being too long. The keys and the tags without value are never truncated. Values
+// are cut on a rune boundary, after normalization and before deduplication and filtering.
+//
+// The global tags are truncated once when creating the client, the tags of each call are only copied when they need
+// to be modified. The tags of events and service checks are not truncated, only the global tags sent with them.
+// Default is 0, meaning no limit.
+func WithMaxTagValueLength(n int) Option {
+	return func(o *Options) error {
+		if n <= 0 {
+			return fmt.Errorf("max tag value length must be a positive integer")
+		}
+		o.maxTagValueLength = n
+		return nil
+	}
+}
+
+// WithTagTruncationMarker appends marker (ex: "...") to the values truncated by WithMaxTagValueLength to show they
+// were cut. The marker counts in the length of the value: it is not used when it doesn't fit in it.
+func WithTagTruncationMarker(marker string) Option {
+	return func(o *Options) error {
+		if strings.ContainsAny(marker, ",|\n\r") {
+			return fmt.Errorf("tag truncation marker can't contain ',', '|' or line breaks")
+		}
+		o.tagTruncationMarker = marker
+		return nil
+	}
+}
+
 // WithMaxMessagesPerPayload sets the maximum number of metrics, events and/or service checks that a single payload can
 // contain.
 //
@@ -113,10 +482,190 @@ func WithMaxMessagesPerPayload(maxMessagesPerPayload int) Option {
 	}
 }
 
+// WithAdaptivePayloadPacking makes the client pack as many metrics, events and service checks as fit in a payload
+// (see WithMaxBytesPerPayload), whatever their size, ignoring the limit set by WithMaxMessagesPerPayload.
+//
+// The number of messages per payload isn't capped by default, the payloads are then already filled up to the byte
+// limit: this option only changes the behavior of a client also given WithMaxMessagesPerPayload, for example through a
+// configuration shared with other programs, so that its small messages don't end up in payloads mostly empty.
+//
+// The byte limit stays a hard limit: a message is only added to a payload if it fits entirely. A message bigger than the
+// limit is handled as set by WithOversizedMetricPolicy, while the values of aggregated histograms, distributions and
+// timings are split across payloads.
+func WithAdaptivePayloadPacking() Option {
+	return func(o *Options) error {
+		o.adaptivePayloadPacking = true
+		return nil
+	}
+}
+
+// OversizedMetricPolicy is what the client does with a message that doesn't fit in an empty payload, see
+// WithOversizedMetricPolicy.
+type OversizedMetricPolicy int
+
+const (
+	// OversizedMetricDropAndCount drops the message, reports it to the error handler with ErrBufferTooSmall and
+	// counts it in the "metrics_dropped_oversized" telemetry.
+	OversizedMetricDropAndCount OversizedMetricPolicy = iota
+	// OversizedMetricError is the same as OversizedMetricDropAndCount but ErrBufferTooSmall is also returned to the
+	// caller when the message is written right away (see WithMutexMode).
+	OversizedMetricError
+	// OversizedMetricBestEffortTruncate removes the last tags of the metric, one at a time, until it fits. The global
+	// tags are kept. Messages that still don't fit once all their tags are removed, like events and service checks,
+	// are dropped as with OversizedMetricDropAndCount.
+	OversizedMetricBestEffortTruncate
+)
+
+// WithOversizedMetricPolicy sets what the client does with a message bigger than a payload (see
+// WithMaxBytesPerPayload), which can't be split unlike the values of aggregated histograms, distributions and timings.
+// By default the message is dropped and counted with OversizedMetricDropAndCount.
+//
+// With the client side aggregation or the channel mode, metrics are written in the background: OversizedMetricError
+// can't return the error to the caller and behaves like OversizedMetricDropAndCount.
+func WithOversizedMetricPolicy(policy OversizedMetricPolicy) Option {
+	return func(o *Options) error {
+		if policy < OversizedMetricDropAndCount || policy > OversizedMetricBestEffortTruncate {
+			return fmt.Errorf("unknown oversized metric policy: %d", policy)
+		}
+		o.oversizedMetricPolicy = policy
+		return nil
+	}
+}
+
+// WithTagInterning caches the tags of the metric calls in a bounded LRU (see tagInternerSize): identical tags share the
+// same string instead of each metric keeping its own, including the metrics kept by the client side aggregation. The
+// tags sent are not modified.
+//
+// With WithTagNormalization the cache holds the normalized tags: a tag already seen is not normalized again. The tags
+// that are already normalized are then used as given, without going through the cache.
+func WithTagInterning() Option {
+	return func(o *Options) error {
+		o.tagInterning = true
+		return nil
+	}
+}
+
+// WithStatsdFormat makes the client send metrics in the plain statsd format, for servers that don't support the
+// DogStatsD extensions (ex: a Graphite backend). The tags are dropped, see WithStatsdTagFolding to keep them in the
+// names, and so are the timestamps, the container ID and the cardinality. The sample rate is only sent for counts and
+// timings, the only types statsd samples.
+//
+// Events, service checks and DistributionSketch return ErrStatsdFormat. Histograms and distributions keep their "h"
+// and "d" types, which not every statsd server supports.
+func WithStatsdFormat() Option {
+	return func(o *Options) error {
+		o.statsdFormat = true
+		return nil
+	}
+}
+
+// WithStatsdTagFolding is WithStatsdFormat with the tags appended to the metric names, separated by joiner, instead of
+// being dropped. The ':' of a tag is replaced by joiner too: with ".", the metric "requests" tagged "env:prod" is sent
+// as "requests.env.prod". The global tags come first and the tags are folded in the order given, see WithSortedTags to
+// always get the same name for the same tags.
+func WithStatsdTagFolding(joiner string) Option {
+	return func(o *Options) error {
+		if joiner == "" || strings.ContainsAny(joiner, "|:#@\n") {
+			return fmt.Errorf("invalid statsd tag joiner: %q", joiner)
+		}
+		o.statsdFormat = true
+		o.statsdTagJoiner = joiner
+		return nil
+	}
+}
+
+// TagOrder is the order of the global tags and the tags of the call in a metric, see WithTagOrder.
+type TagOrder int
+
+const (
+	// TagOrderGlobalFirst sends the global tags before the tags of the call (ex: "#env:prod,endpoint:/users").
+	TagOrderGlobalFirst TagOrder = iota
+	// TagOrderCallFirst sends the tags of the call before the global tags (ex: "#endpoint:/users,env:prod").
+	TagOrderCallFirst
+)
+
+// WithTagOrder sets the order of the global tags (see WithTags) and the tags of the call in the metrics, which are sent
+// as given without being sorted or deduplicated. The default is TagOrderGlobalFirst.
+//
+// The order doesn't apply when the global tags are merged into the tags of the call by WithTagDeduplication or
+// WithSortedTags. Events and service checks always have the global tags first.
+func WithTagOrder(order TagOrder) Option {
+	return func(o *Options) error {
+		if order < TagOrderGlobalFirst || order > TagOrderCallFirst {
+			return fmt.Errorf("unknown tag order: %d", order)
+		}
+		o.tagOrder = order
+		return nil
+	}
+}
+
+// WithFlushHighWaterMark makes the client flush a buffer as soon as it holds fraction of WithMaxBytesPerPayload instead
+// of waiting for it to be full or for WithBufferFlushInterval. For example with a fraction of 0.8 and 1432 bytes
+// payloads, a buffer is sent once it holds 1146 bytes or more. This lowers the latency of the metrics and leaves room
+// so a large message arriving late doesn't have to go to the next payload, at the cost of sending smaller payloads.
+//
+// The fraction must be in (0, 1], the default 1 only flushes full buffers.
+func WithFlushHighWaterMark(fraction float64) Option {
+	return func(o *Options) error {
+		if fraction <= 0 || fraction > 1 {
+			return fmt.Errorf("flush high water mark must be in (0, 1]")
+		}
+		o.flushHighWaterMark = fraction
+		return nil
+	}
+}
+
+// WithFlushEveryNMetrics makes the client flush a buffer as soon as n messages were written to it since its last flush,
+// even if it isn't full and WithBufferFlushInterval didn't elapse, bounding the number of metrics waiting to be sent.
+// For example with n set to 10, every payload holds at most 10 messages and the 10th is sent right away.
+//
+// Each worker has its own buffer, see WithWorkersCount: the metrics are spread between them by name so a buffer only
+// counts the metrics written to it. With client side aggregation, the aggregated metrics are written when the
+// aggregator flushes, see WithAggregationInterval. An aggregated histogram, distribution or timing counts as one
+// message whatever its number of values. The default, 0, disables it.
+func WithFlushEveryNMetrics(n int) Option {
+	return func(o *Options) error {
+		if n < 0 {
+			return fmt.Errorf("the number of metrics between flushes must be a positive integer, or 0 to disable it")
+		}
+		o.flushEveryNMetrics = n
+		return nil
+	}
+}
+
+// WithImmediateEvents makes the client send events and service checks as soon as they are written instead of waiting
+// for the buffer to be full or for WithBufferFlushInterval, since they are often used for alerting. The payload holding
+// an event also carries the metrics written before it to the same buffer.
+//
+// Events and service checks are never aggregated. With WithChannelMode they are still queued to the worker first.
+func WithImmediateEvents() Option {
+	return func(o *Options) error {
+		o.immediateEvents = true
+		return nil
+	}
+}
+
+// WithUDPSendBufferSize sets the size in bytes of the send buffer of the UDP socket, to absorb bursts of payloads the
+// kernel would otherwise drop before they leave the host. It only applies to the UDP transport, including the
+// addresses given to WithAdditionalAddresses, and is applied again when the client reconnects.
+//
+// The kernel can apply a different size: Linux doubles the value for its own bookkeeping and caps it to
+// net.core.wmem_max. The size applied is reported by Telemetry.UDPSendBufferSize, a value lower than the one requested
+// meaning it was clamped. Default is 0, keeping the default of the OS.
+func WithUDPSendBufferSize(bytes int) Option {
+	return func(o *Options) error {
+		if bytes <= 0 {
+			return fmt.Errorf("UDP send buffer size must be a positive integer")
+		}
+		o.udpSendBufferSize = bytes
+		return nil
+	}
+}
+
 // WithMaxBytesPerPayload sets the maximum number of bytes a single payload can contain.
 //
 // The deault value 0 which will set the option to the optimal size for the transport protocol used: 1432 for UDP and
-// named pipe and 8192 for UDS.
+// 8192 for UDS and named pipe. An explicit value always takes precedence over these defaults.
 func WithMaxBytesPerPayload(MaxBytesPerPayload int) Option {
 	return func(o *Options) error {
 		o.maxBytesPerPayload = MaxBytesPerPayload
@@ -126,8 +675,8 @@ func WithMaxBytesPerPayload(MaxBytesPerPayload int) Option {
 
 // WithBufferPoolSize sets the size of the pool of buffers used to serialized metrics, events and service_checks.
 //
-// The default, 0, will set the option to the optimal size for the transport protocol used: 2048 for UDP and named pipe
-// and 512 for UDS.
+// The default, 0, will set the option to the optimal size for the transport protocol used: 2048 for UDP and 512 for UDS
+// and named pipe.
 func WithBufferPoolSize(bufferPoolSize int) Option {
 	return func(o *Options) error {
 		o.bufferPoolSize = bufferPoolSize
@@ -135,6 +684,43 @@ func WithBufferPoolSize(bufferPoolSize int) Option {
 	}
 }
 
+// WithBufferAllocator makes the client take the memory of its buffers from allocate instead of the Go heap, for example
+// to back them with a sync.Pool of the application, an arena or mmap'd memory. allocate is called with the capacity
+// needed every time the pool of buffers creates one: when the client starts and when the pool is empty, see
+// WithBufferPoolSize. It must return a slice of at least that length.
+//
+// release, when not nil, is given back the slices of the buffers the client stops using: the ones returned to a full
+// pool and, when the client is closed, every buffer it allocated. They are all released before Close returns but the
+// one being written when the context given to CloseWithContext is done, which is released once the write returns. Both
+// functions can be called concurrently.
+func WithBufferAllocator(allocate func(size int) []byte, release func([]byte)) Option {
+	return func(o *Options) error {
+		if allocate == nil {
+			return fmt.Errorf("the buffer allocator must not be nil")
+		}
+		o.bufferAllocate = allocate
+		o.bufferRelease = release
+		return nil
+	}
+}
+
+// WithRateLimit caps the number of payloads written to the transport every second, as a safety valve against a
+// runaway loop flooding the Agent. The limit allows bursts of up to maxPacketsPerSecond payloads and is refilled
+// continuously.
+//
+// The payloads over the limit are dropped, not buffered: they are counted in TotalPayloadsDroppedRateLimit and
+// ErrRateLimited is given to the ErrorHandler. Payloads are built by the client, so how many metrics a payload
+// contains depends on WithMaxBytesPerPayload and WithMaxMessagesPerPayload. The default is 0: no limit.
+func WithRateLimit(maxPacketsPerSecond int) Option {
+	return func(o *Options) error {
+		if maxPacketsPerSecond < 0 {
+			return fmt.Errorf("rate limit must be equal or greater than 0")
+		}
+		o.rateLimit = maxPacketsPerSecond
+		return nil
+	}
+}
+
 // WithBufferFlushInterval sets the interval after which the current buffer is flushed.
 //
 // A buffers are used to serialized data, they're flushed either when full (see WithMaxBytesPerPayload) or when it's
@@ -172,8 +758,8 @@ func WithWorkersCount(workersCount int) Option {
 // After data has been serialized in a buffer they're pushed to a queue that the sender will consume and then each one
 // ot the agent.
 //
-// The default value 0 will set the option to the optimal size for the transport protocol used: 2048 for UDP and named
-// pipe and 512 for UDS.
+// The default value 0 will set the option to the optimal size for the transport protocol used: 2048 for UDP and 512 for
+// UDS and named pipe.
 func WithSenderQueueSize(senderQueueSize int) Option {
 	return func(o *Options) error {
 		o.senderQueueSize = senderQueueSize
@@ -181,8 +767,43 @@ func WithSenderQueueSize(senderQueueSize int) Option {
 	}
 }
 
+// WithMaxQueueBytes caps the total size in bytes of the buffers waiting in the sender queue, on top of the number of
+// buffers set by WithSenderQueueSize. A buffer exceeding the cap is dropped like when the queue is full: ErrQueueFull is
+// reported and the drop is counted in the "packets_dropped_queue" and "bytes_dropped_queue" telemetry. The bytes of a
+// buffer are released once it has been written to the transport.
+//
+// The default value 0 disables the cap.
+func WithMaxQueueBytes(maxBytes int) Option {
+	return func(o *Options) error {
+		if maxBytes < 0 {
+			return fmt.Errorf("max queue bytes must be equal or greater than 0")
+		}
+		o.maxQueueBytes = maxBytes
+		return nil
+	}
+}
+
+// WithOutageBuffer keeps up to maxBytes of the payloads that could not be written to the transport, instead of
+// dropping them, so a short outage of the Agent like a restart loses no data. The kept payloads are replayed oldest
+// first before the next payload once a write succeeds again, or every second when nothing else is written. When the
+// buffer is full the oldest payloads are dropped to make room and reported like any failed write.
+//
+// The payloads still kept when the client is closed are dropped. UDP writes rarely fail when the Agent is down since
+// the datagrams are lost silently: this is mostly useful with UDS and named pipes. Default is 0, meaning disabled.
+func WithOutageBuffer(maxBytes int) Option {
+	return func(o *Options) error {
+		if maxBytes <= 0 {
+			return fmt.Errorf("outage buffer size must be a positive integer")
+		}
+		o.outageBufferSize = maxBytes
+		return nil
+	}
+}
+
 // WithWriteTimeout sets the timeout for network communication with the Agent, after this interval a payload is
-// dropped. This is only used for UDS and named pipes connection.
+// dropped. The timeout applies to each write on UDS, UDP and named pipes connections so a slow Agent never blocks the
+// client: dropped payloads are reported in the TotalPayloadsDroppedWriterTimeout telemetry. A timeout of 0 disables
+// the write deadline for UDS and UDP.
 func WithWriteTimeout(writeTimeout time.Duration) Option {
 	return func(o *Options) error {
 		o.writeTimeout = writeTimeout
@@ -190,6 +811,37 @@ func WithWriteTimeout(writeTimeout time.Duration) Option {
 	}
 }
 
+// WithConnectTimeout caps how long the client waits when connecting to a UDS socket, for the first connection and
+// the reconnections. Without it a dial can hang, for example when the socket file exists but its backlog is full
+// because nothing accepts the connections. The connection is made on the first write: a dial timing out fails the
+// write like any other transport error, the payload being dropped and reported in the TotalPayloadsDroppedWriter
+// telemetry, and the next write dials again.
+//
+// It is separate from WithWriteTimeout which applies to each write once connected. Default is 0, meaning no timeout.
+func WithConnectTimeout(timeout time.Duration) Option {
+	return func(o *Options) error {
+		if timeout < 0 {
+			return fmt.Errorf("connect timeout must be equal or greater than 0")
+		}
+		o.connectTimeout = timeout
+		return nil
+	}
+}
+
+// WithLazyConnection makes New succeed even when the Agent can't be reached yet, for example when its hostname can't be
+// resolved while it starts: the UDP socket is dialed on the first write instead of when the client is created. The
+// writes fail until a dial succeeds, the dials being spaced by the backoff used for the reconnections. The payloads of
+// the failed writes are dropped and reported in the TotalPayloadsDroppedWriter telemetry, or kept when using
+// WithOutageBuffer.
+//
+// A malformed address is still reported by New. UDS and named pipes are always connected on the first write.
+func WithLazyConnection() Option {
+	return func(o *Options) error {
+		o.lazyConnection = true
+		return nil
+	}
+}
+
 // WithChannelMode make the client use channels to receive metrics
 //
 // This determines how the client receive metrics from the app (for example when calling the `Gauge()` method).
@@ -225,6 +877,71 @@ func WithMutexMode() Option {
 	}
 }
 
+// WithMaxBlockDuration caps how long a call submitting a metric can wait in mutex mode (the default, see
+// WithMutexMode).
+//
+// In mutex mode the metrics are serialized by the goroutine submitting them, under the lock of a worker. The lock is
+// held while a buffer is flushed, and Flush keeps it until every payload has been written to the transport, so a
+// caller can be blocked for as long as a slow write takes (see WithWriteTimeout). By default the wait is unbounded.
+// With a duration, a caller waiting longer gives up: the metric is dropped, counted in TotalDroppedOnReceiveTimeout
+// and ErrBlockTimeout is returned and given to the ErrorHandler.
+//
+// This has no effect in channel mode where callers never wait on the workers, see WithChannelModeTimeout instead.
+func WithMaxBlockDuration(d time.Duration) Option {
+	return func(o *Options) error {
+		if d < 0 {
+			return fmt.Errorf("max block duration must be equal or greater than 0")
+		}
+		o.maxBlockDuration = d
+		return nil
+	}
+}
+
+// WithPrecomputedHistograms enables Client.DistributionPrecomputed, sending the statistics of a distribution computed
+// by the application instead of its samples.
+//
+// WARNING: the Agent has no support for pre-aggregated distributions. The stats are sent as independent gauges and a
+// count (ex: "latency.avg", "latency.95percentile", "latency.count") and are not distributions: they can't be merged
+// across hosts or time to compute global percentiles, and they should not share their name with a real histogram or
+// distribution. Only use this when sending the samples is not an option.
+func WithPrecomputedHistograms() Option {
+	return func(o *Options) error {
+		o.precomputedHistograms = true
+		return nil
+	}
+}
+
+// WithDDSketchEncoding accumulates the samples of each aggregated distribution into a DDSketch instead of keeping every
+// value until the flush: the memory used and the size of the payloads depend on the range of the values instead of
+// their number, which is much smaller for high volume distributions.
+//
+// DogStatsD has no message for sketches: every non empty bin is sent as its center, within 1% of the values it holds,
+// and the bins holding the same number of values are packed in one message with the rate divided by that number for
+// the Agent to count each of them as many times (ex: "latency:1.99:3.02|d|@0.25" for 2 bins of 4 values). The
+// percentiles computed by the Agent are within 1% of the ones of the samples. The counts of the bins can be off by one
+// when the Agent rounds the inverse of a rate.
+//
+// It only applies to the distributions aggregated with WithExtendedClientSideAggregation and replaces
+// WithMaxSamplesPerContext for them.
+func WithDDSketchEncoding() Option {
+	return func(o *Options) error {
+		o.ddSketchEncoding = true
+		return nil
+	}
+}
+
+// WithSketchInput enables Client.DistributionSketch, sending the summary of a distribution (count, sum, min and max)
+// as a single message instead of its samples.
+//
+// WARNING: the message uses the "ds" type, an extension of the DogStatsD protocol that only the Agents accepting
+// sketch input support. Other Agents drop it as an invalid message.
+func WithSketchInput() Option {
+	return func(o *Options) error {
+		o.sketchInput = true
+		return nil
+	}
+}
+
 // WithChannelModeBufferSize sets the size of the channel holding incoming metrics when WithChannelMode is used.
 func WithChannelModeBufferSize(bufferSize int) Option {
 	return func(o *Options) error {
@@ -233,6 +950,35 @@ func WithChannelModeBufferSize(bufferSize int) Option {
 	}
 }
 
+// WithChannelModeTimeout sets how long the app will block when the channel used by WithChannelMode is full before
+// dropping the metric.
+//
+// By default metrics are dropped immediately when the channel is full. Metrics dropped after waiting for this timeout
+// are reported in the TotalDroppedOnReceiveTimeout telemetry instead of TotalDroppedOnReceive, which allows to tell
+// a channel too small for the throughput apart from a client that can't keep up.
+func WithChannelModeTimeout(timeout time.Duration) Option {
+	return func(o *Options) error {
+		if timeout < 0 {
+			return fmt.Errorf("channelModeTimeout must be a positive duration")
+		}
+		o.channelModeTimeout = timeout
+		return nil
+	}
+}
+
+// WithChannelModeErrOnFull makes the methods sending metrics return ErrQueueFull when the metric is dropped because
+// the channel used by WithChannelMode is full, after waiting for WithChannelModeTimeout if set, so the caller can
+// retry or degrade. By default nil is returned and the drop is only visible in the telemetry and the ErrorHandler.
+//
+// This also applies to the channel of the aggregator used with WithExtendedClientSideAggregation. It has no effect
+// without WithChannelMode.
+func WithChannelModeErrOnFull() Option {
+	return func(o *Options) error {
+		o.channelModeErrOnFull = true
+		return nil
+	}
+}
+
 // WithAggregationInterval sets the interval at which aggregated metrics are flushed. See WithClientSideAggregation and
 // WithExtendedClientSideAggregation for more.
 //
@@ -250,6 +996,56 @@ func WithAggregationInterval(interval time.Duration) Option {
 	}
 }
 
+// WithCountsAsRates makes the client send the aggregated counts as per second rates: at each flush the total of a
+// count is divided by the aggregation interval (see WithAggregationInterval) and sent as a gauge, ex: a count
+// incremented 10 times over a 2s interval is sent as "name:5|g".
+//
+// This changes the meaning of the values received by the Agent: they are rates, not totals, and are aggregated as
+// gauges (the last value of the Agent flush interval is kept, they are not summed). The interval is the configured one,
+// including for the partial windows flushed by Flush and Close. Counts are only converted when client side aggregation
+// is enabled, the ones sent with WithoutClientSideAggregation are sent as counts.
+func WithCountsAsRates() Option {
+	return func(o *Options) error {
+		o.countsAsRates = true
+		return nil
+	}
+}
+
+// WithFlushJitter randomizes the flush intervals of the client (see WithBufferFlushInterval, WithAggregationInterval
+// and WithHistogramFlushInterval) by up to plus or minus fraction of their value, so many clients started together
+// don't write to the Agent in synchronized bursts. For example a fraction of 0.1 turns the default 100ms buffer flush
+// interval into an interval between 90ms and 110ms.
+//
+// The jitter is drawn once, when the client is created: each interval then stays the same for the lifetime of the
+// client. Since the jittered aggregation interval no longer divides the Agent reporting period evenly, the aggregated
+// values can look slightly irregular, see WithAggregationInterval. The fraction must be in [0, 1), the default is 0.
+func WithFlushJitter(fraction float64) Option {
+	return func(o *Options) error {
+		if fraction < 0 || fraction >= 1 {
+			return fmt.Errorf("flush jitter must be in [0, 1)")
+		}
+		o.flushJitter = fraction
+		return nil
+	}
+}
+
+// WithHistogramFlushInterval sets the interval at which aggregated histograms, distributions and timings are flushed,
+// independently from the gauges, counts and sets flushed every WithAggregationInterval. A longer interval aggregates
+// more samples in each payload, at the cost of sending them later. It only applies with
+// WithExtendedClientSideAggregation or WithTimingAggregation.
+//
+// The default is 0: histograms, distributions and timings are flushed with the other metrics. Like
+// WithAggregationInterval, the interval should divide the Agent reporting period (default=10s) evenly.
+func WithHistogramFlushInterval(interval time.Duration) Option {
+	return func(o *Options) error {
+		if interval < 0 {
+			return fmt.Errorf("histogram flush interval must be equal or greater than 0")
+		}
+		o.histogramFlushInterval = interval
+		return nil
+	}
+}
+
 // WithClientSideAggregation enables client side aggregation for Gauges, Counts and Sets.
 func WithClientSideAggregation() Option {
 	return func(o *Options) error {
@@ -263,6 +1059,7 @@ func WithoutClientSideAggregation() Option {
 	return func(o *Options) error {
 		o.aggregation = false
 		o.extendedAggregation = false
+		o.timingAggregation = false
 		return nil
 	}
 }
@@ -277,7 +1074,211 @@ func WithExtendedClientSideAggregation() Option {
 	}
 }
 
-// WithoutTelemetry disables the client telemetry.
+// WithTimingAggregation enables client side aggregation for Timings on top of the aggregation of Gauges, Counts and
+// Sets enabled by WithClientSideAggregation.
+//
+// All the timings sampled during an aggregation interval (see WithAggregationInterval) for the same name and tags are
+// sent as a single message (ex: "name:1.1:2.2:3.3|ms"). This is useful to reduce the cost of timings on hot paths
+// without enabling aggregation of Histograms and Distributions through WithExtendedClientSideAggregation. Timings with
+// a sample rate below 1 are sampled by the client before being aggregated and the rate is sent along with them. This
+// feature is only compatible with Agent's version >=6.25.0 && <7.0.0 or Agent's versions >=7.25.0.
+func WithTimingAggregation() Option {
+	return func(o *Options) error {
+		o.aggregation = true
+		o.timingAggregation = true
+		return nil
+	}
+}
+
+// WithDefaultSampleRates sets the sample rate to use for each type of metric when DefaultRate is given as the rate of a
+// metric. Metric types without a configured rate keep the rate given to the client method. Rates must be in the
+// (0, 1] range.
+func WithDefaultSampleRates(rates map[MetricType]float64) Option {
+	return func(o *Options) error {
+		defaultRates := make(map[MetricType]float64, len(rates))
+		for mType, rate := range rates {
+			if rate <= 0 || rate > 1 {
+				return fmt.Errorf("default sample rate must be in the (0, 1] range, got %v", rate)
+			}
+			defaultRates[mType] = rate
+		}
+		o.defaultSampleRates = defaultRates
+		return nil
+	}
+}
+
+// WithSampler sets the Sampler deciding which values are kept when a metric is submitted with a rate lower than 1. By
+// default values are kept randomly, each with a probability equal to the rate. HashSampler can be used to always keep
+// or drop all the values of a series.
+//
+// The Sampler applies to the values sampled by the client before sending or aggregating them, it is not used by
+// WithMaxSamplesPerContext.
+func WithSampler(sampler Sampler) Option {
+	return func(o *Options) error {
+		if sampler == nil {
+			return fmt.Errorf("sampler can't be nil")
+		}
+		o.sampler = sampler
+		return nil
+	}
+}
+
+// WithSampleRNGSeed seeds the random sources used to sample the metrics submitted with a rate lower than 1 and to pick
+// the values kept by WithMaxSamplesPerContext and WithSetSampleLimit, instead of the current time. Every source gets the
+// same seed so the values of a given metric are always kept or dropped in the same sequence: this is meant for tests
+// asserting which samples are sent and should not be used in production where it correlates the sampling of every
+// metric. The Sampler set through WithSampler still takes precedence.
+func WithSampleRNGSeed(seed int64) Option {
+	return func(o *Options) error {
+		o.sampleRNGSeed = &seed
+		return nil
+	}
+}
+
+// WithErrorHandler sets a function called with a *DroppedMetricError every time the client drops data: when a queue is
+// full, when a payload can't be written or when a metric doesn't fit in a buffer. The error can be checked against
+// ErrQueueFull, ErrWriteFailed, ErrBufferTooSmall and ErrRateLimited with errors.Is.
+//
+// The handler is called from a dedicated goroutine so a slow handler never blocks the client. If too many errors are
+// waiting for the handler the new ones are discarded, they are still counted by the telemetry.
+func WithErrorHandler(handler ErrorHandler) Option {
+	return func(o *Options) error {
+		o.errorHandler = handler
+		return nil
+	}
+}
+
+// WithPayloadCompression compresses each payload with the given algorithm before writing it. This reduces the bandwidth
+// used when payloads are forwarded over the network, at the cost of CPU on the client. The Agent receiving the payloads
+// must support the chosen compression.
+//
+// Compression is not supported over UDP: a compressed payload can be slightly bigger than the original one when the
+// data doesn't compress well and would risk going over the datagram size limits. Creating a UDP client with this option
+// returns an error.
+func WithPayloadCompression(compression Compression) Option {
+	return func(o *Options) error {
+		switch compression {
+		case CompressionNone, CompressionGzip, CompressionDeflate:
+		default:
+			return fmt.Errorf("unknown payload compression: %d", compression)
+		}
+		o.compression = compression
+		return nil
+	}
+}
+
+// MaxSamplesStrategy is what the aggregator does once a context reached the limit set by WithMaxSamplesPerContext.
+type MaxSamplesStrategy int
+
+const (
+	// MaxSamplesFlush sends the buffered values of a context right away when it reaches the limit. No value is lost
+	// but more payloads are sent.
+	MaxSamplesFlush MaxSamplesStrategy = iota
+	// MaxSamplesReservoir keeps a uniform random sample of the values of a context until the next flush using
+	// reservoir sampling. The other values are dropped and the sample rate sent to the Agent is adjusted accordingly.
+	MaxSamplesReservoir
+)
+
+// WithMaxSamplesPerContext limits the number of values buffered by the aggregator for each context of Histograms,
+// Distributions and Timings between two flushes. This bounds the memory used by the aggregator when a context receives
+// a lot of values. The strategy decides what happens once a context reaches the limit. By default there is no limit.
+//
+// This is only used when Histograms, Distributions or Timings are aggregated, see WithExtendedClientSideAggregation and
+// WithTimingAggregation.
+func WithMaxSamplesPerContext(maxSamples int, strategy MaxSamplesStrategy) Option {
+	return func(o *Options) error {
+		if maxSamples < 0 {
+			return fmt.Errorf("maxSamples must be a positive integer")
+		}
+		if strategy != MaxSamplesFlush && strategy != MaxSamplesReservoir {
+			return fmt.Errorf("unknown max samples strategy: %d", strategy)
+		}
+		o.maxSamplesPerContext = maxSamples
+		o.maxSamplesStrategy = strategy
+		return nil
+	}
+}
+
+// WithSetSampleLimit limits the number of distinct values of each set (name and tags) forwarded per aggregation flush
+// interval to n. Once n values are kept, new values go through reservoir sampling: each one replaces a kept value with
+// a probability of n divided by the number of distinct values seen so far, so the values sent are a uniform sample of
+// all the values.
+//
+// The number of unique values computed by Datadog is then an approximation, lower than the real one when the limit
+// is reached. A value evicted by the reservoir and submitted again is counted as a new value. This is only used with
+// client side aggregation, enabled by default. Default is 0, meaning no limit.
+func WithSetSampleLimit(n int) Option {
+	return func(o *Options) error {
+		if n < 0 {
+			return fmt.Errorf("n must be a positive integer or 0")
+		}
+		o.setSampleLimit = n
+		return nil
+	}
+}
+
+// WithOriginDetection enables origin detection: the client reads the ID of the container it runs in from the cgroup
+// file of the process and sends it with every metric, event and service check (DogStatsD protocol v1.3) so the Agent
+// can tag them with the right container. If no container ID can be found the feature is silently disabled.
+//
+// The container ID is detected once per process when the first client using this option is created, only the clients
+// using this option send it. This feature is only compatible with Agent's versions >=7.32.0.
+func WithOriginDetection() Option {
+	return func(o *Options) error {
+		o.originDetection = true
+		return nil
+	}
+}
+
+// WithAgentCapabilityDetection makes the client query the info endpoint of the Agent at infoURL (ex:
+// "http://localhost:5000/info") when it is created and enable the extensions of the DogStatsD protocol the Agent
+// supports, instead of enabling them one by one. The endpoint must answer a JSON object listing them, ex:
+//
+//	{"capabilities": ["origin_detection", "sketch_input", "precomputed_histograms"]}
+//
+// enabling respectively WithOriginDetection, WithSketchInput and WithPrecomputedHistograms. Unknown capabilities are
+// ignored and the extensions enabled by other options are kept. The creation of the client waits up to 1 second for
+// the Agent: if it can't be queried or its answer can't be parsed, no extension is enabled.
+func WithAgentCapabilityDetection(infoURL string) Option {
+	return func(o *Options) error {
+		u, err := url.Parse(infoURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid agent info URL %q: must be an absolute http or https URL", infoURL)
+		}
+		o.agentInfoURL = infoURL
+		return nil
+	}
+}
+
+// WithExternalData sets the external data sent with every metric, event and service check (DogStatsD protocol v1.3)
+// to help the Agent find their origin when it can't detect the container of the client. By default the value of the
+// DD_EXTERNAL_ENV environment variable is used. The value can't contain '|' or line breaks.
+func WithExternalData(externalData string) Option {
+	return func(o *Options) error {
+		if strings.ContainsAny(externalData, "|\n\r") {
+			return fmt.Errorf("externalData can't contain '|' or line breaks")
+		}
+		o.externalData = externalData
+		return nil
+	}
+}
+
+// WithCardinality sets the cardinality of the origin tags the Agent adds to every metric, event and service check
+// sent by the client (DogStatsD protocol v1.3): CardinalityLow, CardinalityOrchestrator or CardinalityHigh. It can be
+// overridden for a single metric by giving a Cardinality as the last argument of the call. By default the Agent
+// configuration is used.
+func WithCardinality(cardinality Cardinality) Option {
+	return func(o *Options) error {
+		if !cardinality.isValid() {
+			return fmt.Errorf("invalid cardinality %d", cardinality)
+		}
+		o.cardinality = cardinality
+		return nil
+	}
+}
+
+// WithoutTelemetry disables the client telemetry: no telemetry metrics are sent and no goroutine or ticker is started
+// for it. The counters are still maintained and available through GetTelemetry.
 //
 // More on this here: https://docs.datadoghq.com/developers/dogstatsd/high_throughput/#client-side-telemetry
 func WithoutTelemetry() Option {
@@ -287,6 +1288,99 @@ func WithoutTelemetry() Option {
 	}
 }
 
+// WithAdditionalAddresses makes the client write every payload to the given addresses on top of the one given to New,
+// for example to dual-write to an old and a new Agent during a migration. The addresses use the same format as New.
+//
+// A failure to write to one address doesn't prevent the delivery to the others. The failures of each additional
+// address are counted independently in the Endpoints field of the telemetry. The client telemetry itself is only sent
+// to the main address.
+func WithAdditionalAddresses(addrs ...string) Option {
+	return func(o *Options) error {
+		for _, addr := range addrs {
+			if addr == "" {
+				return fmt.Errorf("additional addresses can't be empty")
+			}
+		}
+		o.additionalAddresses = append(o.additionalAddresses, addrs...)
+		return nil
+	}
+}
+
+// WithFloatPrecision rounds the values of gauges, histograms, distributions and timings to the given number of
+// decimals. The values are then formatted with the fewest digits needed: trailing zeros are removed so an integer
+// value is sent without decimals (ex: with a precision of 3, 1.23456 is sent as "1.235" and 2.5 as "2.5").
+//
+// By default gauges, histograms and distributions are sent with the fewest digits representing them exactly and
+// timings with 6 decimals (ex: "1.200000").
+func WithFloatPrecision(precision int) Option {
+	return func(o *Options) error {
+		if precision < 0 {
+			return fmt.Errorf("float precision must be equal or greater than 0")
+		}
+		o.floatPrecision = precision
+		return nil
+	}
+}
+
+// WithAlwaysEmitSampleRate writes the sample rate of every metric, including "|@1" for the metrics sent with a rate
+// of 1, for the tools expecting the rate to always be present. By default the rate is omitted when it is 1, which is
+// how the Agent reads a metric without rate.
+//
+// It has no effect with WithStatsdFormat, statsd only supporting the rate for sampled counters and timers.
+func WithAlwaysEmitSampleRate() Option {
+	return func(o *Options) error {
+		o.alwaysEmitSampleRate = true
+		return nil
+	}
+}
+
+// WithRecordSeparator sets the byte terminating each metric, event and service check in a payload, ex: 0 for
+// collectors expecting null-byte separated records. The default is '\n', which is the only separator supported by the
+// Agent.
+//
+// The separator can't be one of the characters of the DogStatsD protocol: '|', ':', '#', '@' and ','. Note that the
+// lines given to WriteRaw are still split on '\n'.
+func WithRecordSeparator(sep byte) Option {
+	return func(o *Options) error {
+		if strings.IndexByte("|:#@,", sep) >= 0 {
+			return fmt.Errorf("record separator can't be '%c'", sep)
+		}
+		o.recordSeparator = sep
+		return nil
+	}
+}
+
+// WithSynchronousMode makes the client write each metric, event and service check to the transport on the goroutine
+// calling it, before the call returns. It is meant for short-lived processes (CLI tools, serverless functions) that
+// can't afford to lose the metrics sent right before exiting.
+//
+// No goroutine is started by the client: there is no sender queue, no periodic flush and no telemetry sent (see
+// GetTelemetry to read it). Client side aggregation is disabled and the channel mode is ignored. Flush and Drain have
+// nothing to do and Close only closes the transport. Each metric is written in its own payload, except the ones of a
+// Batch which are written together.
+//
+// Only WithErrorHandler and WithGracefulShutdown still start a goroutine when they are used.
+func WithSynchronousMode() Option {
+	return func(o *Options) error {
+		o.synchronousMode = true
+		return nil
+	}
+}
+
+// WithTransport makes the client write its payloads to the given transport instead of the one created from the address
+// given to New, which is then ignored. The transport is closed by Close.
+//
+// A client cloned with CloneWithExtraOptions shares the transport, which is closed by the first client closed.
+func WithTransport(transport Transport) Option {
+	return func(o *Options) error {
+		if transport == nil {
+			return fmt.Errorf("transport can't be nil")
+		}
+		o.transport = transport
+		return nil
+	}
+}
+
 // WithTelemetryAddr sets a different address for telemetry metrics. By default the same address as the client is used
 // for telemetry.
 //
@@ -297,3 +1391,64 @@ func WithTelemetryAddr(addr string) Option {
 		return nil
 	}
 }
+
+// WithTelemetryTags adds tags to the telemetry metrics of the client, after the global tags and the tags describing the
+// client and its transport. This tells apart the telemetry of the services using the same global tags.
+func WithTelemetryTags(tags ...string) Option {
+	return func(o *Options) error {
+		o.telemetryTags = append([]string{}, tags...)
+		return nil
+	}
+}
+
+// WithTelemetryInterval sets the interval at which the telemetry metrics are sent. The default is 10s.
+func WithTelemetryInterval(interval time.Duration) Option {
+	return func(o *Options) error {
+		if interval <= 0 {
+			return fmt.Errorf("telemetry interval must be a positive duration")
+		}
+		o.telemetryInterval = interval
+		return nil
+	}
+}
+
+// WithTelemetryNamespace sets a namespace to prepend to the names of the telemetry metrics, the telemetry doesn't use
+// the namespace set by WithNamespace.
+//
+// A '.' will automatically be added after the namespace if needed. For example a namespace 'app' will produce telemetry
+// metrics named 'app.datadog.dogstatsd.client.metrics'.
+func WithTelemetryNamespace(namespace string) Option {
+	return func(o *Options) error {
+		if namespace == "" || strings.HasSuffix(namespace, ".") {
+			o.telemetryNamespace = namespace
+		} else {
+			o.telemetryNamespace = namespace + "."
+		}
+		return nil
+	}
+}
+
+// WithGracefulShutdown makes the client close itself, flushing the metrics it buffered, when the program receives one
+// of the given signals (ex: syscall.SIGTERM). This saves the metrics of programs exiting without calling Close.
+//
+// The handler is removed when the client is closed, whether through the signal or Close: a signal received after that
+// gets the behavior it had before the client was created. The signal closing the client is then raised again so the
+// program gets that behavior too: by default SIGTERM and SIGINT still terminate it once the metrics are flushed. A
+// program handling the signal itself with signal.Notify receives it twice.
+func WithGracefulShutdown(signals ...os.Signal) Option {
+	return func(o *Options) error {
+		if len(signals) == 0 {
+			return fmt.Errorf("at least one signal is required for a graceful shutdown")
+		}
+		o.shutdownSignals = append([]os.Signal{}, signals...)
+		return nil
+	}
+}
+
+// withClock sets the clock used by the client for its flush intervals. This is only meant to be used by tests.
+func withClock(c clock) Option {
+	return func(o *Options) error {
+		o.clock = c
+		return nil
+	}
+}
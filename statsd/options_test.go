@@ -1,10 +1,12 @@
 package statsd
 
 import (
+	"os"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestDefaultOptions(t *testing.T) {
@@ -23,10 +25,71 @@ func TestDefaultOptions(t *testing.T) {
 	assert.Equal(t, options.telemetry, defaultTelemetry)
 	assert.Equal(t, options.receiveMode, defaultReceivingMode)
 	assert.Equal(t, options.channelModeBufferSize, defaultChannelModeBufferSize)
+	assert.Equal(t, options.channelModeTimeout, defaultChannelModeTimeout)
 	assert.Equal(t, options.aggregationFlushInterval, defaultAggregationFlushInterval)
 	assert.Equal(t, options.aggregation, defaultAggregation)
 	assert.Equal(t, options.extendedAggregation, defaultExtendedAggregation)
+	assert.Equal(t, options.timingAggregation, defaultTimingAggregation)
+	assert.Nil(t, options.defaultSampleRates)
+	assert.Equal(t, options.clock, realClock{})
+	assert.Equal(t, options.compression, defaultCompression)
+	assert.Equal(t, options.maxSamplesPerContext, defaultMaxSamplesPerContext)
+	assert.Equal(t, options.maxSamplesStrategy, defaultMaxSamplesStrategy)
+	assert.Equal(t, options.originDetection, defaultOriginDetection)
+	assert.Zero(t, options.externalData)
+	assert.Equal(t, options.cardinality, defaultCardinality)
+	assert.Equal(t, options.setSampleLimit, defaultSetSampleLimit)
+	assert.Empty(t, options.additionalAddresses)
+	assert.Nil(t, options.tagFilter)
+	assert.Empty(t, options.tagDenylist)
+	assert.Equal(t, options.tagDeduplication, defaultTagDeduplication)
+	assert.Equal(t, options.tagNormalization, defaultTagNormalization)
+	assert.Nil(t, options.sampler)
+	assert.Equal(t, options.maxBlockDuration, defaultMaxBlockDuration)
+	assert.Equal(t, options.precomputedHistograms, defaultPrecomputedHistograms)
+	assert.Equal(t, options.adaptivePayloadPacking, defaultAdaptivePayloadPacking)
+	assert.Equal(t, options.histogramFlushInterval, defaultHistogramFlushInterval)
+	assert.Equal(t, options.strictNameValidation, defaultStrictNameValidation)
+	assert.Equal(t, options.rateLimit, defaultRateLimit)
+	assert.Equal(t, options.constantHostname, defaultConstantHostname)
+	assert.Equal(t, options.flushJitter, defaultFlushJitter)
+	assert.Equal(t, options.flushHighWaterMark, defaultFlushHighWaterMark)
 	assert.Zero(t, options.telemetryAddr)
+	assert.Nil(t, options.telemetryTags)
+	assert.Equal(t, options.telemetryInterval, defaultTelemetryInterval)
+	assert.Equal(t, options.telemetryNamespace, defaultTelemetryNamespace)
+	assert.Nil(t, options.shutdownSignals)
+	assert.Nil(t, options.transport)
+	assert.Equal(t, options.maxQueueBytes, defaultMaxQueueBytes)
+	assert.Equal(t, options.synchronousMode, defaultSynchronousMode)
+	assert.Equal(t, options.recordSeparator, defaultRecordSeparator)
+	assert.Equal(t, options.sortedTags, defaultSortedTags)
+	assert.Equal(t, options.maxTagsPerMetric, defaultMaxTagsPerMetric)
+	assert.Equal(t, options.maxTagsStrategy, defaultMaxTagsStrategy)
+	assert.Equal(t, options.floatPrecision, defaultFloatPrecision)
+	assert.Equal(t, options.countsAsRates, defaultCountsAsRates)
+	assert.Equal(t, options.unifiedServiceTagging, defaultUnifiedServiceTagging)
+	assert.Equal(t, options.oversizedMetricPolicy, defaultOversizedMetricPolicy)
+	assert.Equal(t, options.tagInterning, defaultTagInterning)
+	assert.Equal(t, options.statsdFormat, defaultStatsdFormat)
+	assert.Equal(t, options.statsdTagJoiner, defaultStatsdTagJoiner)
+	assert.Equal(t, options.tagOrder, defaultTagOrder)
+	assert.Equal(t, options.sketchInput, defaultSketchInput)
+	assert.Equal(t, options.immediateEvents, defaultImmediateEvents)
+	assert.Equal(t, options.udpSendBufferSize, defaultUDPSendBufferSize)
+	assert.Equal(t, options.agentInfoURL, defaultAgentInfoURL)
+	assert.Equal(t, options.maxTagValueLength, defaultMaxTagValueLength)
+	assert.Equal(t, options.tagTruncationMarker, defaultTagTruncationMarker)
+	assert.Equal(t, options.channelModeErrOnFull, defaultChannelModeErrOnFull)
+	assert.Equal(t, options.outageBufferSize, defaultOutageBufferSize)
+	assert.Nil(t, options.sampleRNGSeed)
+	assert.Equal(t, options.connectTimeout, defaultConnectTimeout)
+	assert.Equal(t, options.lazyConnection, defaultLazyConnection)
+	assert.Equal(t, options.ddSketchEncoding, defaultDDSketchEncoding)
+	assert.Nil(t, options.bufferAllocate)
+	assert.Nil(t, options.bufferRelease)
+	assert.Equal(t, options.alwaysEmitSampleRate, defaultAlwaysEmitSampleRate)
+	assert.Equal(t, options.flushEveryNMetrics, defaultFlushEveryNMetrics)
 }
 
 func TestOptions(t *testing.T) {
@@ -40,6 +103,7 @@ func TestOptions(t *testing.T) {
 	testSenderQueueSize := 64
 	testWriteTimeout := 1 * time.Minute
 	testChannelBufferSize := 500
+	testChannelTimeout := 10 * time.Millisecond
 	testAggregationWindow := 10 * time.Second
 	testTelemetryAddr := "localhost:1234"
 
@@ -56,6 +120,7 @@ func TestOptions(t *testing.T) {
 		WithoutTelemetry(),
 		WithChannelMode(),
 		WithChannelModeBufferSize(testChannelBufferSize),
+		WithChannelModeTimeout(testChannelTimeout),
 		WithAggregationInterval(testAggregationWindow),
 		WithClientSideAggregation(),
 		WithTelemetryAddr(testTelemetryAddr),
@@ -74,12 +139,542 @@ func TestOptions(t *testing.T) {
 	assert.Equal(t, options.telemetry, false)
 	assert.Equal(t, options.receiveMode, channelMode)
 	assert.Equal(t, options.channelModeBufferSize, testChannelBufferSize)
+	assert.Equal(t, options.channelModeTimeout, testChannelTimeout)
 	assert.Equal(t, options.aggregationFlushInterval, testAggregationWindow)
 	assert.Equal(t, options.aggregation, true)
 	assert.Equal(t, options.extendedAggregation, false)
 	assert.Equal(t, options.telemetryAddr, testTelemetryAddr)
 }
 
+func TestChannelModeTimeoutInvalid(t *testing.T) {
+	_, err := resolveOptions([]Option{
+		WithChannelModeTimeout(-1 * time.Second),
+	})
+	assert.Error(t, err)
+}
+
+func TestDefaultSampleRates(t *testing.T) {
+	rates := map[MetricType]float64{HistogramType: 0.5, TimingType: 1}
+	options, err := resolveOptions([]Option{
+		WithDefaultSampleRates(rates),
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, rates, options.defaultSampleRates)
+
+	// the map is copied so later changes from the caller have no effect
+	rates[CountType] = 0.1
+	assert.NotContains(t, options.defaultSampleRates, CountType)
+}
+
+func TestDefaultSampleRatesInvalid(t *testing.T) {
+	for _, rate := range []float64{0, -0.5, 1.5} {
+		_, err := resolveOptions([]Option{
+			WithDefaultSampleRates(map[MetricType]float64{GaugeType: rate}),
+		})
+		assert.Error(t, err, "rate %v should be rejected", rate)
+	}
+}
+
+func TestPayloadCompression(t *testing.T) {
+	options, err := resolveOptions([]Option{
+		WithPayloadCompression(CompressionGzip),
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, CompressionGzip, options.compression)
+
+	_, err = resolveOptions([]Option{
+		WithPayloadCompression(Compression(42)),
+	})
+	assert.Error(t, err)
+}
+
+func TestMaxSamplesPerContext(t *testing.T) {
+	options, err := resolveOptions([]Option{
+		WithMaxSamplesPerContext(100, MaxSamplesReservoir),
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 100, options.maxSamplesPerContext)
+	assert.Equal(t, MaxSamplesReservoir, options.maxSamplesStrategy)
+
+	_, err = resolveOptions([]Option{WithMaxSamplesPerContext(-1, MaxSamplesFlush)})
+	assert.Error(t, err)
+	_, err = resolveOptions([]Option{WithMaxSamplesPerContext(10, MaxSamplesStrategy(42))})
+	assert.Error(t, err)
+}
+
+func TestOriginDetection(t *testing.T) {
+	options, err := resolveOptions([]Option{
+		WithOriginDetection(),
+	})
+	assert.NoError(t, err)
+	assert.True(t, options.originDetection)
+}
+
+func TestExternalData(t *testing.T) {
+	options, err := resolveOptions([]Option{
+		WithExternalData("it-false,cn-nginx"),
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "it-false,cn-nginx", options.externalData)
+	assert.Equal(t, "it-false,cn-nginx", options.bufferConfig.externalEnv)
+
+	for _, value := range []string{"it-false|cn-nginx", "it-false\ncn-nginx"} {
+		_, err = resolveOptions([]Option{WithExternalData(value)})
+		assert.Error(t, err)
+	}
+}
+
+func TestCardinality(t *testing.T) {
+	for _, cardinality := range []Cardinality{CardinalityLow, CardinalityOrchestrator, CardinalityHigh} {
+		options, err := resolveOptions([]Option{WithCardinality(cardinality)})
+		assert.NoError(t, err)
+		assert.Equal(t, cardinality, options.cardinality)
+	}
+
+	for _, cardinality := range []Cardinality{-1, CardinalityHigh + 1} {
+		_, err := resolveOptions([]Option{WithCardinality(cardinality)})
+		assert.Error(t, err)
+	}
+}
+
+func TestSetSampleLimit(t *testing.T) {
+	options, err := resolveOptions([]Option{
+		WithSetSampleLimit(100),
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 100, options.setSampleLimit)
+
+	_, err = resolveOptions([]Option{WithSetSampleLimit(-1)})
+	assert.Error(t, err)
+}
+
+func TestAdditionalAddresses(t *testing.T) {
+	options, err := resolveOptions([]Option{
+		WithAdditionalAddresses("localhost:8126"),
+		WithAdditionalAddresses("localhost:8127", "unix:///tmp/dsd.socket"),
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"localhost:8126", "localhost:8127", "unix:///tmp/dsd.socket"}, options.additionalAddresses)
+
+	_, err = resolveOptions([]Option{WithAdditionalAddresses("")})
+	assert.Error(t, err)
+}
+
+func TestTagFilterOptions(t *testing.T) {
+	options, err := resolveOptions([]Option{
+		WithTagFilter(func(tag string) bool { return true }),
+		WithTagDenylist("^user_id:"),
+		WithTagDenylist("^session:", "debug"),
+	})
+	assert.NoError(t, err)
+	assert.NotNil(t, options.tagFilter)
+	assert.Len(t, options.tagDenylist, 3)
+
+	_, err = resolveOptions([]Option{WithTagFilter(nil)})
+	assert.Error(t, err)
+	_, err = resolveOptions([]Option{WithTagDenylist("user_id:(")})
+	assert.Error(t, err)
+}
+
+func TestTagDeduplicationAndNormalizationOptions(t *testing.T) {
+	options, err := resolveOptions([]Option{
+		WithTagDeduplication(),
+		WithTagNormalization(),
+	})
+	assert.NoError(t, err)
+	assert.True(t, options.tagDeduplication)
+	assert.True(t, options.tagNormalization)
+}
+
+func TestSampler(t *testing.T) {
+	options, err := resolveOptions([]Option{
+		WithSampler(HashSampler{}),
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, HashSampler{}, options.sampler)
+
+	_, err = resolveOptions([]Option{WithSampler(nil)})
+	assert.Error(t, err)
+}
+
+func TestMaxBlockDuration(t *testing.T) {
+	options, err := resolveOptions([]Option{
+		WithMaxBlockDuration(10 * time.Millisecond),
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 10*time.Millisecond, options.maxBlockDuration)
+
+	_, err = resolveOptions([]Option{WithMaxBlockDuration(-1)})
+	assert.Error(t, err)
+}
+
+func TestPrecomputedHistograms(t *testing.T) {
+	options, err := resolveOptions([]Option{WithPrecomputedHistograms()})
+	assert.NoError(t, err)
+	assert.True(t, options.precomputedHistograms)
+}
+
+func TestAdaptivePayloadPacking(t *testing.T) {
+	options, err := resolveOptions([]Option{WithAdaptivePayloadPacking()})
+	assert.NoError(t, err)
+	assert.True(t, options.adaptivePayloadPacking)
+}
+
+func TestHistogramFlushInterval(t *testing.T) {
+	options, err := resolveOptions([]Option{WithHistogramFlushInterval(10 * time.Second)})
+	assert.NoError(t, err)
+	assert.Equal(t, 10*time.Second, options.histogramFlushInterval)
+
+	_, err = resolveOptions([]Option{WithHistogramFlushInterval(-1)})
+	assert.Error(t, err)
+}
+
+func TestStrictNameValidation(t *testing.T) {
+	options, err := resolveOptions([]Option{WithStrictNameValidation()})
+	assert.NoError(t, err)
+	assert.True(t, options.strictNameValidation)
+}
+
+func TestRateLimit(t *testing.T) {
+	options, err := resolveOptions([]Option{WithRateLimit(100)})
+	assert.NoError(t, err)
+	assert.Equal(t, 100, options.rateLimit)
+
+	_, err = resolveOptions([]Option{WithRateLimit(-1)})
+	assert.Error(t, err)
+}
+
+func TestConstantHostname(t *testing.T) {
+	options, err := resolveOptions([]Option{WithConstantHostname("my-host")})
+	assert.NoError(t, err)
+	assert.Equal(t, "my-host", options.constantHostname)
+
+	hostname, err := os.Hostname()
+	require.NoError(t, err)
+	options, err = resolveOptions([]Option{WithConstantHostname("")})
+	assert.NoError(t, err)
+	assert.Equal(t, hostname, options.constantHostname)
+}
+
+func TestFlushJitter(t *testing.T) {
+	options, err := resolveOptions([]Option{WithFlushJitter(0.2)})
+	assert.NoError(t, err)
+	assert.Equal(t, 0.2, options.flushJitter)
+
+	_, err = resolveOptions([]Option{WithFlushJitter(-0.1)})
+	assert.Error(t, err)
+	_, err = resolveOptions([]Option{WithFlushJitter(1)})
+	assert.Error(t, err)
+}
+
+func TestFlushHighWaterMark(t *testing.T) {
+	options, err := resolveOptions([]Option{WithFlushHighWaterMark(0.8)})
+	assert.NoError(t, err)
+	assert.Equal(t, 0.8, options.flushHighWaterMark)
+
+	_, err = resolveOptions([]Option{WithFlushHighWaterMark(1)})
+	assert.NoError(t, err)
+	_, err = resolveOptions([]Option{WithFlushHighWaterMark(0)})
+	assert.Error(t, err)
+	_, err = resolveOptions([]Option{WithFlushHighWaterMark(1.1)})
+	assert.Error(t, err)
+}
+
+func TestTelemetryTags(t *testing.T) {
+	tags := []string{"service:api"}
+	options, err := resolveOptions([]Option{WithTelemetryTags(tags...)})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"service:api"}, options.telemetryTags)
+
+	// the option keeps its own copy of the tags
+	tags[0] = "service:web"
+	assert.Equal(t, []string{"service:api"}, options.telemetryTags)
+}
+
+func TestTelemetryInterval(t *testing.T) {
+	options, err := resolveOptions([]Option{WithTelemetryInterval(time.Minute)})
+	assert.NoError(t, err)
+	assert.Equal(t, time.Minute, options.telemetryInterval)
+
+	_, err = resolveOptions([]Option{WithTelemetryInterval(0)})
+	assert.Error(t, err)
+	_, err = resolveOptions([]Option{WithTelemetryInterval(-time.Second)})
+	assert.Error(t, err)
+}
+
+func TestTelemetryNamespace(t *testing.T) {
+	options, err := resolveOptions([]Option{WithTelemetryNamespace("app")})
+	assert.NoError(t, err)
+	assert.Equal(t, "app.", options.telemetryNamespace)
+
+	options, err = resolveOptions([]Option{WithTelemetryNamespace("app.")})
+	assert.NoError(t, err)
+	assert.Equal(t, "app.", options.telemetryNamespace)
+}
+
+func TestGracefulShutdown(t *testing.T) {
+	options, err := resolveOptions([]Option{WithGracefulShutdown(os.Interrupt)})
+	assert.NoError(t, err)
+	assert.Equal(t, []os.Signal{os.Interrupt}, options.shutdownSignals)
+
+	_, err = resolveOptions([]Option{WithGracefulShutdown()})
+	assert.Error(t, err)
+}
+
+func TestTransport(t *testing.T) {
+	transport := &chanTransport{}
+	options, err := resolveOptions([]Option{WithTransport(transport)})
+	assert.NoError(t, err)
+	assert.Equal(t, transport, options.transport)
+
+	_, err = resolveOptions([]Option{WithTransport(nil)})
+	assert.Error(t, err)
+}
+
+func TestMaxQueueBytes(t *testing.T) {
+	options, err := resolveOptions([]Option{WithMaxQueueBytes(4096)})
+	assert.NoError(t, err)
+	assert.Equal(t, 4096, options.maxQueueBytes)
+
+	_, err = resolveOptions([]Option{WithMaxQueueBytes(-1)})
+	assert.Error(t, err)
+}
+
+func TestSynchronousMode(t *testing.T) {
+	options, err := resolveOptions([]Option{WithSynchronousMode()})
+	assert.NoError(t, err)
+	assert.True(t, options.synchronousMode)
+}
+
+func TestRecordSeparator(t *testing.T) {
+	options, err := resolveOptions([]Option{WithRecordSeparator(0)})
+	assert.NoError(t, err)
+	assert.Equal(t, byte(0), options.recordSeparator)
+
+	for _, sep := range []byte("|:#@,") {
+		_, err = resolveOptions([]Option{WithRecordSeparator(sep)})
+		assert.Error(t, err)
+	}
+}
+
+func TestSortedTags(t *testing.T) {
+	options, err := resolveOptions([]Option{WithSortedTags()})
+	assert.NoError(t, err)
+	assert.True(t, options.sortedTags)
+}
+
+func TestMaxTagsPerMetric(t *testing.T) {
+	options, err := resolveOptions([]Option{WithMaxTagsPerMetric(10, MaxTagsDrop)})
+	assert.NoError(t, err)
+	assert.Equal(t, 10, options.maxTagsPerMetric)
+	assert.Equal(t, MaxTagsDrop, options.maxTagsStrategy)
+
+	_, err = resolveOptions([]Option{WithMaxTagsPerMetric(-1, MaxTagsDrop)})
+	assert.Error(t, err)
+	_, err = resolveOptions([]Option{WithMaxTagsPerMetric(10, MaxTagsStrategy(42))})
+	assert.Error(t, err)
+}
+
+func TestFloatPrecision(t *testing.T) {
+	options, err := resolveOptions([]Option{WithFloatPrecision(3)})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, options.floatPrecision)
+
+	_, err = resolveOptions([]Option{WithFloatPrecision(-1)})
+	assert.Error(t, err)
+}
+
+func TestCountsAsRates(t *testing.T) {
+	options, err := resolveOptions([]Option{WithCountsAsRates()})
+	assert.NoError(t, err)
+	assert.True(t, options.countsAsRates)
+}
+
+func TestUnifiedServiceTagging(t *testing.T) {
+	options, err := resolveOptions([]Option{WithUnifiedServiceTagging()})
+	assert.NoError(t, err)
+	assert.True(t, options.unifiedServiceTagging)
+}
+
+func TestOversizedMetricPolicy(t *testing.T) {
+	options, err := resolveOptions([]Option{WithOversizedMetricPolicy(OversizedMetricBestEffortTruncate)})
+	assert.NoError(t, err)
+	assert.Equal(t, OversizedMetricBestEffortTruncate, options.oversizedMetricPolicy)
+
+	_, err = resolveOptions([]Option{WithOversizedMetricPolicy(OversizedMetricPolicy(42))})
+	assert.Error(t, err)
+}
+
+func TestTagInterning(t *testing.T) {
+	options, err := resolveOptions([]Option{WithTagInterning()})
+	assert.NoError(t, err)
+	assert.True(t, options.tagInterning)
+}
+
+func TestStatsdFormat(t *testing.T) {
+	options, err := resolveOptions([]Option{WithStatsdFormat()})
+	assert.NoError(t, err)
+	assert.True(t, options.statsdFormat)
+	assert.Equal(t, "", options.statsdTagJoiner)
+
+	options, err = resolveOptions([]Option{WithStatsdTagFolding(".")})
+	assert.NoError(t, err)
+	assert.True(t, options.statsdFormat)
+	assert.Equal(t, ".", options.statsdTagJoiner)
+
+	for _, joiner := range []string{"", ":", "|", "_#"} {
+		_, err = resolveOptions([]Option{WithStatsdTagFolding(joiner)})
+		assert.Error(t, err, joiner)
+	}
+}
+
+func TestTagOrder(t *testing.T) {
+	options, err := resolveOptions([]Option{WithTagOrder(TagOrderCallFirst)})
+	assert.NoError(t, err)
+	assert.Equal(t, TagOrderCallFirst, options.tagOrder)
+
+	_, err = resolveOptions([]Option{WithTagOrder(TagOrder(42))})
+	assert.Error(t, err)
+}
+
+func TestSketchInput(t *testing.T) {
+	options, err := resolveOptions([]Option{WithSketchInput()})
+	assert.NoError(t, err)
+	assert.True(t, options.sketchInput)
+}
+
+func TestImmediateEvents(t *testing.T) {
+	options, err := resolveOptions([]Option{WithImmediateEvents()})
+	assert.NoError(t, err)
+	assert.True(t, options.immediateEvents)
+}
+
+func TestUDPSendBufferSize(t *testing.T) {
+	options, err := resolveOptions([]Option{WithUDPSendBufferSize(1 << 20)})
+	assert.NoError(t, err)
+	assert.Equal(t, 1<<20, options.udpSendBufferSize)
+
+	_, err = resolveOptions([]Option{WithUDPSendBufferSize(0)})
+	assert.Error(t, err)
+}
+
+func TestAgentCapabilityDetection(t *testing.T) {
+	options, err := resolveOptions([]Option{WithAgentCapabilityDetection("http://localhost:5000/info")})
+	assert.NoError(t, err)
+	assert.Equal(t, "http://localhost:5000/info", options.agentInfoURL)
+
+	for _, infoURL := range []string{"", "localhost:5000/info", "unix:///var/run/agent.sock"} {
+		_, err = resolveOptions([]Option{WithAgentCapabilityDetection(infoURL)})
+		assert.Error(t, err, infoURL)
+	}
+}
+
+func TestMaxTagValueLength(t *testing.T) {
+	options, err := resolveOptions([]Option{WithMaxTagValueLength(200), WithTagTruncationMarker("...")})
+	assert.NoError(t, err)
+	assert.Equal(t, 200, options.maxTagValueLength)
+	assert.Equal(t, "...", options.tagTruncationMarker)
+
+	_, err = resolveOptions([]Option{WithMaxTagValueLength(0)})
+	assert.Error(t, err)
+	_, err = resolveOptions([]Option{WithTagTruncationMarker("a,b")})
+	assert.Error(t, err)
+}
+
+func TestChannelModeErrOnFull(t *testing.T) {
+	options, err := resolveOptions([]Option{WithChannelModeErrOnFull()})
+	assert.NoError(t, err)
+	assert.True(t, options.channelModeErrOnFull)
+}
+
+func TestOutageBuffer(t *testing.T) {
+	options, err := resolveOptions([]Option{WithOutageBuffer(1 << 20)})
+	assert.NoError(t, err)
+	assert.Equal(t, 1<<20, options.outageBufferSize)
+
+	_, err = resolveOptions([]Option{WithOutageBuffer(0)})
+	assert.Error(t, err)
+}
+
+func TestSampleRNGSeed(t *testing.T) {
+	options, err := resolveOptions([]Option{WithSampleRNGSeed(0)})
+	assert.NoError(t, err)
+	require.NotNil(t, options.sampleRNGSeed)
+	assert.Equal(t, int64(0), *options.sampleRNGSeed)
+}
+
+func TestConnectTimeout(t *testing.T) {
+	options, err := resolveOptions([]Option{WithConnectTimeout(time.Second)})
+	assert.NoError(t, err)
+	assert.Equal(t, time.Second, options.connectTimeout)
+
+	_, err = resolveOptions([]Option{WithConnectTimeout(-time.Second)})
+	assert.Error(t, err)
+}
+
+func TestLazyConnection(t *testing.T) {
+	options, err := resolveOptions([]Option{WithLazyConnection()})
+	assert.NoError(t, err)
+	assert.True(t, options.lazyConnection)
+}
+
+func TestDDSketchEncoding(t *testing.T) {
+	options, err := resolveOptions([]Option{WithDDSketchEncoding()})
+	assert.NoError(t, err)
+	assert.True(t, options.ddSketchEncoding)
+}
+
+func TestBufferAllocator(t *testing.T) {
+	options, err := resolveOptions([]Option{WithBufferAllocator(func(size int) []byte { return make([]byte, size) }, nil)})
+	assert.NoError(t, err)
+	assert.NotNil(t, options.bufferAllocate)
+	assert.Nil(t, options.bufferRelease)
+
+	_, err = resolveOptions([]Option{WithBufferAllocator(nil, func([]byte) {})})
+	assert.Error(t, err)
+}
+
+func TestAlwaysEmitSampleRate(t *testing.T) {
+	options, err := resolveOptions([]Option{WithAlwaysEmitSampleRate()})
+	assert.NoError(t, err)
+	assert.True(t, options.alwaysEmitSampleRate)
+}
+
+func TestFlushEveryNMetrics(t *testing.T) {
+	options, err := resolveOptions([]Option{WithFlushEveryNMetrics(10)})
+	assert.NoError(t, err)
+	assert.Equal(t, 10, options.flushEveryNMetrics)
+
+	_, err = resolveOptions([]Option{WithFlushEveryNMetrics(-1)})
+	assert.Error(t, err)
+}
+
+func TestBufferConfig(t *testing.T) {
+	options, err := resolveOptions(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, defaultBufferConfig, options.bufferConfig)
+
+	options, err = resolveOptions([]Option{
+		WithRecordSeparator(0),
+		WithFloatPrecision(3),
+		WithTagOrder(TagOrderCallFirst),
+		WithAlwaysEmitSampleRate(),
+		WithStatsdFormat(),
+		WithStatsdTagFolding("."),
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, bufferConfig{
+		separator:      0,
+		floatPrecision: 3,
+		tagOrder:       TagOrderCallFirst,
+		statsd:         &statsdFormat{tagJoiner: "."},
+	}, options.bufferConfig)
+
+	options, err = resolveOptions([]Option{WithAlwaysEmitSampleRate()})
+	assert.NoError(t, err)
+	assert.True(t, options.bufferConfig.alwaysRate)
+}
+
 func TestExtendedAggregation(t *testing.T) {
 	options, err := resolveOptions([]Option{
 		WithoutClientSideAggregation(),
@@ -91,6 +686,27 @@ func TestExtendedAggregation(t *testing.T) {
 	assert.Equal(t, options.extendedAggregation, true)
 }
 
+func TestTimingAggregation(t *testing.T) {
+	options, err := resolveOptions([]Option{
+		WithoutClientSideAggregation(),
+		WithTimingAggregation(),
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, options.aggregation, true)
+	assert.Equal(t, options.extendedAggregation, false)
+	assert.Equal(t, options.timingAggregation, true)
+
+	options, err = resolveOptions([]Option{
+		WithTimingAggregation(),
+		WithoutClientSideAggregation(),
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, options.aggregation, false)
+	assert.Equal(t, options.timingAggregation, false)
+}
+
 func TestResetOptions(t *testing.T) {
 	options, err := resolveOptions([]Option{
 		WithChannelMode(),
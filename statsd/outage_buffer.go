@@ -0,0 +1,70 @@
+package statsd
+
+import (
+	"container/list"
+	"time"
+)
+
+// outageRetryInterval is how often the sender tries to replay the payloads kept by WithOutageBuffer when no new
+// payload is written.
+const outageRetryInterval = time.Second
+
+// outagePayload is a payload kept by the outageBuffer. size is the size of the payload before compression and messages
+// the number of messages it holds, used for the telemetry like for the other payloads.
+type outagePayload struct {
+	payload  []byte
+	size     int
+	messages int
+}
+
+// outageBuffer keeps the payloads that could not be written to the transport, oldest first, up to maxBytes, see
+// WithOutageBuffer. It is only used by the goroutine writing to the transport.
+type outageBuffer struct {
+	maxBytes int
+	bytes    int
+	payloads *list.List
+	// lastErr is the last error returned by the transport, reported for the payloads dropped from the buffer.
+	lastErr error
+}
+
+func newOutageBuffer(maxBytes int) *outageBuffer {
+	return &outageBuffer{
+		maxBytes: maxBytes,
+		payloads: list.New(),
+	}
+}
+
+// pending returns true if some payloads are waiting to be replayed.
+func (b *outageBuffer) pending() bool {
+	return b != nil && b.payloads.Len() != 0
+}
+
+// push keeps a copy of payload after the others. The oldest payloads are evicted and returned until it fits, payload
+// being returned itself if it is bigger than the whole buffer.
+func (b *outageBuffer) push(payload []byte, size int, messages int, err error) []outagePayload {
+	b.lastErr = err
+	kept := outagePayload{payload: append([]byte(nil), payload...), size: size, messages: messages}
+	if len(payload) > b.maxBytes {
+		return []outagePayload{kept}
+	}
+
+	var evicted []outagePayload
+	for b.bytes+len(payload) > b.maxBytes {
+		evicted = append(evicted, b.pop())
+	}
+	b.payloads.PushBack(kept)
+	b.bytes += len(payload)
+	return evicted
+}
+
+// front returns the oldest payload.
+func (b *outageBuffer) front() outagePayload {
+	return b.payloads.Front().Value.(outagePayload)
+}
+
+// pop removes and returns the oldest payload.
+func (b *outageBuffer) pop() outagePayload {
+	p := b.payloads.Remove(b.payloads.Front()).(outagePayload)
+	b.bytes -= len(p.payload)
+	return p
+}
@@ -0,0 +1,40 @@
+package statsd
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOutageBufferPush(t *testing.T) {
+	b := newOutageBuffer(10)
+	assert.False(t, b.pending())
+
+	payload := []byte("aaaa")
+	assert.Empty(t, b.push(payload, 4, 1, nil))
+	// the payload is copied since the buffer holding it goes back to the pool
+	payload[0] = 'x'
+	assert.Empty(t, b.push([]byte("bbbb"), 4, 1, nil))
+	assert.True(t, b.pending())
+	assert.Equal(t, 8, b.bytes)
+
+	// the oldest payload is evicted to make room
+	err := fmt.Errorf("connection refused")
+	evicted := b.push([]byte("cccc"), 4, 1, err)
+	assert.Equal(t, []outagePayload{{payload: []byte("aaaa"), size: 4, messages: 1}}, evicted)
+	assert.Equal(t, err, b.lastErr)
+
+	// a payload bigger than the buffer is not kept
+	evicted = b.push([]byte("ddddddddddd"), 11, 1, err)
+	assert.Equal(t, []outagePayload{{payload: []byte("ddddddddddd"), size: 11, messages: 1}}, evicted)
+
+	assert.Equal(t, []byte("bbbb"), b.front().payload)
+	assert.Equal(t, []byte("bbbb"), b.pop().payload)
+	assert.Equal(t, []byte("cccc"), b.pop().payload)
+	assert.False(t, b.pending())
+	assert.Equal(t, 0, b.bytes)
+
+	var disabled *outageBuffer
+	assert.False(t, disabled.pending())
+}
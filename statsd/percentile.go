@@ -0,0 +1,63 @@
+package statsd
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// ErrPercentileWithoutAggregation is returned by HistogramPercentile when the client side aggregation is disabled.
+var ErrPercentileWithoutAggregation = errors.New("statsd histogram percentiles require the client side aggregation")
+
+// percentileSuffix returns the suffix of the gauge of a percentile: ".p95" for 95 and ".p99_9" for 99.9, the '.' of
+// the metric names separating their hierarchy.
+func percentileSuffix(percentile float64) (string, error) {
+	if math.IsNaN(percentile) || percentile <= 0 || percentile > 100 {
+		return "", fmt.Errorf("invalid percentile %v: must be greater than 0 and lower or equal to 100", percentile)
+	}
+	return ".p" + strings.Replace(strconv.FormatFloat(percentile, 'f', -1, 64), ".", "_", 1), nil
+}
+
+// nearestRankPercentile returns the smallest value such that at least percentile percent of the values are lower or
+// equal to it. values is sorted in place.
+func nearestRankPercentile(values []float64, percentile float64) float64 {
+	sort.Float64s(values)
+	rank := int(math.Ceil(percentile / 100 * float64(len(values))))
+	if rank < 1 {
+		rank = 1
+	}
+	return values[rank-1]
+}
+
+// HistogramPercentile computes a percentile of a histogram on the client instead of sending its samples: the values
+// of each name and tags are buffered and their percentile is sent as the gauge "<name>.p<percentile>" at the end of
+// each aggregation interval (ex: "latency.p95" for a percentile of 95). The percentile must be in (0, 100] and is
+// computed with the nearest rank method. Several percentiles of the same histogram are sent by calling
+// HistogramPercentile once for each of them.
+//
+// The values are sampled with the rate before being buffered, the gauge is sent without it. WithMaxSamplesPerContext
+// applies to the buffered values. HistogramPercentile requires the client side aggregation,
+// ErrPercentileWithoutAggregation is returned otherwise.
+func (c *Client) HistogramPercentile(name string, value float64, percentile float64, tags []string, rate float64) error {
+	if c == nil {
+		return ErrNoClient
+	}
+	if c.agg == nil {
+		return ErrPercentileWithoutAggregation
+	}
+	suffix, err := percentileSuffix(percentile)
+	if err != nil {
+		return err
+	}
+	tags = c.processTags(tags)
+	if err = c.validateMetric(name, tags); err != nil {
+		return err
+	}
+	rate = c.resolveRate(HistogramType, rate)
+	atomic.AddUint64(&c.telemetry.totalMetricsHistogram, 1)
+	return c.agg.histogramPercentile(name+suffix, value, tags, rate, percentile, c.resolveCardinality(nil))
+}
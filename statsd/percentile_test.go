@@ -0,0 +1,70 @@
+package statsd
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPercentileSuffix(t *testing.T) {
+	for percentile, expected := range map[float64]string{50: ".p50", 95: ".p95", 99.9: ".p99_9", 100: ".p100"} {
+		suffix, err := percentileSuffix(percentile)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, suffix)
+	}
+	for _, percentile := range []float64{0, -1, 100.5} {
+		_, err := percentileSuffix(percentile)
+		assert.Error(t, err)
+	}
+}
+
+func TestNearestRankPercentile(t *testing.T) {
+	values := make([]float64, 100)
+	for i, v := range rand.Perm(100) {
+		values[i] = float64(v + 1)
+	}
+	assert.Equal(t, 50.0, nearestRankPercentile(values, 50))
+	assert.Equal(t, 95.0, nearestRankPercentile(values, 95))
+	assert.Equal(t, 100.0, nearestRankPercentile(values, 100))
+	assert.Equal(t, 1.0, nearestRankPercentile(values, 0.1))
+
+	assert.Equal(t, 20.0, nearestRankPercentile([]float64{40, 20, 30, 10}, 50))
+	assert.Equal(t, 7.0, nearestRankPercentile([]float64{7}, 95))
+}
+
+func TestClientHistogramPercentile(t *testing.T) {
+	for _, options := range [][]Option{{}, {WithExtendedClientSideAggregation()}, {WithChannelMode()}} {
+		w := statsdWriterWrapper{}
+		client, err := NewWithWriter(&w, append(options, WithoutTelemetry(), WithTags([]string{"env:prod"}))...)
+		require.Nil(t, err)
+
+		for _, v := range rand.Perm(100) {
+			require.Nil(t, client.HistogramPercentile("latency", float64(v+1), 50, []string{"route:a", "team:core"}, 1))
+			require.Nil(t, client.HistogramPercentile("latency", float64(v+1), 95, []string{"route:a", "team:core"}, 1))
+		}
+		require.Nil(t, client.HistogramPercentile("latency", 3, 95, nil, 1))
+		client.Flush()
+
+		ts := &testServer{}
+		ts.assertMetric(t, w.data, []string{
+			"latency.p50:50|g|#env:prod,route:a,team:core",
+			"latency.p95:95|g|#env:prod,route:a,team:core",
+			"latency.p95:3|g|#env:prod",
+		})
+		client.Close()
+	}
+}
+
+func TestClientHistogramPercentileErrors(t *testing.T) {
+	client, err := NewWithWriter(&statsdWriterWrapper{}, WithoutTelemetry(), WithoutClientSideAggregation())
+	require.Nil(t, err)
+	defer client.Close()
+	assert.Equal(t, ErrPercentileWithoutAggregation, client.HistogramPercentile("latency", 1, 95, nil, 1))
+
+	client, err = NewWithWriter(&statsdWriterWrapper{}, WithoutTelemetry())
+	require.Nil(t, err)
+	defer client.Close()
+	assert.Error(t, client.HistogramPercentile("latency", 1, 0, nil, 1))
+}
@@ -4,10 +4,9 @@ package statsd
 
 import (
 	"errors"
-	"io"
 	"time"
 )
 
-func newWindowsPipeWriter(pipepath string, writeTimeout time.Duration) (io.WriteCloser, error) {
+func newWindowsPipeWriter(pipepath string, writeTimeout time.Duration) (Transport, error) {
 	return nil, errors.New("Windows Named Pipes are only supported on Windows")
 }
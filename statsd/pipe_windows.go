@@ -62,7 +62,12 @@ func (p *pipeWriter) ensureConnection() (net.Conn, error) {
 }
 
 func (p *pipeWriter) Close() error {
-	return p.conn.Close()
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.conn != nil {
+		return p.conn.Close()
+	}
+	return nil
 }
 
 func newWindowsPipeWriter(pipepath string, writeTimeout time.Duration) (*pipeWriter, error) {
@@ -131,3 +131,14 @@ func TestPipeWriterReconnect(t *testing.T) {
 	}
 	t.Fatal("failed to reconnect")
 }
+
+func TestPipeWriterCloseWithoutConnection(t *testing.T) {
+	pipepath, f, ln := createNamedPipe(t)
+	defer os.Remove(f.Name())
+	defer ln.Close()
+
+	// The connection is only established on the first write
+	writer, err := newWindowsPipeWriter(pipepath, time.Second)
+	require.Nil(t, err)
+	assert.Nil(t, writer.Close())
+}
@@ -0,0 +1,96 @@
+package statsd
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync/atomic"
+)
+
+// ErrPrecomputedHistogramsDisabled is returned by DistributionPrecomputed when the client was not created with
+// WithPrecomputedHistograms.
+var ErrPrecomputedHistogramsDisabled = errors.New("statsd precomputed histograms are disabled, see WithPrecomputedHistograms")
+
+// HistoStats holds the statistics of a distribution already computed by the application, see
+// Client.DistributionPrecomputed.
+type HistoStats struct {
+	Min   float64
+	Max   float64
+	Avg   float64
+	Sum   float64
+	Count int64
+	// Percentiles maps a percentile, between 1 and 99, to its value (ex: 95 to the p95). Percentiles absent from the
+	// map are not sent.
+	Percentiles map[int]float64
+}
+
+// precomputedStat is one of the metrics a HistoStats is sent as. The count keeps its value in ivalue so that it isn't
+// rounded by a float64 above 2^53.
+type precomputedStat struct {
+	suffix     string
+	metricType metricType
+	value      float64
+	ivalue     int64
+}
+
+// stats returns the metrics to send for s: the gauges "min", "max", "avg", "sum", one "<N>percentile" gauge per
+// percentile in increasing order and the count "count".
+func (s HistoStats) stats() ([]precomputedStat, error) {
+	percentiles := make([]int, 0, len(s.Percentiles))
+	for p := range s.Percentiles {
+		if p < 1 || p > 99 {
+			return nil, fmt.Errorf("invalid percentile %d: must be between 1 and 99", p)
+		}
+		percentiles = append(percentiles, p)
+	}
+	sort.Ints(percentiles)
+
+	stats := make([]precomputedStat, 0, 5+len(percentiles))
+	stats = append(stats,
+		precomputedStat{suffix: ".min", metricType: gauge, value: s.Min},
+		precomputedStat{suffix: ".max", metricType: gauge, value: s.Max},
+		precomputedStat{suffix: ".avg", metricType: gauge, value: s.Avg},
+		precomputedStat{suffix: ".sum", metricType: gauge, value: s.Sum},
+	)
+	for _, p := range percentiles {
+		stats = append(stats, precomputedStat{suffix: fmt.Sprintf(".%dpercentile", p), metricType: gauge, value: s.Percentiles[p]})
+	}
+	return append(stats, precomputedStat{suffix: ".count", metricType: count, ivalue: s.Count}), nil
+}
+
+// DistributionPrecomputed sends the statistics of a distribution computed by the application instead of its samples.
+// The client must be created with WithPrecomputedHistograms, ErrPrecomputedHistogramsDisabled is returned otherwise.
+//
+// DogStatsD has no message for pre-aggregated distributions: the stats are sent as separate metrics named after the
+// aggregates the Agent computes for histograms, "<name>.min", "<name>.max", "<name>.avg", "<name>.sum" and
+// "<name>.<N>percentile" gauges and a "<name>.count" count. They bypass the client side aggregation and sampling since
+// they are already aggregated. See WithPrecomputedHistograms for the limitations.
+func (c *Client) DistributionPrecomputed(name string, stats HistoStats, tags []string) error {
+	if c == nil {
+		return ErrNoClient
+	}
+	if !c.precomputed {
+		return ErrPrecomputedHistogramsDisabled
+	}
+	metrics, err := stats.stats()
+	if err != nil {
+		return err
+	}
+	tags = c.processTags(tags)
+	if err = c.validateMetric(name, tags); err != nil {
+		return err
+	}
+	for _, m := range metrics {
+		if m.metricType == count {
+			atomic.AddUint64(&c.telemetry.totalMetricsCount, 1)
+			err = c.send(metric{metricType: count, name: name + m.suffix, ivalue: m.ivalue, tags: tags, rate: 1, globalTags: c.getGlobalTags().metricTags, namespace: c.namespace})
+		} else {
+			atomic.AddUint64(&c.telemetry.totalMetricsGauge, 1)
+			err = c.send(metric{metricType: gauge, name: name + m.suffix, fvalue: m.value, tags: tags, rate: 1, globalTags: c.getGlobalTags().metricTags, namespace: c.namespace})
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package statsd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistoStats(t *testing.T) {
+	stats, err := HistoStats{Min: 1, Max: 3, Avg: 2, Sum: 6, Count: 3, Percentiles: map[int]float64{95: 2.9, 50: 2}}.stats()
+	require.Nil(t, err)
+	assert.Equal(t, []precomputedStat{
+		{suffix: ".min", metricType: gauge, value: 1},
+		{suffix: ".max", metricType: gauge, value: 3},
+		{suffix: ".avg", metricType: gauge, value: 2},
+		{suffix: ".sum", metricType: gauge, value: 6},
+		{suffix: ".50percentile", metricType: gauge, value: 2},
+		{suffix: ".95percentile", metricType: gauge, value: 2.9},
+		{suffix: ".count", metricType: count, ivalue: 3},
+	}, stats)
+
+	_, err = HistoStats{Percentiles: map[int]float64{0: 1}}.stats()
+	assert.NotNil(t, err)
+}
+
+func TestDistributionPrecomputedFormat(t *testing.T) {
+	var buf bytes.Buffer
+	client, err := NewWithWriter(&buf, WithoutTelemetry(), WithPrecomputedHistograms(), WithNamespace("ns"), WithTags([]string{"env:dev"}))
+	require.Nil(t, err)
+
+	stats := HistoStats{
+		Min:         1,
+		Max:         9.5,
+		Avg:         4.25,
+		Sum:         85,
+		Count:       20,
+		Percentiles: map[int]float64{99: 9.25, 50: 4, 95: 8.5},
+	}
+	require.Nil(t, client.DistributionPrecomputed("latency", stats, []string{"tag1"}))
+	require.Nil(t, client.Close())
+
+	ts := &testServer{}
+	ts.assertMetric(t, strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n"), []string{
+		"ns.latency.min:1|g|#env:dev,tag1",
+		"ns.latency.max:9.5|g|#env:dev,tag1",
+		"ns.latency.avg:4.25|g|#env:dev,tag1",
+		"ns.latency.sum:85|g|#env:dev,tag1",
+		"ns.latency.50percentile:4|g|#env:dev,tag1",
+		"ns.latency.95percentile:8.5|g|#env:dev,tag1",
+		"ns.latency.99percentile:9.25|g|#env:dev,tag1",
+		"ns.latency.count:20|c|#env:dev,tag1",
+	})
+}
+
+func TestDistributionPrecomputedWithoutPercentiles(t *testing.T) {
+	var buf bytes.Buffer
+	client, err := NewWithWriter(&buf, WithoutTelemetry(), WithPrecomputedHistograms())
+	require.Nil(t, err)
+
+	require.Nil(t, client.DistributionPrecomputed("latency", HistoStats{Min: 1, Max: 3, Avg: 2, Sum: 6, Count: 3}, nil))
+	require.Nil(t, client.Close())
+
+	ts := &testServer{}
+	ts.assertMetric(t, strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n"), []string{
+		"latency.min:1|g",
+		"latency.max:3|g",
+		"latency.avg:2|g",
+		"latency.sum:6|g",
+		"latency.count:3|c",
+	})
+}
+
+func TestDistributionPrecomputedLargeCount(t *testing.T) {
+	var buf bytes.Buffer
+	client, err := NewWithWriter(&buf, WithoutTelemetry(), WithPrecomputedHistograms())
+	require.Nil(t, err)
+
+	// 2^53 + 1 can't be represented by a float64
+	require.Nil(t, client.DistributionPrecomputed("latency", HistoStats{Count: 1<<53 + 1}, nil))
+	require.Nil(t, client.Close())
+	assert.Contains(t, buf.String(), "latency.count:9007199254740993|c\n")
+}
+
+func TestDistributionPrecomputedErrors(t *testing.T) {
+	var buf bytes.Buffer
+	client, err := NewWithWriter(&buf, WithoutTelemetry())
+	require.Nil(t, err)
+	assert.Equal(t, ErrPrecomputedHistogramsDisabled, client.DistributionPrecomputed("latency", HistoStats{}, nil))
+	require.Nil(t, client.Close())
+
+	client, err = NewWithWriter(&buf, WithoutTelemetry(), WithPrecomputedHistograms())
+	require.Nil(t, err)
+	err = client.DistributionPrecomputed("latency", HistoStats{Percentiles: map[int]float64{100: 1}}, nil)
+	assert.EqualError(t, err, "invalid percentile 100: must be between 1 and 99")
+	require.Nil(t, client.Close())
+	assert.Empty(t, buf.String())
+
+	var nilClient *Client
+	assert.Equal(t, ErrNoClient, nilClient.DistributionPrecomputed("latency", HistoStats{}, nil))
+}
@@ -0,0 +1,282 @@
+package statsd
+
+import (
+	"bytes"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// prometheusContentType is the content type of the Prometheus text exposition format.
+const prometheusContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// prometheusQuantiles are the quantiles rendered for each summary.
+var prometheusQuantiles = []float64{0.5, 0.9, 0.99}
+
+// prometheusSeries is one series of the exposition: the labels of a metric and its value, or values for summaries.
+type prometheusSeries struct {
+	labels string
+	value  float64
+	values []float64
+}
+
+// prometheusFamily groups the series of a metric name, which share a type.
+type prometheusFamily struct {
+	metricType string
+	series     map[string]*prometheusSeries
+}
+
+// prometheusExposition is a snapshot of the aggregator rendered in the Prometheus text format.
+type prometheusExposition struct {
+	families map[string]*prometheusFamily
+}
+
+// PrometheusHandler returns an http.Handler rendering the metrics currently aggregated by the client in the
+// Prometheus text exposition format, so they can be scraped in addition to being sent to the Agent.
+//
+// The handler reads the aggregator without flushing it. Counts are rendered as counters holding their total since
+// PrometheusHandler was first called: the client keeps the total of every count context from then on, so its memory
+// grows with the number of contexts. The other values are the ones aggregated since the last flush (see
+// WithAggregationInterval): gauges are rendered as gauges, sets as gauges of the number of distinct values,
+// HistogramPercentile as gauges of the percentile of the values held and histograms, distributions and timings as
+// summaries with the 0.5, 0.9 and 0.99 quantiles of the values held.
+//
+// Metric and label names are sanitized to the Prometheus charset by replacing invalid characters with '_'. Tags,
+// the global ones included, are rendered as labels: "key:value" gives key="value" and a tag without value gives a
+// label with an empty value. When a tag key is repeated only its first value is kept. Contexts ending up with the same
+// series (ex: only differing by cardinality or sample rate) are merged.
+//
+// Nothing is rendered when client side aggregation is disabled.
+func (c *Client) PrometheusHandler() http.Handler {
+	if c != nil && c.agg != nil {
+		c.agg.keepCountTotals()
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", prometheusContentType)
+		if c == nil || c.agg == nil {
+			return
+		}
+		w.Write(c.agg.prometheusExposition(c.namespace, c.getGlobalTags().metricTags).render())
+	})
+}
+
+// keepCountTotals makes the aggregator keep the total of the flushed counts, see countTotals.
+func (a *aggregator) keepCountTotals() {
+	a.countsM.Lock()
+	if a.countTotals == nil {
+		a.countTotals = countsMap{}
+	}
+	a.countsM.Unlock()
+}
+
+// prometheusExposition snapshots the aggregator contexts.
+func (a *aggregator) prometheusExposition(namespace string, globalTags []string) *prometheusExposition {
+	e := &prometheusExposition{families: map[string]*prometheusFamily{}}
+
+	a.countsM.RLock()
+	for _, m := range a.countTotals {
+		e.series(namespace+m.name, "counter", globalTags, m.tags).value += float64(m.value)
+	}
+	for _, m := range a.counts {
+		e.series(namespace+m.name, "counter", globalTags, m.tags).value += float64(atomic.LoadInt64(&m.value))
+	}
+	a.countsM.RUnlock()
+
+	a.gaugesM.RLock()
+	for _, m := range a.gauges {
+		e.series(namespace+m.name, "gauge", globalTags, m.tags).value = math.Float64frombits(atomic.LoadUint64(&m.value))
+	}
+	a.gaugesM.RUnlock()
+
+	a.setsM.RLock()
+	for _, m := range a.sets {
+		m.Lock()
+		e.series(namespace+m.name, "gauge", globalTags, m.tags).value += float64(len(m.data))
+		m.Unlock()
+	}
+	a.setsM.RUnlock()
+
+	a.percentiles.mutex.RLock()
+	for _, m := range a.percentiles.values {
+		var tags []string
+		if m.tags != "" {
+			tags = strings.Split(m.tags, tagSeparatorSymbol)
+		}
+		m.Lock()
+		if len(m.data) > 0 {
+			e.series(namespace+m.name, "gauge", globalTags, tags).value = nearestRankPercentile(m.data, m.percentile)
+		}
+		m.Unlock()
+	}
+	a.percentiles.mutex.RUnlock()
+
+	for _, bc := range []*bufferedMetricContexts{&a.histograms, &a.distributions, &a.timings} {
+		bc.mutex.RLock()
+		for _, m := range bc.values {
+			var tags []string
+			if m.tags != "" {
+				tags = strings.Split(m.tags, tagSeparatorSymbol)
+			}
+			m.Lock()
+			s := e.series(namespace+m.name, "summary", globalTags, tags)
+			s.values = append(s.values, m.data...)
+			m.Unlock()
+		}
+		bc.mutex.RUnlock()
+	}
+	return e
+}
+
+// series returns the series of name with the given tags, creating it if needed. A name already used with another
+// type keeps its first type.
+func (e *prometheusExposition) series(name string, metricType string, globalTags []string, tags []string) *prometheusSeries {
+	name = prometheusName(name)
+	f, found := e.families[name]
+	if !found {
+		f = &prometheusFamily{metricType: metricType, series: map[string]*prometheusSeries{}}
+		e.families[name] = f
+	}
+	labels := prometheusLabels(globalTags, tags)
+	s, found := f.series[labels]
+	if !found {
+		s = &prometheusSeries{labels: labels}
+		f.series[labels] = s
+	}
+	return s
+}
+
+// render returns the exposition in the Prometheus text format, sorted by metric name and labels.
+func (e *prometheusExposition) render() []byte {
+	names := make([]string, 0, len(e.families))
+	for name := range e.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b bytes.Buffer
+	for _, name := range names {
+		f := e.families[name]
+		b.WriteString("# TYPE " + name + " " + f.metricType + "\n")
+
+		keys := make([]string, 0, len(f.series))
+		for labels := range f.series {
+			keys = append(keys, labels)
+		}
+		sort.Strings(keys)
+
+		for _, labels := range keys {
+			s := f.series[labels]
+			if f.metricType != "summary" {
+				writePrometheusSample(&b, name, labels, "", s.value)
+				continue
+			}
+
+			sort.Float64s(s.values)
+			sum := 0.0
+			for _, v := range s.values {
+				sum += v
+			}
+			for _, q := range prometheusQuantiles {
+				quantile := `quantile="` + strconv.FormatFloat(q, 'g', -1, 64) + `"`
+				writePrometheusSample(&b, name, labels, quantile, prometheusQuantile(s.values, q))
+			}
+			writePrometheusSample(&b, name+"_sum", labels, "", sum)
+			writePrometheusSample(&b, name+"_count", labels, "", float64(len(s.values)))
+		}
+	}
+	return b.Bytes()
+}
+
+func writePrometheusSample(b *bytes.Buffer, name string, labels string, extraLabel string, value float64) {
+	b.WriteString(name)
+	if labels != "" || extraLabel != "" {
+		b.WriteByte('{')
+		b.WriteString(labels)
+		if labels != "" && extraLabel != "" {
+			b.WriteByte(',')
+		}
+		b.WriteString(extraLabel)
+		b.WriteByte('}')
+	}
+	b.WriteByte(' ')
+	b.WriteString(strconv.FormatFloat(value, 'g', -1, 64))
+	b.WriteByte('\n')
+}
+
+// prometheusQuantile returns the nearest rank q quantile of the sorted values, NaN when there are none.
+func prometheusQuantile(sorted []float64, q float64) float64 {
+	if len(sorted) == 0 {
+		return math.NaN()
+	}
+	rank := int(math.Ceil(q*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	return sorted[rank]
+}
+
+// prometheusLabels renders the tags as labels sorted by name, keeping the first value of each name.
+func prometheusLabels(globalTags []string, tags []string) string {
+	if len(globalTags) == 0 && len(tags) == 0 {
+		return ""
+	}
+	labels := map[string]string{}
+	names := make([]string, 0, len(globalTags)+len(tags))
+	for _, list := range [][]string{globalTags, tags} {
+		for _, tag := range list {
+			name, value := tag, ""
+			if i := strings.IndexByte(tag, ':'); i >= 0 {
+				name, value = tag[:i], tag[i+1:]
+			}
+			name = prometheusLabelName(name)
+			if _, found := labels[name]; found {
+				continue
+			}
+			labels[name] = value
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(name)
+		b.WriteString(`="`)
+		b.WriteString(prometheusLabelValueReplacer.Replace(labels[name]))
+		b.WriteByte('"')
+	}
+	return b.String()
+}
+
+var prometheusLabelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// prometheusName replaces the characters not allowed in Prometheus metric names ([a-zA-Z_:][a-zA-Z0-9_:]*) with '_'.
+func prometheusName(name string) string {
+	return sanitizePrometheus(name, true)
+}
+
+// prometheusLabelName replaces the characters not allowed in Prometheus label names ([a-zA-Z_][a-zA-Z0-9_]*) with '_'.
+func prometheusLabelName(name string) string {
+	return sanitizePrometheus(name, false)
+}
+
+func sanitizePrometheus(s string, allowColon bool) string {
+	if s == "" {
+		return "_"
+	}
+	b := []byte(s)
+	for i, r := range b {
+		valid := r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') ||
+			(i > 0 && r >= '0' && r <= '9') || (allowColon && r == ':')
+		if !valid {
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
@@ -0,0 +1,129 @@
+package statsd
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrometheusExposition(t *testing.T) {
+	a := newAggregator(nil, 0, MaxSamplesFlush)
+
+	a.count("requests", 2, []string{"env:prod", "http.status:200"}, CardinalityNotSet)
+	a.count("requests", 3, []string{"env:prod", "http.status:200"}, CardinalityNotSet)
+	// only differs by cardinality: merged into the same series
+	a.count("requests", 1, []string{"env:prod", "http.status:200"}, CardinalityHigh)
+	a.count("requests", 4, []string{"env:prod", "http.status:500"}, CardinalityNotSet)
+	a.gauge("queue.size", 21, nil, CardinalityNotSet)
+	a.gauge("queue.size", 12, nil, CardinalityNotSet)
+	a.set("users", "a", []string{"env:prod"}, CardinalityNotSet)
+	a.set("users", "b", []string{"env:prod"}, CardinalityNotSet)
+	a.set("users", "a", []string{"env:prod"}, CardinalityNotSet)
+	for i := 1; i <= 10; i++ {
+		a.histogram("latency", float64(i), []string{"env:prod", "quote:a\"b"}, 1, CardinalityNotSet)
+	}
+	a.timing("db.query", 2, []string{"flag"}, 1, CardinalityNotSet)
+
+	expected := `# TYPE namespace_db_query summary
+namespace_db_query{flag="",service="api",quantile="0.5"} 2
+namespace_db_query{flag="",service="api",quantile="0.9"} 2
+namespace_db_query{flag="",service="api",quantile="0.99"} 2
+namespace_db_query_sum{flag="",service="api"} 2
+namespace_db_query_count{flag="",service="api"} 1
+# TYPE namespace_latency summary
+namespace_latency{env="prod",quote="a\"b",service="api",quantile="0.5"} 5
+namespace_latency{env="prod",quote="a\"b",service="api",quantile="0.9"} 9
+namespace_latency{env="prod",quote="a\"b",service="api",quantile="0.99"} 10
+namespace_latency_sum{env="prod",quote="a\"b",service="api"} 55
+namespace_latency_count{env="prod",quote="a\"b",service="api"} 10
+# TYPE namespace_queue_size gauge
+namespace_queue_size{service="api"} 12
+# TYPE namespace_requests counter
+namespace_requests{env="prod",http_status="200",service="api"} 6
+namespace_requests{env="prod",http_status="500",service="api"} 4
+# TYPE namespace_users gauge
+namespace_users{env="prod",service="api"} 2
+`
+	assert.Equal(t, expected, string(a.prometheusExposition("namespace.", []string{"service:api"}).render()))
+	assert.Equal(t, "", string(newAggregator(nil, 0, MaxSamplesFlush).prometheusExposition("", nil).render()))
+}
+
+func TestPrometheusLabels(t *testing.T) {
+	assert.Equal(t, "", prometheusLabels(nil, nil))
+	// the first value of a repeated key is kept, the global tags coming first
+	assert.Equal(t, `env="prod",host="a"`, prometheusLabels([]string{"env:prod"}, []string{"host:a", "env:dev"}))
+	assert.Equal(t, `url="http://a\\b"`, prometheusLabels(nil, []string{`url:http://a\b`}))
+	assert.Equal(t, `_x_y=""`, prometheusLabels(nil, []string{"9x-y"}))
+
+	assert.Equal(t, "a:b_c", prometheusName("a:b.c"))
+	assert.Equal(t, "_xx", prometheusName("1xx"))
+}
+
+func TestClientPrometheusHandler(t *testing.T) {
+	client, err := New("localhost:8125", WithoutTelemetry(), WithTags([]string{"env:prod"}), WithNamespace("app."))
+	require.Nil(t, err)
+	defer client.Close()
+
+	client.Count("hits", 3, []string{"page:home"}, 1)
+	client.Gauge("temp", 20.5, nil, 1)
+
+	recorder := httptest.NewRecorder()
+	client.PrometheusHandler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+
+	body, err := ioutil.ReadAll(recorder.Body)
+	require.Nil(t, err)
+	assert.Equal(t, prometheusContentType, recorder.Header().Get("Content-Type"))
+	assert.Equal(t, `# TYPE app_hits counter
+app_hits{env="prod",page="home"} 3
+# TYPE app_temp gauge
+app_temp{env="prod"} 20.5
+`, string(body))
+}
+
+func TestClientPrometheusHandlerWithoutAggregation(t *testing.T) {
+	client, err := New("localhost:8125", WithoutTelemetry(), WithoutClientSideAggregation())
+	require.Nil(t, err)
+	defer client.Close()
+
+	client.Count("hits", 3, nil, 1)
+
+	recorder := httptest.NewRecorder()
+	client.PrometheusHandler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(t, 0, recorder.Body.Len())
+}
+
+func TestPrometheusCountersAcrossFlushes(t *testing.T) {
+	a := newAggregator(nil, 0, MaxSamplesFlush)
+	a.count("before", 5, nil, CardinalityNotSet)
+	a.flushMetrics()
+
+	// only the counts flushed once the totals are kept are part of them
+	a.keepCountTotals()
+	a.count("requests", 2, []string{"env:prod"}, CardinalityNotSet)
+	a.countWithTimestamp("requests", 1, []string{"env:prod"}, 1700000000, CardinalityNotSet)
+	a.flushMetrics()
+	a.count("requests", 3, []string{"env:prod"}, CardinalityNotSet)
+
+	expected := `# TYPE requests counter
+requests{env="prod"} 6
+`
+	assert.Equal(t, expected, string(a.prometheusExposition("", nil).render()))
+
+	a.flushMetrics()
+	assert.Equal(t, expected, string(a.prometheusExposition("", nil).render()))
+	assert.Len(t, a.countTotals, 1)
+}
+
+func TestPrometheusPercentiles(t *testing.T) {
+	a := newAggregator(nil, 0, MaxSamplesFlush)
+	for i := 1; i <= 10; i++ {
+		a.histogramPercentile("latency.p90", float64(i), []string{"env:prod"}, 1, 90, CardinalityNotSet)
+	}
+
+	assert.Equal(t, `# TYPE latency_p90 gauge
+latency_p90{env="prod"} 9
+`, string(a.prometheusExposition("", nil).render()))
+}
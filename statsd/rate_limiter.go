@@ -0,0 +1,39 @@
+package statsd
+
+import "time"
+
+// rateLimiter is a token bucket allowing perSecond payloads every second, see WithRateLimit. The bucket holds up to
+// perSecond tokens so up to a second worth of payloads can be sent in a burst. It is only used by the sender goroutine.
+type rateLimiter struct {
+	perSecond float64
+	tokens    float64
+	last      time.Time
+	now       func() time.Time
+}
+
+func newRateLimiter(perSecond int, now func() time.Time) *rateLimiter {
+	return &rateLimiter{
+		perSecond: float64(perSecond),
+		tokens:    float64(perSecond),
+		last:      now(),
+		now:       now,
+	}
+}
+
+// allow takes a token from the bucket and returns true, or returns false if the bucket is empty. It never blocks.
+func (l *rateLimiter) allow() bool {
+	now := l.now()
+	if elapsed := now.Sub(l.last); elapsed > 0 {
+		l.tokens += elapsed.Seconds() * l.perSecond
+		if l.tokens > l.perSecond {
+			l.tokens = l.perSecond
+		}
+	}
+	l.last = now
+
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+	return true
+}
@@ -0,0 +1,31 @@
+package statsd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Unix(1658934956, 0)
+	l := newRateLimiter(10, func() time.Time { return now })
+
+	// a full second worth of payloads is allowed in a burst
+	for i := 0; i < 10; i++ {
+		assert.True(t, l.allow())
+	}
+	assert.False(t, l.allow())
+
+	// tokens are refilled continuously
+	now = now.Add(100 * time.Millisecond)
+	assert.True(t, l.allow())
+	assert.False(t, l.allow())
+
+	// the bucket never holds more than a second worth of tokens
+	now = now.Add(time.Hour)
+	for i := 0; i < 10; i++ {
+		assert.True(t, l.allow())
+	}
+	assert.False(t, l.allow())
+}
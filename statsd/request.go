@@ -0,0 +1,54 @@
+package statsd
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Request instruments a request that took d: it sends the count "<name>.count" of 1 and the timing "<name>.latency"
+// of d with the same tags. The tags are processed once for both metrics.
+//
+// The rate is applied once: either both metrics are sent or none of them. With a rate lower than 1 the metrics are sent
+// along with the rate for the Agent to upscale them, bypassing the client side aggregation which would count the
+// sampled requests only. With a rate of 1 the metrics are aggregated like the ones of Count and Timing.
+func (c *Client) Request(name string, d time.Duration, tags []string, rate float64) error {
+	if c == nil {
+		return ErrNoClient
+	}
+	tags = c.processTags(tags)
+	if err := c.validateMetric(name, tags); err != nil {
+		return err
+	}
+	rate = c.resolveRate(TimingType, rate)
+	cardinality := c.resolveCardinality(nil)
+	countName, latencyName := name+".count", name+".latency"
+	latency := d.Seconds() * 1000
+	atomic.AddUint64(&c.telemetry.totalMetricsCount, 1)
+	atomic.AddUint64(&c.telemetry.totalMetricsTiming, 1)
+
+	if rate < 1 {
+		w := c.workers[hashString32(name)%uint32(len(c.workers))]
+		if !sampleWith(w.sampler, name, tags, rate, w.random, &w.randomLock) {
+			return nil
+		}
+		globalTags := c.getGlobalTags().metricTags
+		if err := c.writeMetric(metric{metricType: count, name: countName, ivalue: 1, tags: tags, rate: rate, globalTags: globalTags, namespace: c.namespace, cardinality: cardinality}); err != nil {
+			return err
+		}
+		return c.writeMetric(metric{metricType: timing, name: latencyName, fvalue: latency, tags: tags, rate: rate, globalTags: globalTags, namespace: c.namespace, cardinality: cardinality})
+	}
+
+	var err error
+	if c.agg != nil {
+		err = c.agg.count(countName, 1, tags, cardinality)
+	} else {
+		err = c.send(metric{metricType: count, name: countName, ivalue: 1, tags: tags, rate: rate, globalTags: c.getGlobalTags().metricTags, namespace: c.namespace, cardinality: cardinality})
+	}
+	if err != nil {
+		return err
+	}
+	if c.aggTiming != nil {
+		return c.sendToAggregator(timing, latencyName, latency, tags, rate, cardinality, c.aggTiming.timing)
+	}
+	return c.send(metric{metricType: timing, name: latencyName, fvalue: latency, tags: tags, rate: rate, globalTags: c.getGlobalTags().metricTags, namespace: c.namespace, cardinality: cardinality})
+}
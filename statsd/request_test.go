@@ -0,0 +1,61 @@
+package statsd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientRequest(t *testing.T) {
+	for _, options := range [][]Option{{}, {WithExtendedClientSideAggregation()}, {WithoutClientSideAggregation()}, {WithChannelMode()}} {
+		w := statsdWriterWrapper{}
+		client, err := NewWithWriter(&w, append(options, WithoutTelemetry(), WithTags([]string{"env:prod"}))...)
+		require.Nil(t, err)
+
+		require.Nil(t, client.Request("http", 1500*time.Millisecond, []string{"route:/users", "code:200"}, 1))
+		require.Nil(t, client.Flush())
+
+		ts := &testServer{}
+		ts.assertMetric(t, w.data, []string{
+			"http.count:1|c|#env:prod,route:/users,code:200",
+			"http.latency:1500.000000|ms|#env:prod,route:/users,code:200",
+		})
+		client.Close()
+		assert.Equal(t, uint64(1), client.GetTelemetry().TotalMetricsCount)
+		assert.Equal(t, uint64(1), client.GetTelemetry().TotalMetricsTiming)
+	}
+}
+
+func TestClientRequestSampled(t *testing.T) {
+	w := statsdWriterWrapper{}
+	sampler := &recordingSampler{}
+	client, err := NewWithWriter(&w, WithoutTelemetry(), WithSampler(sampler))
+	require.Nil(t, err)
+
+	require.Nil(t, client.Request("http", time.Second, []string{"route:/users"}, 0.5))
+	require.Nil(t, client.Request("dropped", time.Second, nil, 0.5))
+	client.Close()
+
+	// a single sampling decision is made for both metrics, which are sent with the rate
+	assert.Equal(t, []string{"http [route:/users] 0.5", "dropped [] 0.5"}, sampler.calls)
+	ts := &testServer{}
+	ts.assertMetric(t, w.data, []string{
+		"http.count:1|c|@0.5|#route:/users",
+		"http.latency:1000.000000|ms|@0.5|#route:/users",
+	})
+}
+
+func TestClientRequestInvalid(t *testing.T) {
+	w := statsdWriterWrapper{}
+	client, err := NewWithWriter(&w, WithoutTelemetry(), WithStrictNameValidation())
+	require.Nil(t, err)
+
+	assert.Error(t, client.Request("bad name", time.Second, nil, 1))
+	client.Close()
+	assert.Empty(t, w.data)
+
+	var nilClient *Client
+	assert.Equal(t, ErrNoClient, nilClient.Request("http", time.Second, nil, 1))
+}
@@ -0,0 +1,35 @@
+package statsd
+
+import "math"
+
+// Sampler decides which values are kept when a metric is submitted with a rate lower than 1, see WithSampler.
+// Implementations must be safe for concurrent use as they are called from every goroutine submitting metrics.
+type Sampler interface {
+	// ShouldSample returns true if the value of the metric must be sent. It is only called for rates lower than 1, the
+	// values with a higher rate are always sent. The tags are the ones given to the call, without the global tags.
+	ShouldSample(name string, tags []string, rate float64) bool
+}
+
+// HashSampler is a Sampler keeping or dropping every value of a series consistently: the decision is made from a hash
+// of the name and tags instead of a random number. With a rate of 0.1, about 10% of the series are sent with all their
+// values while the other ones are never sent.
+//
+// The order of the tags matters: the same tags given in a different order are considered to be another series.
+type HashSampler struct{}
+
+// ShouldSample returns true if the hash of the name and tags falls within the rate.
+func (HashSampler) ShouldSample(name string, tags []string, rate float64) bool {
+	h := hashString32(name)
+	for _, tag := range tags {
+		h = addString32(h, tagSeparatorSymbol)
+		h = addString32(h, tag)
+	}
+	// FNV-1a doesn't spread well the last bytes of similar series (ex: "host:1" and "host:2"), mix the bits with the
+	// murmur3 finalizer to get a uniform value.
+	h ^= h >> 16
+	h *= 0x85ebca6b
+	h ^= h >> 13
+	h *= 0xc2b2ae35
+	h ^= h >> 16
+	return float64(h) < rate*(math.MaxUint32+1)
+}
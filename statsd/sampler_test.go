@@ -0,0 +1,139 @@
+package statsd
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHashSamplerDeterministic(t *testing.T) {
+	s := HashSampler{}
+	for i := 0; i < 100; i++ {
+		tags := []string{"env:prod", fmt.Sprintf("host:%d", i)}
+		expected := s.ShouldSample("metric", tags, 0.5)
+		for j := 0; j < 10; j++ {
+			assert.Equal(t, expected, s.ShouldSample("metric", tags, 0.5))
+		}
+		// a series kept at a rate is kept at any higher rate
+		if expected {
+			assert.True(t, s.ShouldSample("metric", tags, 0.75))
+		}
+	}
+}
+
+func TestHashSamplerDistribution(t *testing.T) {
+	s := HashSampler{}
+	for _, rate := range []float64{0.01, 0.1, 0.5, 0.9} {
+		kept := 0
+		for i := 0; i < 100000; i++ {
+			if s.ShouldSample("metric", []string{fmt.Sprintf("host:%d", i)}, rate) {
+				kept++
+			}
+		}
+		assert.InDelta(t, rate, float64(kept)/100000, 0.01, "rate %v", rate)
+	}
+}
+
+func TestHashSamplerJoinedTags(t *testing.T) {
+	s := HashSampler{}
+	for i := 0; i < 100; i++ {
+		tags := []string{"env:prod", fmt.Sprintf("host:%d", i)}
+		assert.Equal(t, s.ShouldSample("metric", tags, 0.5), s.ShouldSample("metric", []string{"env:prod," + tags[1]}, 0.5))
+	}
+}
+
+type recordingSampler struct {
+	sync.Mutex
+	calls []string
+}
+
+func (s *recordingSampler) ShouldSample(name string, tags []string, rate float64) bool {
+	s.Lock()
+	defer s.Unlock()
+	s.calls = append(s.calls, fmt.Sprintf("%s %v %v", name, tags, rate))
+	return name != "dropped"
+}
+
+func TestClientSampler(t *testing.T) {
+	w := statsdWriterWrapper{}
+	sampler := &recordingSampler{}
+	client, err := NewWithWriter(&w, WithoutTelemetry(), WithExtendedClientSideAggregation(), WithSampler(sampler))
+	require.Nil(t, err)
+
+	client.Gauge("gauge", 1, []string{"tag1"}, 0.5)
+	client.Histogram("histogram", 1, []string{"tag1"}, 0.5)
+	client.Histogram("dropped", 1, []string{"tag1"}, 0.5)
+	// the sampler is given the tags of the packed values one by one
+	client.DistributionSamples("distribution", []float64{1, 2}, []string{"tag1", "tag2"}, 0.5)
+	client.HistogramCounts("histogram_counts", map[float64]int{1: 2}, []string{"tag1", "tag2"}, 0.5)
+	// values with a rate of 1 are always sent without asking the sampler
+	client.Histogram("histogram", 2, nil, 1)
+	client.Close()
+
+	assert.ElementsMatch(t, []string{
+		"histogram [tag1] 0.5",
+		"dropped [tag1] 0.5",
+		"distribution [tag1 tag2] 0.5",
+		"histogram_counts [tag1 tag2] 0.5",
+	}, sampler.calls)
+
+	ts := &testServer{}
+	ts.assertMetric(t, w.data, []string{
+		"gauge:1|g|#tag1",
+		"histogram:1|h|@0.5|#tag1",
+		"histogram:2|h",
+		"distribution:1:2|d|@0.5|#tag1,tag2",
+		"histogram_counts:1:1|h|@0.5|#tag1,tag2",
+	})
+}
+
+func TestClientSampleRNGSeed(t *testing.T) {
+	// the first 10 draws of a source seeded with 42 keep the values 0, 1, 3, 4, 5, 7 and 8 with a rate of 0.5
+	w := statsdWriterWrapper{}
+	client, err := NewWithWriter(&w, WithoutTelemetry(), WithoutClientSideAggregation(), WithSampleRNGSeed(42))
+	require.Nil(t, err)
+	for i := 0; i < 10; i++ {
+		client.Count("count", int64(i), nil, 0.5)
+	}
+	client.Close()
+
+	ts := &testServer{}
+	ts.assertMetric(t, w.data, []string{
+		"count:0|c|@0.5",
+		"count:1|c|@0.5",
+		"count:3|c|@0.5",
+		"count:4|c|@0.5",
+		"count:5|c|@0.5",
+		"count:7|c|@0.5",
+		"count:8|c|@0.5",
+	})
+
+	// the aggregator sources are seeded the same way
+	w = statsdWriterWrapper{}
+	client, err = NewWithWriter(&w, WithoutTelemetry(), WithExtendedClientSideAggregation(), WithSampleRNGSeed(42))
+	require.Nil(t, err)
+	for i := 0; i < 10; i++ {
+		client.Histogram("histogram", float64(i), nil, 0.5)
+	}
+	client.Close()
+
+	ts.assertMetric(t, w.data, []string{"histogram:0:1:3:4:5:7:8|h|@0.5"})
+}
+
+func TestWorkerHashSampler(t *testing.T) {
+	w := newWorker(newBufferPool(1, 1024, 1), nil)
+	w.sampler = HashSampler{}
+
+	expected := HashSampler{}.ShouldSample("test", []string{"host:1"}, 0.5)
+	for i := 0; i < 20; i++ {
+		w.processMetric(metric{metricType: gauge, name: "test", fvalue: 1, tags: []string{"host:1"}, rate: 0.5})
+	}
+	if expected {
+		assert.NotEmpty(t, w.buffer.bytes())
+	} else {
+		assert.Empty(t, w.buffer.bytes())
+	}
+}
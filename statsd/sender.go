@@ -1,8 +1,12 @@
 package statsd
 
 import (
-	"io"
+	"context"
+	"errors"
+	"net"
+	"sync"
 	"sync/atomic"
+	"time"
 )
 
 // senderTelemetry contains telemetry about the health of the sender
@@ -10,21 +14,59 @@ type senderTelemetry struct {
 	totalPayloadsSent             uint64
 	totalPayloadsDroppedQueueFull uint64
 	totalPayloadsDroppedWriter    uint64
+	totalPayloadsDroppedTimeout   uint64
 	totalBytesSent                uint64
 	totalBytesDroppedQueueFull    uint64
 	totalBytesDroppedWriter       uint64
+	totalPayloadsDroppedRateLimit uint64
+	totalBytesDroppedRateLimit    uint64
+	// totalMessagesDropped is the number of messages held by the payloads dropped, see Client.DroppedMetrics.
+	totalMessagesDropped uint64
+}
+
+// endpoint is an additional transport every payload is written to, see WithAdditionalAddresses. Its failures are
+// counted independently from the ones of the main transport.
+type endpoint struct {
+	addr                    string
+	transport               Transport
+	totalPayloadsSent       uint64
+	totalPayloadsDropped    uint64
+	totalBytesSent          uint64
+	totalBytesDroppedWriter uint64
 }
 
 type sender struct {
-	transport   io.WriteCloser
+	// queuedBytes is first to be 64-bit aligned for atomic operations on 32-bit platforms.
+	queuedBytes   uint64
+	maxQueueBytes uint64
+
+	transport   Transport
 	pool        *bufferPool
 	queue       chan *statsdBuffer
 	telemetry   *senderTelemetry
 	stop        chan struct{}
 	flushSignal chan struct{}
+	drainSignal chan chan struct{}
+
+	errorReporter *errorReporter
+	compressor    *payloadCompressor
+	endpoints     []*endpoint
+	rateLimiter   *rateLimiter
+	// outage keeps the payloads that could not be written to replay them later, see WithOutageBuffer.
+	outage *outageBuffer
+
+	// metricsPerPayload counts the payloads sent by number of messages they hold since the last telemetry flush,
+	// see flushMetricsPerPayload.
+	metricsPerPayload  map[int]uint64
+	metricsPerPayloadM sync.Mutex
+
+	// synchronous is set by newSynchronousSender: buffers are written by the goroutine sending them, one at a time
+	// since the compressor and the rate limiter are not safe for concurrent use.
+	synchronous bool
+	writeMutex  sync.Mutex
 }
 
-func newSender(transport io.WriteCloser, queueSize int, pool *bufferPool) *sender {
+func newSender(transport Transport, queueSize int, pool *bufferPool) *sender {
 	sender := &sender{
 		transport:   transport,
 		pool:        pool,
@@ -32,50 +74,249 @@ func newSender(transport io.WriteCloser, queueSize int, pool *bufferPool) *sende
 		telemetry:   &senderTelemetry{},
 		stop:        make(chan struct{}),
 		flushSignal: make(chan struct{}),
+		drainSignal: make(chan chan struct{}),
 	}
 
 	go sender.sendLoop()
 	return sender
 }
 
+// newSynchronousSender returns a sender without queue nor goroutine, writing each buffer to the transport before send
+// returns, see WithSynchronousMode.
+func newSynchronousSender(transport Transport, pool *bufferPool) *sender {
+	return &sender{
+		transport:   transport,
+		pool:        pool,
+		telemetry:   &senderTelemetry{},
+		synchronous: true,
+	}
+}
+
 func (s *sender) send(buffer *statsdBuffer) {
+	s.countMetricsPerPayload(buffer.elementCount)
+	if s.synchronous {
+		s.writeMutex.Lock()
+		s.write(buffer)
+		s.writeMutex.Unlock()
+		return
+	}
+	if s.maxQueueBytes != 0 && atomic.AddUint64(&s.queuedBytes, uint64(len(buffer.bytes()))) > s.maxQueueBytes {
+		s.dropQueueFull(buffer)
+		return
+	}
 	select {
 	case s.queue <- buffer:
 	default:
-		atomic.AddUint64(&s.telemetry.totalPayloadsDroppedQueueFull, 1)
-		atomic.AddUint64(&s.telemetry.totalBytesDroppedQueueFull, uint64(len(buffer.bytes())))
-		s.pool.returnBuffer(buffer)
+		s.dropQueueFull(buffer)
 	}
 }
 
+// countMetricsPerPayload records the number of messages of a payload for the "metrics_per_payload" telemetry.
+func (s *sender) countMetricsPerPayload(count int) {
+	s.metricsPerPayloadM.Lock()
+	if s.metricsPerPayload == nil {
+		s.metricsPerPayload = map[int]uint64{}
+	}
+	s.metricsPerPayload[count]++
+	s.metricsPerPayloadM.Unlock()
+}
+
+// flushMetricsPerPayload returns the number of payloads sent for each number of messages per payload since the
+// previous call.
+func (s *sender) flushMetricsPerPayload() map[int]uint64 {
+	s.metricsPerPayloadM.Lock()
+	counts := s.metricsPerPayload
+	s.metricsPerPayload = nil
+	s.metricsPerPayloadM.Unlock()
+	return counts
+}
+
+// dropQueueFull drops a buffer that could not be queued, either because the queue is full or because its bytes would
+// exceed WithMaxQueueBytes.
+func (s *sender) dropQueueFull(buffer *statsdBuffer) {
+	atomic.AddUint64(&s.telemetry.totalPayloadsDroppedQueueFull, 1)
+	atomic.AddUint64(&s.telemetry.totalBytesDroppedQueueFull, uint64(len(buffer.bytes())))
+	atomic.AddUint64(&s.telemetry.totalMessagesDropped, uint64(buffer.elementCount))
+	s.errorReporter.report("", ErrQueueFull, nil)
+	s.returnBuffer(buffer)
+}
+
+// returnBuffer releases the bytes of buffer from the queue budget and returns it to the pool.
+func (s *sender) returnBuffer(buffer *statsdBuffer) {
+	if s.maxQueueBytes != 0 {
+		atomic.AddUint64(&s.queuedBytes, ^uint64(len(buffer.bytes())-1))
+	}
+	s.pool.returnBuffer(buffer)
+}
+
 func (s *sender) write(buffer *statsdBuffer) {
-	_, err := s.transport.Write(buffer.bytes())
+	if s.rateLimiter != nil && !s.rateLimiter.allow() {
+		atomic.AddUint64(&s.telemetry.totalPayloadsDroppedRateLimit, 1)
+		atomic.AddUint64(&s.telemetry.totalBytesDroppedRateLimit, uint64(len(buffer.bytes())))
+		atomic.AddUint64(&s.telemetry.totalMessagesDropped, uint64(buffer.elementCount))
+		s.errorReporter.report("", ErrRateLimited, nil)
+		s.returnBuffer(buffer)
+		return
+	}
+
+	payload := buffer.bytes()
+	var err error
+	if s.compressor != nil {
+		payload, err = s.compressor.compress(payload)
+	}
+	if err == nil && s.outage != nil {
+		s.writeWithOutage(payload, len(buffer.bytes()), buffer.elementCount)
+		s.writeEndpoints(payload, len(buffer.bytes()))
+		s.returnBuffer(buffer)
+		return
+	}
+	if err == nil {
+		_, err = s.transport.Write(payload)
+		s.writeEndpoints(payload, len(buffer.bytes()))
+	}
 	if err != nil {
-		atomic.AddUint64(&s.telemetry.totalPayloadsDroppedWriter, 1)
-		atomic.AddUint64(&s.telemetry.totalBytesDroppedWriter, uint64(len(buffer.bytes())))
+		s.dropWriteFailed(len(buffer.bytes()), buffer.elementCount, err)
 	} else {
-		atomic.AddUint64(&s.telemetry.totalPayloadsSent, 1)
-		atomic.AddUint64(&s.telemetry.totalBytesSent, uint64(len(buffer.bytes())))
+		s.countSent(len(buffer.bytes()))
+	}
+	s.returnBuffer(buffer)
+}
+
+func (s *sender) countSent(size int) {
+	atomic.AddUint64(&s.telemetry.totalPayloadsSent, 1)
+	atomic.AddUint64(&s.telemetry.totalBytesSent, uint64(size))
+}
+
+// dropWriteFailed counts and reports a payload of size bytes holding messages messages dropped because the transport
+// returned err.
+func (s *sender) dropWriteFailed(size int, messages int, err error) {
+	atomic.AddUint64(&s.telemetry.totalPayloadsDroppedWriter, 1)
+	atomic.AddUint64(&s.telemetry.totalBytesDroppedWriter, uint64(size))
+	atomic.AddUint64(&s.telemetry.totalMessagesDropped, uint64(messages))
+	if isTimeout(err) {
+		atomic.AddUint64(&s.telemetry.totalPayloadsDroppedTimeout, 1)
+	}
+	s.errorReporter.report("", ErrWriteFailed, err)
+}
+
+// writeWithOutage writes a payload when using WithOutageBuffer: the payloads kept during an outage are replayed first
+// so the Agent receives them in order, and the payload is kept after them if the transport still fails.
+func (s *sender) writeWithOutage(payload []byte, size int, messages int) {
+	err := s.replayOutage()
+	if err == nil {
+		if _, err = s.transport.Write(payload); err == nil {
+			s.countSent(size)
+			return
+		}
+	}
+	for _, p := range s.outage.push(payload, size, messages, err) {
+		s.dropWriteFailed(p.size, p.messages, err)
 	}
-	s.pool.returnBuffer(buffer)
+}
+
+// replayOutage writes the payloads kept by WithOutageBuffer, oldest first. It stops at the first failure, the
+// payload that failed staying first in line.
+func (s *sender) replayOutage() error {
+	for s.outage.pending() {
+		p := s.outage.front()
+		if _, err := s.transport.Write(p.payload); err != nil {
+			s.outage.lastErr = err
+			return err
+		}
+		s.outage.pop()
+		s.countSent(p.size)
+	}
+	return nil
+}
+
+// dropOutage drops the payloads still kept by WithOutageBuffer when the client is closed.
+func (s *sender) dropOutage() {
+	for s.outage.pending() {
+		p := s.outage.pop()
+		s.dropWriteFailed(p.size, p.messages, s.outage.lastErr)
+	}
+}
+
+// isTimeout returns true if err is a timeout of the transport, see WithWriteTimeout.
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// writeEndpoints writes the payload to the additional endpoints. A failing endpoint doesn't prevent the delivery to
+// the others nor to the main transport.
+func (s *sender) writeEndpoints(payload []byte, size int) {
+	for _, e := range s.endpoints {
+		if _, err := e.transport.Write(payload); err != nil {
+			atomic.AddUint64(&e.totalPayloadsDropped, 1)
+			atomic.AddUint64(&e.totalBytesDroppedWriter, uint64(size))
+			s.errorReporter.report("", ErrWriteFailed, err)
+		} else {
+			atomic.AddUint64(&e.totalPayloadsSent, 1)
+			atomic.AddUint64(&e.totalBytesSent, uint64(size))
+		}
+	}
+}
+
+// reconnectingWriter is implemented by the transports reconnecting to the Agent when it becomes unreachable.
+type reconnectingWriter interface {
+	reconnections() uint64
+}
+
+// sendBufferSizer is implemented by the transports whose socket send buffer can be sized, see WithUDPSendBufferSize.
+type sendBufferSizer interface {
+	udpSendBufferSize() uint64
 }
 
 func (s *sender) flushTelemetryMetrics(t *Telemetry) {
 	t.TotalPayloadsSent = atomic.LoadUint64(&s.telemetry.totalPayloadsSent)
 	t.TotalPayloadsDroppedQueueFull = atomic.LoadUint64(&s.telemetry.totalPayloadsDroppedQueueFull)
 	t.TotalPayloadsDroppedWriter = atomic.LoadUint64(&s.telemetry.totalPayloadsDroppedWriter)
+	t.TotalPayloadsDroppedWriterTimeout = atomic.LoadUint64(&s.telemetry.totalPayloadsDroppedTimeout)
 
 	t.TotalBytesSent = atomic.LoadUint64(&s.telemetry.totalBytesSent)
 	t.TotalBytesDroppedQueueFull = atomic.LoadUint64(&s.telemetry.totalBytesDroppedQueueFull)
 	t.TotalBytesDroppedWriter = atomic.LoadUint64(&s.telemetry.totalBytesDroppedWriter)
+	t.TotalPayloadsDroppedRateLimit = atomic.LoadUint64(&s.telemetry.totalPayloadsDroppedRateLimit)
+	t.TotalBytesDroppedRateLimit = atomic.LoadUint64(&s.telemetry.totalBytesDroppedRateLimit)
+
+	if r, ok := s.transport.(reconnectingWriter); ok {
+		t.TotalTransportReconnections = r.reconnections()
+	}
+	if b, ok := s.transport.(sendBufferSizer); ok {
+		t.UDPSendBufferSize = b.udpSendBufferSize()
+	}
+
+	if len(s.endpoints) != 0 {
+		t.Endpoints = make(map[string]EndpointTelemetry, len(s.endpoints))
+		for _, e := range s.endpoints {
+			t.Endpoints[e.addr] = EndpointTelemetry{
+				TotalPayloadsSent:          atomic.LoadUint64(&e.totalPayloadsSent),
+				TotalPayloadsDroppedWriter: atomic.LoadUint64(&e.totalPayloadsDropped),
+				TotalBytesSent:             atomic.LoadUint64(&e.totalBytesSent),
+				TotalBytesDroppedWriter:    atomic.LoadUint64(&e.totalBytesDroppedWriter),
+			}
+		}
+	}
 }
 
 func (s *sender) sendLoop() {
 	defer close(s.stop)
+	// retry replays the payloads kept by WithOutageBuffer when no new payload is written. It only runs while some are
+	// kept.
+	var retry *time.Ticker
+	var retryC <-chan time.Time
+	defer func() {
+		if retry != nil {
+			retry.Stop()
+		}
+	}()
 	for {
 		select {
 		case buffer := <-s.queue:
 			s.write(buffer)
+		case <-retryC:
+			s.replayOutage()
 		case <-s.stop:
 			return
 		case <-s.flushSignal:
@@ -84,6 +325,21 @@ func (s *sender) sendLoop() {
 			// So we can fully flush the input queue
 			s.flushInputQueue()
 			s.flushSignal <- struct{}{}
+		case done := <-s.drainSignal:
+			// Unlike flushSignal the workers are still running, so we only
+			// write the buffers queued before the drain was requested.
+			for n := len(s.queue); n > 0; n-- {
+				s.write(<-s.queue)
+			}
+			close(done)
+		}
+
+		if pending := s.outage.pending(); pending && retry == nil {
+			retry = time.NewTicker(outageRetryInterval)
+			retryC = retry.C
+		} else if !pending && retry != nil {
+			retry.Stop()
+			retry, retryC = nil, nil
 		}
 	}
 }
@@ -99,13 +355,100 @@ func (s *sender) flushInputQueue() {
 	}
 }
 func (s *sender) flush() {
+	if s.synchronous {
+		return
+	}
 	s.flushSignal <- struct{}{}
 	<-s.flushSignal
 }
 
+// drain blocks until all the buffers queued before the call have been written to the transport or until ctx is done.
+func (s *sender) drain(ctx context.Context) error {
+	if s.synchronous {
+		return nil
+	}
+	done := make(chan struct{})
+	select {
+	case s.drainSignal <- done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 func (s *sender) close() error {
-	s.stop <- struct{}{}
-	<-s.stop
-	s.flushInputQueue()
+	return s.closeContext(context.Background())
+}
+
+// closeContext writes the queued buffers and closes the transports. Once ctx is done the remaining buffers are dropped
+// and ctx.Err() is returned without waiting for a pending write: the transports are closed in the background once it
+// returns, Write and Close of a Transport are never called concurrently.
+func (s *sender) closeContext(ctx context.Context) error {
+	if !s.synchronous {
+		done := make(chan struct{})
+		go func() {
+			s.stop <- struct{}{}
+			<-s.stop
+			s.flushInputQueueContext(ctx)
+			if ctx.Err() == nil {
+				s.replayOutage()
+			}
+			s.dropOutage()
+			close(done)
+		}()
+
+		select {
+		case <-done:
+		case <-ctx.Done():
+			go func() {
+				<-done
+				s.closeTransports()
+			}()
+			return ctx.Err()
+		}
+	} else {
+		s.writeMutex.Lock()
+		s.replayOutage()
+		s.dropOutage()
+		s.writeMutex.Unlock()
+	}
+	return s.closeTransports()
+}
+
+// flushInputQueueContext is flushInputQueue stopping once ctx is done.
+func (s *sender) flushInputQueueContext(ctx context.Context) {
+	for ctx.Err() == nil {
+		select {
+		case buffer := <-s.queue:
+			s.write(buffer)
+		default:
+			return
+		}
+	}
+}
+
+// dropQueue returns the buffers still queued to the pool once the client is closed, the sender giving up on them when
+// the context of Close is done.
+func (s *sender) dropQueue() {
+	for {
+		select {
+		case buffer := <-s.queue:
+			s.returnBuffer(buffer)
+		default:
+			return
+		}
+	}
+}
+
+func (s *sender) closeTransports() error {
+	for _, e := range s.endpoints {
+		e.transport.Close()
+	}
 	return s.transport.Close()
 }
@@ -1,8 +1,12 @@
 package statsd
 
 import (
+	"context"
 	"fmt"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -97,3 +101,350 @@ func TestSenderWriteError(t *testing.T) {
 	assert.Equal(t, uint64(0), sender.telemetry.totalBytesDroppedQueueFull)
 	assert.Equal(t, uint64(1), sender.telemetry.totalBytesDroppedWriter)
 }
+
+func TestSenderDrain(t *testing.T) {
+	writer := new(mockedWriter)
+	writer.On("Write", mock.Anything).Return(1, nil)
+	writer.On("Close").Return(nil)
+	pool := newBufferPool(10, 1024, 1)
+	sender := newSender(writer, 10, pool)
+	defer sender.close()
+
+	for i := 0; i < 3; i++ {
+		buffer := pool.borrowBuffer()
+		buffer.writeSeparator() // add some dummy data
+		sender.send(buffer)
+	}
+
+	err := sender.drain(context.Background())
+	assert.Nil(t, err)
+	writer.AssertNumberOfCalls(t, "Write", 3)
+	assert.Equal(t, uint64(3), sender.telemetry.totalPayloadsSent)
+}
+
+func TestSenderDrainTimeout(t *testing.T) {
+	unblock := make(chan struct{})
+	writer := new(mockedWriter)
+	writer.On("Write", mock.Anything).Return(1, nil).Run(func(mock.Arguments) { <-unblock })
+	writer.On("Close").Return(nil)
+	pool := newBufferPool(10, 1024, 1)
+	sender := newSender(writer, 10, pool)
+	defer sender.close()
+
+	buffer := pool.borrowBuffer()
+	buffer.writeSeparator() // add some dummy data
+	sender.send(buffer)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := sender.drain(ctx)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	close(unblock)
+}
+
+func TestSenderCloseContext(t *testing.T) {
+	unblock := make(chan struct{})
+	closed := make(chan struct{})
+	writer := new(mockedWriter)
+	writer.On("Write", mock.Anything).Return(1, nil).Run(func(mock.Arguments) { <-unblock })
+	writer.On("Close").Return(nil).Run(func(mock.Arguments) { close(closed) })
+	pool := newBufferPool(10, 1024, 1)
+	sender := newSender(writer, 10, pool)
+
+	for i := 0; i < 3; i++ {
+		buffer := pool.borrowBuffer()
+		buffer.writeSeparator() // add some dummy data
+		sender.send(buffer)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := sender.closeContext(ctx)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	// the transport is only closed once the pending write returns
+	select {
+	case <-closed:
+		t.Fatal("the transport was closed during a write")
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(unblock)
+	<-closed
+}
+
+func TestSenderErrorHandler(t *testing.T) {
+	writeErr := fmt.Errorf("some write error")
+	writer := new(mockedWriter)
+	writer.On("Write", mock.Anything).Return(1, writeErr)
+	writer.On("Close").Return(nil)
+	pool := newBufferPool(10, 1024, 1)
+	sender := newSender(writer, 10, pool)
+
+	errs := []error{}
+	sender.errorReporter = newErrorReporter(func(err error) { errs = append(errs, err) })
+
+	buffer := pool.borrowBuffer()
+	buffer.writeSeparator() // add some dummy data
+	sender.send(buffer)
+	sender.close()
+
+	// the sender is closed: new payloads can't be queued
+	sender.queue = make(chan *statsdBuffer)
+	sender.send(pool.borrowBuffer())
+	sender.errorReporter.close()
+
+	assert.Equal(t, []error{
+		&DroppedMetricError{Reason: ErrWriteFailed, Cause: writeErr},
+		&DroppedMetricError{Reason: ErrQueueFull},
+	}, errs)
+}
+
+func TestSenderAdditionalEndpoints(t *testing.T) {
+	main := new(mockedWriter)
+	main.On("Write", mock.Anything).Return(1, nil)
+	main.On("Close").Return(nil)
+	other := new(mockedWriter)
+	other.On("Write", mock.Anything).Return(1, nil)
+	other.On("Close").Return(nil)
+	failing := new(mockedWriter)
+	failing.On("Write", mock.Anything).Return(0, fmt.Errorf("some write error"))
+	failing.On("Close").Return(nil)
+
+	pool := newBufferPool(10, 1024, 1)
+	sender := newSender(main, 10, pool)
+	sender.endpoints = []*endpoint{
+		{addr: "failing:8125", transport: failing},
+		{addr: "other:8125", transport: other},
+	}
+	buffer := pool.borrowBuffer()
+	buffer.writeSeparator() // add some dummy data
+	sender.send(buffer)
+
+	err := sender.close()
+	assert.Nil(t, err)
+	// every endpoint gets the same bytes, the failing one doesn't prevent the delivery to the others
+	main.AssertCalled(t, "Write", []byte("\n"))
+	other.AssertCalled(t, "Write", []byte("\n"))
+	failing.AssertCalled(t, "Write", []byte("\n"))
+	other.AssertCalled(t, "Close")
+	failing.AssertCalled(t, "Close")
+
+	tlm := Telemetry{}
+	sender.flushTelemetryMetrics(&tlm)
+	assert.Equal(t, uint64(1), tlm.TotalPayloadsSent)
+	assert.Equal(t, uint64(0), tlm.TotalPayloadsDroppedWriter)
+	assert.Equal(t, map[string]EndpointTelemetry{
+		"failing:8125": {TotalPayloadsDroppedWriter: 1, TotalBytesDroppedWriter: 1},
+		"other:8125":   {TotalPayloadsSent: 1, TotalBytesSent: 1},
+	}, tlm.Endpoints)
+}
+
+func TestSenderAdditionalEndpointsMainFailing(t *testing.T) {
+	main := new(mockedWriter)
+	main.On("Write", mock.Anything).Return(0, fmt.Errorf("some write error"))
+	main.On("Close").Return(nil)
+	other := new(mockedWriter)
+	other.On("Write", mock.Anything).Return(1, nil)
+	other.On("Close").Return(nil)
+
+	pool := newBufferPool(10, 1024, 1)
+	sender := newSender(main, 10, pool)
+	sender.endpoints = []*endpoint{{addr: "other:8125", transport: other}}
+	buffer := pool.borrowBuffer()
+	buffer.writeSeparator() // add some dummy data
+	sender.send(buffer)
+	sender.close()
+
+	other.AssertCalled(t, "Write", []byte("\n"))
+	assert.Equal(t, uint64(1), sender.telemetry.totalPayloadsDroppedWriter)
+	assert.Equal(t, uint64(1), sender.endpoints[0].totalPayloadsSent)
+}
+
+// countingWriter counts the payloads written to it.
+type countingWriter struct {
+	payloads uint64
+}
+
+func (w *countingWriter) Write(data []byte) (int, error) {
+	atomic.AddUint64(&w.payloads, 1)
+	return len(data), nil
+}
+
+func (w *countingWriter) Close() error {
+	return nil
+}
+
+func TestSenderRateLimit(t *testing.T) {
+	const limit = 50
+	writer := &countingWriter{}
+	pool := newBufferPool(10, 1024, 1)
+	sender := newSender(writer, 10, pool)
+	sender.rateLimiter = newRateLimiter(limit, time.Now)
+
+	start := time.Now()
+	for time.Since(start) < 500*time.Millisecond {
+		buffer := pool.borrowBuffer()
+		buffer.writeSeparator()
+		sender.send(buffer)
+	}
+	sender.flush()
+	sender.close()
+	elapsed := time.Since(start)
+
+	sent := atomic.LoadUint64(&writer.payloads)
+	// the initial burst plus what was refilled during the flood
+	assert.LessOrEqual(t, sent, uint64(limit+limit*elapsed.Seconds()+1))
+	assert.GreaterOrEqual(t, sent, uint64(limit))
+	assert.Equal(t, sent, atomic.LoadUint64(&sender.telemetry.totalPayloadsSent))
+	assert.NotZero(t, atomic.LoadUint64(&sender.telemetry.totalPayloadsDroppedRateLimit))
+	assert.Equal(t, atomic.LoadUint64(&sender.telemetry.totalPayloadsDroppedRateLimit), atomic.LoadUint64(&sender.telemetry.totalBytesDroppedRateLimit))
+}
+
+func TestSenderMaxQueueBytes(t *testing.T) {
+	writer := &countingWriter{}
+	pool := newBufferPool(10, 1024, 1)
+	// manually create the sender so the sender loop is not started and the queue is only consumed by the test
+	sender := &sender{
+		transport:     writer,
+		pool:          pool,
+		queue:         make(chan *statsdBuffer, 10),
+		telemetry:     &senderTelemetry{},
+		maxQueueBytes: 250,
+	}
+
+	largeBuffer := func() *statsdBuffer {
+		buffer := pool.borrowBuffer()
+		buffer.buffer = append(buffer.buffer, make([]byte, 100)...)
+		return buffer
+	}
+
+	sender.send(largeBuffer())
+	sender.send(largeBuffer())
+	assert.Equal(t, uint64(200), atomic.LoadUint64(&sender.queuedBytes))
+
+	// the queue has room for more buffers but not for more bytes
+	sender.send(largeBuffer())
+	assert.Len(t, sender.queue, 2)
+	assert.Equal(t, uint64(200), atomic.LoadUint64(&sender.queuedBytes))
+	assert.Equal(t, uint64(1), sender.telemetry.totalPayloadsDroppedQueueFull)
+	assert.Equal(t, uint64(100), sender.telemetry.totalBytesDroppedQueueFull)
+
+	// writing a buffer releases its bytes
+	sender.write(<-sender.queue)
+	assert.Equal(t, uint64(100), atomic.LoadUint64(&sender.queuedBytes))
+	sender.send(largeBuffer())
+	assert.Len(t, sender.queue, 2)
+	assert.Equal(t, uint64(200), atomic.LoadUint64(&sender.queuedBytes))
+
+	sender.flushInputQueue()
+	assert.Equal(t, uint64(0), atomic.LoadUint64(&sender.queuedBytes))
+	assert.Equal(t, uint64(3), atomic.LoadUint64(&writer.payloads))
+	assert.Equal(t, uint64(1), sender.telemetry.totalPayloadsDroppedQueueFull)
+	assert.Equal(t, 10, len(pool.pool))
+}
+
+// outageWriter records the payloads written to it and fails while down is set.
+type outageWriter struct {
+	sync.Mutex
+	down     bool
+	payloads []string
+}
+
+func (w *outageWriter) Write(data []byte) (int, error) {
+	w.Lock()
+	defer w.Unlock()
+	if w.down {
+		return 0, fmt.Errorf("connection refused")
+	}
+	w.payloads = append(w.payloads, string(data))
+	return len(data), nil
+}
+
+func (w *outageWriter) Close() error {
+	return nil
+}
+
+func (w *outageWriter) setDown(down bool) {
+	w.Lock()
+	w.down = down
+	w.Unlock()
+}
+
+func (w *outageWriter) received() []string {
+	w.Lock()
+	defer w.Unlock()
+	return append([]string(nil), w.payloads...)
+}
+
+func TestSenderOutageBuffer(t *testing.T) {
+	writer := &outageWriter{down: true}
+	pool := newBufferPool(10, 1024, 1)
+	// manually create the sender so the sender loop is not started
+	sender := &sender{
+		transport: writer,
+		pool:      pool,
+		telemetry: &senderTelemetry{},
+		outage:    newOutageBuffer(10),
+	}
+	write := func(payload string) {
+		buffer := pool.borrowBuffer()
+		buffer.buffer = append(buffer.buffer, payload...)
+		sender.write(buffer)
+	}
+
+	write("aaaa")
+	write("bbbb")
+	write("cccc")
+	assert.Empty(t, writer.received())
+	// "aaaa" was dropped to make room for "cccc"
+	assert.Equal(t, uint64(1), sender.telemetry.totalPayloadsDroppedWriter)
+	assert.Equal(t, uint64(4), sender.telemetry.totalBytesDroppedWriter)
+
+	writer.setDown(false)
+	write("dddd")
+	assert.Equal(t, []string{"bbbb", "cccc", "dddd"}, writer.received())
+	assert.Equal(t, uint64(3), sender.telemetry.totalPayloadsSent)
+	assert.Equal(t, uint64(12), sender.telemetry.totalBytesSent)
+	assert.False(t, sender.outage.pending())
+	assert.Equal(t, 10, len(pool.pool))
+}
+
+func TestSenderOutageBufferRetry(t *testing.T) {
+	writer := &outageWriter{down: true}
+	pool := newBufferPool(10, 1024, 1)
+	sender := newSender(writer, 10, pool)
+	sender.outage = newOutageBuffer(1024)
+
+	for _, payload := range []string{"aaaa", "bbbb"} {
+		buffer := pool.borrowBuffer()
+		buffer.buffer = append(buffer.buffer, payload...)
+		sender.send(buffer)
+	}
+	sender.flush()
+	assert.Empty(t, writer.received())
+
+	// the kept payloads are replayed without waiting for a new one
+	writer.setDown(false)
+	for i := 0; i < 30 && len(writer.received()) < 2; i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+	assert.Equal(t, []string{"aaaa", "bbbb"}, writer.received())
+	assert.Nil(t, sender.close())
+	assert.Equal(t, uint64(0), sender.telemetry.totalPayloadsDroppedWriter)
+}
+
+func TestSenderOutageBufferClose(t *testing.T) {
+	writer := &outageWriter{down: true}
+	pool := newBufferPool(10, 1024, 1)
+	sender := newSender(writer, 10, pool)
+	sender.outage = newOutageBuffer(1024)
+
+	buffer := pool.borrowBuffer()
+	buffer.buffer = append(buffer.buffer, "aaaa"...)
+	sender.send(buffer)
+
+	// the payloads still kept are dropped
+	assert.Nil(t, sender.close())
+	assert.Empty(t, writer.received())
+	assert.Equal(t, uint64(1), sender.telemetry.totalPayloadsDroppedWriter)
+	assert.Equal(t, uint64(4), sender.telemetry.totalBytesDroppedWriter)
+}
@@ -28,7 +28,9 @@ type ServiceCheck struct {
 	// Timestamp is a timestamp for the serviceCheck.  If not provided, the dogstatsd
 	// server will set this to the current time.
 	Timestamp time.Time
-	// Hostname for the serviceCheck.
+	// Hostname for the serviceCheck, when it describes another host than the
+	// one sending it. If not provided, the host given to WithConstantHostname
+	// is used. '|' and line breaks are replaced with '_'.
 	Hostname string
 	// A message describing the current state of the serviceCheck.
 	Message string
@@ -2,6 +2,7 @@ package statsd
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -52,6 +53,15 @@ func TestServiceChecks(t *testing.T) {
 		}, {
 			&ServiceCheck{Name: "DataCatService", Status: Ok, Hostname: "DataStation.Cat", Message: "Here goes m: that should be escaped", Tags: []string{"host:foo", "app:bar"}},
 			`_sc|DataCatService|0|h:DataStation.Cat|#host:foo,app:bar|m:Here goes m\: that should be escaped`,
+		}, {
+			&ServiceCheck{Name: "DataCatService", Status: Critical, Timestamp: time.Unix(1658934956, 0)},
+			`_sc|DataCatService|2|d:1658934956`,
+		}, {
+			&ServiceCheck{Name: "DataCatService", Status: Warn, Timestamp: time.Unix(1658934956, 0), Hostname: "DataStation.Cat", Message: "line1\nline2", Tags: []string{"host:foo"}},
+			`_sc|DataCatService|1|d:1658934956|h:DataStation.Cat|#host:foo|m:line1\nline2`,
+		}, {
+			&ServiceCheck{Name: "DataCatService", Status: Ok, Hostname: "Data|Station\nCat"},
+			`_sc|DataCatService|0|h:Data_Station_Cat`,
 		},
 	}
 
@@ -0,0 +1,52 @@
+// +build !windows
+
+package statsd
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// gracefulShutdownEnvVar makes TestGracefulShutdownTerminates run the program receiving the signal.
+const gracefulShutdownEnvVar = "STATSD_TEST_GRACEFUL_SHUTDOWN"
+
+// stdoutWriter writes the payloads to the standard output for the process running the test to read them.
+type stdoutWriter struct{}
+
+func (stdoutWriter) Write(data []byte) (int, error) {
+	return os.Stdout.Write(data)
+}
+
+func (stdoutWriter) Close() error {
+	return nil
+}
+
+func TestGracefulShutdownTerminates(t *testing.T) {
+	if os.Getenv(gracefulShutdownEnvVar) != "" {
+		client, err := NewWithWriter(stdoutWriter{}, WithoutTelemetry(), WithBufferFlushInterval(time.Hour), WithGracefulShutdown(syscall.SIGTERM))
+		require.Nil(t, err)
+		client.Gauge("gauge", 1, nil, 1)
+		syscall.Kill(os.Getpid(), syscall.SIGTERM)
+		time.Sleep(10 * time.Second)
+		// only reached if the signal didn't terminate the program
+		os.Exit(0)
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestGracefulShutdownTerminates$")
+	cmd.Env = append(os.Environ(), gracefulShutdownEnvVar+"=1")
+	output, err := cmd.Output()
+
+	// the metrics are flushed before the program is terminated by the signal
+	assert.Contains(t, string(output), "gauge:1|g")
+	exitErr, ok := err.(*exec.ExitError)
+	require.True(t, ok, "the program wasn't terminated by the signal: %v", err)
+	status := exitErr.Sys().(syscall.WaitStatus)
+	assert.True(t, status.Signaled())
+	assert.Equal(t, syscall.SIGTERM, status.Signal())
+}
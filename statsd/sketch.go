@@ -0,0 +1,54 @@
+package statsd
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync/atomic"
+)
+
+// ErrSketchInputDisabled is returned by DistributionSketch when the client was not created with WithSketchInput.
+var ErrSketchInputDisabled = errors.New("statsd sketch input is disabled, see WithSketchInput")
+
+// validateSketch returns an error if the summary given to DistributionSketch can't describe a set of samples.
+func validateSketch(count int64, sum, min, max float64) error {
+	if count < 1 {
+		return fmt.Errorf("statsd sketch count must be greater than 0: %d", count)
+	}
+	for _, v := range [3]float64{sum, min, max} {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return fmt.Errorf("statsd sketch values must be finite: %v", v)
+		}
+	}
+	if min > max {
+		return fmt.Errorf("statsd sketch min must be lower or equal to max: %v > %v", min, max)
+	}
+	return nil
+}
+
+// DistributionSketch sends the summary of count samples of a distribution, their sum, minimum and maximum, as a single
+// message instead of every sample. This is meant for sparse distributions of high values where sending the samples is
+// too costly. The client must be created with WithSketchInput, ErrSketchInputDisabled is returned otherwise.
+//
+// The message is "<name>:<count>:<sum>:<min>:<max>|ds" followed by the tags. It bypasses the client side aggregation
+// and sampling since it is already aggregated.
+func (c *Client) DistributionSketch(name string, count int64, sum, min, max float64, tags []string) error {
+	if c == nil {
+		return ErrNoClient
+	}
+	if !c.sketchInput {
+		return ErrSketchInputDisabled
+	}
+	if c.plainStatsd {
+		return ErrStatsdFormat
+	}
+	if err := validateSketch(count, sum, min, max); err != nil {
+		return err
+	}
+	tags = c.processTags(tags)
+	if err := c.validateMetric(name, tags); err != nil {
+		return err
+	}
+	atomic.AddUint64(&c.telemetry.totalMetricsDistribution, 1)
+	return c.send(metric{metricType: distributionSketch, name: name, ivalue: count, fvalues: []float64{sum, min, max}, tags: tags, rate: 1, globalTags: c.getGlobalTags().metricTags, namespace: c.namespace})
+}
@@ -0,0 +1,61 @@
+package statsd
+
+import (
+	"bytes"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateSketch(t *testing.T) {
+	assert.Nil(t, validateSketch(1, 2, 2, 2))
+	assert.Nil(t, validateSketch(3, -6, -3, -1))
+	assert.EqualError(t, validateSketch(0, 0, 0, 0), "statsd sketch count must be greater than 0: 0")
+	assert.EqualError(t, validateSketch(2, 3, 2, 1), "statsd sketch min must be lower or equal to max: 2 > 1")
+	assert.Error(t, validateSketch(2, math.NaN(), 1, 2))
+	assert.Error(t, validateSketch(2, 3, 1, math.Inf(1)))
+}
+
+func TestDistributionSketchFormat(t *testing.T) {
+	for _, options := range [][]Option{{}, {WithExtendedClientSideAggregation()}, {WithoutClientSideAggregation()}} {
+		var buf bytes.Buffer
+		client, err := NewWithWriter(&buf, append(options, WithoutTelemetry(), WithSketchInput(), WithNamespace("ns."), WithTags([]string{"env:dev"}))...)
+		require.Nil(t, err)
+
+		require.Nil(t, client.DistributionSketch("latency", 20, 85.5, 0.25, 9, []string{"tag1"}))
+		require.Nil(t, client.DistributionSketch("latency", 1, 3, 3, 3, nil))
+		require.Nil(t, client.Close())
+
+		ts := &testServer{}
+		ts.assertMetric(t, strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n"), []string{
+			"ns.latency:20:85.5:0.25:9|ds|#env:dev,tag1",
+			"ns.latency:1:3:3:3|ds|#env:dev",
+		})
+		assert.Equal(t, uint64(2), client.GetTelemetry().TotalMetricsDistribution)
+	}
+}
+
+func TestDistributionSketchErrors(t *testing.T) {
+	var buf bytes.Buffer
+	client, err := NewWithWriter(&buf, WithoutTelemetry())
+	require.Nil(t, err)
+	assert.Equal(t, ErrSketchInputDisabled, client.DistributionSketch("latency", 1, 1, 1, 1, nil))
+	require.Nil(t, client.Close())
+
+	client, err = NewWithWriter(&buf, WithoutTelemetry(), WithSketchInput())
+	require.Nil(t, err)
+	assert.EqualError(t, client.DistributionSketch("latency", 2, 3, 2, 1, nil), "statsd sketch min must be lower or equal to max: 2 > 1")
+	require.Nil(t, client.Close())
+
+	client, err = NewWithWriter(&buf, WithoutTelemetry(), WithSketchInput(), WithStatsdFormat())
+	require.Nil(t, err)
+	assert.Equal(t, ErrStatsdFormat, client.DistributionSketch("latency", 1, 1, 1, 1, nil))
+	require.Nil(t, client.Close())
+	assert.Empty(t, buf.String())
+
+	var nilClient *Client
+	assert.Equal(t, ErrNoClient, nilClient.DistributionSketch("latency", 1, 1, 1, 1, nil))
+}
@@ -11,10 +11,18 @@ statsd is based on go-statsd-client.
 package statsd
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
+	"math"
+	"math/rand"
 	"os"
+	"os/signal"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -57,12 +65,24 @@ traffic instead of UDP.
 */
 const UnixAddressPrefix = "unix://"
 
+/*
+UnixAddressStreamPrefix holds the prefix to use to send the traffic over a
+SOCK_STREAM Unix Domain Socket instead of a SOCK_DGRAM one. Each payload is
+prefixed with its length.
+*/
+const UnixAddressStreamPrefix = "unixstream://"
+
 /*
 WindowsPipeAddressPrefix holds the prefix to use to enable Windows Named Pipes
 traffic instead of UDP.
 */
 const WindowsPipeAddressPrefix = `\\.\pipe\`
 
+/*
+noTimestamp is used as a value for metric without a given timestamp.
+*/
+const noTimestamp = int64(0)
+
 const (
 	agentHostEnvVarName = "DD_AGENT_HOST"
 	agentPortEnvVarName = "DD_DOGSTATSD_PORT"
@@ -90,12 +110,40 @@ const (
 	distribution
 	distributionAggregated
 	set
+	setInt
 	timing
 	timingAggregated
 	event
 	serviceCheck
+	raw
+	distributionSketch
 )
 
+// MetricType identifies a type of metric sent by the client. It is used to configure per type behavior, see
+// WithDefaultSampleRates.
+type MetricType int
+
+const (
+	// GaugeType is the type of metrics sent through Gauge.
+	GaugeType MetricType = iota
+	// CountType is the type of metrics sent through Count, Incr and Decr.
+	CountType
+	// HistogramType is the type of metrics sent through Histogram.
+	HistogramType
+	// DistributionType is the type of metrics sent through Distribution and DistributionSamples.
+	DistributionType
+	// SetType is the type of metrics sent through Set and SetInt.
+	SetType
+	// TimingType is the type of metrics sent through Timing and TimeInMilliseconds.
+	TimingType
+)
+
+/*
+DefaultRate can be used as the rate of any metric to use the sample rate configured for its type through
+WithDefaultSampleRates.
+*/
+const DefaultRate = float64(0)
+
 type receivingMode int
 
 const (
@@ -104,25 +152,33 @@ const (
 )
 
 const (
-	writerNameUDP     string = "udp"
-	writerNameUDS     string = "uds"
-	writerWindowsPipe string = "pipe"
+	writerNameUDP       string = "udp"
+	writerNameUDS       string = "uds"
+	writerNameUDSStream string = "uds-stream"
+	writerWindowsPipe   string = "pipe"
+	writerNameCustom    string = "custom"
 )
 
 type metric struct {
-	metricType metricType
-	namespace  string
-	globalTags []string
-	name       string
-	fvalue     float64
-	fvalues    []float64
-	ivalue     int64
-	svalue     string
-	evalue     *Event
-	scvalue    *ServiceCheck
-	tags       []string
-	stags      string
-	rate       float64
+	metricType  metricType
+	namespace   string
+	globalTags  []string
+	name        string
+	fvalue      float64
+	fvalues     []float64
+	ivalue      int64
+	svalue      string
+	evalue      *Event
+	scvalue     *ServiceCheck
+	tags        []string
+	stags       string
+	rate        float64
+	timestamp   int64
+	cardinality Cardinality
+	// containerID overrides the container ID of the client, see ContainerID.
+	containerID string
+	// barrier is only set on the markers used by Flush to wait for the metrics queued before it in channel mode.
+	barrier *sync.WaitGroup
 }
 
 type noClientErr string
@@ -140,32 +196,57 @@ func (e noClientErr) Error() string {
 // downstream users' with their testing.
 type ClientInterface interface {
 	// Gauge measures the value of a metric at a particular time.
-	Gauge(name string, value float64, tags []string, rate float64) error
+	Gauge(name string, value float64, tags []string, rate float64, parameters ...Parameter) error
+
+	// GaugeWithTimestamp measures the value of a metric at a given time.
+	GaugeWithTimestamp(name string, value float64, tags []string, rate float64, timestamp time.Time, parameters ...Parameter) error
+
+	// GaugeNegative sets a gauge to a negative value by sending a reset of the gauge to 0 followed by the value.
+	GaugeNegative(name string, value float64, tags []string, rate float64, parameters ...Parameter) error
 
 	// Count tracks how many times something happened per second.
-	Count(name string, value int64, tags []string, rate float64) error
+	Count(name string, value int64, tags []string, rate float64, parameters ...Parameter) error
+
+	// CountWithTimestamp tracks how many times something happened at the given second.
+	CountWithTimestamp(name string, value int64, tags []string, rate float64, timestamp time.Time, parameters ...Parameter) error
+
+	// MonotonicCount tracks a counter that only ever increases by submitting the difference with the previous value as
+	// a Count.
+	MonotonicCount(name string, value float64, tags []string, rate float64, parameters ...Parameter) error
 
 	// Histogram tracks the statistical distribution of a set of values on each host.
-	Histogram(name string, value float64, tags []string, rate float64) error
+	Histogram(name string, value float64, tags []string, rate float64, parameters ...Parameter) error
 
 	// Distribution tracks the statistical distribution of a set of values across your infrastructure.
-	Distribution(name string, value float64, tags []string, rate float64) error
+	Distribution(name string, value float64, tags []string, rate float64, parameters ...Parameter) error
+
+	// DistributionSamples tracks the statistical distribution of a batch of values across your infrastructure.
+	DistributionSamples(name string, values []float64, tags []string, rate float64, parameters ...Parameter) error
+
+	// Add is just Count of delta, which can be negative.
+	Add(name string, delta int64, tags []string, rate float64, parameters ...Parameter) error
 
 	// Decr is just Count of -1
-	Decr(name string, tags []string, rate float64) error
+	Decr(name string, tags []string, rate float64, parameters ...Parameter) error
 
 	// Incr is just Count of 1
-	Incr(name string, tags []string, rate float64) error
+	Incr(name string, tags []string, rate float64, parameters ...Parameter) error
 
 	// Set counts the number of unique elements in a group.
-	Set(name string, value string, tags []string, rate float64) error
+	Set(name string, value string, tags []string, rate float64, parameters ...Parameter) error
+
+	// SetInt counts the number of unique integers in a group.
+	SetInt(name string, value int64, tags []string, rate float64, parameters ...Parameter) error
+
+	// SetMulti adds several unique elements to a set in one call.
+	SetMulti(name string, values []string, tags []string, rate float64, parameters ...Parameter) error
 
 	// Timing sends timing information, it is an alias for TimeInMilliseconds
-	Timing(name string, value time.Duration, tags []string, rate float64) error
+	Timing(name string, value time.Duration, tags []string, rate float64, parameters ...Parameter) error
 
 	// TimeInMilliseconds sends timing information in milliseconds.
 	// It is flushed by statsd with percentiles, mean and other info (https://github.com/etsy/statsd/blob/master/docs/metric_types.md#timing)
-	TimeInMilliseconds(name string, value float64, tags []string, rate float64) error
+	TimeInMilliseconds(name string, value float64, tags []string, rate float64, parameters ...Parameter) error
 
 	// Event sends the provided Event.
 	Event(e *Event) error
@@ -184,6 +265,10 @@ type ClientInterface interface {
 
 	// Flush forces a flush of all the queued dogstatsd payloads.
 	Flush() error
+
+	// Drain flushes the client and waits for all the queued dogstatsd payloads to be written, the client can still be
+	// used afterward.
+	Drain(ctx context.Context) error
 }
 
 // A Client is a handle for sending messages to dogstatsd.  It is safe to
@@ -193,8 +278,8 @@ type Client struct {
 	sender *sender
 	// namespace to prepend to all statsd calls
 	namespace string
-	// tags are global tags to be added to every statsd call
-	tags            []string
+	// globalTags holds the *globalTags added to every statsd call, see SetGlobalTags.
+	globalTags      atomic.Value
 	flushTime       time.Duration
 	telemetry       *statsdTelemetry
 	telemetryClient *telemetryClient
@@ -202,25 +287,60 @@ type Client struct {
 	wg              sync.WaitGroup
 	workers         []*worker
 	closerLock      sync.Mutex
+	// inputLock serializes the waits for the metrics queued in channel mode, see waitForInputMetrics.
+	inputLock       sync.Mutex
 	workersMode     receivingMode
 	aggregatorMode  receivingMode
+	channelTimeout  time.Duration
+	errOnFullQueue  bool
+	defaultRates    map[MetricType]float64
+	cardinality     Cardinality
+	keepTag         func(tag string) bool
+	tagNormalize    bool
+	tagInterner     *tagInterner
+	maxTagValueLen  int
+	tagMarker       string
+	tagDedup        bool
+	tagSort         bool
+	maxTags         int
+	maxTagsStrategy MaxTagsStrategy
+	precomputed     bool
+	strictNames     bool
+	hostname        string
+	signals         chan os.Signal
+	unifiedTagging  bool
+	plainStatsd     bool
+	sketchInput     bool
+	clock           clock
+	errorReporter   *errorReporter
+	monotonicCounts monotonicCounts
 	agg             *aggregator
 	aggExtended     *aggregator
+	aggTiming       *aggregator
 	options         []Option
 	addrOption      string
+	bufferPool      *bufferPool
+	// batchWorkers holds the workers writing the metrics of Batch.
+	batchWorkers sync.Pool
 }
 
 // statsdTelemetry contains telemetry metrics about the client
 type statsdTelemetry struct {
-	totalMetricsGauge        uint64
-	totalMetricsCount        uint64
-	totalMetricsHistogram    uint64
-	totalMetricsDistribution uint64
-	totalMetricsSet          uint64
-	totalMetricsTiming       uint64
-	totalEvents              uint64
-	totalServiceChecks       uint64
-	totalDroppedOnReceive    uint64
+	totalMetricsGauge         uint64
+	totalMetricsCount         uint64
+	totalMetricsHistogram     uint64
+	totalMetricsDistribution  uint64
+	totalMetricsSet           uint64
+	totalMetricsTiming        uint64
+	totalEvents               uint64
+	totalServiceChecks        uint64
+	totalDroppedOnReceive     uint64
+	totalDroppedOnTimeout     uint64
+	totalTooManyTags          uint64
+	totalDroppedOversized     uint64
+	totalDroppedSerialization uint64
+	// droppedAtReset is the value of DroppedMetrics at the last call to ResetDroppedMetrics.
+	droppedAtReset uint64
 }
 
 // Verify that Client implements the ClientInterface.
@@ -238,7 +358,7 @@ func resolveAddr(addr string) string {
 		return ""
 	}
 
-	if !strings.HasPrefix(addr, WindowsPipeAddressPrefix) && !strings.HasPrefix(addr, UnixAddressPrefix) {
+	if !strings.HasPrefix(addr, WindowsPipeAddressPrefix) && !strings.HasPrefix(addr, UnixAddressPrefix) && !strings.HasPrefix(addr, UnixAddressStreamPrefix) {
 		if !strings.Contains(addr, ":") {
 			if envPort != "" {
 				addr = fmt.Sprintf("%s:%s", addr, envPort)
@@ -250,7 +370,7 @@ func resolveAddr(addr string) string {
 	return addr
 }
 
-func createWriter(addr string, writeTimeout time.Duration) (io.WriteCloser, string, error) {
+func createWriter(addr string, writeTimeout time.Duration, connectTimeout time.Duration, udpSendBufferSize int, lazyConnection bool) (Transport, string, error) {
 	addr = resolveAddr(addr)
 	if addr == "" {
 		return nil, "", errors.New("No address passed and autodetection from environment failed")
@@ -261,25 +381,38 @@ func createWriter(addr string, writeTimeout time.Duration) (io.WriteCloser, stri
 		w, err := newWindowsPipeWriter(addr, writeTimeout)
 		return w, writerWindowsPipe, err
 	case strings.HasPrefix(addr, UnixAddressPrefix):
-		w, err := newUDSWriter(addr[len(UnixAddressPrefix):], writeTimeout)
+		w, err := newUDSWriter(addr[len(UnixAddressPrefix):], writeTimeout, connectTimeout)
 		return w, writerNameUDS, err
+	case strings.HasPrefix(addr, UnixAddressStreamPrefix):
+		w, err := newUDSStreamWriter(addr[len(UnixAddressStreamPrefix):], writeTimeout, connectTimeout)
+		return w, writerNameUDSStream, err
+	case lazyConnection:
+		w, err := newLazyUDPWriter(addr, writeTimeout, udpSendBufferSize)
+		return w, writerNameUDP, err
 	default:
-		w, err := newUDPWriter(addr, writeTimeout)
+		w, err := newUDPWriter(addr, writeTimeout, udpSendBufferSize)
 		return w, writerNameUDP, err
 	}
 }
 
 // New returns a pointer to a new Client given an addr in the format "hostname:port" for UDP,
-// "unix:///path/to/socket" for UDS or "\\.\pipe\path\to\pipe" for Windows Named Pipes.
+// "unix:///path/to/socket" for UDS, "unixstream:///path/to/socket" for UDS over SOCK_STREAM or "\\.\pipe\path\to\pipe"
+// for Windows Named Pipes.
 func New(addr string, options ...Option) (*Client, error) {
 	o, err := resolveOptions(options)
 	if err != nil {
 		return nil, err
 	}
 
-	w, writerType, err := createWriter(addr, o.writeTimeout)
-	if err != nil {
-		return nil, err
+	var w Transport
+	writerType := writerNameCustom
+	if o.transport != nil {
+		w = o.transport
+	} else {
+		w, writerType, err = createWriter(addr, o.writeTimeout, o.connectTimeout, o.udpSendBufferSize, o.lazyConnection)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	client, err := newWithWriter(w, o, writerType)
@@ -290,14 +423,42 @@ func New(addr string, options ...Option) (*Client, error) {
 	return client, err
 }
 
-// NewWithWriter creates a new Client with given writer. Writer is a
-// io.WriteCloser
-func NewWithWriter(w io.WriteCloser, options ...Option) (*Client, error) {
+// NewWithWriter creates a new Client writing its payloads to the given writer
+// instead of a socket, ex: a file or a bytes.Buffer to inspect the exact
+// DogStatsD lines produced. Each payload is given to a single Write call,
+// metrics in a payload are separated by '\n' (see WithRecordSeparator).
+//
+// The writer is closed by Close if it implements io.Closer. Write and Close
+// are never called concurrently.
+func NewWithWriter(w io.Writer, options ...Option) (*Client, error) {
 	o, err := resolveOptions(options)
 	if err != nil {
 		return nil, err
 	}
-	return newWithWriter(w, o, "custom")
+	return newWithWriter(&customWriter{w: w}, o, writerNameCustom)
+}
+
+// customWriter adapts the writer given to NewWithWriter to the transport
+// interface. The lock makes it safe for writers not supporting concurrent
+// calls, like a bytes.Buffer.
+type customWriter struct {
+	sync.Mutex
+	w io.Writer
+}
+
+func (c *customWriter) Write(p []byte) (int, error) {
+	c.Lock()
+	defer c.Unlock()
+	return c.w.Write(p)
+}
+
+func (c *customWriter) Close() error {
+	c.Lock()
+	defer c.Unlock()
+	if closer, ok := c.w.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
 }
 
 // CloneWithExtraOptions create a new Client with extra options
@@ -313,44 +474,135 @@ func CloneWithExtraOptions(c *Client, options ...Option) (*Client, error) {
 	return New(c.addrOption, opt...)
 }
 
-func newWithWriter(w io.WriteCloser, o *Options, writerName string) (*Client, error) {
+// createEndpoints creates the writers of the addresses given to WithAdditionalAddresses.
+func createEndpoints(o *Options) ([]*endpoint, error) {
+	endpoints := []*endpoint{}
+	for _, addr := range o.additionalAddresses {
+		w, writerType, err := createWriter(addr, o.writeTimeout, o.connectTimeout, o.udpSendBufferSize, o.lazyConnection)
+		if err == nil && o.compression != CompressionNone && writerType == writerNameUDP {
+			w.Close()
+			err = errors.New("payload compression is not supported over UDP")
+		}
+		if err != nil {
+			for _, e := range endpoints {
+				e.transport.Close()
+			}
+			return nil, fmt.Errorf("could not create writer for additional address '%s': %v", addr, err)
+		}
+		endpoints = append(endpoints, &endpoint{addr: addr, transport: w})
+	}
+	return endpoints, nil
+}
+
+func newWithWriter(w Transport, o *Options, writerName string) (*Client, error) {
+	if o.agentInfoURL != "" {
+		detectAgentCapabilities(o)
+	}
+	if o.compression != CompressionNone && writerName == writerNameUDP {
+		w.Close()
+		return nil, errors.New("payload compression is not supported over UDP")
+	}
+	endpoints, err := createEndpoints(o)
+	if err != nil {
+		w.Close()
+		return nil, err
+	}
+
 	c := Client{
 		namespace: o.namespace,
-		tags:      o.tags,
 		telemetry: &statsdTelemetry{},
 	}
-	// Inject values of DD_* environment variables as global tags.
-	for _, mapping := range ddEnvTagsMapping {
-		if value := os.Getenv(mapping.envName); value != "" {
-			c.tags = append(c.tags, fmt.Sprintf("%s:%s", mapping.tagName, value))
-		}
+	c.unifiedTagging = o.unifiedServiceTagging
+	c.plainStatsd = o.statsdFormat
+	c.hostname = o.constantHostname
+	c.keepTag = newTagFilter(o.tagFilter, o.tagDenylist)
+	c.tagNormalize = o.tagNormalization
+	if o.tagInterning {
+		c.tagInterner = newTagInterner(tagInternerSize, o.tagNormalization)
 	}
-
+	c.maxTagValueLen = o.maxTagValueLength
+	if len(o.tagTruncationMarker) < o.maxTagValueLength {
+		c.tagMarker = o.tagTruncationMarker
+	}
+	c.tagDedup = o.tagDeduplication
+	c.tagSort = o.sortedTags
+	c.maxTags = o.maxTagsPerMetric
+	c.maxTagsStrategy = o.maxTagsStrategy
+	c.precomputed = o.precomputedHistograms
+	c.sketchInput = o.sketchInput
+	c.strictNames = o.strictNameValidation
+	c.globalTags.Store(c.newGlobalTags(o.tags))
+
+	// Unlike UDP, UDS and named pipes are local transports with no datagram
+	// size constraint: larger payloads improve batching.
+	largePayloads := writerName == writerNameUDS || writerName == writerNameUDSStream || writerName == writerWindowsPipe
 	if o.maxBytesPerPayload == 0 {
-		if writerName == writerNameUDS {
+		if largePayloads {
 			o.maxBytesPerPayload = DefaultMaxAgentPayloadSize
 		} else {
 			o.maxBytesPerPayload = OptimalUDPPayloadSize
 		}
 	}
 	if o.bufferPoolSize == 0 {
-		if writerName == writerNameUDS {
+		if largePayloads {
 			o.bufferPoolSize = DefaultUDSBufferPoolSize
 		} else {
 			o.bufferPoolSize = DefaultUDPBufferPoolSize
 		}
 	}
 	if o.senderQueueSize == 0 {
-		if writerName == writerNameUDS {
+		if largePayloads {
 			o.senderQueueSize = DefaultUDSBufferPoolSize
 		} else {
 			o.senderQueueSize = DefaultUDPBufferPoolSize
 		}
 	}
 
-	bufferPool := newBufferPool(o.bufferPoolSize, o.maxBytesPerPayload, o.maxMessagesPerPayload)
-	c.sender = newSender(w, o.senderQueueSize, bufferPool)
+	if o.originDetection {
+		o.bufferConfig.containerID = initContainerID()
+	}
+
+	if o.errorHandler != nil {
+		c.errorReporter = newErrorReporter(o.errorHandler)
+	}
+
+	maxMessagesPerPayload := o.maxMessagesPerPayload
+	if o.adaptivePayloadPacking {
+		// only the byte limit applies
+		maxMessagesPerPayload = math.MaxInt32
+	}
+	bufferPool := newBufferPoolWithConfig(o.bufferPoolSize, o.maxBytesPerPayload, maxMessagesPerPayload, o.bufferConfig)
+	c.bufferPool = bufferPool
+	if o.synchronousMode {
+		c.sender = newSynchronousSender(w, bufferPool)
+	} else {
+		c.sender = newSender(w, o.senderQueueSize, bufferPool)
+	}
+	c.sender.errorReporter = c.errorReporter
+	c.sender.compressor = newPayloadCompressor(o.compression)
+	c.sender.endpoints = endpoints
+	c.sender.maxQueueBytes = uint64(o.maxQueueBytes)
+	if o.outageBufferSize > 0 {
+		c.sender.outage = newOutageBuffer(o.outageBufferSize)
+	}
+	if o.rateLimit > 0 {
+		c.sender.rateLimiter = newRateLimiter(o.rateLimit, time.Now)
+	}
 	c.aggregatorMode = o.receiveMode
+	c.channelTimeout = o.channelModeTimeout
+	c.errOnFullQueue = o.channelModeErrOnFull
+	c.defaultRates = o.defaultSampleRates
+	c.cardinality = o.cardinality
+	c.clock = o.clock
+
+	if o.synchronousMode {
+		// metrics are written by the goroutine sending them: nothing is aggregated nor queued in channels
+		o.aggregation = false
+		o.extendedAggregation = false
+		o.timingAggregation = false
+		o.receiveMode = mutexMode
+		c.aggregatorMode = mutexMode
+	}
 
 	c.workersMode = o.receiveMode
 	// channelMode mode at the worker level is not enabled when
@@ -361,12 +613,32 @@ func newWithWriter(w io.WriteCloser, o *Options, writerName string) (*Client, er
 		c.workersMode = mutexMode
 	}
 
+	// The jitter is drawn once: the intervals of a client stay regular but differ from the ones of other clients.
+	flushRandom := rand.New(rand.NewSource(time.Now().UnixNano()))
+	bufferFlushInterval := jitterInterval(o.bufferFlushInterval, o.flushJitter, flushRandom)
+	aggregationFlushInterval := jitterInterval(o.aggregationFlushInterval, o.flushJitter, flushRandom)
+	histogramFlushInterval := jitterInterval(o.histogramFlushInterval, o.flushJitter, flushRandom)
+
 	if o.aggregation || o.extendedAggregation {
-		c.agg = newAggregator(&c)
-		c.agg.start(o.aggregationFlushInterval)
+		c.agg = newAggregator(&c, int64(o.maxSamplesPerContext), o.maxSamplesStrategy)
+		c.agg.setSampleLimit = int64(o.setSampleLimit)
+		if o.countsAsRates {
+			c.agg.ratesInterval = aggregationFlushInterval
+		}
+		c.agg.histograms.sampler = o.sampler
+		c.agg.distributions.sampler = o.sampler
+		c.agg.timings.sampler = o.sampler
+		c.agg.distributions.ddSketch = o.ddSketchEncoding
+		if o.sampleRNGSeed != nil {
+			c.agg.seedRandom(*o.sampleRNGSeed)
+		}
+		c.agg.start(aggregationFlushInterval, histogramFlushInterval)
 
 		if o.extendedAggregation {
 			c.aggExtended = c.agg
+		}
+		if o.extendedAggregation || o.timingAggregation {
+			c.aggTiming = c.agg
 
 			if c.aggregatorMode == channelMode {
 				c.agg.startReceivingMetric(o.channelModeBufferSize, o.workersCount)
@@ -374,46 +646,138 @@ func newWithWriter(w io.WriteCloser, o *Options, writerName string) (*Client, er
 		}
 	}
 
+	highWaterMark := 0
+	if o.flushHighWaterMark < 1 {
+		highWaterMark = int(o.flushHighWaterMark * float64(o.maxBytesPerPayload))
+	}
+
+	workersHighWaterMark := highWaterMark
+	if o.synchronousMode {
+		// flush the buffer after each metric
+		workersHighWaterMark = 1
+	}
 	for i := 0; i < o.workersCount; i++ {
 		w := newWorker(bufferPool, c.sender)
+		w.errorReporter = c.errorReporter
+		w.sampler = o.sampler
+		w.highWaterMark = workersHighWaterMark
+		w.flushEvery = o.flushEveryNMetrics
+		w.immediateEvents = o.immediateEvents
+		w.oversizedPolicy = o.oversizedMetricPolicy
+		w.droppedOversized = &c.telemetry.totalDroppedOversized
+		w.droppedSerialization = &c.telemetry.totalDroppedSerialization
+		if o.sampleRNGSeed != nil {
+			w.seedRandom(*o.sampleRNGSeed)
+		}
+		if c.workersMode == mutexMode && o.maxBlockDuration > 0 {
+			w.setBlockTimeout(o.maxBlockDuration, &c.telemetry.totalDroppedOnTimeout)
+		}
 		c.workers = append(c.workers, w)
 
 		if c.workersMode == channelMode {
 			w.startReceivingMetric(o.channelModeBufferSize)
 		}
 	}
+	c.batchWorkers.New = func() interface{} {
+		w := newWorker(bufferPool, c.sender)
+		w.errorReporter = c.errorReporter
+		w.sampler = o.sampler
+		w.highWaterMark = highWaterMark
+		w.flushEvery = o.flushEveryNMetrics
+		w.oversizedPolicy = o.oversizedMetricPolicy
+		w.droppedOversized = &c.telemetry.totalDroppedOversized
+		w.droppedSerialization = &c.telemetry.totalDroppedSerialization
+		if o.sampleRNGSeed != nil {
+			w.seedRandom(*o.sampleRNGSeed)
+		}
+		return w
+	}
 
-	c.flushTime = o.bufferFlushInterval
+	c.flushTime = bufferFlushInterval
 	c.stop = make(chan struct{}, 1)
 
-	c.wg.Add(1)
-	go func() {
-		defer c.wg.Done()
-		c.watch()
-	}()
+	if !o.synchronousMode {
+		// the ticker is created before starting the goroutine so tests advancing a fake clock can't miss it
+		ticker := c.clock.NewTicker(c.flushTime)
+		c.wg.Add(1)
+		go func() {
+			defer c.wg.Done()
+			c.watch(ticker)
+		}()
+	}
 
-	if o.telemetry {
+	if o.telemetry && !o.synchronousMode {
 		if o.telemetryAddr == "" {
-			c.telemetryClient = newTelemetryClient(&c, writerName, c.agg != nil)
+			c.telemetryClient = newTelemetryClient(&c, writerName, c.agg != nil, o.telemetryTags)
 		} else {
 			var err error
-			c.telemetryClient, err = newTelemetryClientWithCustomAddr(&c, writerName, o.telemetryAddr, c.agg != nil, bufferPool, o.writeTimeout)
+			c.telemetryClient, err = newTelemetryClientWithCustomAddr(&c, writerName, o.telemetryAddr, c.agg != nil, o.telemetryTags, bufferPool, o.writeTimeout, o.connectTimeout, o.lazyConnection)
 			if err != nil {
 				return nil, err
 			}
 		}
+		c.telemetryClient.interval = o.telemetryInterval
+		c.telemetryClient.namespace = o.telemetryNamespace
 		c.telemetryClient.run(&c.wg, c.stop)
+	} else {
+		// the telemetry client is not started: it is only used by GetTelemetry
+		c.telemetryClient = newTelemetryClient(&c, writerName, c.agg != nil, nil)
+	}
+
+	if len(o.shutdownSignals) > 0 {
+		c.signals = make(chan os.Signal, 1)
+		signal.Notify(c.signals, o.shutdownSignals...)
+		go c.closeOnSignal()
 	}
 
 	return &c, nil
 }
 
-func (c *Client) watch() {
-	ticker := time.NewTicker(c.flushTime)
+// raiseSignal sends a signal to the current process. It is replaced by the tests that must not be terminated.
+var raiseSignal = func(sig os.Signal) {
+	if p, err := os.FindProcess(os.Getpid()); err == nil {
+		p.Signal(sig)
+	}
+}
+
+// getBatchWorker returns a worker of batchWorkers with a buffer borrowed from the pool.
+func (c *Client) getBatchWorker() *worker {
+	w := c.batchWorkers.Get().(*worker)
+	if w.buffer == nil {
+		w.buffer = w.pool.borrowBuffer()
+	}
+	return w
+}
+
+// putBatchWorker puts back a flushed worker in batchWorkers, its buffer is returned to the pool.
+func (c *Client) putBatchWorker(w *worker) {
+	w.returnBuffer()
+	c.batchWorkers.Put(w)
+}
+
+// closeOnSignal closes the client when it receives one of the signals given to WithGracefulShutdown. It isn't part of
+// the client wait group since it calls Close, which waits for the group.
+func (c *Client) closeOnSignal() {
+	select {
+	case sig := <-c.signals:
+		select {
+		case <-c.stop:
+			// the client was closed before the signal: the handler was already removed
+			return
+		default:
+		}
+		c.Close()
+		// Close removed the handler: the signal is raised again to get the behavior it had before the client was
+		// created, the default one terminating the program.
+		raiseSignal(sig)
+	case <-c.stop:
+	}
+}
 
+func (c *Client) watch(ticker ticker) {
 	for {
 		select {
-		case <-ticker.C:
+		case <-ticker.C():
 			for _, w := range c.workers {
 				w.flush()
 			}
@@ -428,10 +792,51 @@ func (c *Client) watch() {
 // blocking and will not return until everything is sent through the network.
 // In mutexMode, this will also block sampling new data to the client while the
 // workers and sender are flushed.
+//
+// Everything submitted before the call is included, even in channel mode where
+// Flush first waits for the metrics queued in the channels to be processed.
+// The current aggregation window is flushed as well. Flush does nothing once
+// the client is closed.
 func (c *Client) Flush() error {
 	if c == nil {
 		return ErrNoClient
 	}
+	if !c.waitForInputMetrics() {
+		return nil
+	}
+	c.flush()
+	return nil
+}
+
+// waitForInputMetrics blocks until the metrics queued in channel mode before the call have been processed. It returns
+// false once the client is closed.
+//
+// The waits are serialized: the markers of concurrent calls would otherwise be taken by the goroutines of the
+// aggregator in any order, each of them waiting for markers taken by the others. Close takes the same lock before
+// stopping the goroutines so the markers of a wait in progress are always consumed.
+func (c *Client) waitForInputMetrics() bool {
+	c.inputLock.Lock()
+	defer c.inputLock.Unlock()
+	select {
+	case <-c.stop:
+		return false
+	default:
+	}
+
+	if c.agg != nil && c.aggregatorMode == channelMode && c.agg.inputMetrics != nil {
+		c.agg.waitForInputMetrics()
+	}
+	if c.workersMode == channelMode {
+		for _, w := range c.workers {
+			w.waitForInputMetrics()
+		}
+	}
+	return true
+}
+
+// flush writes the aggregated metrics and the buffers of the workers to the transport. Unlike Flush, it doesn't wait
+// for the metrics queued in channel mode since Close stops the goroutines processing them first.
+func (c *Client) flush() {
 	if c.agg != nil {
 		c.agg.flush()
 	}
@@ -443,7 +848,46 @@ func (c *Client) Flush() error {
 	// Now that the worker are pause the sender can flush the queue between
 	// worker and senders
 	c.sender.flush()
-	return nil
+}
+
+// Drain flushes the client and blocks until every payload queued so far has been written to the transport. Unlike
+// Close, the client can still be used afterward, which is useful for graceful shutdowns of part of an application.
+//
+// Like Flush, Drain first waits for the metrics queued in the channels in channel mode to be processed. The error from
+// ctx is returned if it is done before the drain completes, the flush then goes on in the background (ex: when a worker
+// is blocked on a synchronous write). Drain does nothing once the client is closed since Close already flushes
+// everything.
+func (c *Client) Drain(ctx context.Context) error {
+	if c == nil {
+		return ErrNoClient
+	}
+
+	flushed := make(chan bool, 1)
+	go func() {
+		if !c.waitForInputMetrics() {
+			flushed <- false
+			return
+		}
+		if c.agg != nil {
+			c.agg.flush()
+		}
+		for _, w := range c.workers {
+			w.pause()
+			w.flushUnsafe()
+			w.unpause()
+		}
+		flushed <- true
+	}()
+
+	select {
+	case open := <-flushed:
+		if !open {
+			return nil
+		}
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return c.sender.drain(ctx)
 }
 
 func (c *Client) flushTelemetryMetrics(t *Telemetry) {
@@ -456,136 +900,853 @@ func (c *Client) flushTelemetryMetrics(t *Telemetry) {
 	t.TotalEvents = atomic.LoadUint64(&c.telemetry.totalEvents)
 	t.TotalServiceChecks = atomic.LoadUint64(&c.telemetry.totalServiceChecks)
 	t.TotalDroppedOnReceive = atomic.LoadUint64(&c.telemetry.totalDroppedOnReceive)
+	t.TotalDroppedOnReceiveTimeout = atomic.LoadUint64(&c.telemetry.totalDroppedOnTimeout)
+	t.TotalMetricsTooManyTags = atomic.LoadUint64(&c.telemetry.totalTooManyTags)
+	t.TotalMetricsDroppedOversized = atomic.LoadUint64(&c.telemetry.totalDroppedOversized)
+	t.TotalMetricsDroppedSerialization = atomic.LoadUint64(&c.telemetry.totalDroppedSerialization)
 }
 
-// GetTelemetry return the telemetry metrics for the client since it started.
+// GetTelemetry return the telemetry metrics for the client since it started, even when the client was created with
+// WithoutTelemetry.
 func (c *Client) GetTelemetry() Telemetry {
 	return c.telemetryClient.getTelemetry()
 }
 
+// DroppedMetrics returns the number of metrics, events and service checks dropped by the client since it started or
+// since the last call to ResetDroppedMetrics, whatever the cause: a full queue, a timeout, a failed write, the rate
+// limit of WithRateLimit or a failed serialization. The messages of the payloads dropped are counted individually.
+// The failures of the additional addresses of WithAdditionalAddresses are not counted.
+//
+// Unlike GetTelemetry it can be reset, for example by a health check reading the drops of each interval. The
+// telemetry is not affected by the reset.
+func (c *Client) DroppedMetrics() uint64 {
+	if c == nil {
+		return 0
+	}
+	return c.totalDropped() - atomic.LoadUint64(&c.telemetry.droppedAtReset)
+}
+
+// ResetDroppedMetrics resets the count returned by DroppedMetrics to 0. The drops happening between a call to
+// DroppedMetrics and the reset are not reported by either call.
+func (c *Client) ResetDroppedMetrics() {
+	if c == nil {
+		return
+	}
+	atomic.StoreUint64(&c.telemetry.droppedAtReset, c.totalDropped())
+}
+
+// totalDropped returns the number of messages dropped since the client started.
+func (c *Client) totalDropped() uint64 {
+	return atomic.LoadUint64(&c.telemetry.totalDroppedOnReceive) +
+		atomic.LoadUint64(&c.telemetry.totalDroppedOnTimeout) +
+		atomic.LoadUint64(&c.telemetry.totalDroppedOversized) +
+		atomic.LoadUint64(&c.telemetry.totalDroppedSerialization) +
+		atomic.LoadUint64(&c.sender.telemetry.totalMessagesDropped)
+}
+
+// BufferPoolStats returns the statistics of the pool of buffers used to serialize metrics, events and service checks.
+// It helps sizing WithBufferPoolSize: misses are buffers allocated because the pool was empty.
+func (c *Client) BufferPoolStats() BufferPoolStats {
+	if c == nil || c.bufferPool == nil {
+		return BufferPoolStats{}
+	}
+	return c.bufferPool.stats()
+}
+
 func (c *Client) send(m metric) error {
 	h := hashString32(m.name)
 	worker := c.workers[h%uint32(len(c.workers))]
 
 	if c.workersMode == channelMode {
-		select {
-		case worker.inputMetrics <- m:
-		default:
-			atomic.AddUint64(&c.telemetry.totalDroppedOnReceive, 1)
-		}
-		return nil
+		return c.enqueue(worker.inputMetrics, m)
 	}
 	return worker.processMetric(m)
 }
 
+// timerPool holds the timers used by enqueue so blocking on a full channel doesn't allocate a new timer each time.
+var timerPool = sync.Pool{
+	New: func() interface{} {
+		t := time.NewTimer(time.Hour)
+		t.Stop()
+		return t
+	},
+}
+
+// enqueue pushes a metric to the channel of a worker or the aggregator. The metric is dropped right away if the channel
+// is full unless a timeout was set through WithChannelModeTimeout, in which case we wait up to that timeout first.
+// ErrQueueFull is returned for a dropped metric when using WithChannelModeErrOnFull, nil otherwise.
+func (c *Client) enqueue(input chan metric, m metric) error {
+	select {
+	case input <- m:
+		return nil
+	default:
+	}
+
+	if c.channelTimeout == 0 {
+		atomic.AddUint64(&c.telemetry.totalDroppedOnReceive, 1)
+		c.errorReporter.report(m.name, ErrQueueFull, nil)
+		return c.queueFullErr()
+	}
+
+	timer := timerPool.Get().(*time.Timer)
+	timer.Reset(c.channelTimeout)
+	var err error
+	select {
+	case input <- m:
+		if !timer.Stop() {
+			// the timer fired while we were sending, drain it before putting it back in the pool
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+	case <-timer.C:
+		atomic.AddUint64(&c.telemetry.totalDroppedOnTimeout, 1)
+		c.errorReporter.report(m.name, ErrQueueFull, nil)
+		err = c.queueFullErr()
+	}
+	timerPool.Put(timer)
+	return err
+}
+
+// queueFullErr returns the error of a metric dropped because a channel is full, see WithChannelModeErrOnFull.
+func (c *Client) queueFullErr() error {
+	if c.errOnFullQueue {
+		return ErrQueueFull
+	}
+	return nil
+}
+
 // sendBlocking is used by the aggregator to inject aggregated metrics.
 func (c *Client) sendBlocking(m metric) error {
-	m.globalTags = c.tags
+	m.globalTags = c.getGlobalTags().metricTags
 	m.namespace = c.namespace
+	// The values were sampled before being aggregated, we must not sample
+	// them again even if they carry a rate.
+	return c.writeMetric(m)
+}
 
+// writeMetric writes a metric that must not be sampled to the worker of its name.
+func (c *Client) writeMetric(m metric) error {
 	h := hashString32(m.name)
 	worker := c.workers[h%uint32(len(c.workers))]
-	return worker.processMetric(m)
+	return worker.writeMetric(m)
 }
 
-func (c *Client) sendToAggregator(mType metricType, name string, value float64, tags []string, rate float64, f bufferedMetricSampleFunc) error {
+func (c *Client) sendToAggregator(mType metricType, name string, value float64, tags []string, rate float64, cardinality Cardinality, f bufferedMetricSampleFunc) error {
 	if c.aggregatorMode == channelMode {
-		select {
-		case c.aggExtended.inputMetrics <- metric{metricType: mType, name: name, fvalue: value, tags: tags, rate: rate}:
-		default:
-			atomic.AddUint64(&c.telemetry.totalDroppedOnReceive, 1)
+		return c.enqueue(c.agg.inputMetrics, metric{metricType: mType, name: name, fvalue: value, tags: tags, rate: rate, cardinality: cardinality})
+	}
+	return f(name, value, tags, rate, cardinality)
+}
+
+// resolveRate returns the rate configured for the metric type when rate is DefaultRate.
+func (c *Client) resolveRate(mType MetricType, rate float64) float64 {
+	if rate == DefaultRate {
+		if defaultRate, ok := c.defaultRates[mType]; ok {
+			return defaultRate
 		}
-		return nil
 	}
-	return f(name, value, tags, rate)
+	return rate
+}
+
+// resolveCardinality returns the cardinality configured through WithCardinality unless one of the parameters overrides
+// it.
+func (c *Client) resolveCardinality(parameters []Parameter) Cardinality {
+	m := metric{cardinality: c.cardinality}
+	for _, p := range parameters {
+		if p != nil {
+			p.apply(&m)
+		}
+	}
+	return m.cardinality
+}
+
+// resolveContainerID returns the container ID given to a call through the ContainerID parameter, or an empty string
+// to use the one of the client.
+func (c *Client) resolveContainerID(parameters []Parameter) string {
+	m := metric{}
+	for _, p := range parameters {
+		if p != nil {
+			p.apply(&m)
+		}
+	}
+	return m.containerID
+}
+
+// resolveNamespace returns the namespace given to a call through the Namespace parameter, or the one of the client.
+func (c *Client) resolveNamespace(parameters []Parameter) string {
+	m := metric{namespace: c.namespace}
+	for _, p := range parameters {
+		if p != nil {
+			p.apply(&m)
+		}
+	}
+	return m.namespace
 }
 
 // Gauge measures the value of a metric at a particular time.
-func (c *Client) Gauge(name string, value float64, tags []string, rate float64) error {
+func (c *Client) Gauge(name string, value float64, tags []string, rate float64, parameters ...Parameter) error {
 	if c == nil {
 		return ErrNoClient
 	}
+	tags = c.processTags(tags)
+	if err := c.validateMetric(name, tags); err != nil {
+		return err
+	}
+	rate = c.resolveRate(GaugeType, rate)
+	cardinality := c.resolveCardinality(parameters)
+	containerID := c.resolveContainerID(parameters)
+	namespace := c.resolveNamespace(parameters)
 	atomic.AddUint64(&c.telemetry.totalMetricsGauge, 1)
-	if c.agg != nil {
-		return c.agg.gauge(name, value, tags)
+	if c.agg != nil && containerID == "" && namespace == c.namespace {
+		return c.agg.gauge(name, value, tags, cardinality)
+	}
+	return c.send(metric{metricType: gauge, name: name, fvalue: value, tags: tags, rate: rate, globalTags: c.getGlobalTags().metricTags, namespace: namespace, cardinality: cardinality, containerID: containerID})
+}
+
+// GaugeWithTimestamp measures the value of a metric at a given time.
+//
+// The value will bypass any aggregation on the client side, this is useful when sending points in the past. A zero
+// timestamp falls back to the behavior of Gauge.
+func (c *Client) GaugeWithTimestamp(name string, value float64, tags []string, rate float64, timestamp time.Time, parameters ...Parameter) error {
+	if c == nil {
+		return ErrNoClient
+	}
+	if timestamp.IsZero() || timestamp.Unix() <= noTimestamp {
+		// the tags and the rate are processed by Gauge
+		return c.Gauge(name, value, tags, rate, parameters...)
+	}
+	tags = c.processTags(tags)
+	if err := c.validateMetric(name, tags); err != nil {
+		return err
+	}
+	rate = c.resolveRate(GaugeType, rate)
+	atomic.AddUint64(&c.telemetry.totalMetricsGauge, 1)
+	return c.send(metric{metricType: gauge, name: name, fvalue: value, tags: tags, rate: rate, globalTags: c.getGlobalTags().metricTags, namespace: c.resolveNamespace(parameters), timestamp: timestamp.Unix(), cardinality: c.resolveCardinality(parameters), containerID: c.resolveContainerID(parameters)})
+}
+
+// GaugeNegative sets a gauge to a negative value. A gauge value with a sign is read as a change of the gauge instead of
+// its new value, by DogStatsD as well as by plain statsd servers (see WithStatsdFormat): "name:-5|g" decrements the
+// gauge by 5. A negative value is therefore sent as a reset of the gauge to 0 followed by the value, "name:0|g" then
+// "name:-5|g", which sets it to -5. Other values are sent like with Gauge.
+//
+// The two messages bypass the client side aggregation, which would only keep the last one, and are written to the
+// same payload so they can't be reordered. The rate applies to both: either both are sent or none of them.
+func (c *Client) GaugeNegative(name string, value float64, tags []string, rate float64, parameters ...Parameter) error {
+	if c == nil {
+		return ErrNoClient
+	}
+	if value >= 0 {
+		return c.Gauge(name, value, tags, rate, parameters...)
+	}
+	tags = c.processTags(tags)
+	if err := c.validateMetric(name, tags); err != nil {
+		return err
+	}
+	rate = c.resolveRate(GaugeType, rate)
+	namespace := c.resolveNamespace(parameters)
+	cardinality := c.resolveCardinality(parameters)
+	containerID := c.resolveContainerID(parameters)
+	atomic.AddUint64(&c.telemetry.totalMetricsGauge, 2)
+
+	w := c.getBatchWorker()
+	defer c.putBatchWorker(w)
+	if !sampleWith(w.sampler, name, tags, rate, w.random, &w.randomLock) {
+		return nil
 	}
-	return c.send(metric{metricType: gauge, name: name, fvalue: value, tags: tags, rate: rate, globalTags: c.tags, namespace: c.namespace})
+	globalTags := c.getGlobalTags().metricTags
+	err := w.writeMetric(metric{metricType: gauge, name: name, fvalue: 0, tags: tags, rate: rate, globalTags: globalTags, namespace: namespace, cardinality: cardinality, containerID: containerID})
+	if err == nil {
+		err = w.writeMetric(metric{metricType: gauge, name: name, fvalue: value, tags: tags, rate: rate, globalTags: globalTags, namespace: namespace, cardinality: cardinality, containerID: containerID})
+	}
+	w.flush()
+	return err
+}
+
+// ErrGaugeDeltaWithoutAggregation is returned by GaugeDelta when the client side aggregation is disabled.
+var ErrGaugeDeltaWithoutAggregation = errors.New("statsd gauge deltas require the client side aggregation")
+
+// GaugeDelta adds delta to the value of a gauge, negative deltas decrement it. The client keeps the running value of
+// each name and tags, starting at 0, and sends it as a regular gauge at the end of each aggregation interval in which
+// it changed. The rate is ignored since the value is aggregated.
+//
+// GaugeDelta requires the client side aggregation, ErrGaugeDeltaWithoutAggregation is returned otherwise. The running
+// values are kept for the lifetime of the client: Gauge and GaugeDelta should not be mixed for the same name and tags.
+func (c *Client) GaugeDelta(name string, delta float64, tags []string, rate float64, parameters ...Parameter) error {
+	if c == nil {
+		return ErrNoClient
+	}
+	if c.agg == nil {
+		return ErrGaugeDeltaWithoutAggregation
+	}
+	tags = c.processTags(tags)
+	if err := c.validateMetric(name, tags); err != nil {
+		return err
+	}
+	atomic.AddUint64(&c.telemetry.totalMetricsGauge, 1)
+	return c.agg.gaugeDelta(name, delta, tags, c.resolveCardinality(parameters))
 }
 
 // Count tracks how many times something happened per second.
-func (c *Client) Count(name string, value int64, tags []string, rate float64) error {
+//
+// With client side aggregation, counts are not sampled: every call is added to the aggregated value, which is sent
+// with a rate of 1 so it holds the true count and the Agent doesn't upscale it. Without aggregation the calls are
+// sampled and the rate is sent along with them for the Agent to upscale the values.
+func (c *Client) Count(name string, value int64, tags []string, rate float64, parameters ...Parameter) error {
 	if c == nil {
 		return ErrNoClient
 	}
+	tags = c.processTags(tags)
+	if err := c.validateMetric(name, tags); err != nil {
+		return err
+	}
+	rate = c.resolveRate(CountType, rate)
+	cardinality := c.resolveCardinality(parameters)
+	containerID := c.resolveContainerID(parameters)
+	namespace := c.resolveNamespace(parameters)
 	atomic.AddUint64(&c.telemetry.totalMetricsCount, 1)
-	if c.agg != nil {
-		return c.agg.count(name, value, tags)
+	if c.agg != nil && containerID == "" && namespace == c.namespace {
+		return c.agg.count(name, value, tags, cardinality)
 	}
-	return c.send(metric{metricType: count, name: name, ivalue: value, tags: tags, rate: rate, globalTags: c.tags, namespace: c.namespace})
+	return c.send(metric{metricType: count, name: name, ivalue: value, tags: tags, rate: rate, globalTags: c.getGlobalTags().metricTags, namespace: namespace, cardinality: cardinality, containerID: containerID})
+}
+
+// CountWithTimestamp tracks how many times something happened at the given second.
+//
+// This is useful when sending points in the past. When client side aggregation is enabled the points of the same
+// second are summed and sent as one point with that timestamp, the points of different seconds are kept apart. A zero
+// timestamp falls back to the behavior of Count.
+func (c *Client) CountWithTimestamp(name string, value int64, tags []string, rate float64, timestamp time.Time, parameters ...Parameter) error {
+	if c == nil {
+		return ErrNoClient
+	}
+	if timestamp.IsZero() || timestamp.Unix() <= noTimestamp {
+		// the tags and the rate are processed by Count
+		return c.Count(name, value, tags, rate, parameters...)
+	}
+	tags = c.processTags(tags)
+	if err := c.validateMetric(name, tags); err != nil {
+		return err
+	}
+	rate = c.resolveRate(CountType, rate)
+	cardinality := c.resolveCardinality(parameters)
+	containerID := c.resolveContainerID(parameters)
+	namespace := c.resolveNamespace(parameters)
+	atomic.AddUint64(&c.telemetry.totalMetricsCount, 1)
+	if c.agg != nil && containerID == "" && namespace == c.namespace {
+		return c.agg.countWithTimestamp(name, value, tags, timestamp.Unix(), cardinality)
+	}
+	return c.send(metric{metricType: count, name: name, ivalue: value, tags: tags, rate: rate, globalTags: c.getGlobalTags().metricTags, namespace: namespace, timestamp: timestamp.Unix(), cardinality: cardinality, containerID: containerID})
+}
+
+// MonotonicCount tracks a counter that only ever increases, like the number of bytes sent by an interface since boot.
+// The client keeps the last value for each name and tags and submits the difference with the new value as a Count.
+//
+// Nothing is submitted for the first value of a name and tags or when the value is lower than the previous one, since
+// this means the counter was reset. Counts are integers: fractional parts are carried over to the next value. The
+// client keeps the last value of every name and tags for its whole lifetime.
+//
+// Without client side aggregation the values are sampled before the difference is computed: a value dropped by
+// sampling is part of the next difference submitted, which holds the true count and is sent with a rate of 1.
+func (c *Client) MonotonicCount(name string, value float64, tags []string, rate float64, parameters ...Parameter) error {
+	if c == nil {
+		return ErrNoClient
+	}
+	tags = c.processTags(tags)
+	if err := c.validateMetric(name, tags); err != nil {
+		return err
+	}
+	rate = c.resolveRate(CountType, rate)
+	cardinality := c.resolveCardinality(parameters)
+	containerID := c.resolveContainerID(parameters)
+	namespace := c.resolveNamespace(parameters)
+	aggregated := c.agg != nil && containerID == "" && namespace == c.namespace
+	if !aggregated {
+		w := c.workers[hashString32(name)%uint32(len(c.workers))]
+		if !sampleWith(w.sampler, name, tags, rate, w.random, &w.randomLock) {
+			return nil
+		}
+	}
+	delta, ok := c.monotonicCounts.delta(getContext(name, tags), value)
+	if !ok {
+		return nil
+	}
+	atomic.AddUint64(&c.telemetry.totalMetricsCount, 1)
+	if aggregated {
+		return c.agg.count(name, delta, tags, cardinality)
+	}
+	return c.send(metric{metricType: count, name: name, ivalue: delta, tags: tags, rate: 1, globalTags: c.getGlobalTags().metricTags, namespace: namespace, cardinality: cardinality, containerID: containerID})
 }
 
 // Histogram tracks the statistical distribution of a set of values on each host.
-func (c *Client) Histogram(name string, value float64, tags []string, rate float64) error {
+func (c *Client) Histogram(name string, value float64, tags []string, rate float64, parameters ...Parameter) error {
 	if c == nil {
 		return ErrNoClient
 	}
+	tags = c.processTags(tags)
+	if err := c.validateMetric(name, tags); err != nil {
+		return err
+	}
+	rate = c.resolveRate(HistogramType, rate)
+	cardinality := c.resolveCardinality(parameters)
+	containerID := c.resolveContainerID(parameters)
+	namespace := c.resolveNamespace(parameters)
 	atomic.AddUint64(&c.telemetry.totalMetricsHistogram, 1)
-	if c.aggExtended != nil {
-		return c.sendToAggregator(histogram, name, value, tags, rate, c.aggExtended.histogram)
+	if c.aggExtended != nil && containerID == "" && namespace == c.namespace {
+		return c.sendToAggregator(histogram, name, value, tags, rate, cardinality, c.aggExtended.histogram)
 	}
-	return c.send(metric{metricType: histogram, name: name, fvalue: value, tags: tags, rate: rate, globalTags: c.tags, namespace: c.namespace})
+	return c.send(metric{metricType: histogram, name: name, fvalue: value, tags: tags, rate: rate, globalTags: c.getGlobalTags().metricTags, namespace: namespace, cardinality: cardinality, containerID: containerID})
 }
 
 // Distribution tracks the statistical distribution of a set of values across your infrastructure.
-func (c *Client) Distribution(name string, value float64, tags []string, rate float64) error {
+func (c *Client) Distribution(name string, value float64, tags []string, rate float64, parameters ...Parameter) error {
 	if c == nil {
 		return ErrNoClient
 	}
+	tags = c.processTags(tags)
+	if err := c.validateMetric(name, tags); err != nil {
+		return err
+	}
+	rate = c.resolveRate(DistributionType, rate)
+	cardinality := c.resolveCardinality(parameters)
+	containerID := c.resolveContainerID(parameters)
+	namespace := c.resolveNamespace(parameters)
 	atomic.AddUint64(&c.telemetry.totalMetricsDistribution, 1)
-	if c.aggExtended != nil {
-		return c.sendToAggregator(distribution, name, value, tags, rate, c.aggExtended.distribution)
+	if c.aggExtended != nil && containerID == "" && namespace == c.namespace {
+		return c.sendToAggregator(distribution, name, value, tags, rate, cardinality, c.aggExtended.distribution)
+	}
+	return c.send(metric{metricType: distribution, name: name, fvalue: value, tags: tags, rate: rate, globalTags: c.getGlobalTags().metricTags, namespace: namespace, cardinality: cardinality, containerID: containerID})
+}
+
+// newTagFilter combines the filter and denylist set through WithTagFilter and WithTagDenylist. It returns nil when
+// neither is set so the filtering can be skipped entirely.
+func newTagFilter(filter func(tag string) bool, denylist []*regexp.Regexp) func(tag string) bool {
+	if filter == nil && len(denylist) == 0 {
+		return nil
+	}
+	return func(tag string) bool {
+		if filter != nil && !filter(tag) {
+			return false
+		}
+		for _, re := range denylist {
+			if re.MatchString(tag) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// filterTags removes the tags rejected by WithTagFilter and WithTagDenylist, preserving the order of the others. The
+// slice is only copied when a tag is removed, the caller's slice is never modified.
+func (c *Client) filterTags(tags []string) []string {
+	if c.keepTag == nil {
+		return tags
+	}
+	for i, tag := range tags {
+		if c.keepTag(tag) {
+			continue
+		}
+		filtered := make([]string, i, len(tags)-1)
+		copy(filtered, tags[:i])
+		for _, tag := range tags[i+1:] {
+			if c.keepTag(tag) {
+				filtered = append(filtered, tag)
+			}
+		}
+		return filtered
+	}
+	return tags
+}
+
+// globalTags are the tags added to every metric, event and service check sent by a client.
+type globalTags struct {
+	// tags are sent with events and service checks. They are merged into the tags of each metric when deduplicating or
+	// sorting tags.
+	tags []string
+	// metricTags are sent with metrics, they are nil when the global tags are merged into the tags of each metric.
+	metricTags []string
+}
+
+// getGlobalTags returns the current global tags of the client. They are loaded atomically so metrics sent concurrently
+// with SetGlobalTags carry either the previous or the new tags, never a mix of both.
+func (c *Client) getGlobalTags() *globalTags {
+	return c.globalTags.Load().(*globalTags)
+}
+
+// newGlobalTags returns the global tags for the tags given to WithTags or SetGlobalTags: the values of the DD_*
+// environment variables and the host tag of WithConstantHostname are added, then WithTagNormalization,
+// WithTagDeduplication, WithTagFilter, WithTagDenylist and WithSortedTags are applied.
+func (c *Client) newGlobalTags(tags []string) *globalTags {
+	// the tags are copied so the caller can reuse its slice
+	tags = append([]string(nil), tags...)
+	// Inject values of DD_* environment variables as global tags.
+	if c.unifiedTagging {
+		tags = withUnifiedServiceTags(tags)
+	} else {
+		for _, mapping := range ddEnvTagsMapping {
+			if value := os.Getenv(mapping.envName); value != "" {
+				tags = append(tags, fmt.Sprintf("%s:%s", mapping.tagName, value))
+			}
+		}
+	}
+	if c.hostname != "" {
+		tags = withHostTag(tags, c.hostname)
+	}
+	if c.tagNormalize {
+		tags = normalizeTags(tags)
+	}
+	if c.maxTagValueLen != 0 {
+		tags = truncateTagValues(tags, c.maxTagValueLen, c.tagMarker)
+	}
+	if c.tagDedup {
+		tags = dedupTags(nil, tags)
+	}
+	tags = c.filterTags(tags)
+	if c.tagSort {
+		tags = sortTags(nil, tags)
+	}
+
+	g := &globalTags{tags: tags}
+	if !c.tagDedup && !c.tagSort {
+		g.metricTags = tags
+	}
+	return g
+}
+
+// SetGlobalTags replaces the tags given to WithTags without recreating the client, for example when the version of a
+// service changes after a configuration reload. The tags are processed like the ones of WithTags: the DD_*
+// environment variables and WithConstantHostname are applied again.
+//
+// The swap is atomic: a metric sent concurrently carries either all the previous global tags or all the new ones.
+// Aggregated metrics may be flushed with either, and the tags of the client telemetry are not updated.
+func (c *Client) SetGlobalTags(tags []string) {
+	if c == nil {
+		return
+	}
+	c.globalTags.Store(c.newGlobalTags(tags))
+}
+
+// processTags applies WithTagNormalization, WithTagDeduplication, WithTagFilter, WithTagDenylist, WithSortedTags and
+// the MaxTagsTruncate strategy of WithMaxTagsPerMetric, in that order, to the tags given to a metric call.
+func (c *Client) processTags(tags []string) []string {
+	global := c.getGlobalTags()
+	if c.tagInterner != nil {
+		tags = c.tagInterner.internTags(tags)
+	} else if c.tagNormalize {
+		tags = normalizeTags(tags)
+	}
+	if c.maxTagValueLen != 0 {
+		tags = truncateTagValues(tags, c.maxTagValueLen, c.tagMarker)
+	}
+	if c.tagDedup {
+		tags = dedupTags(global.tags, tags)
+	}
+	tags = c.filterTags(tags)
+	if c.tagSort {
+		if c.tagDedup {
+			// the global tags are already merged
+			tags = sortTags(nil, tags)
+		} else {
+			tags = sortTags(global.tags, tags)
+		}
+	}
+	return c.truncateTags(global.metricTags, tags)
+}
+
+// truncateTags removes the last tags of a metric over the limit set by WithMaxTagsPerMetric when using
+// MaxTagsTruncate. The global tags sent along with the metric (metricTags) are never removed.
+func (c *Client) truncateTags(metricTags []string, tags []string) []string {
+	if c.maxTags == 0 || c.maxTagsStrategy != MaxTagsTruncate || len(metricTags)+len(tags) <= c.maxTags {
+		return tags
+	}
+	atomic.AddUint64(&c.telemetry.totalTooManyTags, 1)
+	keep := c.maxTags - len(metricTags)
+	if keep < 0 {
+		keep = 0
+	}
+	// the capacity is reduced too so appending to the result can't overwrite the caller's slice
+	return tags[:keep:keep]
+}
+
+// DistributionSamples is similar to Distribution but sends a batch of values at once.
+//
+// The values are serialized together as a single packed message (ex: "name:1.1:2.2:3.3|d") that will be split across
+// multiple payloads if needed. The rate applies to the whole batch: either all the values are sent or none of them. The
+// values slice must not be modified after the call since it might be serialized asynchronously when using
+// WithChannelMode. Client side aggregation is not used for these values.
+func (c *Client) DistributionSamples(name string, values []float64, tags []string, rate float64, parameters ...Parameter) error {
+	if c == nil {
+		return ErrNoClient
+	}
+	tags = c.processTags(tags)
+	if err := c.validateMetric(name, tags); err != nil {
+		return err
+	}
+	rate = c.resolveRate(DistributionType, rate)
+	if len(values) == 0 {
+		return nil
 	}
-	return c.send(metric{metricType: distribution, name: name, fvalue: value, tags: tags, rate: rate, globalTags: c.tags, namespace: c.namespace})
+	atomic.AddUint64(&c.telemetry.totalMetricsDistribution, uint64(len(values)))
+	return c.send(metric{metricType: distributionAggregated, name: name, fvalues: values, tags: tags, stags: strings.Join(tags, tagSeparatorSymbol), rate: rate, globalTags: c.getGlobalTags().metricTags, namespace: c.resolveNamespace(parameters), cardinality: c.resolveCardinality(parameters), containerID: c.resolveContainerID(parameters)})
 }
 
-// Decr is just Count of -1
-func (c *Client) Decr(name string, tags []string, rate float64) error {
-	return c.Count(name, -1, tags, rate)
+// MaxHistogramCountsSamples is the maximum number of values HistogramCounts expands counts into.
+const MaxHistogramCountsSamples = 1 << 16
+
+// ErrTooManySamples is returned by HistogramCounts when the counts add up to more than MaxHistogramCountsSamples.
+var ErrTooManySamples = errors.New("statsd histogram counts exceed the maximum number of samples")
+
+// HistogramCounts is similar to Histogram but sends values that were already counted: each value of counts is sent as
+// many times as its count, values with a count lower than 1 are ignored.
+//
+// The values are expanded and serialized together as a single packed message in increasing order (ex:
+// "name:1.2:1.2:3.4|h"), split across multiple payloads if needed like with DistributionSamples. The rate applies to
+// the whole batch and client side aggregation is not used for these values. Since DogStatsD has no notion of a count
+// per value the expansion is bounded: ErrTooManySamples is returned, and nothing is sent, when the counts add up to
+// more than MaxHistogramCountsSamples.
+func (c *Client) HistogramCounts(name string, counts map[float64]int, tags []string, rate float64, parameters ...Parameter) error {
+	if c == nil {
+		return ErrNoClient
+	}
+	tags = c.processTags(tags)
+	if err := c.validateMetric(name, tags); err != nil {
+		return err
+	}
+	rate = c.resolveRate(HistogramType, rate)
+
+	total := 0
+	for _, count := range counts {
+		if count < 1 {
+			continue
+		}
+		if count > MaxHistogramCountsSamples-total {
+			return ErrTooManySamples
+		}
+		total += count
+	}
+	if total == 0 {
+		return nil
+	}
+	values := make([]float64, 0, total)
+	for value, count := range counts {
+		for i := 0; i < count; i++ {
+			values = append(values, value)
+		}
+	}
+	sort.Float64s(values)
+
+	atomic.AddUint64(&c.telemetry.totalMetricsHistogram, uint64(total))
+	return c.send(metric{metricType: histogramAggregated, name: name, fvalues: values, tags: tags, stags: strings.Join(tags, tagSeparatorSymbol), rate: rate, globalTags: c.getGlobalTags().metricTags, namespace: c.resolveNamespace(parameters), cardinality: c.resolveCardinality(parameters), containerID: c.resolveContainerID(parameters)})
+}
+
+// Add is just Count of delta, which can be negative.
+func (c *Client) Add(name string, delta int64, tags []string, rate float64, parameters ...Parameter) error {
+	return c.Count(name, delta, tags, rate, parameters...)
 }
 
-// Incr is just Count of 1
-func (c *Client) Incr(name string, tags []string, rate float64) error {
-	return c.Count(name, 1, tags, rate)
+// Decr is just Add of -1
+func (c *Client) Decr(name string, tags []string, rate float64, parameters ...Parameter) error {
+	return c.Add(name, -1, tags, rate, parameters...)
+}
+
+// Incr is just Add of 1
+func (c *Client) Incr(name string, tags []string, rate float64, parameters ...Parameter) error {
+	return c.Add(name, 1, tags, rate, parameters...)
 }
 
 // Set counts the number of unique elements in a group.
-func (c *Client) Set(name string, value string, tags []string, rate float64) error {
+func (c *Client) Set(name string, value string, tags []string, rate float64, parameters ...Parameter) error {
 	if c == nil {
 		return ErrNoClient
 	}
+	tags = c.processTags(tags)
+	if err := c.validateMetric(name, tags); err != nil {
+		return err
+	}
+	rate = c.resolveRate(SetType, rate)
+	cardinality := c.resolveCardinality(parameters)
+	containerID := c.resolveContainerID(parameters)
+	namespace := c.resolveNamespace(parameters)
 	atomic.AddUint64(&c.telemetry.totalMetricsSet, 1)
-	if c.agg != nil {
-		return c.agg.set(name, value, tags)
+	if c.agg != nil && containerID == "" && namespace == c.namespace {
+		return c.agg.set(name, value, tags, cardinality)
 	}
-	return c.send(metric{metricType: set, name: name, svalue: value, tags: tags, rate: rate, globalTags: c.tags, namespace: c.namespace})
+	return c.send(metric{metricType: set, name: name, svalue: value, tags: tags, rate: rate, globalTags: c.getGlobalTags().metricTags, namespace: namespace, cardinality: cardinality, containerID: containerID})
+}
+
+// SetInt counts the number of unique integers in a group. It produces the same metric as Set with the integer
+// formatted in base 10 but, without client side aggregation, the value is written directly in the payload instead of
+// being converted to a string first.
+//
+// Client side aggregation keeps the unique values of a set as strings so integers are still converted when it is
+// enabled.
+func (c *Client) SetInt(name string, value int64, tags []string, rate float64, parameters ...Parameter) error {
+	if c == nil {
+		return ErrNoClient
+	}
+	tags = c.processTags(tags)
+	if err := c.validateMetric(name, tags); err != nil {
+		return err
+	}
+	rate = c.resolveRate(SetType, rate)
+	cardinality := c.resolveCardinality(parameters)
+	containerID := c.resolveContainerID(parameters)
+	namespace := c.resolveNamespace(parameters)
+	atomic.AddUint64(&c.telemetry.totalMetricsSet, 1)
+	if c.agg != nil && containerID == "" && namespace == c.namespace {
+		return c.agg.set(name, strconv.FormatInt(value, 10), tags, cardinality)
+	}
+	return c.send(metric{metricType: setInt, name: name, ivalue: value, tags: tags, rate: rate, globalTags: c.getGlobalTags().metricTags, namespace: namespace, cardinality: cardinality, containerID: containerID})
+}
+
+// SetMulti adds several unique elements to a set in one call. Duplicated values are only submitted once. DogStatsD
+// doesn't pack set values like the ones of DistributionSamples: one message is still written per value, but they are
+// all written through a single buffer private to the call instead of taking the lock of a worker for each of them.
+//
+// The rate applies to the whole call: either all the values are sent or none of them. With client side aggregation the
+// values are added to the aggregated set at once and the rate is ignored, like with Set.
+func (c *Client) SetMulti(name string, values []string, tags []string, rate float64, parameters ...Parameter) error {
+	if c == nil {
+		return ErrNoClient
+	}
+	tags = c.processTags(tags)
+	if err := c.validateMetric(name, tags); err != nil {
+		return err
+	}
+	rate = c.resolveRate(SetType, rate)
+	values = uniqueValues(values)
+	if len(values) == 0 {
+		return nil
+	}
+	cardinality := c.resolveCardinality(parameters)
+	containerID := c.resolveContainerID(parameters)
+	namespace := c.resolveNamespace(parameters)
+	atomic.AddUint64(&c.telemetry.totalMetricsSet, uint64(len(values)))
+	if c.agg != nil && containerID == "" && namespace == c.namespace {
+		return c.agg.setMulti(name, values, tags, cardinality)
+	}
+
+	w := c.getBatchWorker()
+	defer c.putBatchWorker(w)
+	if !sampleWith(w.sampler, name, tags, rate, w.random, &w.randomLock) {
+		return nil
+	}
+	globalTags := c.getGlobalTags().metricTags
+	var err error
+	for _, v := range values {
+		if werr := w.writeMetric(metric{metricType: set, name: name, svalue: v, tags: tags, rate: rate, globalTags: globalTags, namespace: namespace, cardinality: cardinality, containerID: containerID}); werr != nil && err == nil {
+			err = werr
+		}
+	}
+	w.flush()
+	return err
+}
+
+// uniqueValues returns values without their duplicates, keeping the first occurrence of each.
+func uniqueValues(values []string) []string {
+	seen := make(map[string]struct{}, len(values))
+	unique := make([]string, 0, len(values))
+	for _, v := range values {
+		if _, found := seen[v]; !found {
+			seen[v] = struct{}{}
+			unique = append(unique, v)
+		}
+	}
+	return unique
 }
 
 // Timing sends timing information, it is an alias for TimeInMilliseconds
-func (c *Client) Timing(name string, value time.Duration, tags []string, rate float64) error {
-	return c.TimeInMilliseconds(name, value.Seconds()*1000, tags, rate)
+func (c *Client) Timing(name string, value time.Duration, tags []string, rate float64, parameters ...Parameter) error {
+	return c.TimeInMilliseconds(name, value.Seconds()*1000, tags, rate, parameters...)
 }
 
 // TimeInMilliseconds sends timing information in milliseconds.
 // It is flushed by statsd with percentiles, mean and other info (https://github.com/etsy/statsd/blob/master/docs/metric_types.md#timing)
-func (c *Client) TimeInMilliseconds(name string, value float64, tags []string, rate float64) error {
+func (c *Client) TimeInMilliseconds(name string, value float64, tags []string, rate float64, parameters ...Parameter) error {
 	if c == nil {
 		return ErrNoClient
 	}
+	tags = c.processTags(tags)
+	if err := c.validateMetric(name, tags); err != nil {
+		return err
+	}
+	rate = c.resolveRate(TimingType, rate)
+	cardinality := c.resolveCardinality(parameters)
+	containerID := c.resolveContainerID(parameters)
+	namespace := c.resolveNamespace(parameters)
 	atomic.AddUint64(&c.telemetry.totalMetricsTiming, 1)
-	if c.aggExtended != nil {
-		return c.sendToAggregator(timing, name, value, tags, rate, c.aggExtended.timing)
+	if c.aggTiming != nil && containerID == "" && namespace == c.namespace {
+		return c.sendToAggregator(timing, name, value, tags, rate, cardinality, c.aggTiming.timing)
+	}
+	return c.send(metric{metricType: timing, name: name, fvalue: value, tags: tags, rate: rate, globalTags: c.getGlobalTags().metricTags, namespace: namespace, cardinality: cardinality, containerID: containerID})
+}
+
+// TimedBlock returns a function sending the time elapsed since the call to TimedBlock as a timing. It is meant to be
+// deferred at the start of the block to measure:
+//
+//	defer client.TimedBlock("request.duration", tags, 1)()
+//
+// The returned function is a no-op on a nil client.
+func (c *Client) TimedBlock(name string, tags []string, rate float64) func() {
+	if c == nil {
+		return func() {}
+	}
+	start := c.clock.Now()
+	return func() {
+		c.Timing(name, c.clock.Now().Sub(start), tags, rate)
 	}
-	return c.send(metric{metricType: timing, name: name, fvalue: value, tags: tags, rate: rate, globalTags: c.tags, namespace: c.namespace})
+}
+
+// TimedContext is TimedBlock with a "context_error" tag added to the timing when ctx is done once the block completes:
+// "context_error:canceled" or "context_error:deadline_exceeded".
+func (c *Client) TimedContext(ctx context.Context, name string, tags []string, rate float64) func() {
+	if c == nil {
+		return func() {}
+	}
+	start := c.clock.Now()
+	return func() {
+		switch ctx.Err() {
+		case context.Canceled:
+			// the capacity is capped so the caller's slice is never modified
+			tags = append(tags[:len(tags):len(tags)], "context_error:canceled")
+		case context.DeadlineExceeded:
+			tags = append(tags[:len(tags):len(tags)], "context_error:deadline_exceeded")
+		}
+		c.Timing(name, c.clock.Now().Sub(start), tags, rate)
+	}
+}
+
+// WriteRaw sends lines already formatted in the DogStatsD protocol. They are packed into payloads with the other
+// metrics but are sent as is: no namespace, global tags, sampling nor aggregation is applied. line can hold several
+// messages separated by '\n', each one is written separately so they are split across payloads when needed, the
+// trailing newline being optional. A message bigger than the payload size (see WithMaxBytesPerPayload) is dropped.
+//
+// The content is not validated, an invalid message is dropped by the Agent.
+func (c *Client) WriteRaw(line []byte) error {
+	if c == nil {
+		return ErrNoClient
+	}
+	var err error
+	for len(line) > 0 {
+		msg := line
+		if i := bytes.IndexByte(line, '\n'); i >= 0 {
+			msg, line = line[:i], line[i+1:]
+		} else {
+			line = nil
+		}
+		if len(msg) == 0 {
+			continue
+		}
+		// the message is copied since it is written asynchronously in channel mode
+		m := metric{metricType: raw, svalue: string(msg), rate: 1}
+		m.name = tagKey(m.svalue)
+		if e := c.send(m); e != nil {
+			err = e
+		}
+	}
+	return err
 }
 
 // Event sends the provided Event.
@@ -593,8 +1754,19 @@ func (c *Client) Event(e *Event) error {
 	if c == nil {
 		return ErrNoClient
 	}
+	if c.plainStatsd {
+		return ErrStatsdFormat
+	}
+	if err := c.validateEvent(e); err != nil {
+		return err
+	}
+	if e.Hostname == "" && c.hostname != "" {
+		withHostname := *e
+		withHostname.Hostname = c.hostname
+		e = &withHostname
+	}
 	atomic.AddUint64(&c.telemetry.totalEvents, 1)
-	return c.send(metric{metricType: event, evalue: e, rate: 1, globalTags: c.tags, namespace: c.namespace})
+	return c.send(metric{metricType: event, evalue: e, rate: 1, globalTags: c.getGlobalTags().tags, namespace: c.namespace, cardinality: c.cardinality})
 }
 
 // SimpleEvent sends an event with the provided title and text.
@@ -608,8 +1780,19 @@ func (c *Client) ServiceCheck(sc *ServiceCheck) error {
 	if c == nil {
 		return ErrNoClient
 	}
+	if c.plainStatsd {
+		return ErrStatsdFormat
+	}
+	if err := c.validateServiceCheck(sc); err != nil {
+		return err
+	}
+	if sc.Hostname == "" && c.hostname != "" {
+		withHostname := *sc
+		withHostname.Hostname = c.hostname
+		sc = &withHostname
+	}
 	atomic.AddUint64(&c.telemetry.totalServiceChecks, 1)
-	return c.send(metric{metricType: serviceCheck, scvalue: sc, rate: 1, globalTags: c.tags, namespace: c.namespace})
+	return c.send(metric{metricType: serviceCheck, scvalue: sc, rate: 1, globalTags: c.getGlobalTags().tags, namespace: c.namespace, cardinality: c.cardinality})
 }
 
 // SimpleServiceCheck sends an serviceCheck with the provided name and status.
@@ -620,6 +1803,13 @@ func (c *Client) SimpleServiceCheck(name string, status ServiceCheckStatus) erro
 
 // Close the client connection.
 func (c *Client) Close() error {
+	return c.CloseWithContext(context.Background())
+}
+
+// CloseWithContext is Close giving up once ctx is done, for example when a write to the transport blocks during the
+// final flush. ctx.Err() is then returned right away: the metrics not written yet are dropped and the transport is
+// closed in the background once the pending write returns.
+func (c *Client) CloseWithContext(ctx context.Context) error {
 	if c == nil {
 		return ErrNoClient
 	}
@@ -636,23 +1826,68 @@ func (c *Client) Close() error {
 	}
 	close(c.stop)
 
-	if c.workersMode == channelMode {
+	if c.signals != nil {
+		signal.Stop(c.signals)
+	}
+
+	// the final flush might block on a write so it is done in the background, the sender gives up when ctx is done
+	flushed := make(chan struct{})
+	go func() {
+		// a Flush waiting for the metrics queued in channel mode needs the goroutines to be running
+		c.inputLock.Lock()
+		if c.workersMode == channelMode {
+			for _, w := range c.workers {
+				w.stopReceivingMetric()
+			}
+		}
+
+		// flush the aggregator first
+		if c.agg != nil {
+			if c.aggTiming != nil && c.aggregatorMode == channelMode {
+				c.agg.stopReceivingMetric()
+			}
+			c.agg.stop()
+		}
+		c.inputLock.Unlock()
+
+		// Wait for the threads to stop
+		c.wg.Wait()
+
+		// the sender writes what the workers queued once stopped, see sender.closeContext
+		if c.agg != nil {
+			c.agg.flush()
+		}
 		for _, w := range c.workers {
-			w.stopReceivingMetric()
+			w.flush()
 		}
+		close(flushed)
+	}()
+
+	select {
+	case <-flushed:
+		return c.closeSender(ctx)
+	case <-ctx.Done():
+		// the workers might still be writing to their buffers: they are released once the final flush is done
+		go func() {
+			<-flushed
+			c.closeSender(ctx)
+		}()
+		return ctx.Err()
 	}
+}
 
-	// flush the aggregator first
-	if c.agg != nil {
-		if c.aggExtended != nil && c.aggregatorMode == channelMode {
-			c.agg.stopReceivingMetric()
+// closeSender closes the sender and releases every buffer: the ones of the pool and of the workers, and the ones
+// still queued if ctx is done. A buffer being written is released once the write returns. It must only be called once
+// the final flush is done.
+func (c *Client) closeSender(ctx context.Context) error {
+	err := c.sender.closeContext(ctx)
+	if c.bufferPool != nil {
+		c.bufferPool.close()
+		for _, w := range c.workers {
+			w.discardBuffer()
 		}
-		c.agg.stop()
+		c.sender.dropQueue()
 	}
-
-	// Wait for the threads to stop
-	c.wg.Wait()
-
-	c.Flush()
-	return c.sender.close()
+	c.errorReporter.close()
+	return err
 }
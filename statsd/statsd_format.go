@@ -0,0 +1,62 @@
+package statsd
+
+import (
+	"errors"
+	"strings"
+)
+
+// ErrStatsdFormat is returned by Event, ServiceCheck and DistributionSketch when using WithStatsdFormat: they are
+// DogStatsD extensions that plain statsd servers can't parse.
+var ErrStatsdFormat = errors.New("statsd events, service checks and sketches are not supported by the plain statsd format, see WithStatsdFormat")
+
+// statsdFormat serializes metrics for plain statsd servers, see WithStatsdFormat.
+type statsdFormat struct {
+	// tagJoiner is set by WithStatsdTagFolding. The tags are dropped when it is empty.
+	tagJoiner string
+}
+
+// metric returns the name and the rate to serialize a metric with: the tags are folded into the name when using
+// WithStatsdTagFolding and the rate is only kept when sampled, statsd only supporting it for counters and timers.
+// The tags are given either as a slice or, for aggregated metrics, as a string.
+func (f *statsdFormat) metric(name string, globalTags []string, tags []string, stags string, rate float64, sampled bool) (string, float64) {
+	if !sampled {
+		rate = 1
+	}
+	if f.tagJoiner == "" || (len(globalTags) == 0 && len(tags) == 0 && stags == "") {
+		return name, rate
+	}
+
+	var b strings.Builder
+	b.WriteString(name)
+	for _, tag := range globalTags {
+		f.foldTag(&b, tag)
+	}
+	for _, tag := range tags {
+		f.foldTag(&b, tag)
+	}
+	for stags != "" {
+		i := strings.Index(stags, tagSeparatorSymbol)
+		if i < 0 {
+			f.foldTag(&b, stags)
+			break
+		}
+		f.foldTag(&b, stags[:i])
+		stags = stags[i+len(tagSeparatorSymbol):]
+	}
+	return b.String(), rate
+}
+
+// foldTag appends tag to a metric name, the ':' separating its key from its value being replaced by the joiner
+// (ex: "env:prod" gives ".env.prod" with "."). Newlines are removed like in the tags of DogStatsD messages.
+func (f *statsdFormat) foldTag(b *strings.Builder, tag string) {
+	b.WriteString(f.tagJoiner)
+	for i := 0; i < len(tag); i++ {
+		switch tag[i] {
+		case ':':
+			b.WriteString(f.tagJoiner)
+		case '\n':
+		default:
+			b.WriteByte(tag[i])
+		}
+	}
+}
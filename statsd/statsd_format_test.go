@@ -0,0 +1,109 @@
+package statsd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatsdFormatMetric(t *testing.T) {
+	dropped := &statsdFormat{}
+	name, rate := dropped.metric("requests", []string{"env:prod"}, []string{"team:core"}, "", 0.5, true)
+	assert.Equal(t, "requests", name)
+	assert.Equal(t, 0.5, rate)
+
+	_, rate = dropped.metric("requests", nil, nil, "", 0.5, false)
+	assert.Equal(t, 1.0, rate)
+
+	folded := &statsdFormat{tagJoiner: "."}
+	name, _ = folded.metric("requests", []string{"env:prod"}, []string{"team:core", "canary", "url:http://a\n"}, "", 1, true)
+	assert.Equal(t, "requests.env.prod.team.core.canary.url.http.//a", name)
+
+	name, _ = folded.metric("requests", []string{"env:prod"}, nil, "team:core,canary", 1, true)
+	assert.Equal(t, "requests.env.prod.team.core.canary", name)
+
+	name, _ = folded.metric("requests", nil, nil, "", 1, true)
+	assert.Equal(t, "requests", name)
+}
+
+func TestClientStatsdFormat(t *testing.T) {
+	for _, options := range [][]Option{{}, {WithExtendedClientSideAggregation()}, {WithoutClientSideAggregation()}} {
+		w := statsdWriterWrapper{}
+		client, err := NewWithWriter(&w, append(options,
+			WithoutTelemetry(),
+			WithTags([]string{"env:prod"}),
+			WithStatsdFormat(),
+		)...)
+		require.Nil(t, err)
+
+		client.Gauge("gauge", 21, []string{"team:core"}, 1)
+		client.Count("count", 1, []string{"team:core"}, 1)
+		client.GaugeWithTimestamp("gauge_ts", 1, nil, 1, time.Unix(1700000000, 0))
+		client.Histogram("histogram", 1, []string{"team:core"}, 1)
+		client.Timing("timing", time.Second, []string{"team:core"}, 1)
+		client.Set("set", "value", []string{"team:core"}, 1)
+		client.Close()
+
+		ts := &testServer{}
+		ts.assertMetric(t, w.data, []string{
+			"gauge:21|g",
+			"count:1|c",
+			"gauge_ts:1|g",
+			"histogram:1|h",
+			"timing:1000.000000|ms",
+			"set:value|s",
+		})
+	}
+}
+
+func TestBufferStatsdFormat(t *testing.T) {
+	buffer := newStatsdBuffer(1024, 10)
+	buffer.statsd = &statsdFormat{}
+	buffer.writeCount("", nil, "count", 1, nil, 0.5, 0, CardinalityNotSet, "")
+	buffer.writeTiming("", nil, "timing", 1, nil, 0.5, CardinalityNotSet, "")
+	buffer.writeGauge("", nil, "gauge", 1, nil, 0.5, 0, CardinalityNotSet, "")
+	buffer.writeHistogram("", nil, "histogram", 1, nil, 0.5, CardinalityNotSet, "")
+	assert.Equal(t, "count:1|c|@0.5\ntiming:1.000000|ms|@0.5\ngauge:1|g\nhistogram:1|h\n", string(buffer.bytes()))
+}
+
+func TestClientStatsdFormatEvents(t *testing.T) {
+	w := statsdWriterWrapper{}
+	client, err := NewWithWriter(&w, WithoutTelemetry(), WithStatsdFormat())
+	require.Nil(t, err)
+
+	assert.Equal(t, ErrStatsdFormat, client.SimpleEvent("title", "text"))
+	assert.Equal(t, ErrStatsdFormat, client.SimpleServiceCheck("sc", Ok))
+	client.Close()
+	assert.Empty(t, w.data)
+}
+
+func TestClientStatsdTagFolding(t *testing.T) {
+	for _, options := range [][]Option{{}, {WithExtendedClientSideAggregation()}, {WithoutClientSideAggregation()}} {
+		w := statsdWriterWrapper{}
+		client, err := NewWithWriter(&w, append(options,
+			WithoutTelemetry(),
+			WithNamespace("app."),
+			WithTags([]string{"env:prod"}),
+			WithStatsdTagFolding("."),
+		)...)
+		require.Nil(t, err)
+
+		client.Gauge("gauge", 21, []string{"team:core"}, 1)
+		client.Count("count", 1, []string{"team:core"}, 1)
+		client.Count("count", 1, []string{"team:web"}, 1)
+		client.Distribution("distribution", 1, []string{"team:core", "canary"}, 1)
+		client.Set("set", "value", nil, 1)
+		client.Close()
+
+		ts := &testServer{}
+		ts.assertMetric(t, w.data, []string{
+			"app.gauge.env.prod.team.core:21|g",
+			"app.count.env.prod.team.core:1|c",
+			"app.count.env.prod.team.web:1|c",
+			"app.distribution.env.prod.team.core.canary:1|d",
+			"app.set.env.prod:value|s",
+		})
+	}
+}
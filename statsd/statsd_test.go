@@ -1,10 +1,17 @@
 package statsd
 
 import (
+	"bytes"
+	"context"
 	"fmt"
+	"net"
 	"os"
+	"regexp"
+	"runtime"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -25,14 +32,21 @@ func TestNilError(t *testing.T) {
 	var c *Client
 	tests := []func() error{
 		func() error { return c.Flush() },
+		func() error { return c.Drain(context.Background()) },
 		func() error { return c.Close() },
 		func() error { return c.Count("", 0, nil, 1) },
+		func() error { return c.CountWithTimestamp("", 0, nil, 1, time.Now()) },
+		func() error { return c.MonotonicCount("", 0, nil, 1) },
+		func() error { return c.Add("", 0, nil, 1) },
 		func() error { return c.Incr("", nil, 1) },
 		func() error { return c.Decr("", nil, 1) },
 		func() error { return c.Histogram("", 0, nil, 1) },
 		func() error { return c.Distribution("", 0, nil, 1) },
+		func() error { return c.DistributionSamples("", []float64{0}, nil, 1) },
 		func() error { return c.Gauge("", 0, nil, 1) },
+		func() error { return c.GaugeWithTimestamp("", 0, nil, 1, time.Now()) },
 		func() error { return c.Set("", "", nil, 1) },
+		func() error { return c.SetInt("", 0, nil, 1) },
 		func() error { return c.Timing("", time.Second, nil, 1) },
 		func() error { return c.TimeInMilliseconds("", 1, nil, 1) },
 		func() error { return c.Event(NewEvent("", "")) },
@@ -89,6 +103,644 @@ func TestNewWithWriter(t *testing.T) {
 	ts.assertMetric(t, w.data, expected)
 }
 
+func TestTransportDefaultSizes(t *testing.T) {
+	for _, tc := range []struct {
+		writerName      string
+		maxBytes        int
+		bufferPoolSize  int
+		senderQueueSize int
+	}{
+		{writerNameUDP, OptimalUDPPayloadSize, DefaultUDPBufferPoolSize, DefaultUDPBufferPoolSize},
+		{writerNameUDS, DefaultMaxAgentPayloadSize, DefaultUDSBufferPoolSize, DefaultUDSBufferPoolSize},
+		{writerWindowsPipe, DefaultMaxAgentPayloadSize, DefaultUDSBufferPoolSize, DefaultUDSBufferPoolSize},
+	} {
+		t.Run(tc.writerName, func(t *testing.T) {
+			o, err := resolveOptions([]Option{WithoutTelemetry()})
+			require.Nil(t, err)
+			client, err := newWithWriter(&statsdWriterWrapper{}, o, tc.writerName)
+			require.Nil(t, err)
+			client.Close()
+
+			assert.Equal(t, tc.maxBytes, o.maxBytesPerPayload)
+			assert.Equal(t, tc.bufferPoolSize, o.bufferPoolSize)
+			assert.Equal(t, tc.senderQueueSize, o.senderQueueSize)
+
+			// explicit values take precedence over the transport defaults
+			o, err = resolveOptions([]Option{
+				WithoutTelemetry(),
+				WithMaxBytesPerPayload(4096),
+				WithBufferPoolSize(64),
+				WithSenderQueueSize(32),
+			})
+			require.Nil(t, err)
+			client, err = newWithWriter(&statsdWriterWrapper{}, o, tc.writerName)
+			require.Nil(t, err)
+			client.Close()
+
+			assert.Equal(t, 4096, o.maxBytesPerPayload)
+			assert.Equal(t, 64, o.bufferPoolSize)
+			assert.Equal(t, 32, o.senderQueueSize)
+		})
+	}
+}
+
+func TestNewWithWriterBuffer(t *testing.T) {
+	var buf bytes.Buffer
+	client, err := NewWithWriter(&buf, WithoutTelemetry(), WithTags([]string{"env:dev"}))
+	require.Nil(t, err)
+
+	client.Gauge("gauge", 21, []string{"tag1"}, 1)
+	client.Count("count", 2, nil, 1)
+	client.SimpleServiceCheck("sc", Ok)
+	require.Nil(t, client.Close())
+
+	ts := &testServer{}
+	ts.assertMetric(t, strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n"), []string{
+		"gauge:21|g|#env:dev,tag1",
+		"count:2|c|#env:dev",
+		"_sc|sc|0|#env:dev",
+	})
+}
+
+func TestTimedBlock(t *testing.T) {
+	var buf bytes.Buffer
+	clock := newFakeClock()
+	client, err := NewWithWriter(&buf, WithoutTelemetry(), WithoutClientSideAggregation(), withClock(clock))
+	require.Nil(t, err)
+
+	func() {
+		defer client.TimedBlock("block", []string{"tag1"}, 1)()
+		clock.Advance(1500 * time.Millisecond)
+	}()
+	require.Nil(t, client.Close())
+
+	assert.Equal(t, "block:1500.000000|ms|#tag1\n", buf.String())
+
+	var nilClient *Client
+	assert.NotPanics(t, nilClient.TimedBlock("block", nil, 1))
+}
+
+func TestTimedContext(t *testing.T) {
+	var buf bytes.Buffer
+	clock := newFakeClock()
+	client, err := NewWithWriter(&buf, WithoutTelemetry(), WithoutClientSideAggregation(), withClock(clock))
+	require.Nil(t, err)
+
+	tags := []string{"tag1"}
+	done := client.TimedContext(context.Background(), "ok", tags, 1)
+	clock.Advance(10 * time.Millisecond)
+	done()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done = client.TimedContext(ctx, "canceled", tags, 1)
+	clock.Advance(20 * time.Millisecond)
+	cancel()
+	done()
+
+	ctx, cancel = context.WithDeadline(context.Background(), time.Now())
+	defer cancel()
+	done = client.TimedContext(ctx, "expired", tags, 1)
+	clock.Advance(30 * time.Millisecond)
+	done()
+	require.Nil(t, client.Close())
+
+	ts := &testServer{}
+	ts.assertMetric(t, strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n"), []string{
+		"ok:10.000000|ms|#tag1",
+		"canceled:20.000000|ms|#tag1,context_error:canceled",
+		"expired:30.000000|ms|#tag1,context_error:deadline_exceeded",
+	})
+	assert.Equal(t, []string{"tag1"}, tags)
+}
+
+// payloadRecorder records every payload written to it.
+type payloadRecorder struct {
+	sync.Mutex
+	payloads []string
+}
+
+func (r *payloadRecorder) Write(p []byte) (int, error) {
+	r.Lock()
+	defer r.Unlock()
+	r.payloads = append(r.payloads, string(p))
+	return len(p), nil
+}
+
+// channelWriter sends every payload written to payloads.
+type channelWriter struct {
+	payloads chan string
+}
+
+func (w *channelWriter) Write(p []byte) (int, error) {
+	w.payloads <- string(p)
+	return len(p), nil
+}
+
+func TestClientImmediateEvents(t *testing.T) {
+	for _, options := range [][]Option{{}, {WithChannelMode()}} {
+		w := &channelWriter{payloads: make(chan string, 10)}
+		client, err := NewWithWriter(w, append(options,
+			WithoutTelemetry(),
+			WithWorkersCount(1),
+			WithBufferFlushInterval(time.Hour),
+			WithImmediateEvents(),
+		)...)
+		require.Nil(t, err)
+
+		require.Nil(t, client.Gauge("gauge", 1, nil, 1))
+		require.Nil(t, client.Histogram("histogram", 1, nil, 1))
+		require.Nil(t, client.SimpleEvent("title", "text"))
+		select {
+		case p := <-w.payloads:
+			// the metrics written to the same buffer go along with the event, the aggregated ones wait for the flush
+			assert.Equal(t, "histogram:1|h\n_e{5,4}:title|text\n", p)
+		case <-time.After(5 * time.Second):
+			assert.Fail(t, "the event was not sent")
+		}
+
+		require.Nil(t, client.SimpleServiceCheck("sc", Ok))
+		select {
+		case p := <-w.payloads:
+			assert.Equal(t, "_sc|sc|0\n", p)
+		case <-time.After(5 * time.Second):
+			assert.Fail(t, "the service check was not sent")
+		}
+		client.Close()
+	}
+}
+
+func TestClientAdaptivePayloadPacking(t *testing.T) {
+	const maxBytes = 200
+	send := func(options ...Option) ([]string, []string) {
+		w := &payloadRecorder{}
+		client, err := NewWithWriter(w, append([]Option{
+			WithoutTelemetry(),
+			WithoutClientSideAggregation(),
+			WithWorkersCount(1),
+			WithBufferFlushInterval(time.Hour),
+			WithMaxBytesPerPayload(maxBytes),
+		}, options...)...)
+		require.Nil(t, err)
+
+		expected := []string{}
+		for i := 0; i < 50; i++ {
+			// metrics from 10 to 60 bytes
+			name := "m" + strings.Repeat("x", i%6*10)
+			client.Count(name, 1, nil, 1)
+			expected = append(expected, name+":1|c")
+		}
+		require.Nil(t, client.Close())
+		return expected, w.payloads
+	}
+
+	// without the option the payloads are cut after 2 messages
+	_, payloads := send(WithMaxMessagesPerPayload(2))
+	for _, payload := range payloads {
+		assert.LessOrEqual(t, strings.Count(payload, "\n"), 2)
+	}
+
+	expected, payloads := send(WithMaxMessagesPerPayload(2), WithAdaptivePayloadPacking())
+	received := []string{}
+	for i, payload := range payloads {
+		assert.LessOrEqual(t, len(payload), maxBytes)
+		lines := strings.Split(strings.TrimSuffix(payload, "\n"), "\n")
+		received = append(received, lines...)
+		if i < len(payloads)-1 {
+			// more than the 2 messages allowed by WithMaxMessagesPerPayload
+			assert.Greater(t, len(lines), 2)
+			// the payload was only sent because the next metric didn't fit
+			next := strings.SplitN(payloads[i+1], "\n", 2)[0]
+			assert.Greater(t, len(payload)+len(next)+1, maxBytes)
+		}
+	}
+	assert.Equal(t, expected, received)
+
+	// the payloads are packed the same way by default
+	_, defaultPayloads := send()
+	assert.Equal(t, payloads, defaultPayloads)
+
+	// a metric bigger than the payload is still dropped
+	w := &payloadRecorder{}
+	client, err := NewWithWriter(w, WithoutTelemetry(), WithoutClientSideAggregation(), WithMaxBytesPerPayload(maxBytes), WithAdaptivePayloadPacking())
+	require.Nil(t, err)
+	assert.Nil(t, client.Count(strings.Repeat("x", maxBytes), 1, nil, 1))
+	require.Nil(t, client.Close())
+	assert.Empty(t, w.payloads)
+	assert.Equal(t, uint64(1), client.GetTelemetry().TotalMetricsDroppedOversized)
+}
+
+func TestClientOversizedMetricPolicy(t *testing.T) {
+	tags := []string{"tag:" + strings.Repeat("a", 20), "tag:" + strings.Repeat("b", 20), "tag:c"}
+	testCases := map[string]struct {
+		policy      OversizedMetricPolicy
+		expectedErr error
+		expected    []string
+		dropped     uint64
+	}{
+		"drop and count": {OversizedMetricDropAndCount, nil, []string{"small:1|g|#env:dev\n"}, 2},
+		"error":          {OversizedMetricError, ErrBufferTooSmall, []string{"small:1|g|#env:dev\n"}, 2},
+		// tags are removed from the end until the gauge fits, the global tags are kept and the event is still dropped
+		"best effort truncate": {OversizedMetricBestEffortTruncate, nil, []string{"small:1|g|#env:dev\n", "gauge:1|g|#env:dev,tag:" + strings.Repeat("a", 20) + "\n"}, 1},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			w := &payloadRecorder{}
+			client, err := NewWithWriter(w,
+				WithoutTelemetry(),
+				WithoutClientSideAggregation(),
+				WithWorkersCount(1),
+				WithBufferFlushInterval(time.Hour),
+				WithMaxBytesPerPayload(50),
+				WithTags([]string{"env:dev"}),
+				WithOversizedMetricPolicy(tc.policy),
+			)
+			require.Nil(t, err)
+
+			require.Nil(t, client.Gauge("small", 1, nil, 1))
+			assert.Equal(t, tc.expectedErr, client.Gauge("gauge", 1, tags, 1))
+			assert.Equal(t, tc.expectedErr, client.SimpleEvent("title", strings.Repeat("x", 50)))
+			require.Nil(t, client.Close())
+
+			assert.Equal(t, tc.expected, w.payloads)
+			assert.Equal(t, tc.dropped, client.GetTelemetry().TotalMetricsDroppedOversized)
+		})
+	}
+}
+
+func TestWriteRaw(t *testing.T) {
+	w := &payloadRecorder{}
+	client, err := NewWithWriter(w,
+		WithoutTelemetry(),
+		WithoutClientSideAggregation(),
+		WithWorkersCount(1),
+		WithBufferFlushInterval(time.Hour),
+		WithNamespace("ns"),
+		WithTags([]string{"env:dev"}),
+	)
+	require.Nil(t, err)
+
+	client.Gauge("gauge", 21, nil, 1)
+	require.Nil(t, client.WriteRaw([]byte("raw.count:1|c\nraw.gauge:2|g|#tag1\n")))
+	require.Nil(t, client.WriteRaw([]byte("raw.set:abc|s")))
+	require.Nil(t, client.Close())
+
+	// raw lines are batched with the other metrics, without namespace nor global tags
+	assert.Equal(t, []string{
+		"ns.gauge:21|g|#env:dev\nraw.count:1|c\nraw.gauge:2|g|#tag1\nraw.set:abc|s\n",
+	}, w.payloads)
+}
+
+func TestWriteRawSplit(t *testing.T) {
+	w := &payloadRecorder{}
+	client, err := NewWithWriter(w,
+		WithoutTelemetry(),
+		WithoutClientSideAggregation(),
+		WithWorkersCount(1),
+		WithBufferFlushInterval(time.Hour),
+		WithMaxBytesPerPayload(30),
+	)
+	require.Nil(t, err)
+
+	err = client.WriteRaw([]byte("raw.a:1|c\nraw.b:1|c\n" + strings.Repeat("x", 30) + ":1|c\nraw.c:1|c\n"))
+	assert.Nil(t, err)
+	require.Nil(t, client.Close())
+
+	// the message too big for a payload is dropped, the others are split across payloads
+	assert.Equal(t, []string{
+		"raw.a:1|c\nraw.b:1|c\n",
+		"raw.c:1|c\n",
+	}, w.payloads)
+
+	var nilClient *Client
+	assert.Equal(t, ErrNoClient, nilClient.WriteRaw([]byte("raw.a:1|c")))
+}
+
+func TestClientConstantHostname(t *testing.T) {
+	defer func() { os.Unsetenv("DD_ENV") }()
+	os.Setenv("DD_ENV", "staging")
+
+	var buf bytes.Buffer
+	client, err := NewWithWriter(&buf,
+		WithoutTelemetry(),
+		WithoutClientSideAggregation(),
+		WithTags([]string{"host:wrong", "team:a"}),
+		WithConstantHostname("my-host"),
+	)
+	require.Nil(t, err)
+
+	client.Gauge("gauge", 1, []string{"tag1"}, 1)
+	client.SimpleEvent("title", "text")
+	client.SimpleServiceCheck("check", Ok)
+	// the hostname of the call takes precedence and the event isn't modified
+	remoteEvent := &Event{Title: "remote", Text: "text", Hostname: "remote-host"}
+	client.Event(remoteEvent)
+	client.ServiceCheck(&ServiceCheck{Name: "remote", Status: Ok, Hostname: "remote-host"})
+	event := NewEvent("title2", "text")
+	client.Event(event)
+	require.Nil(t, client.Close())
+	assert.Equal(t, "remote-host", remoteEvent.Hostname)
+	assert.Equal(t, "", event.Hostname)
+
+	// the host tag is the last global tag, before the tags of the call
+	ts := &testServer{}
+	ts.assertMetric(t, strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n"), []string{
+		"gauge:1|g|#team:a,env:staging,host:my-host,tag1",
+		"_e{5,4}:title|text|h:my-host|#team:a,env:staging,host:my-host",
+		"_sc|check|0|h:my-host|#team:a,env:staging,host:my-host",
+		"_e{6,4}:remote|text|h:remote-host|#team:a,env:staging,host:my-host",
+		"_sc|remote|0|h:remote-host|#team:a,env:staging,host:my-host",
+		"_e{6,4}:title2|text|h:my-host|#team:a,env:staging,host:my-host",
+	})
+}
+
+func TestClientUnifiedServiceTagging(t *testing.T) {
+	defer func() {
+		os.Unsetenv("DD_ENV")
+		os.Unsetenv("DD_VERSION")
+		os.Unsetenv("DD_TAGS")
+	}()
+	os.Setenv("DD_ENV", "staging")
+	os.Setenv("DD_VERSION", "1.2.3")
+	os.Setenv("DD_TAGS", "team:b,tier:1 region:eu")
+
+	var buf bytes.Buffer
+	client, err := NewWithWriter(&buf,
+		WithoutTelemetry(),
+		WithoutClientSideAggregation(),
+		WithTags([]string{"env:prod", "team:a"}),
+		WithUnifiedServiceTagging(),
+	)
+	require.Nil(t, err)
+
+	client.Gauge("gauge", 1, []string{"tag1"}, 1)
+	require.Nil(t, client.Close())
+
+	assert.Equal(t, "gauge:1|g|#env:prod,team:a,version:1.2.3,tier:1,region:eu,tag1\n", buf.String())
+}
+
+func TestClientEventHostnameWithoutDefault(t *testing.T) {
+	var buf bytes.Buffer
+	client, err := NewWithWriter(&buf, WithoutTelemetry())
+	require.Nil(t, err)
+
+	client.SimpleEvent("title", "text")
+	client.Event(&Event{Title: "title", Text: "text", Hostname: "remote-host"})
+	client.ServiceCheck(&ServiceCheck{Name: "check", Status: Ok, Hostname: "remote|host"})
+	require.Nil(t, client.Close())
+
+	ts := &testServer{}
+	ts.assertMetric(t, strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n"), []string{
+		"_e{5,4}:title|text",
+		"_e{5,4}:title|text|h:remote-host",
+		"_sc|check|0|h:remote_host",
+	})
+}
+
+func TestClientAdd(t *testing.T) {
+	var buf bytes.Buffer
+	client, err := NewWithWriter(&buf, WithoutTelemetry(), WithoutClientSideAggregation())
+	require.Nil(t, err)
+
+	client.Add("add", -3, []string{"tag"}, 1)
+	client.Add("incr", 1, nil, 1)
+	client.Incr("incr", nil, 1)
+	client.Add("decr", -1, nil, 1)
+	client.Decr("decr", nil, 1)
+	require.Nil(t, client.Close())
+
+	ts := &testServer{}
+	ts.assertMetric(t, strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n"), []string{
+		"add:-3|c|#tag",
+		"incr:1|c",
+		"incr:1|c",
+		"decr:-1|c",
+		"decr:-1|c",
+	})
+}
+
+// recordRaisedSignals replaces raiseSignal to keep the signals raised again after a graceful shutdown instead of
+// terminating the tests, it returns a function restoring it.
+func recordRaisedSignals(raised chan os.Signal) func() {
+	previous := raiseSignal
+	raiseSignal = func(sig os.Signal) { raised <- sig }
+	return func() { raiseSignal = previous }
+}
+
+func TestClientGracefulShutdown(t *testing.T) {
+	raised := make(chan os.Signal, 1)
+	defer recordRaisedSignals(raised)()
+
+	w := &payloadRecorder{}
+	client, err := NewWithWriter(w, WithoutTelemetry(), WithBufferFlushInterval(time.Hour), WithGracefulShutdown(os.Interrupt))
+	require.Nil(t, err)
+
+	client.Gauge("gauge", 1, nil, 1)
+	client.Count("count", 1, nil, 1)
+
+	client.signals <- os.Interrupt
+	select {
+	case <-client.stop:
+	case <-time.After(2 * time.Second):
+		require.Fail(t, "the client was not closed on the signal")
+	}
+	// waits for the Close started by the signal
+	require.Nil(t, client.Close())
+
+	w.Lock()
+	ts := &testServer{}
+	ts.assertMetric(t, strings.Split(strings.TrimSuffix(strings.Join(w.payloads, ""), "\n"), "\n"), []string{
+		"gauge:1|g",
+		"count:1|c",
+	})
+	w.Unlock()
+
+	// the signal is raised again once the client is closed
+	select {
+	case sig := <-raised:
+		assert.Equal(t, os.Interrupt, sig)
+	case <-time.After(2 * time.Second):
+		require.Fail(t, "the signal was not raised again")
+	}
+}
+
+func TestClientGracefulShutdownClose(t *testing.T) {
+	raised := make(chan os.Signal, 1)
+	defer recordRaisedSignals(raised)()
+
+	client, err := NewWithWriter(&statsdWriterWrapper{}, WithoutTelemetry(), WithGracefulShutdown(os.Interrupt))
+	require.Nil(t, err)
+	require.Nil(t, client.Close())
+	// a signal received after Close does nothing
+	client.signals <- os.Interrupt
+	require.Nil(t, client.Close())
+	assert.Empty(t, raised)
+}
+
+func TestClientRecordSeparator(t *testing.T) {
+	w := &payloadRecorder{}
+	client, err := NewWithWriter(w, WithoutTelemetry(), WithoutClientSideAggregation(), WithWorkersCount(1), WithRecordSeparator(0))
+	require.Nil(t, err)
+
+	client.Gauge("gauge", 1, []string{"tag1"}, 1)
+	client.Count("count", 2, nil, 1)
+	client.DistributionSamples("distribution", []float64{1, 2}, nil, 1)
+	client.SimpleServiceCheck("sc", Ok)
+	require.Nil(t, client.Close())
+
+	require.Len(t, w.payloads, 1)
+	assert.Equal(t, "gauge:1|g|#tag1\x00count:2|c\x00distribution:1:2|d\x00_sc|sc|0\x00", w.payloads[0])
+	assert.NotContains(t, w.payloads[0], "\n")
+}
+
+func TestClientSynchronousMode(t *testing.T) {
+	w := &payloadRecorder{}
+	clock := newFakeClock()
+	goroutines := runtime.NumGoroutine()
+	client, err := NewWithWriter(w,
+		WithSynchronousMode(),
+		WithExtendedClientSideAggregation(),
+		WithChannelMode(),
+		withClock(clock),
+	)
+	require.Nil(t, err)
+
+	// no flush ticker, aggregator, sender or telemetry goroutine
+	assert.Empty(t, clock.tickers)
+	assert.Nil(t, client.agg)
+	assert.LessOrEqual(t, runtime.NumGoroutine(), goroutines)
+
+	// each metric is written before the call returns, without any flush
+	client.Gauge("gauge", 1, []string{"tag1"}, 1)
+	assert.Equal(t, []string{"gauge:1|g|#tag1\n"}, w.payloads)
+	client.Count("count", 2, nil, 1)
+	client.Histogram("histogram", 3, nil, 1)
+	assert.Equal(t, []string{"gauge:1|g|#tag1\n", "count:2|c\n", "histogram:3|h\n"}, w.payloads)
+
+	client.Batch(func(b *Batch) {
+		b.Gauge("batch1", 1, nil, 1)
+		b.Gauge("batch2", 2, nil, 1)
+		assert.Len(t, w.payloads, 3)
+	})
+	assert.Equal(t, "batch1:1|g\nbatch2:2|g\n", w.payloads[3])
+
+	require.Nil(t, client.Flush())
+	require.Nil(t, client.Close())
+	assert.Len(t, w.payloads, 4)
+	assert.Equal(t, uint64(4), client.GetTelemetry().TotalPayloadsSent)
+}
+
+func TestClientFlushHighWaterMark(t *testing.T) {
+	w := &payloadRecorder{}
+	client, err := NewWithWriter(w,
+		WithoutTelemetry(),
+		WithoutClientSideAggregation(),
+		WithWorkersCount(1),
+		WithBufferFlushInterval(time.Hour),
+		WithMaxBytesPerPayload(100),
+		WithFlushHighWaterMark(0.5),
+	)
+	require.Nil(t, err)
+	defer client.Close()
+
+	name := strings.Repeat("x", 25)
+	client.Gauge(name, 1, nil, 1)
+	client.sender.flush()
+	w.Lock()
+	assert.Empty(t, w.payloads)
+	w.Unlock()
+
+	// 60 bytes are over the 50 bytes mark
+	client.Gauge(name, 1, nil, 1)
+	client.sender.flush()
+	w.Lock()
+	assert.Equal(t, []string{strings.Repeat(name+":1|g\n", 2)}, w.payloads)
+	w.Unlock()
+}
+
+func TestClientFlushEveryNMetrics(t *testing.T) {
+	w := &payloadRecorder{}
+	client, err := NewWithWriter(w,
+		WithoutTelemetry(),
+		WithoutClientSideAggregation(),
+		WithWorkersCount(1),
+		WithBufferFlushInterval(time.Hour),
+		WithFlushEveryNMetrics(3),
+	)
+	require.Nil(t, err)
+	defer client.Close()
+
+	for i := 0; i < 2; i++ {
+		client.Count("count", int64(i), nil, 1)
+	}
+	client.sender.flush()
+	w.Lock()
+	assert.Empty(t, w.payloads)
+	w.Unlock()
+
+	// the buffer is far from full and the timer didn't fire but the third metric flushes it
+	client.Count("count", 2, nil, 1)
+	for i := 3; i < 5; i++ {
+		client.Count("count", int64(i), nil, 1)
+	}
+	client.sender.flush()
+	w.Lock()
+	assert.Equal(t, []string{"count:0|c\ncount:1|c\ncount:2|c\n"}, w.payloads)
+	w.Unlock()
+}
+
+func TestClientFlushJitter(t *testing.T) {
+	w := &payloadRecorder{}
+	clock := newFakeClock()
+	client, err := NewWithWriter(w,
+		WithoutTelemetry(),
+		WithoutClientSideAggregation(),
+		WithBufferFlushInterval(100*time.Millisecond),
+		WithFlushJitter(0.5),
+		withClock(clock),
+	)
+	require.Nil(t, err)
+	defer client.Close()
+
+	require.Len(t, clock.tickers, 1)
+	interval := clock.tickers[0].interval
+	assert.GreaterOrEqual(t, int64(interval), int64(50*time.Millisecond))
+	assert.LessOrEqual(t, int64(interval), int64(150*time.Millisecond))
+
+	client.Gauge("gauge", 1, nil, 1)
+
+	// nothing can be flushed before the shortest jittered interval
+	clock.Advance(50*time.Millisecond - time.Nanosecond)
+	assert.Empty(t, w.payloads)
+
+	// the buffer is flushed at the latest after the longest one
+	clock.Advance(100 * time.Millisecond)
+	// the second tick is only received once the flush of the first one is done
+	clock.Advance(150 * time.Millisecond)
+	client.sender.flush()
+	w.Lock()
+	assert.Equal(t, []string{"gauge:1|g\n"}, w.payloads)
+	w.Unlock()
+}
+
+type closeRecorder struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (c *closeRecorder) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestNewWithWriterClose(t *testing.T) {
+	w := &closeRecorder{}
+	client, err := NewWithWriter(w, WithoutTelemetry())
+	require.Nil(t, err)
+	require.Nil(t, client.Close())
+	assert.True(t, w.closed)
+}
+
 // TestConcurrentSend sends various metric types in separate goroutines to
 // trigger any possible data races. It is intended to be run with the data race
 // detector enabled.
@@ -145,6 +797,48 @@ func TestConcurrentSend(t *testing.T) {
 	}
 }
 
+func TestClientAggregatedSampledCounts(t *testing.T) {
+	options := [][]Option{
+		{},
+		{WithExtendedClientSideAggregation()},
+		{WithChannelMode()},
+	}
+	for _, opts := range options {
+		w := statsdWriterWrapper{}
+		client, err := NewWithWriter(&w, append(opts, WithoutTelemetry())...)
+		require.Nil(t, err)
+
+		for i := 0; i < 1000; i++ {
+			client.Count("count", 1, []string{"tag"}, 0.1)
+			client.Incr("incr", nil, 0.1)
+			client.CountWithTimestamp("count_ts", 2, nil, 0.1, time.Unix(1700000000, 0))
+		}
+		client.Close()
+
+		// every call is counted and the rate is not sent, the Agent would upscale the true count otherwise
+		ts := &testServer{}
+		ts.assertMetric(t, w.data, []string{
+			"count:1000|c|#tag",
+			"incr:1000|c",
+			"count_ts:2000|c|T1700000000",
+		})
+	}
+
+	// without aggregation the calls are sampled and the rate is sent for the Agent to upscale them
+	w := statsdWriterWrapper{}
+	client, err := NewWithWriter(&w, WithoutTelemetry(), WithoutClientSideAggregation())
+	require.Nil(t, err)
+	for i := 0; i < 1000; i++ {
+		client.Count("count", 1, []string{"tag"}, 0.1)
+	}
+	client.Close()
+	assert.NotEmpty(t, w.data)
+	assert.True(t, len(w.data) < 1000)
+	for _, m := range w.data {
+		assert.Equal(t, "count:1|c|@0.1|#tag", m)
+	}
+}
+
 // TestCloseRace close the client multiple times in separate goroutines to
 // trigger any possible data races. It is intended to be run with the data race
 // detector enabled.
@@ -169,7 +863,7 @@ func TestCloneWithExtraOptions(t *testing.T) {
 	client, err := New("localhost:1201", WithTags([]string{"tag1", "tag2"}))
 	require.Nil(t, err, fmt.Sprintf("failed to create client: %s", err))
 
-	assert.Equal(t, client.tags, []string{"tag1", "tag2"})
+	assert.Equal(t, client.getGlobalTags().tags, []string{"tag1", "tag2"})
 	assert.Equal(t, client.namespace, "")
 	assert.Equal(t, client.workersMode, mutexMode)
 	assert.Equal(t, "localhost:1201", client.addrOption)
@@ -178,13 +872,52 @@ func TestCloneWithExtraOptions(t *testing.T) {
 	cloneClient, err := CloneWithExtraOptions(client, WithNamespace("test"), WithChannelMode())
 	require.Nil(t, err, fmt.Sprintf("failed to clone client: %s", err))
 
-	assert.Equal(t, cloneClient.tags, []string{"tag1", "tag2"})
+	assert.Equal(t, cloneClient.getGlobalTags().tags, []string{"tag1", "tag2"})
 	assert.Equal(t, cloneClient.namespace, "test.")
 	assert.Equal(t, cloneClient.workersMode, channelMode)
 	assert.Equal(t, "localhost:1201", cloneClient.addrOption)
 	assert.Len(t, cloneClient.options, 3)
 }
 
+func TestClientSetGlobalTags(t *testing.T) {
+	w := &statsdWriterWrapper{}
+	client, err := NewWithWriter(w, WithoutTelemetry(), WithoutClientSideAggregation(), WithTags([]string{"version:0", "build:0"}))
+	require.Nil(t, err)
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					client.Gauge("gauge", 1, []string{"tag"}, 1)
+				}
+			}
+		}()
+	}
+	for i := 1; i <= 1000; i++ {
+		client.SetGlobalTags([]string{fmt.Sprintf("version:%d", i), fmt.Sprintf("build:%d", i)})
+	}
+	close(stop)
+	wg.Wait()
+	client.Event(NewEvent("title", "text"))
+	require.Nil(t, client.Close())
+
+	// each message carries all the tags of a single call to SetGlobalTags
+	re := regexp.MustCompile(`#version:(\d+),build:(\d+)`)
+	for _, line := range w.data {
+		match := re.FindStringSubmatch(line)
+		require.NotNil(t, match, line)
+		assert.Equal(t, match[1], match[2], line)
+	}
+	assert.Contains(t, w.data, "_e{5,4}:title|text|#version:1000,build:1000")
+}
+
 func TestResolveAddressFromEnvironment(t *testing.T) {
 	hostInitialValue, hostInitiallySet := os.LookupEnv(agentHostEnvVarName)
 	if hostInitiallySet {
@@ -215,6 +948,8 @@ func TestResolveAddressFromEnvironment(t *testing.T) {
 		{"UDS socket passed", "unix://test/path.socket", "", "", "unix://test/path.socket"},
 		{"UDS socket env", "", "unix://test/path.socket", "", "unix://test/path.socket"},
 		{"UDS socket env with port", "", "unix://test/path.socket", "8125", "unix://test/path.socket"},
+		{"UDS stream socket passed", "unixstream://test/path.socket", "", "", "unixstream://test/path.socket"},
+		{"UDS stream socket env with port", "", "unixstream://test/path.socket", "8125", "unixstream://test/path.socket"},
 
 		{"Pipe passed", "\\\\.\\pipe\\my_pipe", "", "", "\\\\.\\pipe\\my_pipe"},
 		{"Pipe env", "", "\\\\.\\pipe\\my_pipe", "", "\\\\.\\pipe\\my_pipe"},
@@ -253,11 +988,12 @@ func TestGetTelemetry(t *testing.T) {
 	assert.Equal(t, uint64(1), tlm.TotalEvents, "telmetry TotalEvents was wrong")
 	assert.Equal(t, uint64(1), tlm.TotalServiceChecks, "telmetry TotalServiceChecks was wrong")
 	assert.Equal(t, uint64(0), tlm.TotalDroppedOnReceive, "telmetry TotalDroppedOnReceive was wrong")
-	assert.Equal(t, uint64(22), tlm.TotalPayloadsSent, "telmetry TotalPayloadsSent was wrong")
+	assert.Equal(t, uint64(25), tlm.TotalPayloadsSent, "telmetry TotalPayloadsSent was wrong")
 	assert.Equal(t, uint64(0), tlm.TotalPayloadsDropped, "telmetry TotalPayloadsDropped was wrong")
 	assert.Equal(t, uint64(0), tlm.TotalPayloadsDroppedWriter, "telmetry TotalPayloadsDroppedWriter was wrong")
+	assert.Equal(t, uint64(0), tlm.TotalPayloadsDroppedWriterTimeout, "telmetry TotalPayloadsDroppedWriterTimeout was wrong")
 	assert.Equal(t, uint64(0), tlm.TotalPayloadsDroppedQueueFull, "telmetry TotalPayloadsDroppedQueueFull was wrong")
-	assert.Equal(t, uint64(3112), tlm.TotalBytesSent, "telmetry TotalBytesSent was wrong")
+	assert.Equal(t, uint64(3777), tlm.TotalBytesSent, "telmetry TotalBytesSent was wrong")
 	assert.Equal(t, uint64(0), tlm.TotalBytesDropped, "telmetry TotalBytesDropped was wrong")
 	assert.Equal(t, uint64(0), tlm.TotalBytesDroppedWriter, "telmetry TotalBytesDroppedWriter was wrong")
 	assert.Equal(t, uint64(0), tlm.TotalBytesDroppedQueueFull, "telmetry TotalBytesDroppedQueueFull was wrong")
@@ -269,3 +1005,1250 @@ func TestGetTelemetry(t *testing.T) {
 	assert.Equal(t, uint64(1), tlm.AggregationNbContextDistribution, "telmetry AggregationNbContextDistribution was wrong")
 	assert.Equal(t, uint64(2), tlm.AggregationNbContextTiming, "telmetry AggregationNbContextTiming was wrong")
 }
+
+func TestMetricsWithTimestamp(t *testing.T) {
+	w := statsdWriterWrapper{}
+	client, err := NewWithWriter(&w, WithoutTelemetry())
+	require.Nil(t, err)
+
+	timestamp := time.Unix(1658934956, 0)
+	client.GaugeWithTimestamp("gauge", 21, []string{"tag1"}, 1, timestamp)
+	client.CountWithTimestamp("count", 22, []string{"tag1"}, 1, timestamp)
+	// Points of the same second are aggregated
+	client.CountWithTimestamp("count", 23, []string{"tag1"}, 1, timestamp)
+	// but not with the ones of another second or without timestamp
+	client.CountWithTimestamp("count", 3, []string{"tag1"}, 1, timestamp.Add(time.Second))
+	client.Count("count", 4, []string{"tag1"}, 1)
+	// A zero timestamp falls back to the regular behavior
+	client.GaugeWithTimestamp("gauge_no_ts", 24, []string{"tag1"}, 1, time.Time{})
+	client.CountWithTimestamp("count_no_ts", 25, []string{"tag1"}, 1, time.Time{})
+	client.Close()
+
+	ts := &testServer{}
+	ts.assertMetric(t, w.data, []string{
+		"gauge:21|g|#tag1|T1658934956",
+		"count:45|c|#tag1|T1658934956",
+		"count:3|c|#tag1|T1658934957",
+		"count:4|c|#tag1",
+		"gauge_no_ts:24|g|#tag1",
+		"count_no_ts:25|c|#tag1",
+	})
+}
+
+func TestMetricsWithZeroTimestampTags(t *testing.T) {
+	w := statsdWriterWrapper{}
+	client, err := NewWithWriter(&w, WithoutTelemetry(), WithoutClientSideAggregation(), WithTags([]string{"env:prod"}), WithSortedTags())
+	require.Nil(t, err)
+
+	// the tags are only processed once when falling back to Gauge and Count
+	client.GaugeWithTimestamp("gauge", 1, []string{"tag2", "tag1"}, 1, time.Time{})
+	client.CountWithTimestamp("count", 2, []string{"tag2", "tag1"}, 1, time.Time{})
+	client.Close()
+
+	ts := &testServer{}
+	ts.assertMetric(t, w.data, []string{
+		"gauge:1|g|#env:prod,tag1,tag2",
+		"count:2|c|#env:prod,tag1,tag2",
+	})
+}
+
+func TestCardinalityOverride(t *testing.T) {
+	w := statsdWriterWrapper{}
+	client, err := NewWithWriter(&w, WithoutTelemetry(), WithExtendedClientSideAggregation(), WithCardinality(CardinalityLow))
+	require.Nil(t, err)
+
+	client.Gauge("gauge", 21, []string{"tag1"}, 1)
+	client.Count("count", 1, []string{"tag1"}, 1)
+	// the same context with another cardinality must not be aggregated with the default one
+	client.Count("count", 2, []string{"tag1"}, 1, CardinalityHigh)
+	client.Count("count", 3, []string{"tag1"}, 1, CardinalityHigh)
+	client.Distribution("distribution", 1, []string{"tag1"}, 1, CardinalityOrchestrator)
+	client.CountWithTimestamp("count_ts", 4, []string{"tag1"}, 1, time.Unix(1658934956, 0), CardinalityHigh)
+	// CardinalityNotSet doesn't override the default of the client
+	client.Set("set", "value", []string{"tag1"}, 1, CardinalityNotSet)
+	client.SimpleServiceCheck("sc", Ok)
+	client.Close()
+
+	ts := &testServer{}
+	ts.assertMetric(t, w.data, []string{
+		"gauge:21|g|#tag1|card:low",
+		"count:1|c|#tag1|card:low",
+		"count:5|c|#tag1|card:high",
+		"distribution:1|d|#tag1|card:orchestrator",
+		"count_ts:4|c|#tag1|T1658934956|card:high",
+		"set:value|s|#tag1|card:low",
+		"_sc|sc|0|card:low",
+	})
+}
+
+func TestContainerIDOverride(t *testing.T) {
+	defer setTestContainerID("detected-id")()
+
+	w := statsdWriterWrapper{}
+	client, err := NewWithWriter(&w, WithoutTelemetry(), WithExtendedClientSideAggregation(), WithOriginDetection())
+	require.Nil(t, err)
+
+	client.Count("count", 1, []string{"tag1"}, 1)
+	client.Count("count", 2, []string{"tag1"}, 1, ContainerID("container-a"))
+	client.Count("count", 3, []string{"tag1"}, 1, ContainerID("container-b"), CardinalityHigh)
+	client.Distribution("distribution", 1, []string{"tag1"}, 1, ContainerID("container-a"))
+	// invalid characters are removed and an empty ContainerID keeps the detected one
+	client.Gauge("gauge", 1, nil, 1, ContainerID("container|c\n"))
+	client.Set("set", "value", nil, 1, ContainerID(""))
+	client.SimpleServiceCheck("sc", Ok)
+	client.Close()
+
+	ts := &testServer{}
+	ts.assertMetric(t, w.data, []string{
+		"count:1|c|#tag1|c:detected-id",
+		"count:2|c|#tag1|c:container-a",
+		"count:3|c|#tag1|c:container-b|card:high",
+		"distribution:1|d|#tag1|c:container-a",
+		"gauge:1|g|c:containerc",
+		"set:value|s|c:detected-id",
+		"_sc|sc|0|c:detected-id",
+	})
+}
+
+func TestOriginDetectionPerClient(t *testing.T) {
+	defer setTestContainerID("detected-id")()
+
+	w1 := statsdWriterWrapper{}
+	withOrigin, err := NewWithWriter(&w1, WithoutTelemetry(), WithOriginDetection())
+	require.Nil(t, err)
+	w2 := statsdWriterWrapper{}
+	withoutOrigin, err := NewWithWriter(&w2, WithoutTelemetry())
+	require.Nil(t, err)
+
+	withOrigin.Gauge("gauge", 1, nil, 1)
+	withoutOrigin.Gauge("gauge", 1, nil, 1)
+	withOrigin.Close()
+	withoutOrigin.Close()
+
+	ts := &testServer{}
+	ts.assertMetric(t, w1.data, []string{"gauge:1|g|c:detected-id"})
+	ts.assertMetric(t, w2.data, []string{"gauge:1|g"})
+}
+
+func TestExternalDataPerClient(t *testing.T) {
+	w1 := statsdWriterWrapper{}
+	client1, err := NewWithWriter(&w1, WithoutTelemetry(), WithExternalData("cn-nginx"))
+	require.Nil(t, err)
+	w2 := statsdWriterWrapper{}
+	client2, err := NewWithWriter(&w2, WithoutTelemetry(), WithExternalData("cn-redis"))
+	require.Nil(t, err)
+
+	client1.Gauge("gauge", 1, nil, 1)
+	client2.Gauge("gauge", 1, nil, 1)
+	client1.Close()
+	client2.Close()
+
+	ts := &testServer{}
+	ts.assertMetric(t, w1.data, []string{"gauge:1|g|e:cn-nginx"})
+	ts.assertMetric(t, w2.data, []string{"gauge:1|g|e:cn-redis"})
+}
+
+func TestNamespaceOverride(t *testing.T) {
+	w := statsdWriterWrapper{}
+	client, err := NewWithWriter(&w, WithoutTelemetry(), WithExtendedClientSideAggregation(), WithNamespace("app"))
+	require.Nil(t, err)
+
+	client.Count("count", 1, []string{"tag1"}, 1)
+	client.Count("count", 2, []string{"tag1"}, 1, Namespace("lib"))
+	client.Count("count", 3, []string{"tag1"}, 1, Namespace("lib."))
+	client.Distribution("distribution", 1, []string{"tag1"}, 1, Namespace("lib"))
+	client.GaugeWithTimestamp("gauge_ts", 1, nil, 1, time.Unix(1658934956, 0), Namespace("lib"))
+	client.DistributionSamples("samples", []float64{1, 2}, nil, 1, Namespace("lib"))
+	// the namespace of the client is kept with an empty Namespace
+	client.Set("set", "value", nil, 1, Namespace(""))
+	client.Close()
+
+	ts := &testServer{}
+	ts.assertMetric(t, w.data, []string{
+		"app.count:1|c|#tag1",
+		"lib.count:2|c|#tag1",
+		"lib.count:3|c|#tag1",
+		"lib.distribution:1|d|#tag1",
+		"lib.gauge_ts:1|g|T1658934956",
+		"lib.samples:1:2|d",
+		"app.set:value|s",
+	})
+}
+
+func TestTagFilter(t *testing.T) {
+	w := statsdWriterWrapper{}
+	client, err := NewWithWriter(&w,
+		WithoutTelemetry(),
+		WithExtendedClientSideAggregation(),
+		WithTags([]string{"env:prod", "host_id:1", "service:api"}),
+		WithTagDenylist("^user_id:", "^host_id:"),
+		WithTagFilter(func(tag string) bool { return tag != "debug" }),
+	)
+	require.Nil(t, err)
+
+	tags := []string{"user_id:42", "region:eu", "debug", "az:1a"}
+	client.Gauge("gauge", 21, tags, 1)
+	client.Count("count", 1, tags, 1)
+	client.Histogram("histogram", 1, tags, 1)
+	client.DistributionSamples("distribution", []float64{1, 2}, tags, 1)
+	client.Set("set", "value", []string{"user_id:42"}, 1)
+	client.SimpleServiceCheck("sc", Ok)
+	client.Close()
+
+	// the caller's slice is left untouched
+	assert.Equal(t, []string{"user_id:42", "region:eu", "debug", "az:1a"}, tags)
+
+	ts := &testServer{}
+	ts.assertMetric(t, w.data, []string{
+		"gauge:21|g|#env:prod,service:api,region:eu,az:1a",
+		"count:1|c|#env:prod,service:api,region:eu,az:1a",
+		"histogram:1|h|#env:prod,service:api,region:eu,az:1a",
+		"distribution:1:2|d|#env:prod,service:api,region:eu,az:1a",
+		"set:value|s|#env:prod,service:api",
+		"_sc|sc|0|#env:prod,service:api",
+	})
+}
+
+func TestTagDeduplicationAndNormalization(t *testing.T) {
+	w := statsdWriterWrapper{}
+	client, err := NewWithWriter(&w,
+		WithoutTelemetry(),
+		WithExtendedClientSideAggregation(),
+		WithTags([]string{"Env:prod", "service:api"}),
+		WithTagDeduplication(),
+		WithTagNormalization(),
+		WithTagDenylist("^user_id:"),
+	)
+	require.Nil(t, err)
+
+	client.Gauge("gauge", 21, []string{"env:staging", "user id:42"}, 1)
+	client.Count("count", 1, []string{"ENV:dev", "region:eu", "region:us"}, 1)
+	client.Distribution("distribution", 1, nil, 1)
+	client.SimpleServiceCheck("sc", Ok)
+	client.Close()
+
+	ts := &testServer{}
+	ts.assertMetric(t, w.data, []string{
+		"gauge:21|g|#service:api,env:staging",
+		"count:1|c|#service:api,env:dev,region:us",
+		"distribution:1|d|#env:prod,service:api",
+		"_sc|sc|0|#env:prod,service:api",
+	})
+}
+
+func TestClientMaxTagValueLength(t *testing.T) {
+	for _, options := range [][]Option{{}, {WithExtendedClientSideAggregation()}, {WithoutClientSideAggregation()}} {
+		w := statsdWriterWrapper{}
+		client, err := NewWithWriter(&w, append(options,
+			WithoutTelemetry(),
+			WithTags([]string{"env:prod", "build:0123456789abcdef"}),
+			WithMaxTagValueLength(10),
+			WithTagTruncationMarker("..."),
+		)...)
+		require.Nil(t, err)
+
+		client.Gauge("gauge", 21, []string{"url:http://example.com/users", "a_long_tag_without_value"}, 1)
+		client.Count("count", 1, []string{"url:http://a"}, 1)
+		client.Distribution("distribution", 1, []string{"url:http://example.com/users"}, 1)
+		client.SimpleServiceCheck("sc", Ok)
+		client.Close()
+
+		ts := &testServer{}
+		ts.assertMetric(t, w.data, []string{
+			"gauge:21|g|#env:prod,build:0123456...,url:http://...,a_long_tag_without_value",
+			"count:1|c|#env:prod,build:0123456...,url:http://a",
+			"distribution:1|d|#env:prod,build:0123456...,url:http://...",
+			"_sc|sc|0|#env:prod,build:0123456...",
+		})
+	}
+}
+
+func TestClientTagOrder(t *testing.T) {
+	for _, options := range [][]Option{{}, {WithExtendedClientSideAggregation()}, {WithoutClientSideAggregation()}} {
+		for order, expectedTags := range map[TagOrder]string{
+			TagOrderGlobalFirst: "#env:prod,service:api,endpoint:/users,code:200",
+			TagOrderCallFirst:   "#endpoint:/users,code:200,env:prod,service:api",
+		} {
+			w := statsdWriterWrapper{}
+			client, err := NewWithWriter(&w, append(options,
+				WithoutTelemetry(),
+				WithTags([]string{"env:prod", "service:api"}),
+				WithTagOrder(order),
+			)...)
+			require.Nil(t, err)
+
+			tags := []string{"endpoint:/users", "code:200"}
+			client.Gauge("gauge", 1, tags, 1)
+			client.Count("count", 1, tags, 1)
+			client.Distribution("distribution", 1, tags, 1)
+			client.Timing("timing", time.Second, tags, 1)
+			client.Set("set", "value", nil, 1)
+			client.SimpleServiceCheck("sc", Ok)
+			client.Close()
+
+			ts := &testServer{}
+			ts.assertMetric(t, w.data, []string{
+				"gauge:1|g|" + expectedTags,
+				"count:1|c|" + expectedTags,
+				"distribution:1|d|" + expectedTags,
+				"timing:1000.000000|ms|" + expectedTags,
+				"set:value|s|#env:prod,service:api",
+				"_sc|sc|0|#env:prod,service:api",
+			})
+		}
+	}
+}
+
+func TestClientSortedTags(t *testing.T) {
+	for _, options := range [][]Option{{}, {WithExtendedClientSideAggregation()}, {WithoutClientSideAggregation()}} {
+		w := statsdWriterWrapper{}
+		client, err := NewWithWriter(&w, append(options,
+			WithoutTelemetry(),
+			WithTags([]string{"service:api", "env:prod"}),
+			WithSortedTags(),
+		)...)
+		require.Nil(t, err)
+
+		client.Count("count", 1, []string{"b", "a"}, 1)
+		client.Count("count", 2, []string{"a", "b"}, 1)
+		client.Histogram("histogram", 1, []string{"region:eu", "az:1"}, 1)
+		client.Histogram("histogram", 2, []string{"az:1", "region:eu"}, 1)
+		client.Gauge("gauge", 1, nil, 1)
+		client.Close()
+
+		expected := []string{
+			"histogram:1|h|#az:1,env:prod,region:eu,service:api",
+			"histogram:2|h|#az:1,env:prod,region:eu,service:api",
+			"gauge:1|g|#env:prod,service:api",
+		}
+		if client.agg != nil {
+			// both orders are aggregated into a single context
+			expected = append(expected, "count:3|c|#a,b,env:prod,service:api")
+		} else {
+			expected = append(expected, "count:1|c|#a,b,env:prod,service:api", "count:2|c|#a,b,env:prod,service:api")
+		}
+		if client.aggExtended != nil {
+			expected = append([]string{"histogram:1:2|h|#az:1,env:prod,region:eu,service:api"}, expected[2:]...)
+		}
+		ts := &testServer{}
+		ts.assertMetric(t, w.data, expected)
+	}
+}
+
+func TestClientMaxTagsPerMetricTruncate(t *testing.T) {
+	w := statsdWriterWrapper{}
+	client, err := NewWithWriter(&w,
+		WithoutTelemetry(),
+		WithTags([]string{"env:prod"}),
+		WithMaxTagsPerMetric(3, MaxTagsTruncate),
+	)
+	require.Nil(t, err)
+
+	tags := []string{"a", "b", "c", "d"}
+	assert.Nil(t, client.Gauge("gauge", 1, tags, 1))
+	assert.Nil(t, client.Count("count", 1, []string{"a", "b"}, 1))
+	assert.Nil(t, client.Histogram("histogram", 1, tags, 1))
+	assert.Equal(t, uint64(2), client.GetTelemetry().TotalMetricsTooManyTags)
+	assert.Equal(t, []string{"a", "b", "c", "d"}, tags)
+	client.Close()
+
+	ts := &testServer{}
+	ts.assertMetric(t, w.data, []string{
+		"gauge:1|g|#env:prod,a,b",
+		"count:1|c|#env:prod,a,b",
+		"histogram:1|h|#env:prod,a,b",
+	})
+}
+
+func TestClientMaxTagsPerMetricDrop(t *testing.T) {
+	w := statsdWriterWrapper{}
+	client, err := NewWithWriter(&w,
+		WithoutTelemetry(),
+		WithTags([]string{"env:prod"}),
+		WithMaxTagsPerMetric(3, MaxTagsDrop),
+	)
+	require.Nil(t, err)
+
+	assert.Equal(t, ErrTooManyTags, client.Gauge("gauge", 1, []string{"a", "b", "c"}, 1))
+	assert.Nil(t, client.Count("count", 1, []string{"a", "b"}, 1))
+	client.Batch(func(b *Batch) {
+		assert.Equal(t, ErrTooManyTags, b.Distribution("distribution", 1, []string{"a", "b", "c"}, 1))
+	})
+	// events and service checks are not limited
+	assert.Nil(t, client.SimpleEvent("title", "text"))
+	tlm := client.GetTelemetry()
+	assert.Equal(t, uint64(2), tlm.TotalMetricsTooManyTags)
+	assert.Equal(t, uint64(0), tlm.TotalMetricsGauge)
+
+	tooManyTags := int64(-1)
+	for _, m := range client.telemetryClient.flush() {
+		if m.name == "datadog.dogstatsd.client.metrics_too_many_tags" {
+			tooManyTags = m.ivalue
+		}
+	}
+	assert.Equal(t, int64(2), tooManyTags)
+	client.Close()
+
+	ts := &testServer{}
+	ts.assertMetric(t, w.data, []string{
+		"count:1|c|#env:prod,a,b",
+		"_e{5,4}:title|text|#env:prod",
+	})
+}
+
+func TestClientFloatPrecision(t *testing.T) {
+	for _, aggregated := range []bool{false, true} {
+		options := []Option{WithoutTelemetry(), WithoutClientSideAggregation(), WithFloatPrecision(3)}
+		if aggregated {
+			options = append(options, WithExtendedClientSideAggregation())
+		}
+		w := statsdWriterWrapper{}
+		client, err := NewWithWriter(&w, options...)
+		require.Nil(t, err)
+
+		client.Gauge("gauge", 1.23456, nil, 1)
+		client.Histogram("histogram", 2, nil, 1)
+		client.Distribution("distribution", 0.0004, nil, 1)
+		client.TimeInMilliseconds("timing", 1.2, nil, 1)
+		client.Timing("duration", 1500*time.Microsecond, nil, 1)
+		client.Close()
+
+		ts := &testServer{}
+		ts.assertMetric(t, w.data, []string{
+			"gauge:1.235|g",
+			"histogram:2|h",
+			"distribution:0|d",
+			"timing:1.2|ms",
+			"duration:1.5|ms",
+		})
+	}
+
+	// timings keep 6 decimals by default
+	w := statsdWriterWrapper{}
+	client, err := NewWithWriter(&w, WithoutTelemetry())
+	require.Nil(t, err)
+	client.TimeInMilliseconds("timing", 1.2, nil, 1)
+	client.Gauge("gauge", 1.23456, nil, 1)
+	client.Close()
+	ts := &testServer{}
+	ts.assertMetric(t, w.data, []string{"timing:1.200000|ms", "gauge:1.23456|g"})
+}
+
+func TestClientAlwaysEmitSampleRate(t *testing.T) {
+	for _, aggregated := range []bool{false, true} {
+		options := []Option{WithoutTelemetry(), WithoutClientSideAggregation(), WithAlwaysEmitSampleRate()}
+		if aggregated {
+			options = append(options, WithExtendedClientSideAggregation())
+		}
+		w := statsdWriterWrapper{}
+		client, err := NewWithWriter(&w, options...)
+		require.Nil(t, err)
+
+		client.Count("count", 1, []string{"tag1"}, 1)
+		client.Gauge("gauge", 2, nil, 1)
+		client.Set("set", "a", nil, 1)
+		client.Histogram("histogram", 3, nil, 1)
+		client.Distribution("distribution", 4, nil, 1)
+		client.TimeInMilliseconds("timing", 5, nil, 1)
+		client.Close()
+
+		ts := &testServer{}
+		ts.assertMetric(t, w.data, []string{
+			"count:1|c|@1|#tag1",
+			"gauge:2|g|@1",
+			"set:a|s|@1",
+			"histogram:3|h|@1",
+			"distribution:4|d|@1",
+			"timing:5.000000|ms|@1",
+		})
+	}
+
+	// the rate is omitted by default
+	w := statsdWriterWrapper{}
+	client, err := NewWithWriter(&w, WithoutTelemetry(), WithoutClientSideAggregation())
+	require.Nil(t, err)
+	client.Count("count", 1, []string{"tag1"}, 1)
+	client.Gauge("gauge", 2, nil, 1)
+	client.Close()
+	ts := &testServer{}
+	ts.assertMetric(t, w.data, []string{"count:1|c|#tag1", "gauge:2|g"})
+}
+
+func TestFilterTagsWithoutFilter(t *testing.T) {
+	c := Client{}
+	tags := []string{"a:b", "c:d"}
+	filtered := c.filterTags(tags)
+	// the slice is returned as is when no filter is configured
+	assert.Equal(t, &tags[0], &filtered[0])
+}
+
+func TestSetInt(t *testing.T) {
+	for _, options := range [][]Option{{}, {WithoutClientSideAggregation()}} {
+		w := statsdWriterWrapper{}
+		client, err := NewWithWriter(&w, append(options, WithoutTelemetry())...)
+		require.Nil(t, err)
+
+		// SetInt must produce the same output as Set with the stringified integer
+		client.SetInt("set", 1234, []string{"tag1"}, 1)
+		client.Set("set_str", strconv.FormatInt(1234, 10), []string{"tag1"}, 1)
+		client.SetInt("set", -42, []string{"tag1"}, 1)
+		client.Set("set_str", strconv.FormatInt(-42, 10), []string{"tag1"}, 1)
+		assert.Equal(t, uint64(4), client.telemetry.totalMetricsSet)
+		client.Close()
+
+		ts := &testServer{}
+		ts.assertMetric(t, w.data, []string{
+			"set:1234|s|#tag1",
+			"set_str:1234|s|#tag1",
+			"set:-42|s|#tag1",
+			"set_str:-42|s|#tag1",
+		})
+	}
+}
+
+func TestSetMulti(t *testing.T) {
+	for _, options := range [][]Option{{}, {WithoutClientSideAggregation()}} {
+		w := statsdWriterWrapper{}
+		client, err := NewWithWriter(&w, append(options, WithoutTelemetry())...)
+		require.Nil(t, err)
+
+		require.Nil(t, client.SetMulti("set", []string{"a", "b", "a", "c", "b"}, []string{"tag1"}, 1))
+		require.Nil(t, client.SetMulti("empty", nil, nil, 1))
+		assert.Equal(t, uint64(3), client.telemetry.totalMetricsSet)
+		client.Close()
+
+		ts := &testServer{}
+		ts.assertMetric(t, w.data, []string{
+			"set:a|s|#tag1",
+			"set:b|s|#tag1",
+			"set:c|s|#tag1",
+		})
+	}
+}
+
+func TestSetMultiParameters(t *testing.T) {
+	for _, options := range [][]Option{{}, {WithoutClientSideAggregation()}} {
+		w := statsdWriterWrapper{}
+		client, err := NewWithWriter(&w, append(options, WithoutTelemetry(), WithNamespace("app."))...)
+		require.Nil(t, err)
+
+		require.Nil(t, client.SetMulti("set", []string{"a", "b"}, nil, 1, Namespace("lib."), CardinalityHigh, ContainerID("container-a")))
+		require.Nil(t, client.SetMulti("set", []string{"c"}, nil, 1, CardinalityLow))
+		client.Close()
+
+		ts := &testServer{}
+		ts.assertMetric(t, w.data, []string{
+			"lib.set:a|s|c:container-a|card:high",
+			"lib.set:b|s|c:container-a|card:high",
+			"app.set:c|s|card:low",
+		})
+	}
+}
+
+func TestSetMultiSinglePayload(t *testing.T) {
+	w := &payloadRecorder{}
+	client, err := NewWithWriter(w, WithoutTelemetry(), WithoutClientSideAggregation())
+	require.Nil(t, err)
+
+	require.Nil(t, client.SetMulti("set", []string{"a", "b", "a", "c"}, nil, 1))
+	client.Close()
+
+	assert.Equal(t, []string{"set:a|s\nset:b|s\nset:c|s\n"}, w.payloads)
+}
+
+func TestGaugeNegative(t *testing.T) {
+	for _, tc := range []struct {
+		options  []Option
+		expected string
+	}{
+		{[]Option{WithoutTelemetry()}, "gauge:0|g|#tag1\ngauge:-5|g|#tag1\n"},
+		{[]Option{WithoutTelemetry(), WithoutClientSideAggregation()}, "gauge:0|g|#tag1\ngauge:-5|g|#tag1\n"},
+		{[]Option{WithoutTelemetry(), WithStatsdFormat()}, "gauge:0|g\ngauge:-5|g\n"},
+	} {
+		w := &payloadRecorder{}
+		client, err := NewWithWriter(w, tc.options...)
+		require.Nil(t, err)
+
+		require.Nil(t, client.GaugeNegative("gauge", -5, []string{"tag1"}, 1))
+		client.Close()
+
+		// the reset and the value are sent in order in the same payload
+		w.Lock()
+		assert.Equal(t, []string{tc.expected}, w.payloads)
+		w.Unlock()
+	}
+
+	// other values are sent as is
+	w := statsdWriterWrapper{}
+	client, err := NewWithWriter(&w, WithoutTelemetry(), WithoutClientSideAggregation())
+	require.Nil(t, err)
+	require.Nil(t, client.GaugeNegative("gauge", 5, nil, 1))
+	require.Nil(t, client.GaugeNegative("gauge", 0, nil, 1))
+	client.Close()
+	assert.Equal(t, []string{"gauge:5|g", "gauge:0|g"}, w.data)
+}
+
+func TestGaugeNegativeParameters(t *testing.T) {
+	w := statsdWriterWrapper{}
+	client, err := NewWithWriter(&w, WithoutTelemetry(), WithNamespace("app."))
+	require.Nil(t, err)
+
+	require.Nil(t, client.GaugeNegative("gauge", -5, nil, 1, Namespace("lib."), CardinalityHigh, ContainerID("container-a")))
+	require.Nil(t, client.GaugeNegative("gauge", 5, nil, 1, Namespace("lib.")))
+	client.Close()
+
+	ts := &testServer{}
+	ts.assertMetric(t, w.data, []string{
+		"lib.gauge:0|g|c:container-a|card:high",
+		"lib.gauge:-5|g|c:container-a|card:high",
+		"lib.gauge:5|g",
+	})
+}
+
+func TestGaugeNegativeSampling(t *testing.T) {
+	w := statsdWriterWrapper{}
+	client, err := NewWithWriter(&w, WithoutTelemetry(), WithSampler(&recordingSampler{}))
+	require.Nil(t, err)
+
+	// the sampler decides once for both messages
+	require.Nil(t, client.GaugeNegative("dropped", -5, nil, 0.5))
+	require.Nil(t, client.GaugeNegative("gauge", -5, nil, 0.5))
+	client.Close()
+
+	assert.Equal(t, []string{"gauge:0|g|@0.5", "gauge:-5|g|@0.5"}, w.data)
+}
+
+func TestUniqueValues(t *testing.T) {
+	assert.Equal(t, []string{"a", "b", "c"}, uniqueValues([]string{"a", "b", "a", "c", "b"}))
+	assert.Equal(t, []string{"a", "b"}, uniqueValues([]string{"a", "b"}))
+	assert.Empty(t, uniqueValues(nil))
+}
+
+func TestClientAdditionalAddresses(t *testing.T) {
+	udpAddr, err := net.ResolveUDPAddr("udp", "localhost:8766")
+	require.Nil(t, err)
+	server, err := net.ListenUDP("udp", udpAddr)
+	require.Nil(t, err)
+	defer server.Close()
+
+	w := statsdWriterWrapper{}
+	client, err := NewWithWriter(&w, WithoutTelemetry(), WithAdditionalAddresses("localhost:8766"))
+	require.Nil(t, err)
+	require.Len(t, client.sender.endpoints, 1)
+
+	client.Gauge("gauge", 21, []string{"tag1"}, 1)
+	client.Flush()
+
+	buffer := make([]byte, 1024)
+	server.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := server.Read(buffer)
+	require.Nil(t, err)
+	assert.Equal(t, "gauge:21|g|#tag1\n", string(buffer[:n]))
+	assert.Equal(t, []string{"gauge:21|g|#tag1"}, w.data)
+	client.Close()
+
+	// an invalid additional address fails the creation of the client
+	_, err = NewWithWriter(&statsdWriterWrapper{}, WithAdditionalAddresses("invalid:address:8125"))
+	assert.Error(t, err)
+}
+
+func TestGetTelemetryConcurrent(t *testing.T) {
+	w := statsdWriterWrapper{}
+	client, err := NewWithWriter(&w, WithoutClientSideAggregation())
+	require.Nil(t, err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				client.Gauge("gauge", 1, nil, 1)
+				client.Count("count", 1, nil, 1)
+				client.GetTelemetry()
+			}
+		}()
+	}
+	wg.Wait()
+
+	tlm := client.GetTelemetry()
+	assert.Equal(t, uint64(2000), tlm.TotalMetrics)
+	assert.Equal(t, uint64(1000), tlm.TotalMetricsGauge)
+	assert.Equal(t, uint64(1000), tlm.TotalMetricsCount)
+
+	client.Close()
+	tlm = client.GetTelemetry()
+	assert.NotZero(t, tlm.TotalPayloadsSent)
+	assert.NotZero(t, tlm.TotalBytesSent)
+	assert.Zero(t, tlm.TotalPayloadsDropped)
+}
+
+func TestEnqueueChannelFull(t *testing.T) {
+	c := &Client{telemetry: &statsdTelemetry{}}
+	input := make(chan metric, 1)
+	input <- metric{}
+
+	// no timeout: the metric is dropped right away
+	c.enqueue(input, metric{name: "dropped"})
+	assert.Equal(t, uint64(1), c.telemetry.totalDroppedOnReceive)
+	assert.Equal(t, uint64(0), c.telemetry.totalDroppedOnTimeout)
+
+	// with a timeout: we wait before dropping the metric
+	c.channelTimeout = 20 * time.Millisecond
+	start := time.Now()
+	c.enqueue(input, metric{name: "dropped"})
+	assert.True(t, time.Since(start) >= 20*time.Millisecond)
+	assert.Equal(t, uint64(1), c.telemetry.totalDroppedOnReceive)
+	assert.Equal(t, uint64(1), c.telemetry.totalDroppedOnTimeout)
+
+	// the channel is emptied before the timeout: the metric is not dropped
+	c.channelTimeout = 5 * time.Second
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		<-input
+	}()
+	c.enqueue(input, metric{name: "sent"})
+	assert.Equal(t, uint64(1), c.telemetry.totalDroppedOnReceive)
+	assert.Equal(t, uint64(1), c.telemetry.totalDroppedOnTimeout)
+	assert.Equal(t, "sent", (<-input).name)
+}
+
+func TestDistributionSamples(t *testing.T) {
+	w := statsdWriterWrapper{}
+	client, err := NewWithWriter(&w, WithoutTelemetry(), WithExtendedClientSideAggregation(), WithMaxBytesPerPayload(40))
+	require.Nil(t, err)
+
+	client.DistributionSamples("distribution", []float64{1.1, 2.2, 3.3, 4.4, 5.5}, []string{"tag1"}, 1)
+	client.DistributionSamples("empty", []float64{}, []string{"tag1"}, 1)
+	assert.Equal(t, uint64(5), client.telemetry.totalMetricsDistribution)
+	client.Close()
+
+	ts := &testServer{}
+	ts.assertMetric(t, w.data, []string{
+		"distribution:1.1:2.2:3.3:4.4|d|#tag1",
+		"distribution:5.5|d|#tag1",
+	})
+}
+
+func TestHistogramCounts(t *testing.T) {
+	w := statsdWriterWrapper{}
+	client, err := NewWithWriter(&w, WithoutTelemetry(), WithExtendedClientSideAggregation(), WithMaxBytesPerPayload(40))
+	require.Nil(t, err)
+
+	assert.Nil(t, client.HistogramCounts("histogram", map[float64]int{3.4: 1, 1.2: 2, 5: 0, 6: -1}, []string{"tag1"}, 1))
+	assert.Nil(t, client.HistogramCounts("empty", map[float64]int{1: 0}, []string{"tag1"}, 1))
+	assert.Nil(t, client.HistogramCounts("split", map[float64]int{1.5: 6, 2.5: 2}, []string{"tag1"}, 1))
+	assert.Equal(t, uint64(11), client.telemetry.totalMetricsHistogram)
+	client.Close()
+
+	ts := &testServer{}
+	ts.assertMetric(t, w.data, []string{
+		"histogram:1.2:1.2:3.4|h|#tag1",
+		"split:1.5:1.5:1.5:1.5:1.5:1.5|h|#tag1",
+		"split:2.5:2.5|h|#tag1",
+	})
+}
+
+func TestHistogramCountsTooManySamples(t *testing.T) {
+	w := statsdWriterWrapper{}
+	client, err := NewWithWriter(&w, WithoutTelemetry())
+	require.Nil(t, err)
+
+	err = client.HistogramCounts("histogram", map[float64]int{1: MaxHistogramCountsSamples, 2: 1}, nil, 1)
+	assert.Equal(t, ErrTooManySamples, err)
+	assert.Nil(t, client.HistogramCounts("histogram", map[float64]int{1: MaxHistogramCountsSamples}, nil, 1))
+	assert.Equal(t, uint64(MaxHistogramCountsSamples), client.telemetry.totalMetricsHistogram)
+	client.Close()
+
+	var nb int
+	for _, payload := range w.data {
+		nb += strings.Count(string(payload), ":1")
+	}
+	assert.Equal(t, MaxHistogramCountsSamples, nb)
+}
+
+func TestResolveRate(t *testing.T) {
+	client, err := NewWithWriter(&statsdWriterWrapper{}, WithoutTelemetry(), WithDefaultSampleRates(map[MetricType]float64{
+		HistogramType: 0.5,
+		CountType:     0.25,
+	}))
+	require.Nil(t, err)
+	defer client.Close()
+
+	assert.Equal(t, 0.5, client.resolveRate(HistogramType, DefaultRate))
+	assert.Equal(t, 0.25, client.resolveRate(CountType, DefaultRate))
+	// explicit rates are kept
+	assert.Equal(t, 0.1, client.resolveRate(HistogramType, 0.1))
+	assert.Equal(t, 1.0, client.resolveRate(CountType, 1))
+	// types without a default keep the given rate
+	assert.Equal(t, DefaultRate, client.resolveRate(GaugeType, DefaultRate))
+}
+
+func TestDefaultSampleRatesSampling(t *testing.T) {
+	w := statsdWriterWrapper{}
+	client, err := NewWithWriter(&w, WithoutTelemetry(), WithoutClientSideAggregation(), WithDefaultSampleRates(map[MetricType]float64{
+		HistogramType: 0.5,
+		TimingType:    1,
+	}))
+	require.Nil(t, err)
+
+	iterations := 1000
+	for i := 0; i < iterations; i++ {
+		client.Histogram("histogram", 1, []string{"tag1"}, DefaultRate)
+	}
+	client.TimeInMilliseconds("timing", 1, []string{"tag1"}, DefaultRate)
+	client.Close()
+
+	histograms := 0
+	for _, m := range w.data {
+		if strings.HasPrefix(m, "histogram") {
+			// the resolved rate is the one sent to the agent
+			assert.Equal(t, "histogram:1|h|@0.5|#tag1", m)
+			histograms++
+		} else {
+			assert.Equal(t, "timing:1.000000|ms|#tag1", m)
+		}
+	}
+	// the resolved rate is the one used to sample
+	assert.InDelta(t, iterations/2, histograms, float64(iterations)/10)
+	assert.Equal(t, histograms+1, len(w.data))
+}
+
+func TestDrain(t *testing.T) {
+	w := statsdWriterWrapper{}
+	client, err := NewWithWriter(&w, WithoutTelemetry(), WithBufferFlushInterval(time.Hour))
+	require.Nil(t, err)
+
+	client.Gauge("gauge", 1, []string{"tag1"}, 1)
+	client.Histogram("histogram", 2, []string{"tag1"}, 1)
+	require.Nil(t, client.Drain(context.Background()))
+
+	ts := &testServer{}
+	ts.assertMetric(t, w.data, []string{
+		"gauge:1|g|#tag1",
+		"histogram:2|h|#tag1",
+	})
+
+	// the client can still be used after a drain
+	w.data = nil
+	client.Count("count", 3, []string{"tag1"}, 1)
+	require.Nil(t, client.Drain(context.Background()))
+	ts.assertMetric(t, w.data, []string{"count:3|c|#tag1"})
+
+	client.Close()
+	assert.Nil(t, client.Drain(context.Background()))
+}
+
+func TestDrainChannelMode(t *testing.T) {
+	for name, options := range map[string][]Option{
+		"channel mode":                           {WithChannelMode(), WithoutClientSideAggregation()},
+		"channel mode with extended aggregation": {WithChannelMode(), WithExtendedClientSideAggregation()},
+	} {
+		t.Run(name, func(t *testing.T) {
+			w := statsdWriterWrapper{}
+			options = append(options, WithoutTelemetry(), WithBufferFlushInterval(time.Hour), WithAggregationInterval(time.Hour))
+			client, err := NewWithWriter(&w, options...)
+			require.Nil(t, err)
+			defer client.Close()
+
+			for i := 0; i < 100; i++ {
+				client.Histogram("histogram", 1, nil, 1)
+			}
+			require.Nil(t, client.Drain(context.Background()))
+
+			// the metrics still queued in the channels when Drain was called are written once it returns
+			assert.Equal(t, 100, strings.Count(strings.Join(w.data, "\n"), ":1"))
+		})
+	}
+}
+
+func TestDrainContext(t *testing.T) {
+	t.Run("blocked synchronous write", func(t *testing.T) {
+		w := &blockingWriter{started: make(chan struct{}), release: make(chan struct{})}
+		client, err := NewWithWriter(w, WithoutTelemetry(), WithSynchronousMode(), WithWorkersCount(1))
+		require.Nil(t, err)
+
+		// the worker is blocked on the write until released
+		go client.Gauge("gauge", 1, nil, 1)
+		<-w.started
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		waitOrFail(t, time.Second, func() {
+			assert.Equal(t, context.DeadlineExceeded, client.Drain(ctx))
+		})
+
+		close(w.release)
+		client.Close()
+	})
+
+	t.Run("concurrent wait for the channels", func(t *testing.T) {
+		client, err := NewWithWriter(&statsdWriterWrapper{}, WithoutTelemetry(), WithChannelMode())
+		require.Nil(t, err)
+
+		// a Flush waiting for the metrics queued in the channels
+		client.inputLock.Lock()
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		waitOrFail(t, time.Second, func() {
+			assert.Equal(t, context.DeadlineExceeded, client.Drain(ctx))
+		})
+
+		client.inputLock.Unlock()
+		client.Close()
+	})
+}
+
+func TestFlushIsSynchronous(t *testing.T) {
+	for name, options := range map[string][]Option{
+		"mutex mode":                             {},
+		"channel mode":                           {WithChannelMode()},
+		"channel mode with extended aggregation": {WithChannelMode(), WithExtendedClientSideAggregation()},
+		"without aggregation":                    {WithoutClientSideAggregation()},
+	} {
+		t.Run(name, func(t *testing.T) {
+			w := statsdWriterWrapper{}
+			options = append(options, WithoutTelemetry(), WithBufferFlushInterval(time.Hour), WithAggregationInterval(time.Hour))
+			client, err := NewWithWriter(&w, options...)
+			require.Nil(t, err)
+			defer client.Close()
+
+			for i := 0; i < 100; i++ {
+				client.Gauge("gauge", float64(i), []string{"tag1"}, 1)
+				client.Count("count", 1, []string{"tag1"}, 1)
+				client.Histogram("histogram", 1, []string{"tag1"}, 1)
+			}
+			require.Nil(t, client.Flush())
+
+			// everything submitted before Flush is written once it returns
+			nbGauges, nbCounts, nbHistograms := 0, 0, 0
+			for _, m := range w.data {
+				switch {
+				case strings.HasPrefix(m, "gauge:"):
+					nbGauges++
+				case strings.HasPrefix(m, "count:"):
+					nbCounts++
+				case strings.HasPrefix(m, "histogram:"):
+					nbHistograms += strings.Count(m, ":1")
+				}
+			}
+			assert.NotZero(t, nbGauges)
+			assert.NotZero(t, nbCounts)
+			assert.Equal(t, 100, nbHistograms)
+			if client.agg != nil {
+				assert.Contains(t, w.data, "gauge:99|g|#tag1")
+				assert.Contains(t, w.data, "count:100|c|#tag1")
+			}
+		})
+	}
+}
+
+func TestClientBufferPoolStats(t *testing.T) {
+	client, err := NewWithWriter(&statsdWriterWrapper{}, WithoutTelemetry(), WithWorkersCount(2), WithBufferPoolSize(2))
+	require.Nil(t, err)
+	defer client.Close()
+
+	// each worker borrows a buffer when created, draining the pool
+	stats := client.BufferPoolStats()
+	assert.Equal(t, 2, stats.Capacity)
+	assert.Equal(t, 0, stats.Available)
+	assert.Equal(t, uint64(2), stats.Gets)
+	assert.Equal(t, uint64(0), stats.Misses)
+
+	// flushing the workers borrows new buffers before the sender returns the written ones
+	client.Gauge("gauge", 1, nil, 1)
+	client.Flush()
+	stats = client.BufferPoolStats()
+	assert.Equal(t, uint64(3), stats.Gets)
+	assert.Equal(t, uint64(1), stats.Misses)
+	assert.Equal(t, uint64(1), stats.Puts)
+
+	var nilClient *Client
+	assert.Equal(t, BufferPoolStats{}, nilClient.BufferPoolStats())
+}
+
+// blockingWriter blocks every write until release is closed. started is closed on the first write.
+type blockingWriter struct {
+	startOnce sync.Once
+	started   chan struct{}
+	release   chan struct{}
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) {
+	w.startOnce.Do(func() { close(w.started) })
+	<-w.release
+	return len(p), nil
+}
+
+func (w *blockingWriter) Close() error {
+	return nil
+}
+
+func TestClientMaxBlockDuration(t *testing.T) {
+	w := &blockingWriter{started: make(chan struct{}), release: make(chan struct{})}
+	errs := make(chan error, 1)
+	client, err := NewWithWriter(w,
+		WithoutTelemetry(),
+		WithWorkersCount(1),
+		WithBufferFlushInterval(time.Hour),
+		WithMaxBlockDuration(20*time.Millisecond),
+		WithErrorHandler(func(err error) { errs <- err }),
+	)
+	require.Nil(t, err)
+
+	require.Nil(t, client.Histogram("first", 1, nil, 1))
+	flushed := make(chan struct{})
+	go func() {
+		// Flush holds the lock of the worker until the stalled write completes
+		client.Flush()
+		close(flushed)
+	}()
+	<-w.started
+
+	start := time.Now()
+	err = client.Histogram("dropped", 1, nil, 1)
+	assert.Equal(t, ErrBlockTimeout, err)
+	assert.True(t, time.Since(start) >= 20*time.Millisecond)
+	assert.Equal(t, uint64(1), atomic.LoadUint64(&client.telemetry.totalDroppedOnTimeout))
+	assert.Equal(t, &DroppedMetricError{Name: "dropped", Reason: ErrBlockTimeout}, <-errs)
+
+	close(w.release)
+	<-flushed
+	// once the write completes callers are not blocked anymore
+	assert.Nil(t, client.Histogram("after", 1, nil, 1))
+	client.Close()
+	assert.Equal(t, uint64(1), atomic.LoadUint64(&client.telemetry.totalDroppedOnTimeout))
+}
+
+func TestCloseWithContext(t *testing.T) {
+	w := &blockingWriter{started: make(chan struct{}), release: make(chan struct{})}
+	client, err := NewWithWriter(w, WithoutTelemetry())
+	require.Nil(t, err)
+	require.Nil(t, client.Gauge("gauge", 1, nil, 1))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	assert.Equal(t, context.DeadlineExceeded, client.CloseWithContext(ctx))
+	assert.True(t, time.Since(start) < time.Second)
+
+	<-w.started
+	close(w.release)
+	// the client is already closed
+	assert.Nil(t, client.Close())
+
+	ww := statsdWriterWrapper{}
+	client, err = NewWithWriter(&ww, WithoutTelemetry())
+	require.Nil(t, err)
+	client.Gauge("gauge", 1, nil, 1)
+	assert.Nil(t, client.CloseWithContext(context.Background()))
+	assert.Equal(t, []string{"gauge:1|g"}, ww.data)
+}
+
+func TestFlushAfterClose(t *testing.T) {
+	client, err := NewWithWriter(&statsdWriterWrapper{}, WithoutTelemetry(), WithChannelMode())
+	require.Nil(t, err)
+	client.Close()
+	assert.Nil(t, client.Flush())
+}
+
+// waitOrFail fails the test if f doesn't return within timeout.
+func waitOrFail(t *testing.T, timeout time.Duration, f func()) {
+	done := make(chan struct{})
+	go func() {
+		f()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		t.Fatal("deadlock: the call didn't return")
+	}
+}
+
+func TestConcurrentFlush(t *testing.T) {
+	client, err := NewWithWriter(&payloadRecorder{}, WithoutTelemetry(), WithChannelMode(), WithExtendedClientSideAggregation(), WithWorkersCount(4))
+	require.Nil(t, err)
+
+	waitOrFail(t, 10*time.Second, func() {
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 200; j++ {
+					client.Histogram("histogram", 1, nil, 1)
+					client.Flush()
+				}
+			}()
+		}
+		wg.Wait()
+	})
+	waitOrFail(t, 10*time.Second, func() { client.Close() })
+}
+
+func TestFlushDuringClose(t *testing.T) {
+	for i := 0; i < 20; i++ {
+		client, err := NewWithWriter(&payloadRecorder{}, WithoutTelemetry(), WithChannelMode(), WithExtendedClientSideAggregation(), WithWorkersCount(4))
+		require.Nil(t, err)
+
+		waitOrFail(t, 10*time.Second, func() {
+			var wg sync.WaitGroup
+			for j := 0; j < 4; j++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for k := 0; k < 10; k++ {
+						client.Histogram("histogram", 1, nil, 1)
+						client.Flush()
+					}
+				}()
+			}
+			client.Close()
+			wg.Wait()
+		})
+	}
+}
+
+func TestEnqueueChannelFullErrorHandler(t *testing.T) {
+	errs := make(chan error, 2)
+	c := &Client{telemetry: &statsdTelemetry{}, errorReporter: newErrorReporter(func(err error) { errs <- err })}
+	defer c.errorReporter.close()
+	input := make(chan metric, 1)
+	input <- metric{}
+
+	c.enqueue(input, metric{name: "dropped"})
+	assert.Equal(t, &DroppedMetricError{Name: "dropped", Reason: ErrQueueFull}, <-errs)
+
+	c.channelTimeout = time.Millisecond
+	c.enqueue(input, metric{name: "dropped_on_timeout"})
+	assert.Equal(t, &DroppedMetricError{Name: "dropped_on_timeout", Reason: ErrQueueFull}, <-errs)
+}
+
+func TestEnqueueChannelFullErrOnFull(t *testing.T) {
+	c := &Client{telemetry: &statsdTelemetry{}}
+	input := make(chan metric, 1)
+	input <- metric{}
+
+	// the default behavior is kept
+	assert.Nil(t, c.enqueue(input, metric{name: "dropped"}))
+
+	c.errOnFullQueue = true
+	assert.Equal(t, ErrQueueFull, c.enqueue(input, metric{name: "dropped"}))
+	c.channelTimeout = time.Millisecond
+	assert.Equal(t, ErrQueueFull, c.enqueue(input, metric{name: "dropped_on_timeout"}))
+
+	<-input
+	assert.Nil(t, c.enqueue(input, metric{name: "queued"}))
+}
+
+func TestClientChannelModeErrOnFull(t *testing.T) {
+	client, err := NewWithWriter(&statsdWriterWrapper{},
+		WithoutTelemetry(),
+		WithChannelMode(),
+		WithoutClientSideAggregation(),
+		WithChannelModeBufferSize(1),
+		WithWorkersCount(1),
+		WithChannelModeErrOnFull(),
+	)
+	require.Nil(t, err)
+
+	// the worker blocks on its lock after taking a metric out of the channel, so the channel is full after a few calls
+	client.workers[0].pause()
+	for i := 0; i < 3 && err == nil; i++ {
+		err = client.Gauge("gauge", 1, nil, 1)
+	}
+	assert.Equal(t, ErrQueueFull, err)
+	assert.Equal(t, ErrQueueFull, client.Count("count", 1, nil, 1))
+	assert.True(t, client.GetTelemetry().TotalDroppedOnReceive >= 2)
+
+	client.workers[0].unpause()
+	client.Close()
+}
+
+func TestErrorHandlerBufferTooSmall(t *testing.T) {
+	errs := []error{}
+	client, err := NewWithWriter(&statsdWriterWrapper{},
+		WithoutTelemetry(),
+		WithMaxBytesPerPayload(20),
+		WithErrorHandler(func(err error) { errs = append(errs, err) }),
+	)
+	require.Nil(t, err)
+
+	client.Histogram("a_histogram_with_a_long_name", 1, nil, 1)
+	client.Close()
+
+	// the reporter is stopped by Close so reading errs is safe
+	assert.Equal(t, []error{&DroppedMetricError{Name: "a_histogram_with_a_long_name", Reason: ErrBufferTooSmall}}, errs)
+}
+
+func TestErrorHandlerSerialization(t *testing.T) {
+	errs := []error{}
+	client, err := NewWithWriter(&statsdWriterWrapper{},
+		WithoutTelemetry(),
+		WithStrictNameValidation(),
+		WithErrorHandler(func(err error) { errs = append(errs, err) }),
+	)
+	require.Nil(t, err)
+
+	nameErr := client.Gauge("bad name", 1, nil, 1)
+	require.Error(t, nameErr)
+	tagErr := client.Count("count", 1, []string{"bad|tag"}, 1)
+	require.Error(t, tagErr)
+	require.Nil(t, client.Gauge("good", 1, nil, 1))
+	client.Close()
+
+	assert.Equal(t, uint64(2), client.GetTelemetry().TotalMetricsDroppedSerialization)
+	// the drops for capacity reasons are not counted with them
+	assert.Equal(t, uint64(0), client.GetTelemetry().TotalMetricsDroppedOversized)
+	assert.Equal(t, []error{
+		&DroppedMetricError{Name: "bad name", Reason: ErrSerialization, Cause: nameErr},
+		&DroppedMetricError{Name: "count", Reason: ErrSerialization, Cause: tagErr},
+	}, errs)
+}
+
+func TestClientOutageBuffer(t *testing.T) {
+	writer := &outageWriter{down: true}
+	client, err := NewWithWriter(writer, WithoutTelemetry(), WithoutClientSideAggregation(), WithOutageBuffer(1024))
+	require.Nil(t, err)
+
+	require.Nil(t, client.Gauge("before", 1, nil, 1))
+	require.Nil(t, client.Flush())
+	assert.Empty(t, writer.received())
+
+	writer.setDown(false)
+	require.Nil(t, client.Gauge("after", 1, nil, 1))
+	require.Nil(t, client.Close())
+	assert.Equal(t, []string{"before:1|g\n", "after:1|g\n"}, writer.received())
+	assert.Equal(t, uint64(0), client.GetTelemetry().TotalPayloadsDroppedWriter)
+}
+
+func TestDroppedMetrics(t *testing.T) {
+	writer := &outageWriter{down: true}
+	client, err := NewWithWriter(writer, WithoutTelemetry(), WithoutClientSideAggregation(), WithSynchronousMode(), WithStrictNameValidation())
+	require.Nil(t, err)
+	defer client.Close()
+	assert.Equal(t, uint64(0), client.DroppedMetrics())
+
+	// every message of a payload that could not be written is counted
+	client.Batch(func(b *Batch) {
+		b.Gauge("gauge", 1, nil, 1)
+		b.Count("count", 1, nil, 1)
+	})
+	assert.Equal(t, uint64(2), client.DroppedMetrics())
+	require.Error(t, client.Gauge("bad name", 1, nil, 1))
+	assert.Equal(t, uint64(3), client.DroppedMetrics())
+
+	client.ResetDroppedMetrics()
+	assert.Equal(t, uint64(0), client.DroppedMetrics())
+	client.Gauge("gauge", 1, nil, 1)
+	assert.Equal(t, uint64(1), client.DroppedMetrics())
+
+	// the telemetry is not reset
+	assert.Equal(t, uint64(2), client.GetTelemetry().TotalPayloadsDroppedWriter)
+	assert.Equal(t, uint64(1), client.GetTelemetry().TotalMetricsDroppedSerialization)
+
+	var nilClient *Client
+	assert.Equal(t, uint64(0), nilClient.DroppedMetrics())
+	nilClient.ResetDroppedMetrics()
+}
@@ -0,0 +1,22 @@
+package statsdtest_test
+
+import (
+	"fmt"
+
+	"github.com/DataDog/datadog-go/v5/statsd"
+	"github.com/DataDog/datadog-go/v5/statsd/statsdtest"
+)
+
+func handleRequest(client statsd.ClientInterface) {
+	client.Gauge("queue.size", 12, []string{"env:prod"}, 1)
+}
+
+func ExampleRecordingClient() {
+	client := statsdtest.NewRecordingClient()
+	handleRequest(client)
+
+	for _, call := range client.GaugeCalls("queue.size") {
+		fmt.Println(call.Value, statsdtest.HasTag(call, "env:prod"))
+	}
+	// Output: 12 true
+}
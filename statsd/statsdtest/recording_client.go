@@ -0,0 +1,85 @@
+/*
+Package statsdtest provides test doubles for the statsd client, letting the code emitting metrics be unit tested
+without a real socket.
+*/
+package statsdtest
+
+import (
+	"github.com/DataDog/datadog-go/v5/statsd"
+)
+
+// Call is a metric submitted to a RecordingClient.
+type Call = statsd.RecordedMetric
+
+// RecordingClient is a statsd.ClientInterface storing every call it receives. It is safe to use from multiple
+// goroutines simultaneously. Events and service checks are available through the methods of the embedded
+// statsd.TestClient.
+type RecordingClient struct {
+	*statsd.TestClient
+}
+
+// NewRecordingClient returns a new RecordingClient.
+func NewRecordingClient() *RecordingClient {
+	return &RecordingClient{TestClient: statsd.NewTestClient()}
+}
+
+// Calls returns every metric recorded so far, in the order they were submitted.
+func (r *RecordingClient) Calls() []Call {
+	return r.Metrics()
+}
+
+// CallsOf returns the metrics of the given type and name recorded so far, in the order they were submitted.
+func (r *RecordingClient) CallsOf(metricType statsd.MetricType, name string) []Call {
+	var calls []Call
+	for _, call := range r.Metrics() {
+		if call.Type == metricType && call.Name == name {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+// GaugeCalls returns the gauges named name recorded so far.
+func (r *RecordingClient) GaugeCalls(name string) []Call {
+	return r.CallsOf(statsd.GaugeType, name)
+}
+
+// CountCalls returns the counts named name recorded so far, including the ones sent through Incr, Decr and
+// MonotonicCount.
+func (r *RecordingClient) CountCalls(name string) []Call {
+	return r.CallsOf(statsd.CountType, name)
+}
+
+// HistogramCalls returns the histograms named name recorded so far.
+func (r *RecordingClient) HistogramCalls(name string) []Call {
+	return r.CallsOf(statsd.HistogramType, name)
+}
+
+// DistributionCalls returns the distributions named name recorded so far.
+func (r *RecordingClient) DistributionCalls(name string) []Call {
+	return r.CallsOf(statsd.DistributionType, name)
+}
+
+// SetCalls returns the sets named name recorded so far, one per value for the ones sent through SetMulti.
+func (r *RecordingClient) SetCalls(name string) []Call {
+	return r.CallsOf(statsd.SetType, name)
+}
+
+// TimingCalls returns the timings named name recorded so far.
+func (r *RecordingClient) TimingCalls(name string) []Call {
+	return r.CallsOf(statsd.TimingType, name)
+}
+
+// HasTag returns true if the call was submitted with the given tag.
+func HasTag(call Call, tag string) bool {
+	for _, t := range call.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// Verify that RecordingClient implements the ClientInterface.
+// https://golang.org/doc/faq#guarantee_satisfies_interface
+var _ statsd.ClientInterface = &RecordingClient{}
@@ -0,0 +1,61 @@
+package statsdtest
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/DataDog/datadog-go/v5/statsd"
+)
+
+func TestRecordingClientCalls(t *testing.T) {
+	r := NewRecordingClient()
+
+	r.Gauge("requests.pending", 3, []string{"env:prod"}, 1)
+	r.Incr("requests", []string{"env:prod"}, 0.5)
+	r.Gauge("requests.pending", 1, nil, 1)
+	r.Timing("requests.duration", 20*time.Millisecond, nil, 1)
+	r.Set("users", "alice", nil, 1)
+
+	assert.Len(t, r.Calls(), 5)
+
+	gauges := r.GaugeCalls("requests.pending")
+	require.Len(t, gauges, 2)
+	assert.Equal(t, float64(3), gauges[0].Value)
+	assert.True(t, HasTag(gauges[0], "env:prod"))
+	assert.False(t, HasTag(gauges[1], "env:prod"))
+
+	counts := r.CountCalls("requests")
+	require.Len(t, counts, 1)
+	assert.Equal(t, Call{Type: statsd.CountType, Name: "requests", Value: 1, Tags: []string{"env:prod"}, Rate: 0.5}, counts[0])
+
+	assert.Len(t, r.TimingCalls("requests.duration"), 1)
+	assert.Len(t, r.SetCalls("users"), 1)
+	assert.Empty(t, r.HistogramCalls("requests.pending"))
+	assert.Empty(t, r.DistributionCalls("requests"))
+
+	r.Reset()
+	assert.Empty(t, r.Calls())
+}
+
+func TestRecordingClientConcurrency(t *testing.T) {
+	r := NewRecordingClient()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				r.Count("count", 1, nil, 1)
+				r.Calls()
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Len(t, r.CountCalls("count"), 1000)
+}
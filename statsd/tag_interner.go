@@ -0,0 +1,111 @@
+package statsd
+
+import (
+	"container/list"
+	"sync"
+)
+
+// tagInternerSize is the number of tags kept by the cache of WithTagInterning. The least recently used tag is evicted
+// once full so that high cardinality tags can't make the cache grow without bound.
+const tagInternerSize = 4096
+
+// internedTag is an entry of the tagInterner: a tag as given to a metric call and its interned version.
+type internedTag struct {
+	tag      string
+	interned string
+}
+
+// tagInterner is a concurrency safe LRU cache of tags, normalized or as given, see WithTagInterning.
+type tagInterner struct {
+	sync.Mutex
+	size int
+	// normalize caches the normalized tags instead of the tags as given, see WithTagNormalization.
+	normalize bool
+	entries   map[string]*list.Element
+	lru       *list.List
+}
+
+func newTagInterner(size int, normalize bool) *tagInterner {
+	return &tagInterner{
+		size:      size,
+		normalize: normalize,
+		entries:   make(map[string]*list.Element, size),
+		lru:       list.New(),
+	}
+}
+
+// intern returns the interned version of tag, normalized (see normalizeTag) when the interner normalizes and as given
+// otherwise: the same string is returned for every call with an identical tag as long as it stays in the cache. When
+// normalizing, the tags already normalized are returned as is without taking the lock: they are the most common ones
+// and don't allocate anyway, only the others are cached.
+func (t *tagInterner) intern(tag string) string {
+	if t.normalize && isNormalizedTag(tag) {
+		return tag
+	}
+
+	t.Lock()
+	if e, ok := t.entries[tag]; ok {
+		t.lru.MoveToFront(e)
+		interned := e.Value.(*internedTag).interned
+		t.Unlock()
+		return interned
+	}
+	t.Unlock()
+
+	interned := tag
+	if t.normalize {
+		interned = normalizeTag(tag)
+	}
+
+	t.Lock()
+	defer t.Unlock()
+	// another goroutine might have added the tag in the meantime
+	if e, ok := t.entries[tag]; ok {
+		t.lru.MoveToFront(e)
+		return e.Value.(*internedTag).interned
+	}
+	t.entries[tag] = t.lru.PushFront(&internedTag{tag: tag, interned: interned})
+	if t.lru.Len() > t.size {
+		oldest := t.lru.Back()
+		t.lru.Remove(oldest)
+		delete(t.entries, oldest.Value.(*internedTag).tag)
+	}
+	return interned
+}
+
+// internTags interns every tag, the caller's slice is never modified. When normalizing it is normalizeTags using the
+// cache and the slice is only copied when a tag is modified, otherwise a new slice holding the interned tags is
+// returned.
+func (t *tagInterner) internTags(tags []string) []string {
+	if !t.normalize {
+		if len(tags) == 0 {
+			return tags
+		}
+		interned := make([]string, len(tags))
+		for i, tag := range tags {
+			interned[i] = t.intern(tag)
+		}
+		return interned
+	}
+	for i, tag := range tags {
+		normalizedTag := t.intern(tag)
+		if normalizedTag == tag {
+			continue
+		}
+		normalized := make([]string, len(tags))
+		copy(normalized, tags[:i])
+		normalized[i] = normalizedTag
+		for j, tag := range tags[i+1:] {
+			normalized[i+1+j] = t.intern(tag)
+		}
+		return normalized
+	}
+	return tags
+}
+
+// len returns the number of tags in the cache.
+func (t *tagInterner) len() int {
+	t.Lock()
+	defer t.Unlock()
+	return t.lru.Len()
+}
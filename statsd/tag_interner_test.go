@@ -0,0 +1,145 @@
+package statsd
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTagInterner(t *testing.T) {
+	interner := newTagInterner(10, true)
+
+	tags := []string{"env:prod", "Host Name:a", "region:eu"}
+	assert.Equal(t, []string{"env:prod", "host_name:a", "region:eu"}, interner.internTags(tags))
+	// the caller's slice is left untouched
+	assert.Equal(t, []string{"env:prod", "Host Name:a", "region:eu"}, tags)
+	// only the tags that needed to be normalized are cached
+	assert.Equal(t, 1, interner.len())
+
+	normalized := []string{"env:prod", "region:eu"}
+	result := interner.internTags(normalized)
+	assert.Equal(t, &normalized[0], &result[0])
+	assert.Equal(t, 1, interner.len())
+
+	// a cached tag is not normalized again
+	allocs := testing.AllocsPerRun(100, func() {
+		interner.intern("Host Name:a")
+	})
+	assert.Equal(t, 0.0, allocs)
+}
+
+func TestTagInternerAsGiven(t *testing.T) {
+	interner := newTagInterner(10, false)
+
+	tags := []string{"Env:prod", "Host Name:a"}
+	interned := interner.internTags(tags)
+	assert.Equal(t, tags, interned)
+	assert.Equal(t, 2, interner.len())
+
+	// an identical tag built by another call gets the cached string
+	tag := fmt.Sprintf("Env:%s", "prod")
+	assert.Equal(t, "Env:prod", interner.intern(tag))
+	assert.Equal(t, 2, interner.len())
+	assert.Empty(t, interner.internTags(nil))
+}
+
+func TestTagInternerEviction(t *testing.T) {
+	interner := newTagInterner(2, true)
+
+	interner.intern("TagA")
+	interner.intern("TagB")
+	// "TagA" becomes the most recently used tag, "TagB" is evicted
+	interner.intern("TagA")
+	interner.intern("TagC")
+	assert.Equal(t, 2, interner.len())
+	assert.Contains(t, interner.entries, "TagA")
+	assert.Contains(t, interner.entries, "TagC")
+	assert.NotContains(t, interner.entries, "TagB")
+
+	for i := 0; i < 100; i++ {
+		assert.Equal(t, fmt.Sprintf("user_id:%d", i), interner.intern(fmt.Sprintf("USER_ID:%d", i)))
+	}
+	assert.Equal(t, 2, interner.len())
+}
+
+func TestTagInternerConcurrent(t *testing.T) {
+	interner := newTagInterner(8, true)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				tag := fmt.Sprintf("Key %d:%d", i, j%16)
+				assert.Equal(t, fmt.Sprintf("key_%d:%d", i, j%16), interner.intern(tag))
+			}
+		}(i)
+	}
+	wg.Wait()
+	assert.Equal(t, 8, interner.len())
+}
+
+func TestClientTagInterning(t *testing.T) {
+	send := func(options ...Option) []string {
+		w := statsdWriterWrapper{}
+		client, err := NewWithWriter(&w, append(options,
+			WithoutTelemetry(),
+			WithTags([]string{"Env:prod"}),
+		)...)
+		require.Nil(t, err)
+
+		for i := 0; i < 3; i++ {
+			client.Gauge("gauge", float64(i), []string{"Host Name:a", "region:eu"}, 1)
+			client.Count("count", 1, []string{"Host Name:a", "Team:Core"}, 1)
+			client.Distribution("distribution", 1, []string{"Team:Core"}, 1)
+		}
+		client.Close()
+		return w.data
+	}
+
+	for _, options := range [][]Option{{}, {WithExtendedClientSideAggregation()}, {WithoutClientSideAggregation()}} {
+		expected := send(append(options, WithTagNormalization())...)
+		require.NotEmpty(t, expected)
+
+		ts := &testServer{}
+		ts.assertMetric(t, send(append(options, WithTagNormalization(), WithTagInterning())...), expected)
+		// the interning alone doesn't change the tags sent
+		assert.Equal(t, send(options...), send(append(options, WithTagInterning())...))
+	}
+}
+
+var benchmarkTags = map[string][]string{
+	"not-normalized": {"Env:prod", "Host Name:a", "Region:eu", "Team:Core"},
+	"normalized":     {"env:prod", "host_name:a", "region:eu", "team:Core"},
+}
+
+func BenchmarkNormalizeTags(b *testing.B) {
+	for name, tags := range benchmarkTags {
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					normalizeTags(tags)
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkInternTags(b *testing.B) {
+	for name, tags := range benchmarkTags {
+		b.Run(name, func(b *testing.B) {
+			interner := newTagInterner(tagInternerSize, true)
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					interner.internTags(tags)
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,169 @@
+package statsd
+
+import (
+	"context"
+	"time"
+)
+
+// TaggedClient sends everything through a parent Client while adding a set of constant tags. It is created by
+// Client.WithTags.
+//
+// A TaggedClient doesn't own any resource: it shares the sender, workers and aggregator of its parent and doesn't start
+// any goroutine, so it is cheap to create one per request. The tags of the metrics sent through it are, in order: the
+// global tags of the parent, the tags of the TaggedClient then the tags given to each call.
+type TaggedClient struct {
+	client *Client
+	tags   []string
+}
+
+// WithTags returns a TaggedClient adding the given tags to every metric, event and service check sent through it.
+func (c *Client) WithTags(tags ...string) *TaggedClient {
+	return &TaggedClient{client: c, tags: tags}
+}
+
+// WithTags returns a new TaggedClient with the given tags added after the ones of t.
+func (t *TaggedClient) WithTags(tags ...string) *TaggedClient {
+	return &TaggedClient{client: t.client, tags: t.mergeTags(tags)}
+}
+
+// mergeTags returns the tags of t followed by tags. A new slice is allocated
+// so the tags of t are never modified.
+func (t *TaggedClient) mergeTags(tags []string) []string {
+	if len(tags) == 0 {
+		return t.tags
+	}
+	return append(t.tags[:len(t.tags):len(t.tags)], tags...)
+}
+
+// Gauge measures the value of a metric at a particular time.
+func (t *TaggedClient) Gauge(name string, value float64, tags []string, rate float64, parameters ...Parameter) error {
+	return t.client.Gauge(name, value, t.mergeTags(tags), rate, parameters...)
+}
+
+// GaugeWithTimestamp measures the value of a metric at a given time.
+func (t *TaggedClient) GaugeWithTimestamp(name string, value float64, tags []string, rate float64, timestamp time.Time, parameters ...Parameter) error {
+	return t.client.GaugeWithTimestamp(name, value, t.mergeTags(tags), rate, timestamp, parameters...)
+}
+
+// GaugeNegative sets a gauge to a negative value by sending a reset of the gauge to 0 followed by the value.
+func (t *TaggedClient) GaugeNegative(name string, value float64, tags []string, rate float64, parameters ...Parameter) error {
+	return t.client.GaugeNegative(name, value, t.mergeTags(tags), rate, parameters...)
+}
+
+// Count tracks how many times something happened per second.
+func (t *TaggedClient) Count(name string, value int64, tags []string, rate float64, parameters ...Parameter) error {
+	return t.client.Count(name, value, t.mergeTags(tags), rate, parameters...)
+}
+
+// CountWithTimestamp tracks how many times something happened at the given second.
+func (t *TaggedClient) CountWithTimestamp(name string, value int64, tags []string, rate float64, timestamp time.Time, parameters ...Parameter) error {
+	return t.client.CountWithTimestamp(name, value, t.mergeTags(tags), rate, timestamp, parameters...)
+}
+
+// MonotonicCount tracks a counter that only ever increases. The last value is shared with the parent Client.
+func (t *TaggedClient) MonotonicCount(name string, value float64, tags []string, rate float64, parameters ...Parameter) error {
+	return t.client.MonotonicCount(name, value, t.mergeTags(tags), rate, parameters...)
+}
+
+// Histogram tracks the statistical distribution of a set of values on each host.
+func (t *TaggedClient) Histogram(name string, value float64, tags []string, rate float64, parameters ...Parameter) error {
+	return t.client.Histogram(name, value, t.mergeTags(tags), rate, parameters...)
+}
+
+// Distribution tracks the statistical distribution of a set of values across your infrastructure.
+func (t *TaggedClient) Distribution(name string, value float64, tags []string, rate float64, parameters ...Parameter) error {
+	return t.client.Distribution(name, value, t.mergeTags(tags), rate, parameters...)
+}
+
+// DistributionSamples is similar to Distribution but sends a batch of values at once.
+func (t *TaggedClient) DistributionSamples(name string, values []float64, tags []string, rate float64, parameters ...Parameter) error {
+	return t.client.DistributionSamples(name, values, t.mergeTags(tags), rate, parameters...)
+}
+
+// Add is just Count of delta, which can be negative.
+func (t *TaggedClient) Add(name string, delta int64, tags []string, rate float64, parameters ...Parameter) error {
+	return t.client.Add(name, delta, t.mergeTags(tags), rate, parameters...)
+}
+
+// Decr is just Count of -1
+func (t *TaggedClient) Decr(name string, tags []string, rate float64, parameters ...Parameter) error {
+	return t.client.Decr(name, t.mergeTags(tags), rate, parameters...)
+}
+
+// Incr is just Count of 1
+func (t *TaggedClient) Incr(name string, tags []string, rate float64, parameters ...Parameter) error {
+	return t.client.Incr(name, t.mergeTags(tags), rate, parameters...)
+}
+
+// Set counts the number of unique elements in a group.
+func (t *TaggedClient) Set(name string, value string, tags []string, rate float64, parameters ...Parameter) error {
+	return t.client.Set(name, value, t.mergeTags(tags), rate, parameters...)
+}
+
+// SetInt counts the number of unique integers in a group.
+func (t *TaggedClient) SetInt(name string, value int64, tags []string, rate float64, parameters ...Parameter) error {
+	return t.client.SetInt(name, value, t.mergeTags(tags), rate, parameters...)
+}
+
+// SetMulti adds several unique elements to a set in one call.
+func (t *TaggedClient) SetMulti(name string, values []string, tags []string, rate float64, parameters ...Parameter) error {
+	return t.client.SetMulti(name, values, t.mergeTags(tags), rate, parameters...)
+}
+
+// Timing sends timing information, it is an alias for TimeInMilliseconds
+func (t *TaggedClient) Timing(name string, value time.Duration, tags []string, rate float64, parameters ...Parameter) error {
+	return t.client.Timing(name, value, t.mergeTags(tags), rate, parameters...)
+}
+
+// TimeInMilliseconds sends timing information in milliseconds.
+func (t *TaggedClient) TimeInMilliseconds(name string, value float64, tags []string, rate float64, parameters ...Parameter) error {
+	return t.client.TimeInMilliseconds(name, value, t.mergeTags(tags), rate, parameters...)
+}
+
+// Event sends the provided Event. The Event is copied so its tags are left untouched.
+func (t *TaggedClient) Event(e *Event) error {
+	if e == nil {
+		return t.client.Event(e)
+	}
+	event := *e
+	event.Tags = t.mergeTags(e.Tags)
+	return t.client.Event(&event)
+}
+
+// SimpleEvent sends an event with the provided title and text.
+func (t *TaggedClient) SimpleEvent(title, text string) error {
+	return t.Event(NewEvent(title, text))
+}
+
+// ServiceCheck sends the provided ServiceCheck. The ServiceCheck is copied so its tags are left untouched.
+func (t *TaggedClient) ServiceCheck(sc *ServiceCheck) error {
+	if sc == nil {
+		return t.client.ServiceCheck(sc)
+	}
+	serviceCheck := *sc
+	serviceCheck.Tags = t.mergeTags(sc.Tags)
+	return t.client.ServiceCheck(&serviceCheck)
+}
+
+// SimpleServiceCheck sends an serviceCheck with the provided name and status.
+func (t *TaggedClient) SimpleServiceCheck(name string, status ServiceCheckStatus) error {
+	return t.ServiceCheck(NewServiceCheck(name, status))
+}
+
+// Close does nothing: the parent Client owns the connection and must be closed instead.
+func (t *TaggedClient) Close() error {
+	return nil
+}
+
+// Flush flushes the parent Client.
+func (t *TaggedClient) Flush() error {
+	return t.client.Flush()
+}
+
+// Drain drains the parent Client, see Client.Drain.
+func (t *TaggedClient) Drain(ctx context.Context) error {
+	return t.client.Drain(ctx)
+}
+
+// Verify that TaggedClient implements the ClientInterface.
+var _ ClientInterface = &TaggedClient{}
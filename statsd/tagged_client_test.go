@@ -0,0 +1,81 @@
+package statsd
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTaggedClient(t *testing.T) {
+	w := statsdWriterWrapper{}
+	client, err := NewWithWriter(&w, WithoutTelemetry(), WithTags([]string{"global"}), WithExtendedClientSideAggregation())
+	require.Nil(t, err)
+
+	child := client.WithTags("child1", "child2")
+	child.Gauge("gauge", 1, []string{"tag1"}, 1)
+	child.Count("count", 2, nil, 1)
+	child.Histogram("histogram", 3, []string{"tag1"}, 1)
+	child.TimeInMilliseconds("timing", 4, []string{"tag1"}, 1)
+	child.GaugeWithTimestamp("gauge_ts", 5, []string{"tag1"}, 1, time.Unix(1658934956, 0))
+	child.GaugeNegative("gauge_neg", -7, []string{"tag1"}, 1)
+	// metrics from the parent and the child are aggregated in different contexts
+	client.Gauge("gauge", 6, []string{"tag1"}, 1)
+
+	// nested tagged clients
+	child.WithTags("grandchild").Incr("incr", []string{"tag1"}, 1)
+	child.Add("add", -3, []string{"tag1"}, 1)
+	child.SetMulti("set", []string{"a", "b"}, []string{"tag1"}, 1)
+
+	event := NewEvent("title", "text")
+	event.Tags = []string{"tag1"}
+	child.Event(event)
+	child.SimpleServiceCheck("sc", Ok)
+
+	require.Nil(t, child.Close())
+	require.Nil(t, child.Drain(context.Background()))
+	client.Close()
+
+	// the event given by the user is left untouched
+	assert.Equal(t, []string{"tag1"}, event.Tags)
+
+	ts := &testServer{}
+	ts.assertMetric(t, w.data, []string{
+		"gauge:1|g|#global,child1,child2,tag1",
+		"count:2|c|#global,child1,child2",
+		"histogram:3|h|#global,child1,child2,tag1",
+		"timing:4.000000|ms|#global,child1,child2,tag1",
+		"gauge_ts:5|g|#global,child1,child2,tag1|T1658934956",
+		"gauge_neg:0|g|#global,child1,child2,tag1",
+		"gauge_neg:-7|g|#global,child1,child2,tag1",
+		"gauge:6|g|#global,tag1",
+		"incr:1|c|#global,child1,child2,grandchild,tag1",
+		"add:-3|c|#global,child1,child2,tag1",
+		"set:a|s|#global,child1,child2,tag1",
+		"set:b|s|#global,child1,child2,tag1",
+		"_e{5,4}:title|text|#global,child1,child2,tag1",
+		"_sc|sc|0|#global,child1,child2",
+	})
+}
+
+func TestTaggedClientDoesNotModifyTags(t *testing.T) {
+	tags := make([]string, 1, 10)
+	tags[0] = "child"
+	child := (&Client{}).WithTags(tags...)
+
+	a := child.mergeTags([]string{"a"})
+	b := child.mergeTags([]string{"b"})
+	assert.Equal(t, []string{"child", "a"}, a)
+	assert.Equal(t, []string{"child", "b"}, b)
+	assert.Equal(t, []string{"child"}, child.mergeTags(nil))
+}
+
+func TestTaggedClientNilParent(t *testing.T) {
+	var c *Client
+	child := c.WithTags("child")
+	assert.Equal(t, ErrNoClient, child.Gauge("gauge", 1, nil, 1))
+	assert.Equal(t, ErrNoClient, child.Event(NewEvent("title", "text")))
+	assert.Equal(t, ErrNoClient, child.Flush())
+}
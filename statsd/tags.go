@@ -0,0 +1,197 @@
+package statsd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// tagKey returns the key of a tag: the text before the first ':', or the whole tag if it has no value.
+func tagKey(tag string) string {
+	if i := strings.IndexByte(tag, ':'); i >= 0 {
+		return tag[:i]
+	}
+	return tag
+}
+
+// dedupTags returns the global tags followed by the tags of the call, keeping only the last tag for each key so the
+// tags of the call override the global ones. The order of the remaining tags is preserved.
+func dedupTags(globalTags []string, tags []string) []string {
+	merged := make([]string, 0, len(globalTags)+len(tags))
+	merged = append(merged, globalTags...)
+	merged = append(merged, tags...)
+
+	// the lists are usually short enough for a quadratic lookup to be faster than building a map
+	deduped := merged[:0]
+	for i, tag := range merged {
+		key := tagKey(tag)
+		overridden := false
+		for _, next := range merged[i+1:] {
+			if tagKey(next) == key {
+				overridden = true
+				break
+			}
+		}
+		if !overridden {
+			deduped = append(deduped, tag)
+		}
+	}
+	return deduped
+}
+
+// withUnifiedServiceTags returns a copy of tags followed by the tags read from the environment, see
+// WithUnifiedServiceTagging: the ones of ddEnvTagsMapping then the ones of DD_TAGS. A tag read from the environment is
+// skipped when its key is already used by tags or by a previous environment tag, except for the repeated keys of
+// DD_TAGS which are all kept.
+func withUnifiedServiceTags(tags []string) []string {
+	result := make([]string, len(tags), len(tags)+len(ddEnvTagsMapping))
+	copy(result, tags)
+	usedKeys := map[string]bool{}
+	for _, tag := range tags {
+		usedKeys[tagKey(tag)] = true
+	}
+
+	for _, mapping := range ddEnvTagsMapping {
+		if value := os.Getenv(mapping.envName); value != "" && !usedKeys[mapping.tagName] {
+			result = append(result, fmt.Sprintf("%s:%s", mapping.tagName, value))
+			usedKeys[mapping.tagName] = true
+		}
+	}
+
+	seen := map[string]bool{}
+	for _, tag := range parseTagsEnv(os.Getenv("DD_TAGS")) {
+		if !usedKeys[tagKey(tag)] && !seen[tag] {
+			result = append(result, tag)
+			seen[tag] = true
+		}
+	}
+	return result
+}
+
+// parseTagsEnv splits the value of DD_TAGS into tags, separated by commas and/or whitespaces (ex: "team:a,tier:1" or
+// "team:a tier:1").
+func parseTagsEnv(value string) []string {
+	return strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	})
+}
+
+// sortTags returns the global tags merged with the tags of the call, sorted. The global tags must already be sorted:
+// they are returned as is when the call has no tags, which saves an allocation. The tags of the call are never
+// modified.
+func sortTags(globalTags []string, tags []string) []string {
+	if len(tags) == 0 {
+		return globalTags
+	}
+	sorted := make([]string, 0, len(globalTags)+len(tags))
+	sorted = append(sorted, globalTags...)
+	sorted = append(sorted, tags...)
+	sort.Strings(sorted)
+	return sorted
+}
+
+// withHostTag returns a copy of tags without their "host" tags, followed by "host:<hostname>".
+func withHostTag(tags []string, hostname string) []string {
+	result := make([]string, 0, len(tags)+1)
+	for _, tag := range tags {
+		if tagKey(tag) != "host" {
+			result = append(result, tag)
+		}
+	}
+	return append(result, "host:"+hostname)
+}
+
+func isValidTagRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '-' || r == ':' || r == '.' || r == '/'
+}
+
+// isNormalizedTag returns true when normalizeTag returns the tag unchanged. It doesn't allocate.
+func isNormalizedTag(tag string) bool {
+	keyLen := len(tagKey(tag))
+	for i, r := range tag {
+		if !isValidTagRune(r) || (i < keyLen && unicode.IsUpper(r)) {
+			return false
+		}
+	}
+	return true
+}
+
+// normalizeTag lowercases the key of the tag and replaces the characters not allowed by DogStatsD (anything other
+// than letters, digits, '_', '-', ':', '.' and '/') by '_'. The tag is returned as is when already normalized.
+func normalizeTag(tag string) string {
+	if isNormalizedTag(tag) {
+		return tag
+	}
+
+	keyLen := len(tagKey(tag))
+	var b strings.Builder
+	b.Grow(len(tag))
+	for i, r := range tag {
+		switch {
+		case !isValidTagRune(r):
+			b.WriteByte('_')
+		case i < keyLen:
+			b.WriteRune(unicode.ToLower(r))
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// normalizeTags applies normalizeTag to every tag. The slice is only copied when a tag is modified, the caller's slice
+// is never modified.
+func normalizeTags(tags []string) []string {
+	for i, tag := range tags {
+		normalizedTag := normalizeTag(tag)
+		if normalizedTag == tag {
+			continue
+		}
+		normalized := make([]string, len(tags))
+		copy(normalized, tags[:i])
+		normalized[i] = normalizedTag
+		for j, tag := range tags[i+1:] {
+			normalized[i+1+j] = normalizeTag(tag)
+		}
+		return normalized
+	}
+	return tags
+}
+
+// truncateTagValue cuts the value of the tag, the text after the first ':', to maxLen bytes with the marker appended.
+// The marker counts in the maxLen bytes and the value is cut on a rune boundary. The key and the tags without value are
+// never truncated.
+func truncateTagValue(tag string, maxLen int, marker string) string {
+	i := strings.IndexByte(tag, ':')
+	if i < 0 || len(tag)-i-1 <= maxLen {
+		return tag
+	}
+	value := tag[i+1:]
+	keep := maxLen - len(marker)
+	for keep > 0 && !utf8.RuneStart(value[keep]) {
+		keep--
+	}
+	return tag[:i+1] + value[:keep] + marker
+}
+
+// truncateTagValues applies truncateTagValue to every tag. The slice is only copied when a tag is truncated, the
+// caller's slice is never modified.
+func truncateTagValues(tags []string, maxLen int, marker string) []string {
+	for i, tag := range tags {
+		truncatedTag := truncateTagValue(tag, maxLen, marker)
+		if truncatedTag == tag {
+			continue
+		}
+		truncated := make([]string, len(tags))
+		copy(truncated, tags[:i])
+		truncated[i] = truncatedTag
+		for j, tag := range tags[i+1:] {
+			truncated[i+1+j] = truncateTagValue(tag, maxLen, marker)
+		}
+		return truncated
+	}
+	return tags
+}
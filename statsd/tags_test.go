@@ -0,0 +1,137 @@
+package statsd
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDedupTags(t *testing.T) {
+	testCases := []struct {
+		name       string
+		globalTags []string
+		tags       []string
+		expected   []string
+	}{
+		{"no tags", nil, nil, []string{}},
+		{"no duplicates", []string{"env:prod"}, []string{"region:eu"}, []string{"env:prod", "region:eu"}},
+		{"call overrides global", []string{"env:prod", "service:api"}, []string{"env:staging"}, []string{"service:api", "env:staging"}},
+		{"last call tag wins", nil, []string{"env:a", "region:eu", "env:b"}, []string{"region:eu", "env:b"}},
+		{"tags without value", []string{"debug"}, []string{"debug", "debug:true"}, []string{"debug:true"}},
+		{"key is before the first colon", nil, []string{"url:http://a", "url:http://b"}, []string{"url:http://b"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, dedupTags(tc.globalTags, tc.tags))
+		})
+	}
+}
+
+func TestDedupTagsDoesNotModifyInputs(t *testing.T) {
+	globalTags := []string{"env:prod", "service:api"}
+	tags := []string{"env:staging", "env:dev"}
+	dedupTags(globalTags[:1], tags)
+	assert.Equal(t, []string{"env:prod", "service:api"}, globalTags)
+	assert.Equal(t, []string{"env:staging", "env:dev"}, tags)
+}
+
+func TestSortTags(t *testing.T) {
+	assert.Equal(t, []string{"a:1", "b:2", "c:3"}, sortTags([]string{"b:2"}, []string{"c:3", "a:1"}))
+	assert.Equal(t, []string{"a", "a:1", "a:1"}, sortTags(nil, []string{"a:1", "a", "a:1"}))
+
+	globalTags := []string{"b:2"}
+	assert.Equal(t, &globalTags[0], &sortTags(globalTags, nil)[0])
+
+	tags := []string{"c:3", "a:1"}
+	sortTags(globalTags, tags)
+	assert.Equal(t, []string{"c:3", "a:1"}, tags)
+}
+
+func TestNormalizeTag(t *testing.T) {
+	testCases := []struct {
+		tag      string
+		expected string
+	}{
+		{"env:prod", "env:prod"},
+		{"Env:Prod", "env:Prod"},
+		{"ENV", "env"},
+		{"my tag:some value!", "my_tag:some_value_"},
+		{"path:/a/b.c-d_e", "path:/a/b.c-d_e"},
+		{"région:Île-de-France", "région:Île-de-France"},
+		{"a|b:c,d", "a_b:c_d"},
+		{"invalid\xffutf8", "invalid_utf8"},
+	}
+
+	for _, tc := range testCases {
+		assert.Equal(t, tc.expected, normalizeTag(tc.tag), tc.tag)
+	}
+}
+
+func TestNormalizeTags(t *testing.T) {
+	tags := []string{"env:prod", "Host Name:a", "region:eu"}
+	assert.Equal(t, []string{"env:prod", "host_name:a", "region:eu"}, normalizeTags(tags))
+	// the caller's slice is left untouched
+	assert.Equal(t, []string{"env:prod", "Host Name:a", "region:eu"}, tags)
+
+	normalized := []string{"env:prod", "region:eu"}
+	result := normalizeTags(normalized)
+	assert.Equal(t, &normalized[0], &result[0])
+}
+
+func TestTruncateTagValue(t *testing.T) {
+	assert.Equal(t, "url:http://", truncateTagValue("url:http://example.com", 7, ""))
+	assert.Equal(t, "url:http...", truncateTagValue("url:http://example.com", 7, "..."))
+	// short values, keys and tags without value are untouched
+	assert.Equal(t, "url:http://a", truncateTagValue("url:http://a", 8, ""))
+	assert.Equal(t, "a_very_long_key:v", truncateTagValue("a_very_long_key:v", 2, ""))
+	assert.Equal(t, "a_very_long_tag_without_value", truncateTagValue("a_very_long_tag_without_value", 2, ""))
+	// only the first ':' separates the key from the value
+	assert.Equal(t, "url:a:b", truncateTagValue("url:a:b:c", 3, ""))
+	// the value is cut on a rune boundary
+	assert.Equal(t, "city:z", truncateTagValue("city:zürich", 2, ""))
+}
+
+func TestTruncateTagValues(t *testing.T) {
+	tags := []string{"env:prod", "url:http://example.com", "team:core"}
+	assert.Equal(t, []string{"env:prod", "url:http:", "team:core"}, truncateTagValues(tags, 5, ""))
+	// the caller's slice is left untouched
+	assert.Equal(t, []string{"env:prod", "url:http://example.com", "team:core"}, tags)
+
+	short := []string{"env:prod", "team:core"}
+	result := truncateTagValues(short, 5, "")
+	assert.Equal(t, &short[0], &result[0])
+}
+
+func TestWithHostTag(t *testing.T) {
+	assert.Equal(t, []string{"host:h"}, withHostTag(nil, "h"))
+
+	tags := []string{"env:prod", "host:other", "hostname:x", "host", "team:a"}
+	assert.Equal(t, []string{"env:prod", "hostname:x", "team:a", "host:h"}, withHostTag(tags, "h"))
+	// the given slice is not modified
+	assert.Equal(t, []string{"env:prod", "host:other", "hostname:x", "host", "team:a"}, tags)
+}
+
+func TestParseTagsEnv(t *testing.T) {
+	assert.Empty(t, parseTagsEnv(""))
+	assert.Equal(t, []string{"team:a", "tier:1"}, parseTagsEnv("team:a,tier:1"))
+	assert.Equal(t, []string{"team:a", "tier:1"}, parseTagsEnv("team:a tier:1"))
+	assert.Equal(t, []string{"team:a", "tier:1", "debug"}, parseTagsEnv(" team:a, tier:1,,\tdebug "))
+}
+
+func TestWithUnifiedServiceTags(t *testing.T) {
+	defer func() {
+		os.Unsetenv("DD_ENV")
+		os.Unsetenv("DD_SERVICE")
+		os.Unsetenv("DD_TAGS")
+	}()
+	os.Setenv("DD_ENV", "staging")
+	os.Setenv("DD_SERVICE", "api")
+	os.Setenv("DD_TAGS", "service:other,team:a team:b team:a,tier:1")
+
+	tags := []string{"tier:2", "env:prod"}
+	assert.Equal(t, []string{"tier:2", "env:prod", "service:api", "team:a", "team:b"}, withUnifiedServiceTags(tags))
+	// the given slice is not modified
+	assert.Equal(t, []string{"tier:2", "env:prod"}, tags)
+}
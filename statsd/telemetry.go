@@ -2,6 +2,7 @@ package statsd
 
 import (
 	"fmt"
+	"sort"
 	"sync"
 	"time"
 )
@@ -50,6 +51,19 @@ type Telemetry struct {
 	// TotalDroppedOnReceive is the total number metrics/event/service_checks dropped when using ChannelMode (see
 	// WithChannelMode option).
 	TotalDroppedOnReceive uint64
+	// TotalDroppedOnReceiveTimeout is the total number metrics/event/service_checks dropped when using ChannelMode
+	// after waiting for the timeout set by the WithChannelModeTimeout option, or when using MutexMode after waiting
+	// for the duration set by the WithMaxBlockDuration option.
+	TotalDroppedOnReceiveTimeout uint64
+	// TotalMetricsTooManyTags is the total number of metrics truncated or dropped because they had more tags than
+	// allowed by WithMaxTagsPerMetric.
+	TotalMetricsTooManyTags uint64
+	// TotalMetricsDroppedOversized is the total number of metrics, events and service checks dropped because they
+	// didn't fit in an empty payload, see WithOversizedMetricPolicy.
+	TotalMetricsDroppedOversized uint64
+	// TotalMetricsDroppedSerialization is the total number of metrics dropped because they could not be serialized,
+	// see ErrSerialization.
+	TotalMetricsDroppedSerialization uint64
 
 	//
 	// Those are produced by the 'sender'
@@ -59,12 +73,18 @@ type Telemetry struct {
 	// using UDP we don't know if packet dropped or not, so all packet are considered as succesfully sent.
 	TotalPayloadsSent uint64
 	// TotalPayloadsDropped is the total number of payload dropped by the client. This includes all cause of dropped
-	// (TotalPayloadsDroppedQueueFull and TotalPayloadsDroppedWriter). When using UDP This won't includes the
-	// network dropped.
+	// (TotalPayloadsDroppedQueueFull, TotalPayloadsDroppedWriter and TotalPayloadsDroppedRateLimit). When using UDP
+	// This won't includes the network dropped.
 	TotalPayloadsDropped uint64
 	// TotalPayloadsDroppedWriter is the total number of payload dropped by the writer (when using UDS or named
 	// pipe) due to network timeout or error.
 	TotalPayloadsDroppedWriter uint64
+	// TotalPayloadsDroppedWriterTimeout is the number of payloads in TotalPayloadsDroppedWriter dropped because the
+	// write didn't complete before the timeout set by the WithWriteTimeout option.
+	TotalPayloadsDroppedWriterTimeout uint64
+	// TotalPayloadsDroppedRateLimit is the total number of payloads dropped because the client sent more payloads
+	// than allowed by WithRateLimit.
+	TotalPayloadsDroppedRateLimit uint64
 	// TotalPayloadsDroppedQueueFull is the total number of payload dropped internally because the queue of payloads
 	// waiting to be sent on the wire is full. This means the client is generating more metrics than can be sent on
 	// the wire. If your app sends metrics in batch look at WithSenderQueueSize option to increase the queue size.
@@ -74,17 +94,29 @@ type Telemetry struct {
 	// packet dropped or not, so all packet are considered as succesfully sent.
 	TotalBytesSent uint64
 	// TotalBytesDropped is the total number of bytes dropped by the client. This includes all cause of dropped
-	// (TotalBytesDroppedQueueFull and TotalBytesDroppedWriter). When using UDP This
+	// (TotalBytesDroppedQueueFull, TotalBytesDroppedWriter and TotalBytesDroppedRateLimit). When using UDP This
 	// won't includes the network dropped.
 	TotalBytesDropped uint64
 	// TotalBytesDroppedWriter is the total number of bytes dropped by the writer (when using UDS or named pipe) due
 	// to network timeout or error.
 	TotalBytesDroppedWriter uint64
+	// TotalBytesDroppedRateLimit is the total number of bytes dropped because the client sent more payloads than
+	// allowed by WithRateLimit.
+	TotalBytesDroppedRateLimit uint64
 	// TotalBytesDroppedQueueFull is the total number of bytes dropped internally because the queue of payloads
 	// waiting to be sent on the wire is full. This means the client is generating more metrics than can be sent on
 	// the wire. If your app sends metrics in batch look at WithSenderQueueSize option to increase the queue size.
 	TotalBytesDroppedQueueFull uint64
 
+	// TotalTransportReconnections is the total number of times the transport reconnected to the Agent after it became
+	// unreachable. Only the UDP and UDS transports reconnect.
+	TotalTransportReconnections uint64
+
+	// UDPSendBufferSize is the size in bytes of the send buffer of the UDP socket as applied by the kernel when using
+	// WithUDPSendBufferSize. A value lower than the one requested means the kernel clamped it. It is 0 when the option
+	// is not used, with other transports and on Windows.
+	UDPSendBufferSize uint64
+
 	//
 	// Those are produced by the 'aggregator'
 	//
@@ -110,24 +142,55 @@ type Telemetry struct {
 	// AggregationNbContextTiming is the total number of contexts for timings flushed by the aggregator when either
 	// WithClientSideAggregation or WithExtendedClientSideAggregation options are enabled.
 	AggregationNbContextTiming uint64
+	// AggregationNbDroppedSamples is the total number of Histograms, Distributions and Timings values dropped by the
+	// aggregator because their context reached the limit set by WithMaxSamplesPerContext with MaxSamplesReservoir.
+	AggregationNbDroppedSamples uint64
+
+	// Endpoints contains the telemetry of each address given to the WithAdditionalAddresses option, by address. The
+	// payloads and bytes counters above only cover the main address.
+	Endpoints map[string]EndpointTelemetry
+}
+
+// EndpointTelemetry represents the health of an additional address, see WithAdditionalAddresses.
+type EndpointTelemetry struct {
+	// TotalPayloadsSent is the total number of payloads successfully sent to the address.
+	TotalPayloadsSent uint64
+	// TotalPayloadsDroppedWriter is the total number of payloads that could not be written to the address.
+	TotalPayloadsDroppedWriter uint64
+	// TotalBytesSent is the total number of bytes successfully sent to the address.
+	TotalBytesSent uint64
+	// TotalBytesDroppedWriter is the total number of bytes that could not be written to the address.
+	TotalBytesDroppedWriter uint64
 }
 
 type telemetryClient struct {
-	c          *Client
-	tags       []string
-	aggEnabled bool // is aggregation enabled and should we sent aggregation telemetry.
-	tagsByType map[metricType][]string
-	sender     *sender
-	worker     *worker
-	lastSample Telemetry // The previous sample of telemetry sent
+	c                *Client
+	tags             []string
+	aggEnabled       bool // is aggregation enabled and should we sent aggregation telemetry.
+	timeoutEnabled   bool // is WithChannelModeTimeout or WithMaxBlockDuration used and should we sent timeout telemetry.
+	reservoirEnabled bool // is WithMaxSamplesPerContext used with reservoir sampling and should we sent dropped samples telemetry.
+	rateLimitEnabled bool // is WithRateLimit used and should we sent rate limit telemetry.
+	maxTagsEnabled   bool // is WithMaxTagsPerMetric used and should we sent too many tags telemetry.
+	tagsByType       map[metricType][]string
+	sender           *sender
+	worker           *worker
+	lastSample       Telemetry // The previous sample of telemetry sent
+	interval         time.Duration
+	namespace        string
 }
 
-func newTelemetryClient(c *Client, transport string, aggregationEnabled bool) *telemetryClient {
+func newTelemetryClient(c *Client, transport string, aggregationEnabled bool, extraTags []string) *telemetryClient {
+	tags := append(c.getGlobalTags().tags, clientTelemetryTag, clientVersionTelemetryTag, "client_transport:"+transport)
 	t := &telemetryClient{
-		c:          c,
-		tags:       append(c.tags, clientTelemetryTag, clientVersionTelemetryTag, "client_transport:"+transport),
-		aggEnabled: aggregationEnabled,
-		tagsByType: map[metricType][]string{},
+		c:                c,
+		tags:             append(tags, extraTags...),
+		interval:         telemetryInterval,
+		aggEnabled:       aggregationEnabled,
+		timeoutEnabled:   c.channelTimeout != 0 || (len(c.workers) > 0 && c.workers[0].blockTimeout != 0),
+		reservoirEnabled: c.agg != nil && c.agg.histograms.maxSamples != 0 && c.agg.histograms.strategy == MaxSamplesReservoir,
+		rateLimitEnabled: c.sender != nil && c.sender.rateLimiter != nil,
+		maxTagsEnabled:   c.maxTags != 0,
+		tagsByType:       map[metricType][]string{},
 	}
 
 	t.tagsByType[gauge] = append(append([]string{}, t.tags...), "metrics_type:gauge")
@@ -139,13 +202,13 @@ func newTelemetryClient(c *Client, transport string, aggregationEnabled bool) *t
 	return t
 }
 
-func newTelemetryClientWithCustomAddr(c *Client, transport string, telemetryAddr string, aggregationEnabled bool, pool *bufferPool, writeTimeout time.Duration) (*telemetryClient, error) {
-	telemetryWriter, _, err := createWriter(telemetryAddr, writeTimeout)
+func newTelemetryClientWithCustomAddr(c *Client, transport string, telemetryAddr string, aggregationEnabled bool, extraTags []string, pool *bufferPool, writeTimeout time.Duration, connectTimeout time.Duration, lazyConnection bool) (*telemetryClient, error) {
+	telemetryWriter, _, err := createWriter(telemetryAddr, writeTimeout, connectTimeout, 0, lazyConnection)
 	if err != nil {
 		return nil, fmt.Errorf("Could not resolve telemetry address: %v", err)
 	}
 
-	t := newTelemetryClient(c, transport, aggregationEnabled)
+	t := newTelemetryClient(c, transport, aggregationEnabled, extraTags)
 
 	// Creating a custom sender/worker with 1 worker in mutex mode for the
 	// telemetry that share the same bufferPool.
@@ -157,13 +220,13 @@ func newTelemetryClientWithCustomAddr(c *Client, transport string, telemetryAddr
 }
 
 func (t *telemetryClient) run(wg *sync.WaitGroup, stop chan struct{}) {
+	ticker := t.c.clock.NewTicker(t.interval)
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		ticker := time.NewTicker(telemetryInterval)
 		for {
 			select {
-			case <-ticker.C:
+			case <-ticker.C():
 				t.sendTelemetry()
 			case <-stop:
 				ticker.Stop()
@@ -177,11 +240,13 @@ func (t *telemetryClient) run(wg *sync.WaitGroup, stop chan struct{}) {
 }
 
 func (t *telemetryClient) sendTelemetry() {
+	// The telemetry is not sampled: the rate of the "metrics_per_payload" values is the number of payloads they stand
+	// for.
 	for _, m := range t.flush() {
 		if t.worker != nil {
-			t.worker.processMetric(m)
+			t.worker.writeMetric(m)
 		} else {
-			t.c.send(m)
+			t.c.writeMetric(m)
 		}
 	}
 
@@ -192,7 +257,7 @@ func (t *telemetryClient) sendTelemetry() {
 
 func (t *telemetryClient) getTelemetry() Telemetry {
 	if t == nil {
-		// telemetry was disabled through the WithoutTelemetry option
+		// the client was not created through New
 		return Telemetry{}
 	}
 
@@ -208,8 +273,8 @@ func (t *telemetryClient) getTelemetry() Telemetry {
 		tlm.TotalMetricsDistribution +
 		tlm.TotalMetricsTiming
 
-	tlm.TotalPayloadsDropped = tlm.TotalPayloadsDroppedQueueFull + tlm.TotalPayloadsDroppedWriter
-	tlm.TotalBytesDropped = tlm.TotalBytesDroppedQueueFull + tlm.TotalBytesDroppedWriter
+	tlm.TotalPayloadsDropped = tlm.TotalPayloadsDroppedQueueFull + tlm.TotalPayloadsDroppedWriter + tlm.TotalPayloadsDroppedRateLimit
+	tlm.TotalBytesDropped = tlm.TotalBytesDroppedQueueFull + tlm.TotalBytesDroppedWriter + tlm.TotalBytesDroppedRateLimit
 
 	if t.aggEnabled {
 		tlm.AggregationNbContext = tlm.AggregationNbContextGauge +
@@ -222,13 +287,33 @@ func (t *telemetryClient) getTelemetry() Telemetry {
 	return tlm
 }
 
+// flushMetricsPerPayload returns the "metrics_per_payload" distribution: one value per distinct number of messages per
+// payload sent since the last flush, with a rate making the Agent count it once per payload.
+func (t *telemetryClient) flushMetricsPerPayload() []metric {
+	if t.c.sender == nil {
+		return nil
+	}
+	counts := t.c.sender.flushMetricsPerPayload()
+	values := make([]int, 0, len(counts))
+	for value := range counts {
+		values = append(values, value)
+	}
+	sort.Ints(values)
+
+	m := make([]metric, 0, len(values))
+	for _, value := range values {
+		m = append(m, metric{metricType: distribution, name: "datadog.dogstatsd.client.metrics_per_payload", fvalue: float64(value), tags: t.tags, rate: 1 / float64(counts[value]), namespace: t.namespace})
+	}
+	return m
+}
+
 // flushTelemetry returns Telemetry metrics to be flushed. It's its own function to ease testing.
 func (t *telemetryClient) flush() []metric {
 	m := []metric{}
 
-	// same as Count but without global namespace
+	// same as Count but without global namespace, only the one set through WithTelemetryNamespace
 	telemetryCount := func(name string, value int64, tags []string) {
-		m = append(m, metric{metricType: count, name: name, ivalue: value, tags: tags, rate: 1})
+		m = append(m, metric{metricType: count, name: name, ivalue: value, tags: tags, rate: 1, namespace: t.namespace})
 	}
 
 	tlm := t.getTelemetry()
@@ -247,16 +332,31 @@ func (t *telemetryClient) flush() []metric {
 	telemetryCount("datadog.dogstatsd.client.service_checks", int64(tlm.TotalServiceChecks-t.lastSample.TotalServiceChecks), t.tags)
 
 	telemetryCount("datadog.dogstatsd.client.metric_dropped_on_receive", int64(tlm.TotalDroppedOnReceive-t.lastSample.TotalDroppedOnReceive), t.tags)
+	if t.timeoutEnabled {
+		telemetryCount("datadog.dogstatsd.client.metric_dropped_on_receive_timeout", int64(tlm.TotalDroppedOnReceiveTimeout-t.lastSample.TotalDroppedOnReceiveTimeout), t.tags)
+	}
+	telemetryCount("datadog.dogstatsd.client.metrics_dropped_oversized", int64(tlm.TotalMetricsDroppedOversized-t.lastSample.TotalMetricsDroppedOversized), t.tags)
+	telemetryCount("datadog.dogstatsd.client.metric_dropped_on_serialization", int64(tlm.TotalMetricsDroppedSerialization-t.lastSample.TotalMetricsDroppedSerialization), t.tags)
+	if t.maxTagsEnabled {
+		telemetryCount("datadog.dogstatsd.client.metrics_too_many_tags", int64(tlm.TotalMetricsTooManyTags-t.lastSample.TotalMetricsTooManyTags), t.tags)
+	}
 
 	telemetryCount("datadog.dogstatsd.client.packets_sent", int64(tlm.TotalPayloadsSent-t.lastSample.TotalPayloadsSent), t.tags)
 	telemetryCount("datadog.dogstatsd.client.packets_dropped", int64(tlm.TotalPayloadsDropped-t.lastSample.TotalPayloadsDropped), t.tags)
 	telemetryCount("datadog.dogstatsd.client.packets_dropped_queue", int64(tlm.TotalPayloadsDroppedQueueFull-t.lastSample.TotalPayloadsDroppedQueueFull), t.tags)
 	telemetryCount("datadog.dogstatsd.client.packets_dropped_writer", int64(tlm.TotalPayloadsDroppedWriter-t.lastSample.TotalPayloadsDroppedWriter), t.tags)
+	telemetryCount("datadog.dogstatsd.client.packets_dropped_writer_timeout", int64(tlm.TotalPayloadsDroppedWriterTimeout-t.lastSample.TotalPayloadsDroppedWriterTimeout), t.tags)
 
 	telemetryCount("datadog.dogstatsd.client.bytes_dropped", int64(tlm.TotalBytesDropped-t.lastSample.TotalBytesDropped), t.tags)
 	telemetryCount("datadog.dogstatsd.client.bytes_sent", int64(tlm.TotalBytesSent-t.lastSample.TotalBytesSent), t.tags)
 	telemetryCount("datadog.dogstatsd.client.bytes_dropped_queue", int64(tlm.TotalBytesDroppedQueueFull-t.lastSample.TotalBytesDroppedQueueFull), t.tags)
 	telemetryCount("datadog.dogstatsd.client.bytes_dropped_writer", int64(tlm.TotalBytesDroppedWriter-t.lastSample.TotalBytesDroppedWriter), t.tags)
+	if t.rateLimitEnabled {
+		telemetryCount("datadog.dogstatsd.client.packets_dropped_rate_limit", int64(tlm.TotalPayloadsDroppedRateLimit-t.lastSample.TotalPayloadsDroppedRateLimit), t.tags)
+		telemetryCount("datadog.dogstatsd.client.bytes_dropped_rate_limit", int64(tlm.TotalBytesDroppedRateLimit-t.lastSample.TotalBytesDroppedRateLimit), t.tags)
+	}
+
+	telemetryCount("datadog.dogstatsd.client.transport_reconnections", int64(tlm.TotalTransportReconnections-t.lastSample.TotalTransportReconnections), t.tags)
 
 	if t.aggEnabled {
 		telemetryCount("datadog.dogstatsd.client.aggregated_context", int64(tlm.AggregationNbContext-t.lastSample.AggregationNbContext), t.tags)
@@ -267,6 +367,18 @@ func (t *telemetryClient) flush() []metric {
 		telemetryCount("datadog.dogstatsd.client.aggregated_context_by_type", int64(tlm.AggregationNbContextDistribution-t.lastSample.AggregationNbContextDistribution), t.tagsByType[distribution])
 		telemetryCount("datadog.dogstatsd.client.aggregated_context_by_type", int64(tlm.AggregationNbContextTiming-t.lastSample.AggregationNbContextTiming), t.tagsByType[timing])
 	}
+	if t.reservoirEnabled {
+		telemetryCount("datadog.dogstatsd.client.aggregated_dropped_samples", int64(tlm.AggregationNbDroppedSamples-t.lastSample.AggregationNbDroppedSamples), t.tags)
+	}
+	m = append(m, t.flushMetricsPerPayload()...)
+	for addr, e := range tlm.Endpoints {
+		last := t.lastSample.Endpoints[addr]
+		tags := append(append([]string{}, t.tags...), "endpoint:"+addr)
+		telemetryCount("datadog.dogstatsd.client.endpoint.packets_sent", int64(e.TotalPayloadsSent-last.TotalPayloadsSent), tags)
+		telemetryCount("datadog.dogstatsd.client.endpoint.packets_dropped_writer", int64(e.TotalPayloadsDroppedWriter-last.TotalPayloadsDroppedWriter), tags)
+		telemetryCount("datadog.dogstatsd.client.endpoint.bytes_sent", int64(e.TotalBytesSent-last.TotalBytesSent), tags)
+		telemetryCount("datadog.dogstatsd.client.endpoint.bytes_dropped_writer", int64(e.TotalBytesDroppedWriter-last.TotalBytesDroppedWriter), tags)
+	}
 
 	t.lastSample = tlm
 
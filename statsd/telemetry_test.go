@@ -44,6 +44,11 @@ func TestTelemetryCustomAddr(t *testing.T) {
 		"datadog.dogstatsd.client.events:1|c|#client:go," + clientVersionTelemetryTag + ",client_transport:udp",
 		"datadog.dogstatsd.client.service_checks:1|c|#client:go," + clientVersionTelemetryTag + ",client_transport:udp",
 		"datadog.dogstatsd.client.metric_dropped_on_receive:0|c|#client:go," + clientVersionTelemetryTag + ",client_transport:udp",
+		"datadog.dogstatsd.client.metrics_dropped_oversized:0|c|#client:go," + clientVersionTelemetryTag + ",client_transport:udp",
+		"datadog.dogstatsd.client.metric_dropped_on_serialization:0|c|#client:go," + clientVersionTelemetryTag + ",client_transport:udp",
+		// 9 payloads with a single message and 1 with 2
+		"datadog.dogstatsd.client.metrics_per_payload:1|d|@0.1111111111111111|#client:go," + clientVersionTelemetryTag + ",client_transport:udp",
+		"datadog.dogstatsd.client.metrics_per_payload:2|d|#client:go," + clientVersionTelemetryTag + ",client_transport:udp",
 		"datadog.dogstatsd.client.packets_sent:10|c|#client:go," + clientVersionTelemetryTag + ",client_transport:udp",
 		"datadog.dogstatsd.client.bytes_sent:473|c|#client:go," + clientVersionTelemetryTag + ",client_transport:udp",
 		"datadog.dogstatsd.client.packets_dropped:0|c|#client:go," + clientVersionTelemetryTag + ",client_transport:udp",
@@ -51,7 +56,9 @@ func TestTelemetryCustomAddr(t *testing.T) {
 		"datadog.dogstatsd.client.packets_dropped_queue:0|c|#client:go," + clientVersionTelemetryTag + ",client_transport:udp",
 		"datadog.dogstatsd.client.bytes_dropped_queue:0|c|#client:go," + clientVersionTelemetryTag + ",client_transport:udp",
 		"datadog.dogstatsd.client.packets_dropped_writer:0|c|#client:go," + clientVersionTelemetryTag + ",client_transport:udp",
+		"datadog.dogstatsd.client.packets_dropped_writer_timeout:0|c|#client:go," + clientVersionTelemetryTag + ",client_transport:udp",
 		"datadog.dogstatsd.client.bytes_dropped_writer:0|c|#client:go," + clientVersionTelemetryTag + ",client_transport:udp",
+		"datadog.dogstatsd.client.transport_reconnections:0|c|#client:go," + clientVersionTelemetryTag + ",client_transport:udp",
 		"datadog.dogstatsd.client.aggregated_context:5|c|#client:go," + clientVersionTelemetryTag + ",client_transport:udp",
 		"datadog.dogstatsd.client.aggregated_context_by_type:0|c|#client:go," + clientVersionTelemetryTag + ",client_transport:udp,metrics_type:distribution",
 		"datadog.dogstatsd.client.aggregated_context_by_type:0|c|#client:go," + clientVersionTelemetryTag + ",client_transport:udp,metrics_type:histogram",
@@ -94,3 +101,197 @@ func TestTelemetryCustomAddr(t *testing.T) {
 
 	assert.Equal(t, expectedResult, result)
 }
+
+func TestTelemetryAdditionalEndpoints(t *testing.T) {
+	client, err := NewWithWriter(&statsdWriterWrapper{}, WithoutTelemetry())
+	require.Nil(t, err)
+	defer client.Close()
+	client.sender.endpoints = []*endpoint{{addr: "localhost:8126", transport: &statsdWriterWrapper{}, totalPayloadsSent: 3, totalPayloadsDropped: 1}}
+
+	tc := newTelemetryClient(client, "custom", false, nil)
+	endpointMetrics := map[string]int64{}
+	for _, m := range tc.flush() {
+		if strings.HasPrefix(m.name, "datadog.dogstatsd.client.endpoint.") {
+			assert.Contains(t, m.tags, "endpoint:localhost:8126")
+			endpointMetrics[m.name] = m.ivalue
+		}
+	}
+	assert.Equal(t, map[string]int64{
+		"datadog.dogstatsd.client.endpoint.packets_sent":           3,
+		"datadog.dogstatsd.client.endpoint.packets_dropped_writer": 1,
+		"datadog.dogstatsd.client.endpoint.bytes_sent":             0,
+		"datadog.dogstatsd.client.endpoint.bytes_dropped_writer":   0,
+	}, endpointMetrics)
+
+	// only the difference with the previous flush is sent
+	client.sender.endpoints[0].totalPayloadsSent = 5
+	for _, m := range tc.flush() {
+		if m.name == "datadog.dogstatsd.client.endpoint.packets_sent" {
+			assert.Equal(t, int64(2), m.ivalue)
+		}
+	}
+}
+
+func TestTelemetryRateLimit(t *testing.T) {
+	client, err := NewWithWriter(&statsdWriterWrapper{}, WithoutTelemetry())
+	require.Nil(t, err)
+	defer client.Close()
+
+	for _, m := range newTelemetryClient(client, "custom", false, nil).flush() {
+		assert.NotContains(t, m.name, "rate_limit")
+	}
+
+	client, err = NewWithWriter(&statsdWriterWrapper{}, WithoutTelemetry(), WithRateLimit(10))
+	require.Nil(t, err)
+	defer client.Close()
+	client.sender.telemetry.totalPayloadsDroppedRateLimit = 2
+	client.sender.telemetry.totalBytesDroppedRateLimit = 20
+
+	tc := newTelemetryClient(client, "custom", false, nil)
+	assert.Equal(t, uint64(2), tc.getTelemetry().TotalPayloadsDropped)
+	assert.Equal(t, uint64(20), tc.getTelemetry().TotalBytesDropped)
+
+	rateLimitMetrics := map[string]int64{}
+	for _, m := range tc.flush() {
+		if strings.HasSuffix(m.name, "_rate_limit") {
+			rateLimitMetrics[m.name] = m.ivalue
+		}
+	}
+	assert.Equal(t, map[string]int64{
+		"datadog.dogstatsd.client.packets_dropped_rate_limit": 2,
+		"datadog.dogstatsd.client.bytes_dropped_rate_limit":   20,
+	}, rateLimitMetrics)
+}
+
+func TestTelemetryMetricsPerPayload(t *testing.T) {
+	w := &payloadRecorder{}
+	client, err := NewWithWriter(w,
+		WithoutTelemetry(),
+		WithoutClientSideAggregation(),
+		WithWorkersCount(1),
+		WithMaxMessagesPerPayload(2),
+	)
+	require.Nil(t, err)
+	defer client.Close()
+
+	for i := 0; i < 5; i++ {
+		client.Gauge("gauge", float64(i), nil, 1)
+	}
+	require.Nil(t, client.Flush())
+	require.Len(t, w.payloads, 3)
+
+	tc := newTelemetryClient(client, "custom", false, nil)
+	perPayload := map[float64]float64{}
+	for _, m := range tc.flush() {
+		if m.name == "datadog.dogstatsd.client.metrics_per_payload" {
+			assert.Equal(t, distribution, m.metricType)
+			perPayload[m.fvalue] = m.rate
+		}
+	}
+	// 2 payloads with 2 metrics, sent with a rate of 1/2 to count both, and 1 with a single metric
+	assert.Equal(t, map[float64]float64{1: 1, 2: 0.5}, perPayload)
+
+	// the values are reset on each flush
+	for _, m := range tc.flush() {
+		assert.NotEqual(t, "datadog.dogstatsd.client.metrics_per_payload", m.name)
+	}
+}
+
+func TestTelemetryExtraTags(t *testing.T) {
+	w := &payloadRecorder{}
+	client, err := NewWithWriter(w, WithTags([]string{"env:prod"}), WithTelemetryTags("service:api", "team:a"))
+	require.Nil(t, err)
+
+	client.Gauge("gauge", 1, nil, 1)
+	client.telemetryClient.sendTelemetry()
+	require.Nil(t, client.Close())
+
+	nbTelemetry := 0
+	for _, payload := range w.payloads {
+		for _, line := range strings.Split(strings.TrimSuffix(payload, "\n"), "\n") {
+			if !strings.HasPrefix(line, "datadog.dogstatsd.client.") {
+				assert.Equal(t, "gauge:1|g|#env:prod", line)
+				continue
+			}
+			nbTelemetry++
+			assert.Contains(t, line, "|#env:prod,client:go,"+clientVersionTelemetryTag+",client_transport:custom,service:api,team:a")
+		}
+	}
+	assert.NotZero(t, nbTelemetry)
+}
+
+func TestTelemetryDisabled(t *testing.T) {
+	w := &payloadRecorder{}
+	clock := newFakeClock()
+	client, err := NewWithWriter(w, WithoutTelemetry(), withClock(clock))
+	require.Nil(t, err)
+
+	// no ticker is started for the telemetry
+	for _, ticker := range clock.tickers {
+		assert.NotEqual(t, telemetryInterval, ticker.interval)
+	}
+
+	client.Gauge("gauge", 1, nil, 1)
+	client.Count("count", 1, nil, 1)
+	for i := 0; i < 3; i++ {
+		clock.Advance(telemetryInterval)
+	}
+	require.Nil(t, client.Flush())
+
+	w.Lock()
+	for _, payload := range w.payloads {
+		assert.NotContains(t, payload, "datadog.dogstatsd.client.")
+	}
+	assert.NotEmpty(t, w.payloads)
+	w.Unlock()
+
+	// the counters are still available
+	tlm := client.GetTelemetry()
+	assert.Equal(t, uint64(2), tlm.TotalMetrics)
+	assert.Equal(t, uint64(1), tlm.TotalMetricsGauge)
+	assert.NotZero(t, tlm.TotalPayloadsSent)
+	require.Nil(t, client.Close())
+}
+
+func TestTelemetryIntervalAndNamespace(t *testing.T) {
+	w := &payloadRecorder{}
+	clock := newFakeClock()
+	client, err := NewWithWriter(w,
+		WithNamespace("ignored"),
+		WithTelemetryInterval(time.Minute),
+		WithTelemetryNamespace("app"),
+		withClock(clock),
+	)
+	require.Nil(t, err)
+	defer client.Close()
+
+	telemetryPayloads := func() []string {
+		client.sender.flush()
+		w.Lock()
+		defer w.Unlock()
+		payloads := []string{}
+		for _, payload := range w.payloads {
+			if strings.Contains(payload, "datadog.dogstatsd.client.") {
+				payloads = append(payloads, payload)
+			}
+		}
+		return payloads
+	}
+
+	// the default interval is no longer used
+	clock.Advance(telemetryInterval)
+	client.Flush()
+	assert.Empty(t, telemetryPayloads())
+
+	clock.Advance(time.Minute - telemetryInterval)
+	// the flush of the previous tick is done when the next one is received
+	clock.Advance(time.Minute)
+	client.Flush()
+	payloads := telemetryPayloads()
+	require.NotEmpty(t, payloads)
+	for _, payload := range payloads {
+		for _, line := range strings.Split(strings.TrimSuffix(payload, "\n"), "\n") {
+			assert.True(t, strings.HasPrefix(line, "app.datadog.dogstatsd.client."), line)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package statsd
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RecordedMetric is a metric captured by a TestClient.
+type RecordedMetric struct {
+	Type MetricType
+	Name string
+	// Value holds the value of every type except sets. Timings are recorded in milliseconds.
+	Value float64
+	// SetValue holds the value of sets, SetInt values are formatted in base 10.
+	SetValue    string
+	Tags        []string
+	Rate        float64
+	Timestamp   time.Time
+	Cardinality Cardinality
+}
+
+// TestClient is a statsd client recording everything it is given instead of sending it. It is meant to be used in
+// place of a real client in the tests of library users to assert on the submitted metrics, events and service checks.
+// Its zero value is ready to use and it is safe to use from multiple goroutines simultaneously.
+//
+// Unlike the real client, the TestClient does not apply sampling, aggregation, namespace nor global tags: every call
+// is recorded as given.
+type TestClient struct {
+	lock          sync.Mutex
+	metrics       []RecordedMetric
+	events        []Event
+	serviceChecks []ServiceCheck
+}
+
+// NewTestClient returns a new TestClient.
+func NewTestClient() *TestClient {
+	return &TestClient{}
+}
+
+func (t *TestClient) record(m RecordedMetric, parameters []Parameter) error {
+	carrier := metric{}
+	for _, p := range parameters {
+		if p != nil {
+			p.apply(&carrier)
+		}
+	}
+	m.Cardinality = carrier.cardinality
+	m.Tags = append([]string(nil), m.Tags...)
+
+	t.lock.Lock()
+	t.metrics = append(t.metrics, m)
+	t.lock.Unlock()
+	return nil
+}
+
+// Gauge records a gauge.
+func (t *TestClient) Gauge(name string, value float64, tags []string, rate float64, parameters ...Parameter) error {
+	return t.record(RecordedMetric{Type: GaugeType, Name: name, Value: value, Tags: tags, Rate: rate}, parameters)
+}
+
+// GaugeWithTimestamp records a gauge with its timestamp.
+func (t *TestClient) GaugeWithTimestamp(name string, value float64, tags []string, rate float64, timestamp time.Time, parameters ...Parameter) error {
+	return t.record(RecordedMetric{Type: GaugeType, Name: name, Value: value, Tags: tags, Rate: rate, Timestamp: timestamp}, parameters)
+}
+
+// GaugeNegative records a negative value as the reset of the gauge to 0 followed by the value, like the messages sent
+// by Client.GaugeNegative. Other values are recorded like with Gauge.
+func (t *TestClient) GaugeNegative(name string, value float64, tags []string, rate float64, parameters ...Parameter) error {
+	if value < 0 {
+		t.record(RecordedMetric{Type: GaugeType, Name: name, Value: 0, Tags: tags, Rate: rate}, parameters)
+	}
+	return t.record(RecordedMetric{Type: GaugeType, Name: name, Value: value, Tags: tags, Rate: rate}, parameters)
+}
+
+// Count records a count.
+func (t *TestClient) Count(name string, value int64, tags []string, rate float64, parameters ...Parameter) error {
+	return t.record(RecordedMetric{Type: CountType, Name: name, Value: float64(value), Tags: tags, Rate: rate}, parameters)
+}
+
+// CountWithTimestamp records a count with its timestamp.
+func (t *TestClient) CountWithTimestamp(name string, value int64, tags []string, rate float64, timestamp time.Time, parameters ...Parameter) error {
+	return t.record(RecordedMetric{Type: CountType, Name: name, Value: float64(value), Tags: tags, Rate: rate, Timestamp: timestamp}, parameters)
+}
+
+// MonotonicCount records the raw value given as a count, no delta is computed.
+func (t *TestClient) MonotonicCount(name string, value float64, tags []string, rate float64, parameters ...Parameter) error {
+	return t.record(RecordedMetric{Type: CountType, Name: name, Value: value, Tags: tags, Rate: rate}, parameters)
+}
+
+// Histogram records a histogram.
+func (t *TestClient) Histogram(name string, value float64, tags []string, rate float64, parameters ...Parameter) error {
+	return t.record(RecordedMetric{Type: HistogramType, Name: name, Value: value, Tags: tags, Rate: rate}, parameters)
+}
+
+// Distribution records a distribution.
+func (t *TestClient) Distribution(name string, value float64, tags []string, rate float64, parameters ...Parameter) error {
+	return t.record(RecordedMetric{Type: DistributionType, Name: name, Value: value, Tags: tags, Rate: rate}, parameters)
+}
+
+// DistributionSamples records one distribution per sample.
+func (t *TestClient) DistributionSamples(name string, values []float64, tags []string, rate float64, parameters ...Parameter) error {
+	for _, value := range values {
+		t.record(RecordedMetric{Type: DistributionType, Name: name, Value: value, Tags: tags, Rate: rate}, parameters)
+	}
+	return nil
+}
+
+// Add records a count of delta.
+func (t *TestClient) Add(name string, delta int64, tags []string, rate float64, parameters ...Parameter) error {
+	return t.Count(name, delta, tags, rate, parameters...)
+}
+
+// Decr records a count of -1.
+func (t *TestClient) Decr(name string, tags []string, rate float64, parameters ...Parameter) error {
+	return t.Add(name, -1, tags, rate, parameters...)
+}
+
+// Incr records a count of 1.
+func (t *TestClient) Incr(name string, tags []string, rate float64, parameters ...Parameter) error {
+	return t.Add(name, 1, tags, rate, parameters...)
+}
+
+// Set records a set.
+func (t *TestClient) Set(name string, value string, tags []string, rate float64, parameters ...Parameter) error {
+	return t.record(RecordedMetric{Type: SetType, Name: name, SetValue: value, Tags: tags, Rate: rate}, parameters)
+}
+
+// SetInt records a set, the value is formatted in base 10.
+func (t *TestClient) SetInt(name string, value int64, tags []string, rate float64, parameters ...Parameter) error {
+	return t.Set(name, strconv.FormatInt(value, 10), tags, rate, parameters...)
+}
+
+// SetMulti records one set per unique value.
+func (t *TestClient) SetMulti(name string, values []string, tags []string, rate float64, parameters ...Parameter) error {
+	for _, value := range uniqueValues(values) {
+		t.record(RecordedMetric{Type: SetType, Name: name, SetValue: value, Tags: tags, Rate: rate}, parameters)
+	}
+	return nil
+}
+
+// Timing records a timing in milliseconds.
+func (t *TestClient) Timing(name string, value time.Duration, tags []string, rate float64, parameters ...Parameter) error {
+	return t.TimeInMilliseconds(name, value.Seconds()*1000, tags, rate, parameters...)
+}
+
+// TimeInMilliseconds records a timing.
+func (t *TestClient) TimeInMilliseconds(name string, value float64, tags []string, rate float64, parameters ...Parameter) error {
+	return t.record(RecordedMetric{Type: TimingType, Name: name, Value: value, Tags: tags, Rate: rate}, parameters)
+}
+
+// Event records a copy of the event.
+func (t *TestClient) Event(e *Event) error {
+	if e == nil {
+		return nil
+	}
+	event := *e
+	event.Tags = append([]string(nil), e.Tags...)
+	t.lock.Lock()
+	t.events = append(t.events, event)
+	t.lock.Unlock()
+	return nil
+}
+
+// SimpleEvent records an event with the provided title and text.
+func (t *TestClient) SimpleEvent(title, text string) error {
+	return t.Event(NewEvent(title, text))
+}
+
+// ServiceCheck records a copy of the service check.
+func (t *TestClient) ServiceCheck(sc *ServiceCheck) error {
+	if sc == nil {
+		return nil
+	}
+	check := *sc
+	check.Tags = append([]string(nil), sc.Tags...)
+	t.lock.Lock()
+	t.serviceChecks = append(t.serviceChecks, check)
+	t.lock.Unlock()
+	return nil
+}
+
+// SimpleServiceCheck records a service check with the provided name and status.
+func (t *TestClient) SimpleServiceCheck(name string, status ServiceCheckStatus) error {
+	return t.ServiceCheck(NewServiceCheck(name, status))
+}
+
+// Close does nothing and returns nil, the recorded data is kept.
+func (t *TestClient) Close() error {
+	return nil
+}
+
+// Flush does nothing and returns nil
+func (t *TestClient) Flush() error {
+	return nil
+}
+
+// Drain does nothing and returns nil
+func (t *TestClient) Drain(ctx context.Context) error {
+	return nil
+}
+
+// Metrics returns a copy of the metrics recorded so far, in the order they were submitted.
+func (t *TestClient) Metrics() []RecordedMetric {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return append([]RecordedMetric(nil), t.metrics...)
+}
+
+// Events returns a copy of the events recorded so far, in the order they were submitted.
+func (t *TestClient) Events() []Event {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return append([]Event(nil), t.events...)
+}
+
+// ServiceChecks returns a copy of the service checks recorded so far, in the order they were submitted.
+func (t *TestClient) ServiceChecks() []ServiceCheck {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return append([]ServiceCheck(nil), t.serviceChecks...)
+}
+
+// Reset drops everything recorded so far.
+func (t *TestClient) Reset() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.metrics = nil
+	t.events = nil
+	t.serviceChecks = nil
+}
+
+// Verify that TestClient implements the ClientInterface.
+// https://golang.org/doc/faq#guarantee_satisfies_interface
+var _ ClientInterface = &TestClient{}
@@ -0,0 +1,84 @@
+package statsd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTestClientMetrics(t *testing.T) {
+	c := NewTestClient()
+	tags := []string{"a:b"}
+	ts := time.Unix(1658934092, 0)
+
+	c.Gauge("gauge", 21, tags, 1)
+	c.GaugeWithTimestamp("gauge", 22, nil, 1, ts)
+	c.GaugeNegative("gauge", -5, nil, 1)
+	c.Count("count", 3, tags, 0.5)
+	c.CountWithTimestamp("count", 4, nil, 1, ts)
+	c.Incr("count", nil, 1, CardinalityHigh)
+	c.Decr("count", nil, 1)
+	c.Add("count", -3, nil, 1)
+	c.MonotonicCount("monotonic", 10, nil, 1)
+	c.Histogram("histogram", 1.5, nil, 1)
+	c.Distribution("distribution", 2.5, nil, 1)
+	c.DistributionSamples("distribution", []float64{1, 2}, nil, 1)
+	c.Set("set", "value", nil, 1)
+	c.SetInt("set", 42, nil, 1)
+	c.SetMulti("set", []string{"a", "b", "a"}, nil, 1)
+	c.Timing("timing", 2*time.Second, nil, 1)
+	c.TimeInMilliseconds("timing", 12.5, nil, 1)
+
+	// the recorded tags must not change with the caller's slice
+	tags[0] = "c:d"
+
+	assert.Equal(t, []RecordedMetric{
+		{Type: GaugeType, Name: "gauge", Value: 21, Tags: []string{"a:b"}, Rate: 1},
+		{Type: GaugeType, Name: "gauge", Value: 22, Rate: 1, Timestamp: ts},
+		{Type: GaugeType, Name: "gauge", Value: 0, Rate: 1},
+		{Type: GaugeType, Name: "gauge", Value: -5, Rate: 1},
+		{Type: CountType, Name: "count", Value: 3, Tags: []string{"a:b"}, Rate: 0.5},
+		{Type: CountType, Name: "count", Value: 4, Rate: 1, Timestamp: ts},
+		{Type: CountType, Name: "count", Value: 1, Rate: 1, Cardinality: CardinalityHigh},
+		{Type: CountType, Name: "count", Value: -1, Rate: 1},
+		{Type: CountType, Name: "count", Value: -3, Rate: 1},
+		{Type: CountType, Name: "monotonic", Value: 10, Rate: 1},
+		{Type: HistogramType, Name: "histogram", Value: 1.5, Rate: 1},
+		{Type: DistributionType, Name: "distribution", Value: 2.5, Rate: 1},
+		{Type: DistributionType, Name: "distribution", Value: 1, Rate: 1},
+		{Type: DistributionType, Name: "distribution", Value: 2, Rate: 1},
+		{Type: SetType, Name: "set", SetValue: "value", Rate: 1},
+		{Type: SetType, Name: "set", SetValue: "42", Rate: 1},
+		{Type: SetType, Name: "set", SetValue: "a", Rate: 1},
+		{Type: SetType, Name: "set", SetValue: "b", Rate: 1},
+		{Type: TimingType, Name: "timing", Value: 2000, Rate: 1},
+		{Type: TimingType, Name: "timing", Value: 12.5, Rate: 1},
+	}, c.Metrics())
+
+	c.Reset()
+	assert.Empty(t, c.Metrics())
+}
+
+func TestTestClientEventsAndServiceChecks(t *testing.T) {
+	c := NewTestClient()
+
+	require.Nil(t, c.SimpleEvent("title", "text"))
+	require.Nil(t, c.Event(&Event{Title: "other", Text: "text", Tags: []string{"a:b"}}))
+	require.Nil(t, c.Event(nil))
+	require.Nil(t, c.SimpleServiceCheck("check", Warn))
+	require.Nil(t, c.ServiceCheck(nil))
+	require.Nil(t, c.Flush())
+	require.Nil(t, c.Close())
+
+	events := c.Events()
+	require.Len(t, events, 2)
+	assert.Equal(t, "title", events[0].Title)
+	assert.Equal(t, []string{"a:b"}, events[1].Tags)
+
+	checks := c.ServiceChecks()
+	require.Len(t, checks, 1)
+	assert.Equal(t, "check", checks[0].Name)
+	assert.Equal(t, Warn, checks[0].Status)
+}
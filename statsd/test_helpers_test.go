@@ -6,6 +6,7 @@ import (
 	"net"
 	"os"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"testing"
@@ -38,10 +39,12 @@ type testTelemetryData struct {
 	packets_dropped           int
 	packets_dropped_queue     int
 	packets_dropped_writer    int
+	packets_dropped_timeout   int
 	bytes_sent                int
 	bytes_dropped             int
 	bytes_dropped_queue       int
 	bytes_dropped_writer      int
+	transport_reconnections   int
 }
 
 // testServer acts as a fake server and keep track of what was sent to a client. This allows end-to-end testing of the
@@ -61,6 +64,7 @@ type testServer struct {
 
 	aggregation         bool
 	extendedAggregation bool
+	timingAggregation   bool
 	telemetry           testTelemetryData
 	telemetryEnabled    bool
 }
@@ -77,6 +81,7 @@ func newClientAndTestServer(t *testing.T, proto string, addr string, tags []stri
 		stopped:             make(chan struct{}),
 		aggregation:         opt.aggregation,
 		extendedAggregation: opt.extendedAggregation,
+		timingAggregation:   opt.timingAggregation,
 		telemetryEnabled:    opt.telemetry,
 		telemetry:           testTelemetryData{},
 		namespace:           opt.namespace,
@@ -215,6 +220,7 @@ func (ts *testServer) assert(t *testing.T, client *Client, expectedMetrics []str
 	if ts.telemetryEnabled {
 		// Now that all the metrics have been handled we can flush the telemetry before the default interval of
 		// 10s
+		expectedMetrics = append(expectedMetrics, ts.getMetricsPerPayload()...)
 		client.telemetryClient.sendTelemetry()
 		expectedMetrics = append(expectedMetrics, ts.getTelemetry()...)
 		// Wait for the telemetry to arrive
@@ -243,6 +249,27 @@ func (ts *testServer) getData() []string {
 	return data
 }
 
+// getMetricsPerPayload returns the "metrics_per_payload" telemetry expected for the payloads received so far.
+func (ts *testServer) getMetricsPerPayload() []string {
+	ts.Lock()
+	defer ts.Unlock()
+
+	payloads := map[int]int{}
+	for _, s := range ts.readData {
+		payloads[strings.Count(s, "\n")]++
+	}
+
+	metrics := []string{}
+	for nbMetrics, nbPayloads := range payloads {
+		rate := ""
+		if nbPayloads > 1 {
+			rate = "|@" + strconv.FormatFloat(1/float64(nbPayloads), 'f', -1, 64)
+		}
+		metrics = append(metrics, fmt.Sprintf("datadog.dogstatsd.client.metrics_per_payload:%d|d%s%s", nbMetrics, rate, ts.getFinalTelemetryTags()))
+	}
+	return metrics
+}
+
 func (ts *testServer) getTelemetry() []string {
 	ts.Lock()
 	defer ts.Unlock()
@@ -261,14 +288,18 @@ func (ts *testServer) getTelemetry() []string {
 		fmt.Sprintf("datadog.dogstatsd.client.events:%d|c%s", ts.telemetry.event, tags),
 		fmt.Sprintf("datadog.dogstatsd.client.service_checks:%d|c%s", ts.telemetry.service_check, tags),
 		fmt.Sprintf("datadog.dogstatsd.client.metric_dropped_on_receive:%d|c%s", ts.telemetry.metric_dropped_on_receive, tags),
+		fmt.Sprintf("datadog.dogstatsd.client.metrics_dropped_oversized:0|c%s", tags),
+		fmt.Sprintf("datadog.dogstatsd.client.metric_dropped_on_serialization:0|c%s", tags),
 		fmt.Sprintf("datadog.dogstatsd.client.packets_sent:%d|c%s", ts.telemetry.packets_sent, tags),
 		fmt.Sprintf("datadog.dogstatsd.client.packets_dropped:%d|c%s", ts.telemetry.packets_dropped, tags),
 		fmt.Sprintf("datadog.dogstatsd.client.packets_dropped_queue:%d|c%s", ts.telemetry.packets_dropped_queue, tags),
 		fmt.Sprintf("datadog.dogstatsd.client.packets_dropped_writer:%d|c%s", ts.telemetry.packets_dropped_writer, tags),
+		fmt.Sprintf("datadog.dogstatsd.client.packets_dropped_writer_timeout:%d|c%s", ts.telemetry.packets_dropped_timeout, tags),
 		fmt.Sprintf("datadog.dogstatsd.client.bytes_sent:%d|c%s", ts.telemetry.bytes_sent, tags),
 		fmt.Sprintf("datadog.dogstatsd.client.bytes_dropped:%d|c%s", ts.telemetry.bytes_dropped, tags),
 		fmt.Sprintf("datadog.dogstatsd.client.bytes_dropped_queue:%d|c%s", ts.telemetry.bytes_dropped_queue, tags),
 		fmt.Sprintf("datadog.dogstatsd.client.bytes_dropped_writer:%d|c%s", ts.telemetry.bytes_dropped_writer, tags),
+		fmt.Sprintf("datadog.dogstatsd.client.transport_reconnections:%d|c%s", ts.telemetry.transport_reconnections, tags),
 		fmt.Sprintf("datadog.dogstatsd.client.metrics_by_type:%d|c%s,metrics_type:gauge", ts.telemetry.gauge, tags),
 		fmt.Sprintf("datadog.dogstatsd.client.metrics_by_type:%d|c%s,metrics_type:count", ts.telemetry.count, tags),
 		fmt.Sprintf("datadog.dogstatsd.client.metrics_by_type:%d|c%s,metrics_type:histogram", ts.telemetry.histogram, tags),
@@ -352,6 +383,9 @@ func (ts *testServer) sendAllMetrics(c *Client) []string {
 		ts.telemetry.aggregated_histogram += 1
 		ts.telemetry.aggregated_distribution += 1
 		ts.telemetry.aggregated_timing += 2
+	} else if ts.timingAggregation {
+		ts.telemetry.aggregated_context += 2
+		ts.telemetry.aggregated_timing += 2
 	}
 
 	finalTags := ts.getFinalTags(tags...)
@@ -530,3 +564,104 @@ func (ts *testServer) sendExtendedBasicAggregationMetrics(client *Client) []stri
 		ts.namespace + "timing:6000.000000|ms" + finalTags,
 	}
 }
+
+// fakeClock is a clock that only moves forward when Advance is called. Ticks are sent synchronously: Advance blocks
+// until every due ticker has been received by its goroutine.
+type fakeClock struct {
+	sync.Mutex
+	now     time.Time
+	tickers []*fakeTicker
+}
+
+type fakeTicker struct {
+	clock    *fakeClock
+	c        chan time.Time
+	interval time.Duration
+	next     time.Time
+	stopped  bool
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Unix(1658934956, 0)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.Lock()
+	defer c.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) NewTicker(d time.Duration) ticker {
+	c.Lock()
+	defer c.Unlock()
+	t := &fakeTicker{clock: c, c: make(chan time.Time), interval: d, next: c.now.Add(d)}
+	c.tickers = append(c.tickers, t)
+	return t
+}
+
+// Advance moves the clock forward and fires, at most once, every ticker that is due.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.Lock()
+	c.now = c.now.Add(d)
+	now := c.now
+	due := []*fakeTicker{}
+	for _, t := range c.tickers {
+		if !t.stopped && !t.next.After(now) {
+			t.next = now.Add(t.interval)
+			due = append(due, t)
+		}
+	}
+	c.Unlock()
+
+	for _, t := range due {
+		t.c <- now
+	}
+}
+
+func (t *fakeTicker) C() <-chan time.Time {
+	return t.c
+}
+
+// Stop takes the lock of the clock, which guards the state of its tickers.
+func (t *fakeTicker) Stop() {
+	t.clock.Lock()
+	defer t.clock.Unlock()
+	t.stopped = true
+}
+
+// stalledConn is a net.Conn whose peer never reads: writes block until the write deadline and then fail with a
+// timeout, like a socket with a full buffer.
+type stalledConn struct {
+	net.Conn
+	sync.Mutex
+	deadline time.Time
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func (c *stalledConn) SetWriteDeadline(t time.Time) error {
+	c.Lock()
+	defer c.Unlock()
+	c.deadline = t
+	return nil
+}
+
+func (c *stalledConn) Write(b []byte) (int, error) {
+	c.Lock()
+	deadline := c.deadline
+	c.Unlock()
+	if deadline.IsZero() {
+		// no deadline: block forever
+		select {}
+	}
+	time.Sleep(time.Until(deadline))
+	return 0, timeoutError{}
+}
+
+func (c *stalledConn) Close() error {
+	return nil
+}
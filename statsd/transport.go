@@ -0,0 +1,15 @@
+package statsd
+
+// Transport is the backend the client writes its payloads to. The UDP, UDS and Windows named pipe transports are
+// created from the address given to New, a custom one can be given through WithTransport (ex: to publish the payloads
+// to a message queue).
+//
+// Write and Close are never called concurrently: when CloseWithContext gives up on a pending write, Close is called once
+// that write returns.
+type Transport interface {
+	// Write sends a payload: one or more DogStatsD messages separated by '\n', compressed when using
+	// WithPayloadCompression. An error counts the payload as dropped, see Telemetry.
+	Write(payload []byte) (int, error)
+	// Close is called once, when the client is closed.
+	Close() error
+}
@@ -0,0 +1,137 @@
+package statsd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// chanTransport publishes each payload to a channel, like a transport writing to a message queue would.
+type chanTransport struct {
+	payloads chan []byte
+	closed   bool
+}
+
+func (t *chanTransport) Write(payload []byte) (int, error) {
+	// the payload is only valid during the call
+	t.payloads <- append([]byte{}, payload...)
+	return len(payload), nil
+}
+
+func (t *chanTransport) Close() error {
+	t.closed = true
+	close(t.payloads)
+	return nil
+}
+
+// blockingTransport blocks its writes until released and records a Close called during a write.
+type blockingTransport struct {
+	startOnce         sync.Once
+	started           chan struct{}
+	release           chan struct{}
+	closed            chan struct{}
+	writing           int32
+	closedDuringWrite int32
+}
+
+func newBlockingTransport() *blockingTransport {
+	return &blockingTransport{started: make(chan struct{}), release: make(chan struct{}), closed: make(chan struct{})}
+}
+
+func (t *blockingTransport) Write(payload []byte) (int, error) {
+	atomic.StoreInt32(&t.writing, 1)
+	defer atomic.StoreInt32(&t.writing, 0)
+	t.startOnce.Do(func() { close(t.started) })
+	<-t.release
+	return len(payload), nil
+}
+
+func (t *blockingTransport) Close() error {
+	atomic.StoreInt32(&t.closedDuringWrite, atomic.LoadInt32(&t.writing))
+	close(t.closed)
+	return nil
+}
+
+func TestWithTransportCloseWithContext(t *testing.T) {
+	for _, synchronous := range []bool{false, true} {
+		transport := newBlockingTransport()
+		a := &countingAllocator{}
+		options := []Option{WithTransport(transport), WithoutTelemetry(), WithBufferAllocator(a.allocate, a.release)}
+		if synchronous {
+			options = append(options, WithSynchronousMode())
+		}
+		client, err := New("", options...)
+		require.Nil(t, err)
+		if synchronous {
+			// the write blocks the call itself, the final flush waits for it
+			go client.Gauge("gauge", 1, nil, 1)
+			<-transport.started
+		} else {
+			require.Nil(t, client.Gauge("gauge", 1, nil, 1))
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		assert.Equal(t, context.DeadlineExceeded, client.CloseWithContext(ctx))
+		cancel()
+
+		<-transport.started
+		select {
+		case <-transport.closed:
+			t.Fatal("the transport was closed during a write")
+		case <-time.After(20 * time.Millisecond):
+		}
+		// the transport is closed once the pending write returns
+		close(transport.release)
+		waitOrFail(t, time.Second, func() { <-transport.closed })
+		assert.Equal(t, int32(0), atomic.LoadInt32(&transport.closedDuringWrite))
+	}
+}
+
+func TestWithTransport(t *testing.T) {
+	transport := &chanTransport{payloads: make(chan []byte, 100)}
+	// the address is ignored
+	client, err := New("", WithTransport(transport), WithoutTelemetry(), WithoutClientSideAggregation(), WithMaxBytesPerPayload(100))
+	require.Nil(t, err)
+
+	expected := []string{}
+	for i := 0; i < 20; i++ {
+		client.Gauge(fmt.Sprintf("gauge%d", i), float64(i), []string{"tag:value"}, 1)
+		expected = append(expected, fmt.Sprintf("gauge%d:%d|g|#tag:value", i, i))
+	}
+	require.Nil(t, client.Close())
+	assert.True(t, transport.closed)
+
+	received := []string{}
+	nbPayloads := 0
+	for payload := range transport.payloads {
+		nbPayloads++
+		assert.LessOrEqual(t, len(payload), 100)
+		received = append(received, strings.Split(strings.TrimSuffix(string(payload), "\n"), "\n")...)
+	}
+	assert.Greater(t, nbPayloads, 1)
+	ts := &testServer{}
+	ts.assertMetric(t, received, expected)
+}
+
+func TestWithTransportTelemetry(t *testing.T) {
+	transport := &chanTransport{payloads: make(chan []byte, 100)}
+	client, err := New("", WithTransport(transport), WithoutClientSideAggregation())
+	require.Nil(t, err)
+	defer client.Close()
+
+	client.telemetryClient.sendTelemetry()
+	require.Nil(t, client.Flush())
+	select {
+	case payload := <-transport.payloads:
+		assert.Contains(t, string(payload), "client_transport:custom")
+	case <-time.After(time.Second):
+		require.Fail(t, "no telemetry was written to the transport")
+	}
+}
@@ -1,34 +1,212 @@
 package statsd
 
 import (
+	"errors"
 	"net"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
+const (
+	// udpReconnectThreshold is the number of consecutive write errors after which the udpWriter reconnects.
+	udpReconnectThreshold = 3
+	// udpMinReconnectBackoff and udpMaxReconnectBackoff bound the delay between two reconnections.
+	udpMinReconnectBackoff = time.Second
+	udpMaxReconnectBackoff = 30 * time.Second
+)
+
+// errUDPNotConnected is returned by the writes of a udpWriter created with WithLazyConnection while it waits for the
+// backoff before dialing again.
+var errUDPNotConnected = errors.New("statsd udp connection not established yet")
+
+// errUDPClosed is returned by the writes of a udpWriter once it is closed.
+var errUDPClosed = errors.New("statsd udp writer closed")
+
 // udpWriter is an internal class wrapping around management of UDP connection
 type udpWriter struct {
+	// nbReconnections is first to be 64 bits aligned for atomic operations on 32 bits architectures.
+	nbReconnections uint64
+	// appliedSendBufferSize is the size of the send buffer of the socket read back from the kernel, see
+	// WithUDPSendBufferSize. It is 0 when the option is not used or the size can't be read.
+	appliedSendBufferSize uint64
+
+	addr string
+	// conn is nil until the first successful dial when using WithLazyConnection
 	conn net.Conn
+	// write timeout
+	writeTimeout time.Duration
+	// sendBufferSize is the size of the send buffer requested for every connection, 0 keeps the default of the OS.
+	sendBufferSize int
+
+	// Reconnection state. dial and now are replaced by tests.
+	dial              func(addr string) (net.Conn, error)
+	now               func() time.Time
+	consecutiveErrors int
+	backoff           time.Duration
+	nextReconnect     time.Time
+	// closed is set by Close, no connection is made after that.
+	closed     bool
+	sync.Mutex // used to lock conn and the reconnection state, Write and Close can be called concurrently
 }
 
-// New returns a pointer to a new udpWriter given an addr in the format "hostname:port".
-func newUDPWriter(addr string, _ time.Duration) (*udpWriter, error) {
-	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+// New returns a pointer to a new udpWriter given an addr in the format "hostname:port". A sendBufferSize greater than 0
+// sets the size of the send buffer of the socket, see WithUDPSendBufferSize.
+func newUDPWriter(addr string, writeTimeout time.Duration, sendBufferSize int) (*udpWriter, error) {
+	conn, err := dialUDP(addr)
 	if err != nil {
 		return nil, err
 	}
-	conn, err := net.DialUDP("udp", nil, udpAddr)
-	if err != nil {
+	writer := &udpWriter{addr: addr, conn: conn, writeTimeout: writeTimeout, sendBufferSize: sendBufferSize, dial: dialUDP, now: time.Now}
+	if err = writer.applySendBufferSize(conn); err != nil {
+		conn.Close()
 		return nil, err
 	}
-	writer := &udpWriter{conn: conn}
 	return writer, nil
 }
 
-// Write data to the UDP connection with no error handling
+// newLazyUDPWriter returns a udpWriter that dials on its first write instead of when it is created, see
+// WithLazyConnection. Only the format of addr is checked: resolving it can fail until the Agent is up.
+func newLazyUDPWriter(addr string, writeTimeout time.Duration, sendBufferSize int) (*udpWriter, error) {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return nil, err
+	}
+	return &udpWriter{addr: addr, writeTimeout: writeTimeout, sendBufferSize: sendBufferSize, dial: dialUDP, now: time.Now}, nil
+}
+
+// applySendBufferSize sets the size of the send buffer of conn and records the size applied by the kernel, which can
+// differ from the one requested: Linux doubles it for its own bookkeeping and caps it to net.core.wmem_max.
+func (w *udpWriter) applySendBufferSize(conn net.Conn) error {
+	udpConn, ok := conn.(*net.UDPConn)
+	if w.sendBufferSize == 0 || !ok {
+		return nil
+	}
+	if err := udpConn.SetWriteBuffer(w.sendBufferSize); err != nil {
+		return err
+	}
+	if size, err := socketSendBufferSize(udpConn); err == nil {
+		atomic.StoreUint64(&w.appliedSendBufferSize, uint64(size))
+	}
+	return nil
+}
+
+func dialUDP(addr string) (net.Conn, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return net.DialUDP("udp", nil, udpAddr)
+}
+
+// Write data to the UDP connection with write timeout. Writing to a UDP socket rarely blocks but the deadline still
+// protects the sender when the socket buffer of the host is full.
+//
+// A connected UDP socket keeps returning errors like "connection refused" once the Agent is unreachable, for example
+// while it restarts. After udpReconnectThreshold consecutive errors the address is resolved again and a new
+// connection is created, so the metrics resume once the Agent is back even if its IP changed. The reconnections are
+// spaced by an exponential backoff.
+//
+// A writer created with WithLazyConnection dials on its first write. The writes fail until a dial succeeds, the dials
+// being spaced by the same backoff.
 func (w *udpWriter) Write(data []byte) (int, error) {
-	return w.conn.Write(data)
+	w.Lock()
+	defer w.Unlock()
+	if w.closed {
+		return 0, errUDPClosed
+	}
+	if w.conn == nil {
+		if err := w.connect(); err != nil {
+			return 0, err
+		}
+	}
+	if w.writeTimeout > 0 {
+		w.conn.SetWriteDeadline(time.Now().Add(w.writeTimeout))
+	}
+	n, err := w.conn.Write(data)
+	if err == nil {
+		w.consecutiveErrors = 0
+		w.backoff = 0
+		w.nextReconnect = time.Time{}
+	} else if !isTimeout(err) {
+		// a timeout means the socket is slow, not that the destination is gone
+		w.maybeReconnect()
+	}
+	return n, err
+}
+
+func (w *udpWriter) maybeReconnect() {
+	w.consecutiveErrors++
+	now := w.now()
+	if w.consecutiveErrors < udpReconnectThreshold || now.Before(w.nextReconnect) {
+		return
+	}
+
+	atomic.AddUint64(&w.nbReconnections, 1)
+	w.delayNextDial(now)
+
+	conn, err := w.dial(w.addr)
+	if err != nil {
+		return
+	}
+	w.applySendBufferSize(conn)
+	w.conn.Close()
+	w.conn = conn
+}
+
+// connect makes the first connection of a writer created with WithLazyConnection. A failed dial delays the next one
+// like a failed reconnection. It must be called with the lock held and never dials once the writer is closed, the
+// connection would be leaked.
+func (w *udpWriter) connect() error {
+	if w.closed {
+		return errUDPClosed
+	}
+	now := w.now()
+	if now.Before(w.nextReconnect) {
+		return errUDPNotConnected
+	}
+	conn, err := w.dial(w.addr)
+	if err == nil {
+		if err = w.applySendBufferSize(conn); err != nil {
+			conn.Close()
+		}
+	}
+	if err != nil {
+		w.delayNextDial(now)
+		return err
+	}
+	w.conn = conn
+	w.backoff = 0
+	w.nextReconnect = time.Time{}
+	return nil
+}
+
+// delayNextDial increases the exponential backoff between two dials.
+func (w *udpWriter) delayNextDial(now time.Time) {
+	if w.backoff == 0 {
+		w.backoff = udpMinReconnectBackoff
+	} else if w.backoff *= 2; w.backoff > udpMaxReconnectBackoff {
+		w.backoff = udpMaxReconnectBackoff
+	}
+	w.nextReconnect = now.Add(w.backoff)
+}
+
+// reconnections returns the number of reconnections attempted so far.
+func (w *udpWriter) reconnections() uint64 {
+	return atomic.LoadUint64(&w.nbReconnections)
+}
+
+// udpSendBufferSize returns the size of the send buffer of the socket as applied by the kernel, see
+// WithUDPSendBufferSize.
+func (w *udpWriter) udpSendBufferSize() uint64 {
+	return atomic.LoadUint64(&w.appliedSendBufferSize)
 }
 
 func (w *udpWriter) Close() error {
+	w.Lock()
+	defer w.Unlock()
+	w.closed = true
+	if w.conn == nil {
+		return nil
+	}
 	return w.conn.Close()
 }
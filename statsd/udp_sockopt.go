@@ -0,0 +1,25 @@
+// +build !windows
+
+package statsd
+
+import (
+	"net"
+	"syscall"
+)
+
+// socketSendBufferSize returns the size of the send buffer of conn as applied by the kernel.
+func socketSendBufferSize(conn *net.UDPConn) (int, error) {
+	rawConn, err := conn.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+	var size int
+	var sockErr error
+	err = rawConn.Control(func(fd uintptr) {
+		size, sockErr = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_SNDBUF)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return size, sockErr
+}
@@ -0,0 +1,13 @@
+// +build windows
+
+package statsd
+
+import (
+	"errors"
+	"net"
+)
+
+// socketSendBufferSize is not implemented on Windows, the size applied by WithUDPSendBufferSize can't be read back.
+func socketSendBufferSize(conn *net.UDPConn) (int, error) {
+	return 0, errors.New("reading the send buffer size of a socket is not supported on Windows")
+}
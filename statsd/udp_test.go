@@ -0,0 +1,279 @@
+package statsd
+
+import (
+	"errors"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUDPWriteTimeout(t *testing.T) {
+	w := &udpWriter{conn: &stalledConn{}, writeTimeout: 50 * time.Millisecond}
+
+	start := time.Now()
+	_, err := w.Write([]byte("metric:1|c"))
+	assert.True(t, isTimeout(err))
+	assert.Less(t, int64(time.Since(start)), int64(time.Second))
+}
+
+// refusedConn is a net.Conn whose writes fail like a connected UDP socket whose destination is unreachable.
+type refusedConn struct {
+	net.Conn
+	closed bool
+}
+
+func (c *refusedConn) SetWriteDeadline(t time.Time) error { return nil }
+func (c *refusedConn) Write(b []byte) (int, error) {
+	return 0, errors.New("write: connection refused")
+}
+func (c *refusedConn) Close() error {
+	c.closed = true
+	return nil
+}
+
+// workingConn is a net.Conn accepting every write.
+type workingConn struct {
+	net.Conn
+}
+
+func (c *workingConn) SetWriteDeadline(t time.Time) error { return nil }
+func (c *workingConn) Write(b []byte) (int, error)        { return len(b), nil }
+func (c *workingConn) Close() error                       { return nil }
+
+func TestUDPReconnect(t *testing.T) {
+	now := time.Unix(1658934092, 0)
+	conn := &refusedConn{}
+	dials := 0
+	w := &udpWriter{
+		addr: "localhost:8125",
+		conn: conn,
+		now:  func() time.Time { return now },
+		dial: func(addr string) (net.Conn, error) {
+			assert.Equal(t, "localhost:8125", addr)
+			dials++
+			// the Agent is still down for the first two reconnections
+			if dials <= 2 {
+				return nil, errors.New("dial failed")
+			}
+			return &workingConn{}, nil
+		},
+	}
+	data := []byte("metric:1|c")
+
+	// no reconnection before udpReconnectThreshold consecutive errors
+	for i := 0; i < udpReconnectThreshold-1; i++ {
+		_, err := w.Write(data)
+		require.NotNil(t, err)
+	}
+	assert.Equal(t, 0, dials)
+
+	_, err := w.Write(data)
+	require.NotNil(t, err)
+	assert.Equal(t, 1, dials)
+	assert.Equal(t, udpMinReconnectBackoff, w.backoff)
+
+	// the next reconnection waits for the backoff
+	now = now.Add(udpMinReconnectBackoff / 2)
+	w.Write(data)
+	assert.Equal(t, 1, dials)
+	now = now.Add(udpMinReconnectBackoff / 2)
+	w.Write(data)
+	assert.Equal(t, 2, dials)
+	assert.Equal(t, 2*udpMinReconnectBackoff, w.backoff)
+
+	now = now.Add(udpMinReconnectBackoff)
+	w.Write(data)
+	assert.Equal(t, 2, dials)
+	now = now.Add(udpMinReconnectBackoff)
+	w.Write(data)
+	assert.Equal(t, 3, dials)
+	assert.Equal(t, uint64(3), w.reconnections())
+
+	// the third dial succeeded: the old connection is closed and the writes go through again
+	assert.True(t, conn.closed)
+	n, err := w.Write(data)
+	require.Nil(t, err)
+	assert.Equal(t, len(data), n)
+	assert.Equal(t, 0, w.consecutiveErrors)
+	assert.Equal(t, time.Duration(0), w.backoff)
+}
+
+func TestUDPReconnectBackoffIsCapped(t *testing.T) {
+	now := time.Unix(1658934092, 0)
+	w := &udpWriter{
+		conn: &refusedConn{},
+		now:  func() time.Time { return now },
+		dial: func(addr string) (net.Conn, error) {
+			return nil, errors.New("dial failed")
+		},
+	}
+
+	for i := 0; i < 20; i++ {
+		w.Write([]byte("metric:1|c"))
+		now = now.Add(udpMaxReconnectBackoff)
+	}
+	assert.Equal(t, udpMaxReconnectBackoff, w.backoff)
+	assert.Equal(t, uint64(20-udpReconnectThreshold+1), w.reconnections())
+}
+
+func TestUDPTimeoutDoesNotReconnect(t *testing.T) {
+	dials := 0
+	w := &udpWriter{
+		conn:         &stalledConn{},
+		writeTimeout: time.Millisecond,
+		now:          time.Now,
+		dial: func(addr string) (net.Conn, error) {
+			dials++
+			return &workingConn{}, nil
+		},
+	}
+
+	for i := 0; i < udpReconnectThreshold; i++ {
+		w.Write([]byte("metric:1|c"))
+	}
+	assert.Equal(t, 0, dials)
+}
+
+func TestUDPWriterSendBufferSize(t *testing.T) {
+	w, err := newUDPWriter("localhost:8125", 0, 65536)
+	require.NoError(t, err)
+	defer w.Close()
+	assert.Equal(t, 65536, w.sendBufferSize)
+	if runtime.GOOS != "windows" {
+		// the kernel can double or clamp the requested size
+		assert.NotZero(t, w.udpSendBufferSize())
+	}
+
+	w, err = newUDPWriter("localhost:8125", 0, 0)
+	require.NoError(t, err)
+	defer w.Close()
+	assert.Zero(t, w.udpSendBufferSize())
+}
+
+func TestClientUDPSendBufferSize(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the send buffer size can't be read back on Windows")
+	}
+	client, err := New("localhost:8125", WithoutTelemetry(), WithUDPSendBufferSize(65536))
+	require.NoError(t, err)
+	defer client.Close()
+	assert.NotZero(t, client.GetTelemetry().UDPSendBufferSize)
+}
+
+func TestUDPLazyConnection(t *testing.T) {
+	_, err := newLazyUDPWriter("localhost", 0, 0)
+	assert.Error(t, err)
+
+	w, err := newLazyUDPWriter("agent:8125", 0, 0)
+	require.NoError(t, err)
+	assert.Nil(t, w.conn)
+
+	now := time.Unix(1658934092, 0)
+	up := false
+	dials := 0
+	w.now = func() time.Time { return now }
+	w.dial = func(addr string) (net.Conn, error) {
+		assert.Equal(t, "agent:8125", addr)
+		dials++
+		if !up {
+			return nil, errors.New("no such host")
+		}
+		return &workingConn{}, nil
+	}
+	data := []byte("metric:1|c")
+
+	// the first write dials and fails while the Agent is down
+	_, err = w.Write(data)
+	assert.EqualError(t, err, "no such host")
+	assert.Equal(t, 1, dials)
+
+	// the next dial waits for the backoff
+	up = true
+	_, err = w.Write(data)
+	assert.Equal(t, errUDPNotConnected, err)
+	assert.Equal(t, 1, dials)
+
+	now = now.Add(udpMinReconnectBackoff)
+	n, err := w.Write(data)
+	require.NoError(t, err)
+	assert.Equal(t, len(data), n)
+	assert.Equal(t, 2, dials)
+	assert.Zero(t, w.backoff)
+	assert.Zero(t, w.reconnections())
+
+	// once connected the writes don't dial anymore
+	_, err = w.Write(data)
+	require.NoError(t, err)
+	assert.Equal(t, 2, dials)
+	assert.NoError(t, w.Close())
+}
+
+func TestUDPLazyConnectionAfterClose(t *testing.T) {
+	w, err := newLazyUDPWriter("agent:8125", 0, 0)
+	require.NoError(t, err)
+	dials := 0
+	w.dial = func(addr string) (net.Conn, error) {
+		dials++
+		return &workingConn{}, nil
+	}
+	require.NoError(t, w.Close())
+
+	// a write after Close must not dial a connection that would never be closed
+	_, err = w.Write([]byte("metric:1|c"))
+	assert.Equal(t, errUDPClosed, err)
+	assert.Zero(t, dials)
+	assert.Nil(t, w.conn)
+}
+
+func TestUDPConcurrentWriteAndClose(t *testing.T) {
+	w, err := newLazyUDPWriter("agent:8125", 0, 0)
+	require.NoError(t, err)
+	w.dial = func(addr string) (net.Conn, error) { return &workingConn{}, nil }
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			if _, err := w.Write([]byte("metric:1|c")); err == errUDPClosed {
+				return
+			}
+		}
+	}()
+	require.NoError(t, w.Close())
+	<-done
+}
+
+func TestClientLazyConnection(t *testing.T) {
+	// the Agent hostname can't be resolved while it starts
+	_, err := New("agent.invalid:8125", WithoutTelemetry())
+	require.Error(t, err)
+
+	server, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer server.Close()
+
+	client, err := New("agent.invalid:8125", WithoutTelemetry(), WithoutClientSideAggregation(), WithSynchronousMode(), WithLazyConnection())
+	require.NoError(t, err)
+	defer client.Close()
+	w := client.sender.transport.(*udpWriter)
+	now := time.Unix(1658934092, 0)
+	w.now = func() time.Time { return now }
+
+	client.Gauge("before", 1, nil, 1)
+	assert.Equal(t, uint64(1), client.GetTelemetry().TotalPayloadsDroppedWriter)
+
+	// the Agent is up: the metrics flow once the backoff is over
+	w.dial = func(addr string) (net.Conn, error) { return dialUDP(server.LocalAddr().String()) }
+	now = now.Add(udpMinReconnectBackoff)
+	client.Gauge("after", 1, nil, 1)
+
+	buffer := make([]byte, 1024)
+	server.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := server.Read(buffer)
+	require.NoError(t, err)
+	assert.Equal(t, "after:1|g\n", string(buffer[:n]))
+}
@@ -3,30 +3,53 @@
 package statsd
 
 import (
+	"encoding/binary"
+	"io"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 // udsWriter is an internal class wrapping around management of UDS connection
 type udsWriter struct {
+	// nbReconnections is first to be 64 bits aligned for atomic operations on 32 bits architectures.
+	nbReconnections uint64
 	// Address to send metrics to, needed to allow reconnection on error
 	addr net.Addr
 	// Established connection object, or nil if not connected yet
 	conn net.Conn
 	// write timeout
 	writeTimeout time.Duration
+	// connectTimeout caps how long a dial can take, 0 meaning no timeout, see WithConnectTimeout
+	connectTimeout time.Duration
+	// hadConnection is true once a connection was established, any later dial is a reconnection
+	hadConnection bool
+	// stream is true for SOCK_STREAM sockets, where each payload is prefixed with its length
+	stream       bool
 	sync.RWMutex // used to lock conn / writer can replace it
 }
 
 // newUDSWriter returns a pointer to a new udsWriter given a socket file path as addr.
-func newUDSWriter(addr string, writeTimeout time.Duration) (*udsWriter, error) {
+func newUDSWriter(addr string, writeTimeout time.Duration, connectTimeout time.Duration) (*udsWriter, error) {
 	udsAddr, err := net.ResolveUnixAddr("unixgram", addr)
 	if err != nil {
 		return nil, err
 	}
 	// Defer connection to first Write
-	writer := &udsWriter{addr: udsAddr, conn: nil, writeTimeout: writeTimeout}
+	writer := &udsWriter{addr: udsAddr, conn: nil, writeTimeout: writeTimeout, connectTimeout: connectTimeout}
+	return writer, nil
+}
+
+// newUDSStreamWriter returns a pointer to a new udsWriter for a SOCK_STREAM socket given its file path as addr. Each
+// payload is written prefixed with its length as a 32 bits little endian integer so the Agent can split the stream.
+func newUDSStreamWriter(addr string, writeTimeout time.Duration, connectTimeout time.Duration) (*udsWriter, error) {
+	udsAddr, err := net.ResolveUnixAddr("unix", addr)
+	if err != nil {
+		return nil, err
+	}
+	// Defer connection to first Write
+	writer := &udsWriter{addr: udsAddr, conn: nil, writeTimeout: writeTimeout, connectTimeout: connectTimeout, stream: true}
 	return writer, nil
 }
 
@@ -38,7 +61,12 @@ func (w *udsWriter) Write(data []byte) (int, error) {
 		return 0, err
 	}
 
-	conn.SetWriteDeadline(time.Now().Add(w.writeTimeout))
+	if w.writeTimeout > 0 {
+		conn.SetWriteDeadline(time.Now().Add(w.writeTimeout))
+	}
+	if w.stream {
+		return w.writeFrame(conn, data)
+	}
 	n, e := conn.Write(data)
 
 	if err, isNetworkErr := e.(net.Error); err != nil && (!isNetworkErr || !err.Temporary()) {
@@ -49,9 +77,51 @@ func (w *udsWriter) Write(data []byte) (int, error) {
 	return n, e
 }
 
+// writeFrame writes data prefixed with its length to a stream connection. A frame only partially written would be
+// misread by the Agent: the connection is closed on any error happening after its first byte was written, the next
+// write reconnecting.
+func (w *udsWriter) writeFrame(conn net.Conn, data []byte) (int, error) {
+	var header [4]byte
+	binary.LittleEndian.PutUint32(header[:], uint32(len(data)))
+
+	written, err := writeFull(conn, header[:])
+	if err == nil {
+		var n int
+		n, err = writeFull(conn, data)
+		written += n
+	}
+	if err == nil {
+		return len(data), nil
+	}
+	if netErr, isNetworkErr := err.(net.Error); written > 0 || !isNetworkErr || !netErr.Temporary() {
+		conn.Close()
+		w.unsetConnection()
+	}
+	return 0, err
+}
+
+// writeFull writes b to conn, writing again what's left after a short write.
+func writeFull(conn net.Conn, b []byte) (int, error) {
+	written := 0
+	for written < len(b) {
+		n, err := conn.Write(b[written:])
+		written += n
+		if err != nil {
+			return written, err
+		}
+		if n == 0 {
+			return written, io.ErrShortWrite
+		}
+	}
+	return written, nil
+}
+
 func (w *udsWriter) Close() error {
-	if w.conn != nil {
-		return w.conn.Close()
+	w.RLock()
+	conn := w.conn
+	w.RUnlock()
+	if conn != nil {
+		return conn.Close()
 	}
 	return nil
 }
@@ -73,14 +143,23 @@ func (w *udsWriter) ensureConnection() (net.Conn, error) {
 		return w.conn, nil
 	}
 
-	newConn, err := net.Dial(w.addr.Network(), w.addr.String())
+	if w.hadConnection {
+		atomic.AddUint64(&w.nbReconnections, 1)
+	}
+	newConn, err := net.DialTimeout(w.addr.Network(), w.addr.String(), w.connectTimeout)
 	if err != nil {
 		return nil, err
 	}
 	w.conn = newConn
+	w.hadConnection = true
 	return newConn, nil
 }
 
+// reconnections returns the number of reconnections attempted after the statsd server disconnected.
+func (w *udsWriter) reconnections() uint64 {
+	return atomic.LoadUint64(&w.nbReconnections)
+}
+
 func (w *udsWriter) unsetConnection() {
 	w.Lock()
 	defer w.Unlock()
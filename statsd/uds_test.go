@@ -3,10 +3,16 @@
 package statsd
 
 import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"io"
 	"math/rand"
 	"net"
 	"os"
+	"syscall"
 	"testing"
 	"time"
 
@@ -19,7 +25,7 @@ func init() {
 }
 
 func TestNewUDSWriter(t *testing.T) {
-	w, err := newUDSWriter("/tmp/test.socket", 100*time.Millisecond)
+	w, err := newUDSWriter("/tmp/test.socket", 100*time.Millisecond, 0)
 	assert.NotNil(t, w)
 	assert.NoError(t, err)
 }
@@ -35,7 +41,7 @@ func TestUDSWrite(t *testing.T) {
 	err = os.Chmod(socketPath, 0722)
 	require.NoError(t, err)
 
-	w, err := newUDSWriter(socketPath, 100*time.Millisecond)
+	w, err := newUDSWriter(socketPath, 100*time.Millisecond, 0)
 	require.Nil(t, err)
 	require.NotNil(t, w)
 
@@ -63,7 +69,7 @@ func TestUDSWriteUnsetConnection(t *testing.T) {
 	err = os.Chmod(socketPath, 0722)
 	require.NoError(t, err)
 
-	w, err := newUDSWriter(socketPath, 100*time.Millisecond)
+	w, err := newUDSWriter(socketPath, 100*time.Millisecond, 0)
 	require.Nil(t, err)
 	require.NotNil(t, w)
 
@@ -82,3 +88,147 @@ func TestUDSWriteUnsetConnection(t *testing.T) {
 		w.unsetConnection()
 	}
 }
+
+func TestUDSWriteTimeout(t *testing.T) {
+	w := &udsWriter{conn: &stalledConn{}, writeTimeout: 50 * time.Millisecond}
+	pool := newBufferPool(10, 1024, 1)
+	sender := newSender(w, 10, pool)
+	buffer := pool.borrowBuffer()
+	buffer.writeSeparator() // add some dummy data
+
+	start := time.Now()
+	sender.send(buffer)
+	require.NoError(t, sender.drain(context.Background()))
+	assert.Less(t, int64(time.Since(start)), int64(time.Second))
+
+	// the payload is dropped and the connection is kept for the next write
+	assert.Equal(t, uint64(1), sender.telemetry.totalPayloadsDroppedWriter)
+	assert.Equal(t, uint64(1), sender.telemetry.totalPayloadsDroppedTimeout)
+	assert.NotNil(t, w.conn)
+	sender.close()
+}
+
+func TestUDSConnectTimeout(t *testing.T) {
+	socketPath := fmt.Sprintf("/tmp/dsd_%d.socket", rand.Int())
+	defer os.Remove(socketPath)
+
+	// a listening socket whose backlog is never accepted: once it is full the dials hang
+	fd, err := syscall.Socket(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	require.NoError(t, err)
+	defer syscall.Close(fd)
+	require.NoError(t, syscall.Bind(fd, &syscall.SockaddrUnix{Name: socketPath}))
+	require.NoError(t, syscall.Listen(fd, 0))
+	for i := 0; i < 10; i++ {
+		conn, err := net.DialTimeout("unix", socketPath, 100*time.Millisecond)
+		if err != nil {
+			break
+		}
+		defer conn.Close()
+	}
+
+	w, err := newUDSStreamWriter(socketPath, 100*time.Millisecond, 100*time.Millisecond)
+	require.NoError(t, err)
+	defer w.Close()
+
+	start := time.Now()
+	_, err = w.Write([]byte("some data"))
+	assert.Error(t, err)
+	assert.Less(t, int64(time.Since(start)), int64(time.Second))
+	assert.Nil(t, w.conn)
+}
+
+func TestUDSStreamWrite(t *testing.T) {
+	socketPath := fmt.Sprintf("/tmp/dsd_%d.socket", rand.Int())
+	defer os.Remove(socketPath)
+
+	listener, err := net.Listen("unix", socketPath)
+	require.NoError(t, err)
+	defer listener.Close()
+
+	w, transport, err := createWriter(UnixAddressStreamPrefix+socketPath, 100*time.Millisecond, 0, 0, false)
+	require.NoError(t, err)
+	assert.Equal(t, writerNameUDSStream, transport)
+	defer w.Close()
+
+	n, err := w.Write([]byte("some data"))
+	require.NoError(t, err)
+	assert.Equal(t, 9, n)
+	n, err = w.Write([]byte("more data\n"))
+	require.NoError(t, err)
+	assert.Equal(t, 10, n)
+
+	conn, err := listener.Accept()
+	require.NoError(t, err)
+	defer conn.Close()
+	for _, expected := range []string{"some data", "more data\n"} {
+		var size uint32
+		require.NoError(t, binary.Read(conn, binary.LittleEndian, &size))
+		payload := make([]byte, size)
+		_, err = io.ReadFull(conn, payload)
+		require.NoError(t, err)
+		assert.Equal(t, expected, string(payload))
+	}
+}
+
+// shortWriteConn writes at most maxWrite bytes per call and fails once failAfter bytes were written, if set.
+type shortWriteConn struct {
+	net.Conn
+	maxWrite  int
+	failAfter int
+	data      bytes.Buffer
+	closed    bool
+}
+
+func (c *shortWriteConn) SetWriteDeadline(t time.Time) error { return nil }
+
+func (c *shortWriteConn) Close() error {
+	c.closed = true
+	return nil
+}
+
+func (c *shortWriteConn) Write(b []byte) (int, error) {
+	if c.failAfter > 0 && c.data.Len() >= c.failAfter {
+		return 0, errors.New("broken pipe")
+	}
+	if len(b) > c.maxWrite {
+		b = b[:c.maxWrite]
+	}
+	return c.data.Write(b)
+}
+
+func TestUDSStreamWritePartial(t *testing.T) {
+	conn := &shortWriteConn{maxWrite: 3}
+	w := &udsWriter{conn: conn, stream: true}
+
+	n, err := w.Write([]byte("some data"))
+	require.NoError(t, err)
+	assert.Equal(t, 9, n)
+	n, err = w.Write([]byte("x"))
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	// the short writes are completed, giving the same frames as single writes
+	assert.Equal(t, "\x09\x00\x00\x00some data\x01\x00\x00\x00x", conn.data.String())
+	assert.Equal(t, conn, w.conn)
+}
+
+func TestUDSStreamWriteFailureWithinFrame(t *testing.T) {
+	conn := &shortWriteConn{maxWrite: 3, failAfter: 6}
+	w := &udsWriter{conn: conn, stream: true}
+
+	n, err := w.Write([]byte("some data"))
+	assert.Error(t, err)
+	assert.Equal(t, 0, n)
+	// the frame was partially written: the connection can't be used anymore
+	assert.True(t, conn.closed)
+	assert.Nil(t, w.conn)
+}
+
+func TestUDSStreamWriteTimeout(t *testing.T) {
+	w := &udsWriter{conn: &stalledConn{}, writeTimeout: 50 * time.Millisecond, stream: true}
+
+	_, err := w.Write([]byte("some data"))
+	assert.True(t, isTimeout(err))
+	// nothing was written so the connection is kept for the next write
+	assert.NotNil(t, w.conn)
+}
@@ -2,9 +2,17 @@
 
 package statsd
 
-import "fmt"
+import (
+	"fmt"
+	"time"
+)
 
 // newUDSWriter is disable on windows as unix sockets are not available
-func newUDSWriter(addr string) (io.WriteCloser, error) {
+func newUDSWriter(addr string, writeTimeout time.Duration, connectTimeout time.Duration) (Transport, error) {
+	return nil, fmt.Errorf("unix socket is not available on windows")
+}
+
+// newUDSStreamWriter is disable on windows as unix sockets are not available
+func newUDSStreamWriter(addr string, writeTimeout time.Duration, connectTimeout time.Duration) (Transport, error) {
 	return nil, fmt.Errorf("unix socket is not available on windows")
 }
@@ -3,6 +3,7 @@ package statsd
 import (
 	"math/rand"
 	"sync"
+	"time"
 )
 
 func shouldSample(rate float64, r *rand.Rand, lock *sync.Mutex) bool {
@@ -21,3 +22,20 @@ func shouldSample(rate float64, r *rand.Rand, lock *sync.Mutex) bool {
 	return true
 
 }
+
+// sampleWith uses the Sampler set through WithSampler when there is one, and the random source otherwise.
+func sampleWith(sampler Sampler, name string, tags []string, rate float64, r *rand.Rand, lock *sync.Mutex) bool {
+	if sampler == nil || rate >= 1 {
+		return shouldSample(rate, r, lock)
+	}
+	return sampler.ShouldSample(name, tags, rate)
+}
+
+// jitterInterval returns interval randomly changed by up to fraction of its value in either direction, see
+// WithFlushJitter.
+func jitterInterval(interval time.Duration, fraction float64, r *rand.Rand) time.Duration {
+	if fraction == 0 || interval == 0 {
+		return interval
+	}
+	return time.Duration(float64(interval) * (1 + fraction*(2*r.Float64()-1)))
+}
@@ -0,0 +1,21 @@
+package statsd
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJitterInterval(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	assert.Equal(t, time.Second, jitterInterval(time.Second, 0, r))
+	assert.Equal(t, time.Duration(0), jitterInterval(0, 0.5, r))
+
+	for i := 0; i < 1000; i++ {
+		interval := jitterInterval(time.Second, 0.2, r)
+		assert.GreaterOrEqual(t, int64(interval), int64(800*time.Millisecond))
+		assert.LessOrEqual(t, int64(interval), int64(1200*time.Millisecond))
+	}
+}
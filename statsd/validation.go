@@ -0,0 +1,91 @@
+package statsd
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+)
+
+// ErrTooManyTags is returned when a metric has more tags than allowed by WithMaxTagsPerMetric with the MaxTagsDrop
+// strategy.
+var ErrTooManyTags = errors.New("statsd metric has too many tags, see WithMaxTagsPerMetric")
+
+func isValidNameRune(r rune) bool {
+	return (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_' || r == '.'
+}
+
+// validateName returns an error if name is empty or holds a character other than ASCII letters, digits, '_' and '.'.
+// kind names what is validated in the error (ex: "metric").
+func validateName(kind string, name string) error {
+	if name == "" {
+		return fmt.Errorf("statsd %s name is required", kind)
+	}
+	for _, r := range name {
+		if !isValidNameRune(r) {
+			return fmt.Errorf("statsd %s name %q is invalid: %q is not allowed", kind, name, r)
+		}
+	}
+	return nil
+}
+
+// validateTags returns an error if a tag holds a character not allowed in DogStatsD tags, see isValidTagRune.
+func validateTags(tags []string) error {
+	for _, tag := range tags {
+		for _, r := range tag {
+			if !isValidTagRune(r) {
+				return fmt.Errorf("statsd tag %q is invalid: %q is not allowed", tag, r)
+			}
+		}
+	}
+	return nil
+}
+
+// validateMetric returns an error if WithStrictNameValidation is used and the name or one of the tags of a metric is
+// invalid, or ErrTooManyTags if the metric has too many tags for WithMaxTagsPerMetric with MaxTagsDrop. Invalid
+// metrics are counted and reported to the ErrorHandler with ErrSerialization.
+func (c *Client) validateMetric(name string, tags []string) error {
+	if c.maxTags != 0 && c.maxTagsStrategy == MaxTagsDrop && len(c.getGlobalTags().metricTags)+len(tags) > c.maxTags {
+		atomic.AddUint64(&c.telemetry.totalTooManyTags, 1)
+		return ErrTooManyTags
+	}
+	if !c.strictNames {
+		return nil
+	}
+	err := validateName("metric", name)
+	if err == nil {
+		err = validateTags(tags)
+	}
+	if err != nil {
+		atomic.AddUint64(&c.telemetry.totalDroppedSerialization, 1)
+		c.errorReporter.report(name, ErrSerialization, err)
+	}
+	return err
+}
+
+// validateEvent returns an error if WithStrictNameValidation is used and the event has no title or text, its title
+// holds a newline or one of its tags is invalid. The title is free text but it is not escaped like the text is.
+func (c *Client) validateEvent(e *Event) error {
+	if !c.strictNames {
+		return nil
+	}
+	if err := e.Check(); err != nil {
+		return err
+	}
+	if strings.ContainsAny(e.Title, "\r\n") {
+		return fmt.Errorf("statsd event title %q is invalid: newlines are not allowed", e.Title)
+	}
+	return validateTags(e.Tags)
+}
+
+// validateServiceCheck returns an error if WithStrictNameValidation is used and the name or one of the tags of the
+// service check is invalid.
+func (c *Client) validateServiceCheck(sc *ServiceCheck) error {
+	if !c.strictNames {
+		return nil
+	}
+	if err := validateName("service check", sc.Name); err != nil {
+		return err
+	}
+	return validateTags(sc.Tags)
+}
@@ -0,0 +1,75 @@
+package statsd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateName(t *testing.T) {
+	assert.Nil(t, validateName("metric", "my_app.request.count2"))
+
+	assert.EqualError(t, validateName("metric", ""), "statsd metric name is required")
+	assert.EqualError(t, validateName("metric", "my metric"), `statsd metric name "my metric" is invalid: ' ' is not allowed`)
+	assert.EqualError(t, validateName("metric", "my|metric"), `statsd metric name "my|metric" is invalid: '|' is not allowed`)
+	assert.EqualError(t, validateName("service check", "check\n"), `statsd service check name "check\n" is invalid: '\n' is not allowed`)
+	assert.NotNil(t, validateName("metric", "name:1"))
+	assert.NotNil(t, validateName("metric", "métrique"))
+}
+
+func TestValidateTags(t *testing.T) {
+	assert.Nil(t, validateTags(nil))
+	assert.Nil(t, validateTags([]string{"env:prod", "path:/a/b", "version:1.2-rc", "standalone", "clé:valeur"}))
+
+	assert.EqualError(t, validateTags([]string{"env:prod", "env:my prod"}), `statsd tag "env:my prod" is invalid: ' ' is not allowed`)
+	assert.EqualError(t, validateTags([]string{"a|b"}), `statsd tag "a|b" is invalid: '|' is not allowed`)
+	assert.EqualError(t, validateTags([]string{"a:b\n"}), `statsd tag "a:b\n" is invalid: '\n' is not allowed`)
+	assert.NotNil(t, validateTags([]string{"a,b"}))
+	assert.NotNil(t, validateTags([]string{"a#b"}))
+}
+
+func TestClientStrictNameValidation(t *testing.T) {
+	var buf bytes.Buffer
+	client, err := NewWithWriter(&buf, WithoutTelemetry(), WithoutClientSideAggregation(), WithStrictNameValidation())
+	require.Nil(t, err)
+
+	assert.NotNil(t, client.Gauge("my gauge", 1, nil, 1))
+	assert.NotNil(t, client.Count("count", 1, []string{"a|b"}, 1))
+	assert.NotNil(t, client.Incr("count\n", nil, 1))
+	assert.NotNil(t, client.Histogram("histogram", 1, []string{"env:my prod"}, 1))
+	assert.NotNil(t, client.Distribution("distri|bution", 1, nil, 1))
+	assert.NotNil(t, client.Set("set", "value", []string{"a\nb"}, 1))
+	assert.NotNil(t, client.Timing("tim ing", 1, nil, 1))
+	assert.NotNil(t, client.SimpleEvent("title\n", "text"))
+	assert.NotNil(t, client.SimpleEvent("title", ""))
+	assert.NotNil(t, client.Event(&Event{Title: "title", Text: "text", Tags: []string{"a b"}}))
+	assert.NotNil(t, client.SimpleServiceCheck("check|name", Ok))
+	assert.NotNil(t, client.ServiceCheck(&ServiceCheck{Name: "check", Tags: []string{"a|b"}}))
+
+	require.Nil(t, client.Gauge("gauge", 1, []string{"env:prod"}, 1))
+	require.Nil(t, client.Count("count", 1, nil, 1))
+	require.Nil(t, client.SimpleEvent("my title", "text\nwith newline"))
+	require.Nil(t, client.SimpleServiceCheck("check.name", Ok))
+	require.Nil(t, client.Close())
+
+	ts := &testServer{}
+	ts.assertMetric(t, strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n"), []string{
+		"gauge:1|g|#env:prod",
+		"count:1|c",
+		`_e{8,18}:my title|text\nwith newline`,
+		"_sc|check.name|0",
+	})
+}
+
+func TestClientLenientNameValidation(t *testing.T) {
+	var buf bytes.Buffer
+	client, err := NewWithWriter(&buf, WithoutTelemetry(), WithoutClientSideAggregation())
+	require.Nil(t, err)
+
+	require.Nil(t, client.Gauge("my gauge", 1, []string{"a b"}, 1))
+	require.Nil(t, client.Close())
+	assert.Equal(t, "my gauge:1|g|#a b\n", buf.String())
+}
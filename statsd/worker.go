@@ -2,7 +2,10 @@ package statsd
 
 import (
 	"math/rand"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -12,10 +15,39 @@ type worker struct {
 	sender     *sender
 	random     *rand.Rand
 	randomLock sync.Mutex
-	sync.Mutex
+	sampler    Sampler
+	timedMutex
+
+	// blockTimeout caps how long processMetric waits for the lock, see
+	// WithMaxBlockDuration. Metrics dropped on timeout are counted in
+	// droppedOnTimeout.
+	blockTimeout     time.Duration
+	droppedOnTimeout *uint64
+
+	// oversizedPolicy is applied to the metrics that don't fit in an empty
+	// buffer, see WithOversizedMetricPolicy. Dropped metrics are counted in
+	// droppedOversized when set.
+	oversizedPolicy  OversizedMetricPolicy
+	droppedOversized *uint64
+
+	// droppedSerialization counts the metrics that could not be serialized when set, see ErrSerialization.
+	droppedSerialization *uint64
+
+	// highWaterMark is the size in bytes from which the buffer is flushed
+	// right after a write, see WithFlushHighWaterMark. 0 disables it.
+	highWaterMark int
+
+	// flushEvery is the number of messages from which the buffer is flushed
+	// right after a write, see WithFlushEveryNMetrics. 0 disables it.
+	flushEvery int
+
+	// immediateEvents flushes the buffer right after an event or a service check is written, see WithImmediateEvents.
+	immediateEvents bool
 
 	inputMetrics chan metric
 	stop         chan struct{}
+
+	errorReporter *errorReporter
 }
 
 func newWorker(pool *bufferPool, sender *sender) *worker {
@@ -38,6 +70,12 @@ func newWorker(pool *bufferPool, sender *sender) *worker {
 	}
 }
 
+// seedRandom replaces the random source of the worker with one seeded with seed, see WithSampleRNGSeed. It must be
+// called before the worker is used.
+func (w *worker) seedRandom(seed int64) {
+	w.random = rand.New(rand.NewSource(seed))
+}
+
 func (w *worker) startReceivingMetric(bufferSize int) {
 	w.inputMetrics = make(chan metric, bufferSize)
 	go w.pullMetric()
@@ -47,10 +85,23 @@ func (w *worker) stopReceivingMetric() {
 	w.stop <- struct{}{}
 }
 
+// waitForInputMetrics blocks until the metrics queued in inputMetrics before
+// the call have been processed.
+func (w *worker) waitForInputMetrics() {
+	var barrier sync.WaitGroup
+	barrier.Add(1)
+	w.inputMetrics <- metric{barrier: &barrier}
+	barrier.Wait()
+}
+
 func (w *worker) pullMetric() {
 	for {
 		select {
 		case m := <-w.inputMetrics:
+			if m.barrier != nil {
+				m.barrier.Done()
+				continue
+			}
 			w.processMetric(m)
 		case <-w.stop:
 			return
@@ -59,19 +110,115 @@ func (w *worker) pullMetric() {
 }
 
 func (w *worker) processMetric(m metric) error {
-	if !shouldSample(m.rate, w.random, &w.randomLock) {
+	if !sampleWith(w.sampler, m.name, m.tags, m.rate, w.random, &w.randomLock) {
 		return nil
 	}
+	if w.blockTimeout == 0 {
+		return w.writeMetric(m)
+	}
+	if !w.lockWithTimeout(w.blockTimeout) {
+		atomic.AddUint64(w.droppedOnTimeout, 1)
+		w.errorReporter.report(m.name, ErrBlockTimeout, nil)
+		return ErrBlockTimeout
+	}
+	err := w.writeMetricLocked(m)
+	w.Unlock()
+	return err
+}
+
+// setBlockTimeout enables the timeout of WithMaxBlockDuration. It must be
+// called before the worker is used.
+func (w *worker) setBlockTimeout(timeout time.Duration, droppedOnTimeout *uint64) {
+	w.blockTimeout = timeout
+	w.droppedOnTimeout = droppedOnTimeout
+	w.timedMutex.sem = make(chan struct{}, 1)
+}
+
+// writeMetric writes a metric that was already sampled, like the ones flushed by the aggregator.
+func (w *worker) writeMetric(m metric) error {
 	w.Lock()
+	err := w.writeMetricLocked(m)
+	w.Unlock()
+	return err
+}
+
+// writeMetricLocked writes a metric, flushing the buffer if needed. Lock must be held by caller.
+func (w *worker) writeMetricLocked(m metric) error {
 	var err error
 	if err = w.writeMetricUnsafe(m); err == errBufferFull {
 		w.flushUnsafe()
 		err = w.writeMetricUnsafe(m)
+		if err == errBufferFull {
+			// the metric doesn't even fit in an empty buffer
+			err = w.writeOversizedMetricUnsafe(m)
+		}
+	}
+	if err != nil && err != ErrBufferTooSmall {
+		w.dropOnSerialization(m, err)
+	}
+	if w.highWaterMark > 0 && len(w.buffer.bytes()) >= w.highWaterMark {
+		w.flushUnsafe()
+	} else if w.flushEvery > 0 && w.buffer.elementCount >= w.flushEvery {
+		w.flushUnsafe()
+	} else if w.immediateEvents && (m.metricType == event || m.metricType == serviceCheck) {
+		w.flushUnsafe()
 	}
-	w.Unlock()
 	return err
 }
 
+// writeOversizedMetricUnsafe applies the policy of WithOversizedMetricPolicy to a metric that doesn't fit in an empty
+// buffer.
+func (w *worker) writeOversizedMetricUnsafe(m metric) error {
+	if w.oversizedPolicy == OversizedMetricBestEffortTruncate {
+		for dropLastTag(&m) {
+			if err := w.writeMetricUnsafe(m); err != errBufferFull {
+				return err
+			}
+		}
+	}
+
+	if w.droppedOversized != nil {
+		atomic.AddUint64(w.droppedOversized, 1)
+	}
+	w.errorReporter.report(m.name, ErrBufferTooSmall, nil)
+	if w.oversizedPolicy == OversizedMetricError {
+		return ErrBufferTooSmall
+	}
+	return nil
+}
+
+// dropOnSerialization counts and reports a metric whose message could not be written to the buffer.
+func (w *worker) dropOnSerialization(m metric, err error) {
+	if w.droppedSerialization != nil {
+		atomic.AddUint64(w.droppedSerialization, 1)
+	}
+	w.errorReporter.report(m.name, ErrSerialization, err)
+}
+
+// dropLastTag removes the last tag of the call from m, the global tags are kept. It returns false if m has no tag left
+// to remove.
+func dropLastTag(m *metric) bool {
+	if m.stags != "" && len(m.tags) != 0 {
+		// the packed metrics of the calls keep their tags joined in stags as well
+		m.tags = m.tags[:len(m.tags)-1]
+		m.stags = strings.Join(m.tags, tagSeparatorSymbol)
+		return true
+	}
+	if m.stags != "" {
+		if i := strings.LastIndex(m.stags, tagSeparatorSymbol); i >= 0 {
+			m.stags = m.stags[:i]
+		} else {
+			m.stags = ""
+		}
+		return true
+	}
+	if len(m.tags) != 0 {
+		m.tags = m.tags[:len(m.tags)-1]
+		return true
+	}
+	return false
+}
+
 func (w *worker) writeAggregatedMetricUnsafe(m metric, metricSymbol []byte, precision int) error {
 	globalPos := 0
 
@@ -82,9 +229,26 @@ func (w *worker) writeAggregatedMetricUnsafe(m metric, metricSymbol []byte, prec
 	for _, t := range m.globalTags {
 		tagsSize += len(t) + 1
 	}
+	// +2 for the '|@' before the rate, see appendRate
+	if m.rate < 1 || w.buffer.alwaysRate {
+		var rate [32]byte
+		tagsSize += len(strconv.AppendFloat(rate[:0], m.rate, 'f', -1, 64)) + 2
+	}
+	// +3 for the '|c:' before the container ID
+	if containerID := w.buffer.messageContainerID(m.containerID); containerID != "" {
+		tagsSize += len(containerID) + 3
+	}
+	// +3 for the '|e:' before the external data
+	if w.buffer.externalEnv != "" {
+		tagsSize += len(w.buffer.externalEnv) + 3
+	}
+	// +6 for the '|card:' before the cardinality
+	if m.cardinality != CardinalityNotSet {
+		tagsSize += len(m.cardinality.String()) + 6
+	}
 
 	for {
-		pos, err := w.buffer.writeAggregated(metricSymbol, m.namespace, m.globalTags, m.name, m.fvalues[globalPos:], m.stags, tagsSize, precision)
+		pos, err := w.buffer.writeAggregated(metricSymbol, m.namespace, m.globalTags, m.name, m.fvalues[globalPos:], m.stags, tagsSize, precision, m.rate, m.cardinality, m.containerID)
 		if err == errPartialWrite {
 			// We successfully wrote part of the histogram metrics.
 			// We flush the current buffer and finish the histogram
@@ -100,27 +264,33 @@ func (w *worker) writeAggregatedMetricUnsafe(m metric, metricSymbol []byte, prec
 func (w *worker) writeMetricUnsafe(m metric) error {
 	switch m.metricType {
 	case gauge:
-		return w.buffer.writeGauge(m.namespace, m.globalTags, m.name, m.fvalue, m.tags, m.rate)
+		return w.buffer.writeGauge(m.namespace, m.globalTags, m.name, m.fvalue, m.tags, m.rate, m.timestamp, m.cardinality, m.containerID)
 	case count:
-		return w.buffer.writeCount(m.namespace, m.globalTags, m.name, m.ivalue, m.tags, m.rate)
+		return w.buffer.writeCount(m.namespace, m.globalTags, m.name, m.ivalue, m.tags, m.rate, m.timestamp, m.cardinality, m.containerID)
 	case histogram:
-		return w.buffer.writeHistogram(m.namespace, m.globalTags, m.name, m.fvalue, m.tags, m.rate)
+		return w.buffer.writeHistogram(m.namespace, m.globalTags, m.name, m.fvalue, m.tags, m.rate, m.cardinality, m.containerID)
 	case distribution:
-		return w.buffer.writeDistribution(m.namespace, m.globalTags, m.name, m.fvalue, m.tags, m.rate)
+		return w.buffer.writeDistribution(m.namespace, m.globalTags, m.name, m.fvalue, m.tags, m.rate, m.cardinality, m.containerID)
 	case set:
-		return w.buffer.writeSet(m.namespace, m.globalTags, m.name, m.svalue, m.tags, m.rate)
+		return w.buffer.writeSet(m.namespace, m.globalTags, m.name, m.svalue, m.tags, m.rate, m.cardinality, m.containerID)
+	case setInt:
+		return w.buffer.writeSetInt(m.namespace, m.globalTags, m.name, m.ivalue, m.tags, m.rate, m.cardinality, m.containerID)
 	case timing:
-		return w.buffer.writeTiming(m.namespace, m.globalTags, m.name, m.fvalue, m.tags, m.rate)
+		return w.buffer.writeTiming(m.namespace, m.globalTags, m.name, m.fvalue, m.tags, m.rate, m.cardinality, m.containerID)
 	case event:
-		return w.buffer.writeEvent(m.evalue, m.globalTags)
+		return w.buffer.writeEvent(m.evalue, m.globalTags, m.cardinality)
 	case serviceCheck:
-		return w.buffer.writeServiceCheck(m.scvalue, m.globalTags)
+		return w.buffer.writeServiceCheck(m.scvalue, m.globalTags, m.cardinality)
+	case raw:
+		return w.buffer.writeRaw(m.svalue)
+	case distributionSketch:
+		return w.buffer.writeDistributionSketch(m.namespace, m.globalTags, m.name, m.ivalue, m.fvalues[0], m.fvalues[1], m.fvalues[2], m.tags, m.cardinality, m.containerID)
 	case histogramAggregated:
 		return w.writeAggregatedMetricUnsafe(m, histogramSymbol, -1)
 	case distributionAggregated:
 		return w.writeAggregatedMetricUnsafe(m, distributionSymbol, -1)
 	case timingAggregated:
-		return w.writeAggregatedMetricUnsafe(m, timingSymbol, 6)
+		return w.writeAggregatedMetricUnsafe(m, timingSymbol, timingPrecision)
 	default:
 		return nil
 	}
@@ -140,6 +310,25 @@ func (w *worker) unpause() {
 	w.Unlock()
 }
 
+// returnBuffer gives the buffer of an idle worker back to the pool once flushed, so that the idle workers of
+// Client.batchWorkers don't hold a buffer Close couldn't release. The worker borrows a new one before writing again,
+// see Client.getBatchWorker.
+func (w *worker) returnBuffer() {
+	w.Lock()
+	w.pool.returnBuffer(w.buffer)
+	w.buffer = nil
+	w.Unlock()
+}
+
+// discardBuffer releases the memory of the buffer of the worker once the client is closed, see WithBufferAllocator.
+// The metrics written after that are dropped by the closed sender and only use the Go heap.
+func (w *worker) discardBuffer() {
+	w.Lock()
+	w.buffer.reset()
+	w.pool.discard(w.buffer)
+	w.Unlock()
+}
+
 // flush the current buffer. Lock must be held by caller.
 // flushed buffer written to the network asynchronously.
 func (w *worker) flushUnsafe() {
@@ -148,3 +337,58 @@ func (w *worker) flushUnsafe() {
 		w.buffer = w.pool.borrowBuffer()
 	}
 }
+
+// timedMutex is a mutex whose lock can give up after a timeout. It uses a
+// sync.Mutex unless sem is set, keeping the default path as cheap as possible:
+// a sync.Mutex can't be locked with a timeout before Go 1.18.
+type timedMutex struct {
+	mutex sync.Mutex
+	sem   chan struct{}
+}
+
+func (m *timedMutex) Lock() {
+	if m.sem != nil {
+		m.sem <- struct{}{}
+		return
+	}
+	m.mutex.Lock()
+}
+
+func (m *timedMutex) Unlock() {
+	if m.sem != nil {
+		<-m.sem
+		return
+	}
+	m.mutex.Unlock()
+}
+
+// lockWithTimeout returns false if the lock could not be acquired within the
+// timeout. The timeout is only honored when sem is set.
+func (m *timedMutex) lockWithTimeout(timeout time.Duration) bool {
+	if m.sem == nil {
+		m.mutex.Lock()
+		return true
+	}
+	select {
+	case m.sem <- struct{}{}:
+		return true
+	default:
+	}
+
+	timer := timerPool.Get().(*time.Timer)
+	timer.Reset(timeout)
+	locked := false
+	select {
+	case m.sem <- struct{}{}:
+		locked = true
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+	case <-timer.C:
+	}
+	timerPool.Put(timer)
+	return locked
+}
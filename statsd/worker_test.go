@@ -2,9 +2,12 @@ package statsd
 
 import (
 	"fmt"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestShouldSample(t *testing.T) {
@@ -50,6 +53,22 @@ func initWorker(bufferSize int) (*bufferPool, *sender, *worker) {
 	return pool, s, w
 }
 
+func TestWorkerSerializationError(t *testing.T) {
+	_, _, w := initWorker(100)
+	w.buffer.statsd = &statsdFormat{}
+	var dropped uint64
+	w.droppedSerialization = &dropped
+	errs := make(chan error, 1)
+	w.errorReporter = newErrorReporter(func(err error) { errs <- err })
+	defer w.errorReporter.close()
+
+	err := w.processMetric(metric{metricType: distributionSketch, name: "latency", ivalue: 1, fvalues: []float64{1, 1, 1}, rate: 1})
+	assert.Equal(t, ErrStatsdFormat, err)
+	assert.Equal(t, uint64(1), dropped)
+	assert.Equal(t, &DroppedMetricError{Name: "latency", Reason: ErrSerialization, Cause: ErrStatsdFormat}, <-errs)
+	assert.Empty(t, w.buffer.bytes())
+}
+
 func testWorker(t *testing.T, m metric, expectedBuffer string) {
 	_, s, w := initWorker(100)
 
@@ -94,6 +113,40 @@ func TestWorkerCount(t *testing.T) {
 	)
 }
 
+func TestWorkerGaugeWithTimestamp(t *testing.T) {
+	testWorker(
+		t,
+		metric{
+			metricType: gauge,
+			namespace:  "namespace.",
+			globalTags: []string{"globalTags", "globalTags2"},
+			name:       "test_gauge",
+			fvalue:     21,
+			tags:       []string{"tag1", "tag2"},
+			rate:       1,
+			timestamp:  1658934956,
+		},
+		"namespace.test_gauge:21|g|#globalTags,globalTags2,tag1,tag2|T1658934956\n",
+	)
+}
+
+func TestWorkerCountWithTimestamp(t *testing.T) {
+	testWorker(
+		t,
+		metric{
+			metricType: count,
+			namespace:  "namespace.",
+			globalTags: []string{"globalTags", "globalTags2"},
+			name:       "test_count",
+			ivalue:     21,
+			tags:       []string{"tag1", "tag2"},
+			rate:       1,
+			timestamp:  1658934956,
+		},
+		"namespace.test_count:21|c|#globalTags,globalTags2,tag1,tag2|T1658934956\n",
+	)
+}
+
 func TestWorkerHistogram(t *testing.T) {
 	testWorker(
 		t,
@@ -142,6 +195,22 @@ func TestWorkerSet(t *testing.T) {
 	)
 }
 
+func TestWorkerSetInt(t *testing.T) {
+	testWorker(
+		t,
+		metric{
+			metricType: setInt,
+			namespace:  "namespace.",
+			globalTags: []string{"globalTags", "globalTags2"},
+			name:       "test_set",
+			ivalue:     1234567890,
+			tags:       []string{"tag1", "tag2"},
+			rate:       1,
+		},
+		"namespace.test_set:1234567890|s|#globalTags,globalTags2,tag1,tag2\n",
+	)
+}
+
 func TestWorkerTiming(t *testing.T) {
 	testWorker(
 		t,
@@ -174,6 +243,26 @@ func TestWorkerHistogramAggregated(t *testing.T) {
 	)
 }
 
+func TestWorkerHistogramAggregatedWithRate(t *testing.T) {
+	_, s, w := initWorker(100)
+
+	// bypassing the sampling done by processMetric
+	err := w.writeMetricUnsafe(metric{
+		metricType: histogramAggregated,
+		namespace:  "namespace.",
+		globalTags: []string{"globalTags"},
+		name:       "test_histogram",
+		fvalues:    []float64{1.2, 3.4},
+		stags:      "tag1",
+		rate:       0.1,
+	})
+	assert.Nil(t, err)
+
+	w.flush()
+	data := <-s.queue
+	assert.Equal(t, "namespace.test_histogram:1.2:3.4|h|@0.1|#globalTags,tag1\n", string(data.buffer))
+}
+
 func TestWorkerHistogramAggregatedMultiple(t *testing.T) {
 	_, s, w := initWorker(100)
 
@@ -254,6 +343,40 @@ func TestWorkerDistributionAggregatedMultiple(t *testing.T) {
 	assert.Equal(t, "namespace.test_distribution:3.3:4.4|d|#globalTags,globalTags2,tag1,tag2\n", string(data.buffer))
 }
 
+func TestWorkerDistributionAggregatedWithRate(t *testing.T) {
+	_, s, w := initWorker(100)
+
+	m := metric{
+		metricType: distributionAggregated,
+		namespace:  "namespace.",
+		globalTags: []string{"globalTags", "globalTags2"},
+		name:       "test_distribution",
+		fvalues:    []float64{1.1, 2.2, 3.3, 4.4},
+		stags:      "tag1,tag2",
+		rate:       0.5,
+	}
+	// bypassing the sampling done by processMetric
+	err := w.writeMetricUnsafe(m)
+	assert.Nil(t, err)
+
+	w.flush()
+	data := <-s.queue
+	assert.Equal(t, "namespace.test_distribution:1.1:2.2:3.3:4.4|d|@0.5|#globalTags,globalTags2,tag1,tag2\n", string(data.buffer))
+
+	// reducing buffer size so not all values fit in one packet: the rate
+	// needs to be accounted for when splitting the values.
+	_, s, w = initWorker(77)
+
+	err = w.writeMetricUnsafe(m)
+	assert.Nil(t, err)
+
+	w.flush()
+	data = <-s.queue
+	assert.Equal(t, "namespace.test_distribution:1.1:2.2|d|@0.5|#globalTags,globalTags2,tag1,tag2\n", string(data.buffer))
+	data = <-s.queue
+	assert.Equal(t, "namespace.test_distribution:3.3:4.4|d|@0.5|#globalTags,globalTags2,tag1,tag2\n", string(data.buffer))
+}
+
 func TestWorkerMultipleDifferentDistributionAggregated(t *testing.T) {
 	// first metric will fit but not the second one
 	_, s, w := initWorker(160)
@@ -287,3 +410,145 @@ func TestWorkerMultipleDifferentDistributionAggregated(t *testing.T) {
 	data = <-s.queue
 	assert.Equal(t, "namespace.test_distribution_2:4.4|d|#globalTags,globalTags2,tag1,tag2\n", string(data.buffer))
 }
+
+func TestWorkerDistributionAggregatedWithContainerID(t *testing.T) {
+	m := metric{
+		metricType: distributionAggregated,
+		namespace:  "namespace.",
+		globalTags: []string{"globalTags", "globalTags2"},
+		name:       "test_distribution",
+		fvalues:    []float64{1.1, 2.2, 3.3, 4.4},
+		stags:      "tag1,tag2",
+		rate:       1,
+	}
+
+	// the container ID needs to be accounted for when splitting the values
+	config := defaultBufferConfig
+	config.containerID = "container-id"
+	pool := newBufferPoolWithConfig(10, 87, 5, config)
+	s := &sender{
+		queue: make(chan *statsdBuffer, 10),
+		pool:  pool,
+	}
+	w := newWorker(pool, s)
+	err := w.processMetric(m)
+	assert.Nil(t, err)
+
+	w.flush()
+	data := <-s.queue
+	assert.Equal(t, "namespace.test_distribution:1.1:2.2|d|#globalTags,globalTags2,tag1,tag2|c:container-id\n", string(data.buffer))
+	data = <-s.queue
+	assert.Equal(t, "namespace.test_distribution:3.3:4.4|d|#globalTags,globalTags2,tag1,tag2|c:container-id\n", string(data.buffer))
+}
+
+func TestWorkerDistributionAggregatedAlwaysRate(t *testing.T) {
+	m := metric{
+		metricType: distributionAggregated,
+		namespace:  "namespace.",
+		globalTags: []string{"globalTags", "globalTags2"},
+		name:       "test_distribution",
+		fvalues:    []float64{1.1, 2.2, 3, 4},
+		stags:      "tag1,tag2",
+		rate:       1,
+	}
+
+	// the rate sent with WithAlwaysEmitSampleRate needs to be accounted for when splitting the values: the first
+	// payload is exactly 75 bytes, ":3" would go over it
+	config := defaultBufferConfig
+	config.alwaysRate = true
+	pool := newBufferPoolWithConfig(10, 75, 5, config)
+	s := &sender{
+		queue: make(chan *statsdBuffer, 10),
+		pool:  pool,
+	}
+	w := newWorker(pool, s)
+	err := w.processMetric(m)
+	assert.Nil(t, err)
+
+	w.flush()
+	data := <-s.queue
+	assert.Equal(t, "namespace.test_distribution:1.1:2.2|d|@1|#globalTags,globalTags2,tag1,tag2\n", string(data.buffer))
+	assert.Len(t, data.buffer, 75)
+	data = <-s.queue
+	assert.Equal(t, "namespace.test_distribution:3:4|d|@1|#globalTags,globalTags2,tag1,tag2\n", string(data.buffer))
+}
+
+func TestWorkerFlushHighWaterMark(t *testing.T) {
+	// 30 bytes with the line break
+	line := strings.Repeat("x", 25) + ":1|g\n"
+	m := metric{metricType: gauge, name: strings.Repeat("x", 25), fvalue: 1, rate: 1}
+
+	_, s, w := initWorker(100)
+	for i := 0; i < 3; i++ {
+		require.Nil(t, w.processMetric(m))
+	}
+	// 90 bytes still fit in the buffer
+	assert.Len(t, s.queue, 0)
+
+	_, s, w = initWorker(100)
+	w.highWaterMark = 80
+	for i := 0; i < 2; i++ {
+		require.Nil(t, w.processMetric(m))
+	}
+	assert.Len(t, s.queue, 0)
+	// the third metric crosses the mark and flushes the buffer right away
+	require.Nil(t, w.processMetric(m))
+	require.Len(t, s.queue, 1)
+	data := <-s.queue
+	assert.Equal(t, strings.Repeat(line, 3), string(data.buffer))
+
+	require.Nil(t, w.processMetric(m))
+	assert.Len(t, s.queue, 0)
+	w.flush()
+	data = <-s.queue
+	assert.Equal(t, line, string(data.buffer))
+}
+
+func TestWorkerFlushEveryNMetrics(t *testing.T) {
+	line := "test:1|g\n"
+	m := metric{metricType: gauge, name: "test", fvalue: 1, rate: 1}
+
+	_, s, w := initWorker(100)
+	w.flushEvery = 3
+	for i := 0; i < 2; i++ {
+		require.Nil(t, w.processMetric(m))
+	}
+	assert.Len(t, s.queue, 0)
+	// the third metric flushes the buffer right away
+	require.Nil(t, w.processMetric(m))
+	require.Len(t, s.queue, 1)
+	data := <-s.queue
+	assert.Equal(t, strings.Repeat(line, 3), string(data.buffer))
+
+	// the count starts over after a flush
+	require.Nil(t, w.processMetric(m))
+	w.flush()
+	data = <-s.queue
+	assert.Equal(t, line, string(data.buffer))
+	for i := 0; i < 2; i++ {
+		require.Nil(t, w.processMetric(m))
+	}
+	assert.Len(t, s.queue, 0)
+	require.Nil(t, w.processMetric(m))
+	assert.Len(t, s.queue, 1)
+}
+
+func TestTimedMutex(t *testing.T) {
+	m := timedMutex{sem: make(chan struct{}, 1)}
+
+	assert.True(t, m.lockWithTimeout(time.Millisecond))
+	// already locked: gives up after the timeout
+	assert.False(t, m.lockWithTimeout(10*time.Millisecond))
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		m.Unlock()
+	}()
+	assert.True(t, m.lockWithTimeout(time.Second))
+	m.Unlock()
+
+	// without sem the timeout is ignored and a sync.Mutex is used
+	m = timedMutex{}
+	assert.True(t, m.lockWithTimeout(time.Millisecond))
+	m.Unlock()
+}